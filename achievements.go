@@ -0,0 +1,484 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Badge identifiers
+const (
+	badgeFirst20kDay  = "first-20k-day"
+	badgeWinStreak    = "5-day-win-streak"
+	badgePerfectMonth = "perfect-month"
+	badgePersonalBest = "personal-best"
+)
+
+const (
+	// winStreakLength is the number of consecutive daily wins required for a win streak badge
+	winStreakLength = 5
+	// bigDaySteps is the daily step count required for the first 20k day badge
+	bigDaySteps = 20000
+	// minPerfectMonthChallenges is the minimum number of challenges in a channel for a month before participating
+	// in all of them is worth a badge
+	minPerfectMonthChallenges = 5
+	// perfectMonthEvaluationDelay is how long after the end of a month perfect months get evaluated. Challenges of the
+	// last day of the month wrap up the following day and their results need to be counted first
+	perfectMonthEvaluationDelay = 24 * time.Hour
+)
+
+// Badge holds the definition of a badge users can earn. The name and description are only set once the badge is
+// localized with the messages it's rendered with
+type Badge struct {
+	ID          string
+	Emoji       string
+	Name        string
+	Description string
+}
+
+// badges holds all badges in the order they are displayed in a collection
+var badges = [...]Badge{
+	{ID: badgeFirst20kDay, Emoji: ":mountain:"},
+	{ID: badgeWinStreak, Emoji: ":fire:"},
+	{ID: badgePerfectMonth, Emoji: ":calendar:"},
+	{ID: badgePersonalBest, Emoji: ":chart_with_upwards_trend:"},
+}
+
+// badgeNameMessages holds the name of the message template of each badge's name, by badge id
+var badgeNameMessages = map[string]string{
+	badgeFirst20kDay:  msgFirst20kDay,
+	badgeWinStreak:    msgWinStreak,
+	badgePerfectMonth: msgPerfectMonth,
+	badgePersonalBest: msgPersonalBest,
+}
+
+// badgeDescriptionMessages holds the name of the message template of each badge's description, by badge id
+var badgeDescriptionMessages = map[string]string{
+	badgeFirst20kDay:  msgFirst20kDayDesc,
+	badgeWinStreak:    msgWinStreakDesc,
+	badgePerfectMonth: msgPerfectMonthDesc,
+	badgePersonalBest: msgPersonalBestDesc,
+}
+
+// localizeBadge returns a badge with its name and description rendered with the given messages
+func localizeBadge(messages *Messages, badge Badge) Badge {
+	badge.Name = messages.render(badgeNameMessages[badge.ID], nil)
+	badge.Description = messages.render(badgeDescriptionMessages[badge.ID], nil)
+
+	return badge
+}
+
+// UserAchievements holds a user's awarded badges along with the running stats achievement rules are evaluated against
+type UserAchievements struct {
+	UserID       string         `datastore:"userID"`
+	PersonalBest int            `datastore:"personalBest,noindex"`
+	WinStreak    int            `datastore:"winStreak,noindex"`
+	LastWinDate  string         `datastore:"lastWinDate,noindex"`
+	Badges       []AwardedBadge `datastore:"badges,noindex"`
+}
+
+// AwardedBadge holds a badge awarded to a user. Some badges can be earned more than once in which
+// case the count is incremented and the award key identifies the occurrence it was last awarded for
+type AwardedBadge struct {
+	BadgeID      string `datastore:"badgeID"`
+	Count        int    `datastore:"count"`
+	FirstAwarded string `datastore:"firstAwarded"`
+	LastAwardKey string `datastore:"lastAwardKey"`
+}
+
+// BadgeAward holds a newly awarded badge for a user
+type BadgeAward struct {
+	UserID string
+	Badge  Badge
+}
+
+// PerfectMonthEvaluation identifies the month of a channel to award perfect month badges for once it's over
+type PerfectMonthEvaluation struct {
+	TeamID    string `json:"teamID" datastore:"teamID"`
+	ChannelID string `json:"channelID" datastore:"channelID"`
+	Month     string `json:"month" datastore:"month"`
+}
+
+// achievementContext holds everything an achievement rule can evaluate for a user when a challenge is wrapped
+// up. The achievements are the user's state before the challenge results are applied
+type achievementContext struct {
+	challenge    StepsChallenge
+	rank         int
+	steps        int
+	achievements UserAchievements
+	winStreak    int
+}
+
+// achievementRule holds a badge and the function evaluating whether it was earned. The rule returns an award key
+// identifying the occurrence so that a badge is never awarded twice for the same thing. Rules for badges that can
+// only be earned once can return any constant key
+type achievementRule struct {
+	badgeID  string
+	evaluate func(ac achievementContext) (earned bool, awardKey string)
+}
+
+// achievementRules holds all the rules evaluated when a challenge is wrapped up. Perfect months aren't evaluated
+// with challenges but once the month is over by evaluatePerfectMonth
+var achievementRules = [...]achievementRule{
+	{badgeID: badgeFirst20kDay, evaluate: func(ac achievementContext) (bool, string) {
+		return ac.steps >= bigDaySteps, badgeFirst20kDay
+	}},
+	{badgeID: badgeWinStreak, evaluate: func(ac achievementContext) (bool, string) {
+		return ac.winStreak > 0 && ac.winStreak%winStreakLength == 0, ac.challenge.Date
+	}},
+	{badgeID: badgePersonalBest, evaluate: func(ac achievementContext) (bool, string) {
+		return ac.achievements.PersonalBest > 0 && ac.steps > ac.achievements.PersonalBest, ac.challenge.Date
+	}},
+}
+
+// findBadge returns the badge definition for a badge id
+func findBadge(badgeID string) (badge Badge, ok bool) {
+	for _, b := range badges {
+		if b.ID == badgeID {
+			return b, true
+		}
+	}
+
+	return badge, false
+}
+
+// award records a badge award and returns true if the badge wasn't already awarded for the same award key
+func (ua *UserAchievements) award(badgeID string, awardKey string, date string) (awarded bool) {
+	for i, ab := range ua.Badges {
+		if ab.BadgeID == badgeID {
+			if ab.LastAwardKey == awardKey {
+				return false
+			}
+
+			ua.Badges[i].Count++
+			ua.Badges[i].LastAwardKey = awardKey
+			return true
+		}
+	}
+
+	ua.Badges = append(ua.Badges, AwardedBadge{BadgeID: badgeID, Count: 1, FirstAwarded: date, LastAwardKey: awardKey})
+	return true
+}
+
+// nextWinStreak returns the updated win streak given a user won a challenge on the given date. Winning multiple
+// challenges on the same day doesn't extend the streak
+func (ua *UserAchievements) nextWinStreak(date time.Time) int {
+	lastWin, err := time.Parse(challengeDateFormat, ua.LastWinDate)
+	if err != nil {
+		return 1
+	}
+
+	switch {
+	case lastWin.Equal(date):
+		return ua.WinStreak
+	case lastWin.AddDate(0, 0, 1).Equal(date):
+		return ua.WinStreak + 1
+	default:
+		return 1
+	}
+}
+
+// evaluateAchievements runs all achievement rules for participants of a wrapped up challenge, persists
+// their updated achievements and returns the newly awarded badges
+func (sc *StepCurry) evaluateAchievements(stepsChallenge StepsChallenge) (awards []BadgeAward, err error) {
+	awards = make([]BadgeAward, 0)
+	if len(stepsChallenge.RankedUsers) == 0 {
+		return awards, nil
+	}
+
+	challengeDate, err := time.Parse(challengeDateFormat, stepsChallenge.Date)
+	if err != nil {
+		return awards, errors.Wrapf(err, "error parsing date of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	ctx := context.Background()
+	for rank, us := range stepsChallenge.RankedUsers {
+		ac := achievementContext{challenge: stepsChallenge, rank: rank, steps: us.Steps}
+		userAwards, err := sc.updateUserAchievements(ctx, ac, challengeDate, us.UserID)
+		if err != nil {
+			return awards, err
+		}
+
+		awards = append(awards, userAwards...)
+	}
+
+	return awards, nil
+}
+
+// schedulePerfectMonth schedules the evaluation of perfect months in the channel of a challenge once the month of the
+// challenge is over. Evaluations are named after their channel and month so that scheduling one on every wrap up
+// results in a single evaluation
+func (sc *StepCurry) schedulePerfectMonth(stepsChallenge StepsChallenge) (err error) {
+	location, err := time.LoadLocation(stepsChallenge.TimezoneID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading location [%s]", stepsChallenge.TimezoneID)
+	}
+
+	challengeDate, err := time.ParseInLocation(challengeDateFormat, stepsChallenge.Date, location)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing date of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	monthEnd := time.Date(challengeDate.Year(), challengeDate.Month()+1, 1, 0, 0, 0, 0, location)
+	evaluation := PerfectMonthEvaluation{TeamID: stepsChallenge.TeamID, ChannelID: stepsChallenge.ChannelID, Month: periodID(periodMonth, challengeDate)}
+
+	return sc.scheduler.SchedulePerfectMonth(context.Background(), evaluation, monthEnd.Add(perfectMonthEvaluationDelay))
+}
+
+// EvaluatePerfectMonth handles a request to award perfect month badges for a month of a channel. The requests are
+// coming from evaluations scheduled by schedulePerfectMonth
+func (sc *StepCurry) EvaluatePerfectMonth(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	var evaluation PerfectMonthEvaluation
+	err = json.Unmarshal(body, &evaluation)
+	if err != nil {
+		return newHttpError(err, "Error decoding perfect month evaluation from body", http.StatusBadRequest)
+	}
+
+	err = sc.evaluatePerfectMonth(evaluation)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error evaluating perfect month [%s] of channel [%s]", evaluation.Month, evaluation.ChannelID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// evaluatePerfectMonth awards the perfect month badge to users who took part in every challenge of a month in a
+// channel and announces the awards in the channel. Awards are keyed by channel and month so that a failed evaluation
+// can be retried without awarding badges twice
+func (sc *StepCurry) evaluatePerfectMonth(evaluation PerfectMonthEvaluation) (err error) {
+	ctx := context.Background()
+	svcs, err := sc.Route(evaluation.TeamID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting api services for team ID [%s]", evaluation.TeamID)
+	}
+
+	leaderboard, err := sc.storage.GetLeaderboard(ctx, evaluation.TeamID, scopeChannel, evaluation.ChannelID, periodMonth, evaluation.Month)
+	if err != nil && err != ErrNoSuchEntity {
+		return errors.Wrapf(err, "error loading leaderboard [%s.%s]", evaluation.TeamID, LeaderboardName(scopeChannel, evaluation.ChannelID, periodMonth, evaluation.Month))
+	}
+
+	if leaderboard.Challenges < minPerfectMonthChallenges {
+		return nil
+	}
+
+	monthStart, err := time.Parse("2006-01", evaluation.Month)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing month [%s]", evaluation.Month)
+	}
+
+	awardKey := fmt.Sprintf("%s:%s", evaluation.ChannelID, evaluation.Month)
+	awardDate := monthStart.AddDate(0, 1, -1).Format(challengeDateFormat)
+	badge, _ := findBadge(badgePerfectMonth)
+
+	awards := make([]BadgeAward, 0)
+	for _, r := range leaderboard.Standings {
+		if r.Challenges != leaderboard.Challenges {
+			continue
+		}
+
+		awarded, err := sc.awardUserBadge(ctx, evaluation.TeamID, r.UserID, badgePerfectMonth, awardKey, awardDate)
+		if err != nil {
+			return err
+		}
+
+		if awarded {
+			awards = append(awards, BadgeAward{UserID: r.UserID, Badge: badge})
+		}
+	}
+
+	if len(awards) == 0 {
+		return nil
+	}
+
+	// Badges awarded are persisted by now so a retried evaluation wouldn't announce them again
+	text := renderBadgeAwardsText(svcs.messages, awards)
+	_, _, err = svcs.messenger.PostMessage(evaluation.ChannelID, svcs.messages.postOptions(slack.MsgOptionText(text, false), slack.MsgOptionBlocks(renderBadgeAwards(svcs.messages, awards)...))...)
+	if err != nil {
+		newLogEntry(svcs.logger).with(Fields{teamIDField: evaluation.TeamID, channelIDField: evaluation.ChannelID}).withError(err).warningf("Error announcing perfect month badges")
+	}
+
+	return nil
+}
+
+// awardUserBadge awards a badge to a user unless it was already awarded for the same award key. The achievements are
+// read and updated in a transaction, like in updateUserAchievements
+func (sc *StepCurry) awardUserBadge(ctx context.Context, teamID string, userID string, badgeID string, awardKey string, date string) (awarded bool, err error) {
+	err = sc.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		achievements, err := sc.storage.GetUserAchievements(tc, teamID, userID)
+		if err != nil && err != ErrNoSuchEntity {
+			return errors.Wrapf(err, "error loading achievements for user [%s]", userID)
+		}
+		achievements.UserID = userID
+
+		awarded = achievements.award(badgeID, awardKey, date)
+		if !awarded {
+			return nil
+		}
+
+		err = sc.storage.PutUserAchievements(tc, teamID, achievements)
+		if err != nil {
+			return errors.Wrapf(err, "error persisting achievements for user [%s]", userID)
+		}
+
+		return nil
+	})
+
+	return awarded, err
+}
+
+// updateUserAchievements runs all achievement rules for a user and persists their updated achievements. The
+// achievements are read and updated in a transaction so that challenges wrapping up at the same time don't
+// overwrite each other's updates
+func (sc *StepCurry) updateUserAchievements(ctx context.Context, ac achievementContext, challengeDate time.Time, userID string) (awards []BadgeAward, err error) {
+	err = sc.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		// Reset awards in case the transaction is retried
+		awards = make([]BadgeAward, 0)
+
+		achievements, err := sc.storage.GetUserAchievements(tc, ac.challenge.TeamID, userID)
+		if err != nil && err != ErrNoSuchEntity {
+			return errors.Wrapf(err, "error loading achievements for user [%s]", userID)
+		}
+		achievements.UserID = userID
+
+		ac.achievements = achievements
+		if ac.rank == 0 {
+			ac.winStreak = achievements.nextWinStreak(challengeDate)
+		}
+
+		for _, rule := range achievementRules {
+			if earned, awardKey := rule.evaluate(ac); earned && achievements.award(rule.badgeID, awardKey, ac.challenge.Date) {
+				badge, _ := findBadge(rule.badgeID)
+				awards = append(awards, BadgeAward{UserID: userID, Badge: badge})
+			}
+		}
+
+		if ac.steps > achievements.PersonalBest {
+			achievements.PersonalBest = ac.steps
+		}
+
+		if ac.rank == 0 {
+			achievements.WinStreak = ac.winStreak
+			achievements.LastWinDate = ac.challenge.Date
+		}
+
+		err = sc.storage.PutUserAchievements(tc, ac.challenge.TeamID, achievements)
+		if err != nil {
+			return errors.Wrapf(err, "error persisting achievements for user [%s]", userID)
+		}
+
+		return nil
+	})
+
+	return awards, err
+}
+
+// renderBadgeAwards renders newly awarded badges as slack blocks to be included in the wrap up message
+func renderBadgeAwards(messages *Messages, awards []BadgeAward) (renderBlocks []slack.Block) {
+	renderBlocks = make([]slack.Block, 0)
+	if len(awards) == 0 {
+		return renderBlocks
+	}
+
+	text := renderBadgeAwardsText(messages, awards)
+	return append(renderBlocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil))
+}
+
+// renderBadgeAwardsText renders the text announcing newly awarded badges
+func renderBadgeAwardsText(messages *Messages, awards []BadgeAward) (text string) {
+	localizedAwards := make([]BadgeAward, 0, len(awards))
+	for _, award := range awards {
+		localizedAwards = append(localizedAwards, BadgeAward{UserID: award.UserID, Badge: localizeBadge(messages, award.Badge)})
+	}
+
+	return messages.render(msgBadgeAwards, messageData{"Awards": localizedAwards})
+}
+
+// userMentionRegexp matches an escaped slack user mention such as <@U1234|frans>
+var userMentionRegexp = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(\|[^>]*)?>$`)
+
+// Badges handles an incoming slack request in response to a user invoking /step-badges and responds with
+// the badge collection of the requesting user or of the mentioned user
+func (sc *StepCurry) Badges(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Parse the slack payload to get the originating context (channel, user)
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	// Badges are shown to the requester in their own language
+	messages := svcs.userMessages(userID)
+
+	if text := params[textParam]; len(text) > 0 {
+		matches := userMentionRegexp.FindStringSubmatch(text)
+		if matches == nil {
+			usageMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgBadgesUsage, messageData{"Command": sc.slashCommands.Badges})}
+			return sendResponse(responseURL, usageMsg, "badges usage")
+		}
+
+		userID = matches[1]
+	}
+
+	ctx := context.Background()
+	achievements, err := sc.storage.GetUserAchievements(ctx, teamID, userID)
+	if err != nil && err != ErrNoSuchEntity {
+		return newHttpError(err, fmt.Sprintf("Error loading achievements for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	badgesMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgNoBadges, messageData{"UserID": userID})}
+	if len(achievements.Badges) > 0 {
+		badgesMsg.Text = messages.render(msgBadgeCollection, messageData{"UserID": userID})
+		badgesMsg.Blocks = renderBadgeCollection(messages, badgesMsg.Text, achievements)
+	}
+
+	return sendResponse(responseURL, badgesMsg, "badges")
+}
+
+// renderBadgeCollection renders a user's awarded badges as slack blocks, in the order badges are defined
+func renderBadgeCollection(messages *Messages, header string, achievements UserAchievements) (renderBlocks []slack.Block) {
+	renderBlocks = []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", header, false, false), nil, nil)}
+
+	for _, badge := range badges {
+		for _, ab := range achievements.Badges {
+			if ab.BadgeID != badge.ID {
+				continue
+			}
+
+			badge := localizeBadge(messages, badge)
+			text := messages.render(msgBadge, messageData{"Emoji": badge.Emoji, "Name": badge.Name, "Count": ab.Count, "Description": badge.Description, "FirstAwarded": ab.FirstAwarded})
+
+			renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", text, false, false)))
+		}
+	}
+
+	return renderBlocks
+}
@@ -0,0 +1,304 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextWinStreak(t *testing.T) {
+	tests := map[string]struct {
+		achievements UserAchievements
+		date         time.Time
+		expected     int
+	}{
+		"FirstWin":            {achievements: UserAchievements{}, date: time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC), expected: 1},
+		"ConsecutiveWin":      {achievements: UserAchievements{WinStreak: 4, LastWinDate: "2019-10-10"}, date: time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC), expected: 5},
+		"SameDayWin":          {achievements: UserAchievements{WinStreak: 3, LastWinDate: "2019-10-11"}, date: time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC), expected: 3},
+		"BrokenStreak":        {achievements: UserAchievements{WinStreak: 7, LastWinDate: "2019-10-09"}, date: time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC), expected: 1},
+		"AcrossMonthBoundary": {achievements: UserAchievements{WinStreak: 2, LastWinDate: "2019-09-30"}, date: time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC), expected: 3},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.achievements.nextWinStreak(tc.date))
+		})
+	}
+}
+
+func TestAwardBadge(t *testing.T) {
+	achievements := UserAchievements{}
+
+	assert.True(t, achievements.award(badgePersonalBest, "2019-10-10", "2019-10-10"))
+	assert.False(t, achievements.award(badgePersonalBest, "2019-10-10", "2019-10-10"))
+	assert.True(t, achievements.award(badgePersonalBest, "2019-10-11", "2019-10-11"))
+	assert.True(t, achievements.award(badgeFirst20kDay, badgeFirst20kDay, "2019-10-11"))
+	assert.False(t, achievements.award(badgeFirst20kDay, badgeFirst20kDay, "2019-10-12"))
+
+	assert.Equal(t, []AwardedBadge{{BadgeID: badgePersonalBest, Count: 2, FirstAwarded: "2019-10-10", LastAwardKey: "2019-10-11"}, {BadgeID: badgeFirst20kDay, Count: 1, FirstAwarded: "2019-10-11", LastAwardKey: badgeFirst20kDay}}, achievements.Badges)
+}
+
+func TestEvaluateAchievements(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == "U1"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*UserAchievements)
+		*returnVal = UserAchievements{UserID: "U1", PersonalBest: 18000, WinStreak: 4, LastWinDate: "2019-09-30"}
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == "U2"
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == "U1"
+	}), &UserAchievements{UserID: "U1", PersonalBest: 21000, WinStreak: 5, LastWinDate: "2019-10-01", Badges: []AwardedBadge{
+		{BadgeID: badgeFirst20kDay, Count: 1, FirstAwarded: "2019-10-01", LastAwardKey: badgeFirst20kDay},
+		{BadgeID: badgeWinStreak, Count: 1, FirstAwarded: "2019-10-01", LastAwardKey: "2019-10-01"},
+		{BadgeID: badgePersonalBest, Count: 1, FirstAwarded: "2019-10-01", LastAwardKey: "2019-10-01"},
+	}}).Return(nil, nil)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == "U2"
+	}), &UserAchievements{UserID: "U2", PersonalBest: 9000}).Return(nil, nil)
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	awards, err := sc.evaluateAchievements(StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-01"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 21000}, {UserID: "U2", Steps: 9000}}})
+	require.NoError(t, err)
+
+	awardedBadges := make([]string, 0)
+	for _, a := range awards {
+		awardedBadges = append(awardedBadges, fmt.Sprintf("%s:%s", a.UserID, a.Badge.ID))
+	}
+	assert.Equal(t, []string{"U1:" + badgeFirst20kDay, "U1:" + badgeWinStreak, "U1:" + badgePersonalBest}, awardedBadges)
+}
+
+func TestPerfectMonthEvaluatedOnceMonthIsOver(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+	defer cleanup()
+
+	ctx := context.Background()
+	for day := 1; day <= minPerfectMonthChallenges; day++ {
+		rankedUsers := []UserSteps{{UserID: "U1", Steps: 1000}}
+		if day > 1 {
+			rankedUsers = append(rankedUsers, UserSteps{UserID: "U2", Steps: 900})
+		}
+
+		stepsChallenge := StepsChallenge{ChallengeID: ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: fmt.Sprintf("2019-10-%02d", day)}, TimezoneID: "UTC", RankedUsers: rankedUsers}
+		require.NoError(t, h.sc.recordLeaderboardResults(ctx, stepsChallenge))
+		require.NoError(t, h.sc.schedulePerfectMonth(stepsChallenge))
+	}
+
+	next, ok := h.scheduler.NextScheduleTime()
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2019, 11, 2, 0, 0, 0, 0, time.UTC).Unix(), next.Unix())
+
+	assert.Equal(t, 1, h.runScheduledTasks(func(now time.Time) {}))
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ":sports_medal: *New badges unlocked*\n:calendar: <@U1> earned *Perfect month*", messages[0].text)
+
+	achievements, err := h.storage.GetUserAchievements(ctx, lifecycleTeamID, "U1")
+	require.NoError(t, err)
+	assert.Equal(t, []AwardedBadge{{BadgeID: badgePerfectMonth, Count: 1, FirstAwarded: "2019-10-31", LastAwardKey: "C1:2019-10"}}, achievements.Badges)
+
+	_, err = h.storage.GetUserAchievements(ctx, lifecycleTeamID, "U2")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	// Evaluating the same month again, like a retried evaluation would, doesn't award or announce the badge twice
+	require.NoError(t, h.sc.evaluatePerfectMonth(PerfectMonthEvaluation{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Month: "2019-10"}))
+	assert.Len(t, h.slack.messagesTo(lifecycleChannelID), 1)
+
+	achievements, err = h.storage.GetUserAchievements(ctx, lifecycleTeamID, "U1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, achievements.Badges[0].Count)
+}
+
+func TestEvaluateAchievementsErrorPersisting(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(datastore.ErrNoSuchEntity)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == "U1"
+	}), mock.Anything).Return(nil, fmt.Errorf("backend error"))
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	_, err = sc.evaluateAchievements(StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 21000}}})
+	assert.EqualError(t, err, "error persisting achievements for user [U1]: backend error")
+}
+
+func TestRenderBadgeAwards(t *testing.T) {
+	assert.Empty(t, renderBadgeAwards(DefaultMessages(), []BadgeAward{}))
+
+	blocks := renderBadgeAwards(DefaultMessages(), []BadgeAward{{UserID: "U1", Badge: badges[0]}, {UserID: "U2", Badge: badges[3]}})
+	require.Len(t, blocks, 1)
+	require.IsType(t, new(slack.SectionBlock), blocks[0])
+	assert.Equal(t, ":sports_medal: *New badges unlocked*\n:mountain: <@U1> earned *First 20k day*\n:chart_with_upwards_trend: <@U2> earned *Personal best*", blocks[0].(*slack.SectionBlock).Text.Text)
+}
+
+func TestRenderBadgesLocalized(t *testing.T) {
+	french := DefaultMessages().forLocale("fr-FR")
+
+	blocks := renderBadgeAwards(french, []BadgeAward{{UserID: "U1", Badge: badges[3]}})
+	require.Len(t, blocks, 1)
+	assert.Contains(t, blocks[0].(*slack.SectionBlock).Text.Text, ":chart_with_upwards_trend: <@U1> a obtenu *Record personnel*")
+
+	blocks = renderBadgeCollection(french, "header", UserAchievements{Badges: []AwardedBadge{{BadgeID: badgeWinStreak, Count: 1, FirstAwarded: "2019-10-01"}}})
+	require.Len(t, blocks, 2)
+	require.IsType(t, new(slack.ContextBlock), blocks[1])
+	assert.Contains(t, blocks[1].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text, ":fire: *5 victoires d'affilée* _Défis gagnés 5 jours de suite_")
+}
+
+func TestBadges(t *testing.T) {
+	tests := map[string]struct {
+		text             string
+		expectedUser     string
+		achievements     *UserAchievements
+		expectedMessages []string
+	}{
+		"OwnCollection": {
+			text:             "",
+			expectedUser:     "frans",
+			achievements:     &UserAchievements{Badges: []AwardedBadge{{BadgeID: badgePersonalBest, Count: 3, FirstAwarded: "2019-10-02"}, {BadgeID: badgeFirst20kDay, Count: 1, FirstAwarded: "2019-10-01"}}},
+			expectedMessages: []string{"Badge collection of \\u003c@frans\\u003e", ":mountain: *First 20k day* _Walked 20,000 steps in a challenge_ (first earned on 2019-10-01)", ":chart_with_upwards_trend: *Personal best* x3 _Beat your personal best step count_ (first earned on 2019-10-02)"},
+		},
+		"MentionedUserWithoutBadges": {
+			text:             "<@U1234|marco>",
+			expectedUser:     "U1234",
+			achievements:     nil,
+			expectedMessages: []string{"\\u003c@U1234\\u003e hasn't earned any badges yet"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			slackRequest := ""
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqBody, _ := ioutil.ReadAll(r.Body)
+				slackRequest = string(reqBody)
+				fmt.Fprintln(w, "OK")
+			}))
+			defer server.Close()
+
+			body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-badges&text=%s&response_url=%s&trigger_id=someTriggerID", tc.text, server.URL)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			verifier := &mocks.Verifier{}
+			verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+			defer verifier.AssertExpectations(t)
+
+			storer := &mocks.Datastorer{}
+			call := storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+				return k.Namespace == "TEAMID" && k.Kind == "UserAchievements" && k.Name == tc.expectedUser
+			}), mock.Anything)
+			if tc.achievements != nil {
+				call.Return(nil).Run(func(args mock.Arguments) {
+					returnVal := args.Get(2).(*UserAchievements)
+					*returnVal = *tc.achievements
+				})
+			} else {
+				call.Return(datastore.ErrNoSuchEntity)
+			}
+			defer storer.AssertExpectations(t)
+
+			taskScheduler := &mocks.TaskScheduler{}
+			defer taskScheduler.AssertExpectations(t)
+
+			userInfoFinder := &mocks.UserInfoFinder{}
+			userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+			defer userInfoFinder.AssertExpectations(t)
+
+			teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+			require.NoError(t, err)
+
+			err = sc.Badges(w, r)
+			require.NoError(t, err)
+
+			for _, m := range tc.expectedMessages {
+				assert.Contains(t, slackRequest, m)
+			}
+		})
+	}
+}
+
+func TestBadgesInvalidMention(t *testing.T) {
+	slackRequest := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		slackRequest = string(reqBody)
+		fmt.Fprintln(w, "OK")
+	}))
+	defer server.Close()
+
+	body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-badges&text=marco&response_url=%s&trigger_id=someTriggerID", server.URL)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	verifier := &mocks.Verifier{}
+	verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.Badges(w, r)
+	require.NoError(t, err)
+
+	assert.Contains(t, slackRequest, "Try `/step-badges` or `/step-badges @someone`")
+}
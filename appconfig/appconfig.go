@@ -0,0 +1,323 @@
+// Package appconfig loads the settings shared by all Step Curry entrypoints, the app's credentials and public base URL,
+// from, in increasing order of precedence, defaults, a YAML, TOML or JSON configuration file, environment variables
+// and command-line flags. Secrets left unset by all of those are then looked up from an optional SecretSource such as
+// Google Secret Manager
+package appconfig
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFlag is the name of the flag holding the path to the configuration file
+	ConfigFlag = "config"
+)
+
+// Setting names
+const (
+	baseURLSetting                = "base-url"
+	slackAppIDSetting             = "slack-app-id"
+	slackClientIDSetting          = "slack-client-id"
+	slackClientSecretSetting      = "slack-client-secret"
+	slackSigningSecretSetting     = "slack-signing-secret"
+	fitbitClientIDSetting         = "fitbit-client-id"
+	fitbitClientSecretSetting     = "fitbit-client-secret"
+	fitbitVerificationCodeSetting = "fitbit-verification-code"
+)
+
+// secretSettings are the settings that can be loaded from a SecretSource along with the name of their secret
+var secretSettings = []struct {
+	name       string
+	secretName string
+}{
+	{slackAppIDSetting, "slackAppID"},
+	{slackClientIDSetting, "slackClientID"},
+	{slackClientSecretSetting, "slackClientSecret"},
+	{slackSigningSecretSetting, "slackSigningSecret"},
+	{fitbitClientIDSetting, "fitbitClientID"},
+	{fitbitClientSecretSetting, "fitbitClientSecret"},
+	{fitbitVerificationCodeSetting, "fitbitSubscriberVerificationCode"},
+}
+
+// Config holds the settings shared by all Step Curry entrypoints
+type Config struct {
+	ConfigFile             string
+	BaseURL                string
+	SlackAppID             string
+	SlackClientID          string
+	SlackClientSecret      string
+	SlackSigningSecret     string
+	FitbitClientID         string
+	FitbitClientSecret     string
+	FitbitVerificationCode string
+}
+
+// SecretSource defines the interface for looking up secrets by name
+type SecretSource interface {
+	// LookupSecret returns the value of a secret and whether it exists
+	LookupSecret(name string) (value string, found bool, err error)
+}
+
+// NewFlagSet creates a FlagSet with the configuration file and shared settings bound to the fields of cfg. The
+// current values of cfg are the defaults. Entrypoints register their own settings on it before calling Parse
+func NewFlagSet(name string, cfg *Config) (fs *flag.FlagSet) {
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(&cfg.ConfigFile, ConfigFlag, cfg.ConfigFile, "path to a yaml, toml or json configuration file with settings keyed by flag name")
+	fs.StringVar(&cfg.BaseURL, baseURLSetting, cfg.BaseURL, "public base URL of the server, used to build callback URLs")
+	fs.StringVar(&cfg.SlackAppID, slackAppIDSetting, cfg.SlackAppID, "slack app id")
+	fs.StringVar(&cfg.SlackClientID, slackClientIDSetting, cfg.SlackClientID, "slack client id")
+	fs.StringVar(&cfg.SlackClientSecret, slackClientSecretSetting, cfg.SlackClientSecret, "slack client secret")
+	fs.StringVar(&cfg.SlackSigningSecret, slackSigningSecretSetting, cfg.SlackSigningSecret, "slack signing secret used to verify requests")
+	fs.StringVar(&cfg.FitbitClientID, fitbitClientIDSetting, cfg.FitbitClientID, "fitbit client id")
+	fs.StringVar(&cfg.FitbitClientSecret, fitbitClientSecretSetting, cfg.FitbitClientSecret, "fitbit client secret")
+	fs.StringVar(&cfg.FitbitVerificationCode, fitbitVerificationCodeSetting, cfg.FitbitVerificationCode, "fitbit subscriber verification code, enables fitbit subscriptions when set")
+
+	return fs
+}
+
+// Load loads the shared settings of an entrypoint without flags or settings of its own. See Parse
+func Load(defaults Config, envPrefix string, getenv func(string) string, secrets SecretSource) (cfg Config, err error) {
+	cfg = defaults
+	err = Parse(NewFlagSet("stepcurry", &cfg), nil, envPrefix, getenv, secrets)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Parse sets the settings of a FlagSet created with NewFlagSet from, in increasing order of precedence, their
+// defaults, the configuration file, environment variables and args. The environment variable of a setting is its
+// name in upper case with dashes replaced by underscores, prefixed with envPrefix (i.e. STEPCURRY_BASE_URL for
+// base-url with the STEPCURRY_ prefix). Secrets still unset are then looked up from secrets, if not nil
+func Parse(fs *flag.FlagSet, args []string, envPrefix string, getenv func(string) string, secrets SecretSource) (err error) {
+	// Flags are parsed a first time to find out about the configuration file and then again once the file and
+	// environment are applied so that flags take precedence
+	err = fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	path := fs.Lookup(ConfigFlag).Value.String()
+	if path == "" {
+		path = getenv(EnvName(envPrefix, ConfigFlag))
+	}
+
+	if path != "" {
+		err = applyConfigFile(fs, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = applyEnv(fs, envPrefix, getenv)
+	if err != nil {
+		return err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if secrets != nil {
+		return applySecrets(fs, secrets)
+	}
+
+	return nil
+}
+
+// EnvName returns the name of the environment variable for a setting
+func EnvName(envPrefix string, name string) (envName string) {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyEnv sets the value of every setting that has its environment variable set
+func applyEnv(fs *flag.FlagSet, envPrefix string, getenv func(string) string) (err error) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		if value := getenv(EnvName(envPrefix, f.Name)); value != "" {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = errors.Wrapf(setErr, "invalid value [%s] for %s", value, EnvName(envPrefix, f.Name))
+			}
+		}
+	})
+
+	return err
+}
+
+// applyConfigFile sets the value of every setting present in the configuration file at path. Files with the .toml
+// extension are decoded as TOML and all others as YAML, which JSON is a subset of
+func applyConfigFile(fs *flag.FlagSet, path string) (err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "error reading configuration file [%s]", path)
+	}
+
+	settings, err := decodeConfigFile(path, content)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range settings {
+		f := fs.Lookup(name)
+		if f == nil || name == ConfigFlag {
+			return fmt.Errorf("unknown setting [%s] in configuration file [%s]", name, path)
+		}
+
+		err = f.Value.Set(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value [%s] for setting [%s] in configuration file [%s]", value, name, path)
+		}
+	}
+
+	return nil
+}
+
+// decodeConfigFile decodes the settings of a configuration file as strings. YAML and JSON scalars are kept exactly as
+// written so that unquoted values that look like numbers, such as Slack client IDs, aren't mangled by a round-trip
+// through float64. TOML has no such thing as an untyped scalar so fractional numbers are rejected and have to be
+// quoted
+func decodeConfigFile(path string, content []byte) (settings map[string]string, err error) {
+	settings = make(map[string]string)
+
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		var values map[string]interface{}
+		err = toml.Unmarshal(content, &values)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding configuration file [%s]", path)
+		}
+
+		for name, value := range values {
+			switch value.(type) {
+			case string, bool, int64:
+				settings[name] = fmt.Sprint(value)
+			case float64:
+				return nil, fmt.Errorf("invalid value [%v] for setting [%s] in configuration file [%s], should be quoted", value, name, path)
+			default:
+				return nil, fmt.Errorf("invalid value [%v] for setting [%s] in configuration file [%s], should be a scalar", value, name, path)
+			}
+		}
+
+		return settings, nil
+	}
+
+	var nodes map[string]yaml.Node
+	err = yaml.Unmarshal(content, &nodes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decoding configuration file [%s]", path)
+	}
+
+	for name, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("invalid value for setting [%s] in configuration file [%s], should be a scalar", name, path)
+		}
+
+		if node.Tag == "!!null" {
+			settings[name] = ""
+			continue
+		}
+
+		settings[name] = node.Value
+	}
+
+	return settings, nil
+}
+
+// applySecrets looks up the secret of every secret setting that's still unset
+func applySecrets(fs *flag.FlagSet, secrets SecretSource) (err error) {
+	for _, setting := range secretSettings {
+		f := fs.Lookup(setting.name)
+		if f == nil || f.Value.String() != "" {
+			continue
+		}
+
+		value, found, err := secrets.LookupSecret(setting.secretName)
+		if err != nil {
+			return errors.Wrapf(err, "error loading secret [%s] for setting [%s]", setting.secretName, setting.name)
+		}
+
+		if found {
+			err = f.Value.Set(value)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value for setting [%s] from secret [%s]", setting.name, setting.secretName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Missing returns the names of the required settings that are missing
+func (cfg Config) Missing() (missing []string) {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{baseURLSetting, cfg.BaseURL},
+		{slackAppIDSetting, cfg.SlackAppID},
+		{slackClientIDSetting, cfg.SlackClientID},
+		{slackClientSecretSetting, cfg.SlackClientSecret},
+		{slackSigningSecretSetting, cfg.SlackSigningSecret},
+		{fitbitClientIDSetting, cfg.FitbitClientID},
+		{fitbitClientSecretSetting, cfg.FitbitClientSecret},
+	}
+
+	missing = []string{}
+	for _, setting := range required {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+
+	return missing
+}
+
+// Validate returns an error listing all missing required settings or describing the first invalid one
+func (cfg Config) Validate() (err error) {
+	if missing := cfg.Missing(); len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+
+	return cfg.ValidateBaseURL()
+}
+
+// ValidateBaseURL returns an error if the base URL is set but isn't an absolute http(s) URL. It's for entrypoints that
+// report missing settings of their own along with the ones returned by Missing rather than calling Validate
+func (cfg Config) ValidateBaseURL() (err error) {
+	if cfg.BaseURL == "" {
+		return nil
+	}
+
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return fmt.Errorf("invalid %s [%s], should be an absolute http(s) URL", baseURLSetting, cfg.BaseURL)
+	}
+
+	return nil
+}
+
+// New creates a StepCurry instance with the app's credentials. Request verification and, if a verification code is
+// set, fitbit subscriptions are enabled on top of opts
+func (cfg Config) New(opts ...stepcurry.Option) (sc *stepcurry.StepCurry, err error) {
+	opts = append(opts, stepcurry.OptionSlackVerifier(cfg.SlackSigningSecret))
+	if cfg.FitbitVerificationCode != "" {
+		opts = append(opts, stepcurry.OptionFitbitSubscriber(cfg.FitbitVerificationCode))
+	}
+
+	return stepcurry.New(cfg.BaseURL, cfg.SlackAppID, cfg.FitbitClientID, cfg.FitbitClientSecret, cfg.SlackClientID, cfg.SlackClientSecret, opts...)
+}
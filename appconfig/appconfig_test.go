@@ -0,0 +1,207 @@
+package appconfig
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// complete holds all required settings, with a Slack client ID in the real format that also parses as a number
+var complete = Config{BaseURL: "https://steps.example.com", SlackAppID: "app", SlackClientID: "123456789012.1234567890123", SlackClientSecret: "slackSecret", SlackSigningSecret: "signing", FitbitClientID: "fitbitID", FitbitClientSecret: "fitbitSecret"}
+
+// fakeSecrets is a SecretSource recording the secrets looked up
+type fakeSecrets struct {
+	secrets  map[string]string
+	err      error
+	lookedUp []string
+}
+
+func (fs *fakeSecrets) LookupSecret(name string) (value string, found bool, err error) {
+	fs.lookedUp = append(fs.lookedUp, name)
+	if fs.err != nil {
+		return "", false, fs.err
+	}
+
+	value, found = fs.secrets[name]
+	return value, found, nil
+}
+
+func envFrom(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "appconfig")
+	require.NoError(t, err)
+
+	path = filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigFiles(t *testing.T) {
+	tests := map[string]struct {
+		content string
+	}{
+		"config.yaml": {content: "base-url: https://steps.example.com\nslack-app-id: app\nslack-client-id: 123456789012.1234567890123\nslack-client-secret: slackSecret\nslack-signing-secret: signing\nfitbit-client-id: fitbitID\nfitbit-client-secret: fitbitSecret\n"},
+		"config.toml": {content: "base-url = \"https://steps.example.com\"\nslack-app-id = \"app\"\nslack-client-id = \"123456789012.1234567890123\"\nslack-client-secret = \"slackSecret\"\nslack-signing-secret = \"signing\"\nfitbit-client-id = \"fitbitID\"\nfitbit-client-secret = \"fitbitSecret\"\n"},
+		"config.json": {content: `{"base-url": "https://steps.example.com", "slack-app-id": "app", "slack-client-id": 123456789012.1234567890123, "slack-client-secret": "slackSecret", "slack-signing-secret": "signing", "fitbit-client-id": "fitbitID", "fitbit-client-secret": "fitbitSecret"}`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path, cleanup := writeConfigFile(t, name, tc.content)
+			defer cleanup()
+
+			cfg, err := Load(Config{}, "STEPCURRY_", envFrom(map[string]string{"STEPCURRY_CONFIG": path}), nil)
+			require.NoError(t, err)
+
+			expected := complete
+			expected.ConfigFile = path
+			assert.Equal(t, expected, cfg)
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "config.yaml", "base-url: https://file.example.com\nslack-app-id: fileApp\nslack-client-id: fileSlackID\n")
+	defer cleanup()
+
+	env := map[string]string{"CONFIG": path, "SLACK_APP_ID": "envApp", "SLACK_CLIENT_SECRET": "envSlackSecret"}
+	secrets := &fakeSecrets{secrets: map[string]string{"slackAppID": "secretApp", "slackClientSecret": "secretSlackSecret", "slackSigningSecret": "secretSigning", "fitbitClientID": "secretFitbitID", "fitbitClientSecret": "secretFitbitSecret"}}
+
+	cfg, err := Load(Config{BaseURL: "https://default.example.com", FitbitClientID: "defaultFitbitID"}, "", envFrom(env), secrets)
+	require.NoError(t, err)
+
+	// The environment wins over the file which wins over defaults. Secrets are only looked up for unset settings
+	assert.Equal(t, Config{ConfigFile: path, BaseURL: "https://file.example.com", SlackAppID: "envApp", SlackClientID: "fileSlackID", SlackClientSecret: "envSlackSecret", SlackSigningSecret: "secretSigning", FitbitClientID: "defaultFitbitID", FitbitClientSecret: "secretFitbitSecret"}, cfg)
+	assert.Equal(t, []string{"slackSigningSecret", "fitbitClientSecret", "fitbitSubscriberVerificationCode"}, secrets.lookedUp)
+}
+
+func TestParseFlagsWinOverEnvironment(t *testing.T) {
+	var cfg Config
+	var addr string
+	fs := NewFlagSet("test", &cfg)
+	fs.StringVar(&addr, "addr", ":8080", "address to listen on")
+
+	env := map[string]string{"STEPCURRY_ADDR": ":7070", "STEPCURRY_SLACK_APP_ID": "envApp", "STEPCURRY_FITBIT_VERIFICATION_CODE": "verifyme"}
+	err := Parse(fs, []string{"-slack-app-id", "flagApp"}, "STEPCURRY_", envFrom(env), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7070", addr)
+	assert.Equal(t, "flagApp", cfg.SlackAppID)
+	assert.Equal(t, "verifyme", cfg.FitbitVerificationCode)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]struct {
+		defaults      Config
+		env           map[string]string
+		fileName      string
+		fileContent   string
+		secrets       SecretSource
+		expectedError string
+	}{
+		"MissingRequired": {
+			defaults:      Config{BaseURL: "https://steps.example.com", SlackAppID: "app"},
+			expectedError: "missing required settings: slack-client-id, slack-client-secret, slack-signing-secret, fitbit-client-id, fitbit-client-secret",
+		},
+		"InvalidBaseURL": {
+			defaults:      Config{BaseURL: "/steps", SlackAppID: "app", SlackClientID: "slackID", SlackClientSecret: "slackSecret", SlackSigningSecret: "signing", FitbitClientID: "fitbitID", FitbitClientSecret: "fitbitSecret"},
+			expectedError: "invalid base-url [/steps], should be an absolute http(s) URL",
+		},
+		"SecretError": {
+			defaults:      complete,
+			secrets:       &fakeSecrets{err: fmt.Errorf("permission denied")},
+			expectedError: "error loading secret [fitbitSubscriberVerificationCode] for setting [fitbit-verification-code]: permission denied",
+		},
+		"UnknownFileSetting": {
+			fileName:      "config.yaml",
+			fileContent:   "colour: blue",
+			expectedError: "unknown setting [colour] in configuration file",
+		},
+		"NestedFileSetting": {
+			fileName:      "config.toml",
+			fileContent:   "[base-url]\nhost = \"steps.example.com\"",
+			expectedError: "should be a scalar",
+		},
+		"UnquotedTOMLNumber": {
+			fileName:      "config.toml",
+			fileContent:   "slack-client-id = 123456789012.1234567890123",
+			expectedError: "invalid value [1.2345678901212346e+11] for setting [slack-client-id] in configuration file",
+		},
+		"MalformedYAML": {
+			fileName:      "config.yaml",
+			fileContent:   "base-url: [",
+			expectedError: "error decoding configuration file",
+		},
+		"MalformedTOML": {
+			fileName:      "config.toml",
+			fileContent:   "base-url = ",
+			expectedError: "error decoding configuration file",
+		},
+		"MissingFile": {
+			env:           map[string]string{"CONFIG": "/nonexistent/config.yaml"},
+			expectedError: "error reading configuration file [/nonexistent/config.yaml]",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			env := tc.env
+			if tc.fileContent != "" {
+				path, cleanup := writeConfigFile(t, tc.fileName, tc.fileContent)
+				defer cleanup()
+
+				env = map[string]string{"CONFIG": path}
+			}
+
+			_, err := Load(tc.defaults, "", envFrom(env), tc.secrets)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+func TestParseInvalidEnvValue(t *testing.T) {
+	var cfg Config
+	var timeout time.Duration
+	fs := NewFlagSet("test", &cfg)
+	fs.DurationVar(&timeout, "shutdown-timeout", time.Second, "shutdown timeout")
+
+	err := Parse(fs, nil, "STEPCURRY_", envFrom(map[string]string{"STEPCURRY_SHUTDOWN_TIMEOUT": "soon"}), nil)
+	assert.Contains(t, err.Error(), "invalid value [soon] for STEPCURRY_SHUTDOWN_TIMEOUT")
+}
+
+func TestNew(t *testing.T) {
+	cfg := complete
+	cfg.FitbitVerificationCode = "verifyme"
+
+	storage := stepcurry.NewMemoryStorage()
+	router, err := stepcurry.NewMultiTenantRouter("project", storage, nil, nil, false)
+	require.NoError(t, err)
+
+	// The slack verifier is set from the signing secret
+	sc, err := cfg.New(stepcurry.OptionStorage(storage), stepcurry.OptionTeamRouter(router), stepcurry.OptionScheduler(stepcurry.NewLocalScheduler(storage, stepcurry.NewSystemClock())))
+	require.NoError(t, err)
+	assert.NotNil(t, sc)
+}
+
+func TestNewFlagSetUsesConfigAsDefaults(t *testing.T) {
+	cfg := Config{BaseURL: "https://steps.example.com"}
+	fs := NewFlagSet("test", &cfg)
+
+	assert.Equal(t, "https://steps.example.com", fs.Lookup("base-url").DefValue)
+	assert.Equal(t, flag.ContinueOnError, fs.ErrorHandling())
+}
@@ -0,0 +1,69 @@
+package stepcurry
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock defines the interface for telling the current time. It allows running challenges against a controlled time
+// in tests and local development
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+// systemClock is the Clock telling the actual time
+type systemClock struct{}
+
+// Now returns time.Now()
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewSystemClock returns the Clock telling the actual time
+func NewSystemClock() (clock Clock) {
+	return systemClock{}
+}
+
+// OptionClock sets a clock as the source of the current time on StepCurry. It defaults to the system clock
+func OptionClock(clock Clock) Option {
+	return func(sc *StepCurry) (err error) {
+		sc.clock = clock
+		return nil
+	}
+}
+
+// FakeClock is a Clock that only moves when told to. It's safe for concurrent use
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a new FakeClock set to the given time
+func NewFakeClock(now time.Time) (clock *FakeClock) {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake clock's current time
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.now
+}
+
+// Set sets the fake clock's current time
+func (fc *FakeClock) Set(now time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.now = now
+}
+
+// Advance moves the fake clock's current time forward by d
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.now = fc.now.Add(d)
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 
 	"github.com/alexandre-normand/stepcurry"
+	"github.com/alexandre-normand/stepcurry/appconfig"
+	"github.com/alexandre-normand/stepcurry/secretstore"
 	"github.com/spf13/cast"
 )
 
@@ -26,10 +28,24 @@ var sc *stepcurry.StepCurry
 func init() {
 	projectID := os.Getenv(projectIDEnv)
 	region := os.Getenv(regionEnv)
+	debug := cast.ToBool(os.Getenv(debugEnv))
 
-	appID, slackClientID, slackClientSecret, slackSigningSecret, fitbitClientID, fitbitClientSecret, err := loadSecrets(projectID)
+	// Entries are written in Cloud Logging's structured format so that their severity and fields are picked up
+	minSeverity := stepcurry.SeverityInfo
+	if debug {
+		minSeverity = stepcurry.SeverityDebug
+	}
+	logger := stepcurry.NewJSONLogger(os.Stderr, minSeverity)
+
+	secrets, err := NewSecretManagerSource(projectID)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize Secret Manager: %s", err.Error()))
+	}
+
+	// Settings are read from the environment and, for credentials that aren't set there, from Secret Manager
+	cfg, err := appconfig.Load(appconfig.Config{BaseURL: inferBaseURL(projectID, region)}, "", os.Getenv, secrets)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to load Step Curry secrets: %s", err.Error()))
+		panic(fmt.Sprintf("Failed to load Step Curry configuration: %s", err.Error()))
 	}
 
 	storer, err := stepcurry.NewDatastorer(projectID)
@@ -42,17 +58,34 @@ func init() {
 		panic(fmt.Sprintf("Failed to initialize Cloud Tasks Client: %s", err.Error()))
 	}
 
-	tokenManager := NewMultiTenantTokenManager(projectID)
-	router, err := stepcurry.NewMultiTenantRouter(projectID, storer, tokenManager, tokenManager, cast.ToBool(os.Getenv(debugEnv)))
+	// Tokens are cached so that routing a team whose services were evicted or expired doesn't hit Secret Manager. The
+	// router makes the cache forget the token of a team it invalidates
+	secretManagerTokens := NewMultiTenantTokenManager(projectID)
+	tokenManager := secretstore.NewCachingTokenManager(secretManagerTokens, secretManagerTokens, secretstore.DefaultTokenCacheTTL)
+	router, err := stepcurry.NewMultiTenantRouter(projectID, stepcurry.NewDatastoreStorage(storer), tokenManager, tokenManager, debug)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize Step Curry: %s", err.Error()))
 	}
 
-	step, err := stepcurry.New(inferBaseURL(projectID, region), appID, fitbitClientID, fitbitClientSecret, slackClientID, slackClientSecret, stepcurry.OptionSlackVerifier(slackSigningSecret), stepcurry.OptionStorer(storer), stepcurry.OptionTeamRouter(router), stepcurry.OptionTaskScheduler(taskScheduler))
+	// Fitbit subscriptions are optional, steps are polled for every update without them
+	if cfg.FitbitVerificationCode == "" {
+		logger.Log(stepcurry.SeverityWarning, "Fitbit subscriptions disabled, no subscriber verification code configured", nil)
+	}
+
+	step, err := cfg.New(stepcurry.OptionStorer(storer), stepcurry.OptionTeamRouter(router), stepcurry.OptionTaskScheduler(taskScheduler), stepcurry.OptionLogger(logger))
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize Step Curry: %s", err.Error()))
 	}
 
+	// Rotating tokens are refreshed with the app's client credentials
+	router.UseTokenRefresher(step.SlackTokenRefresher())
+
+	// Every sweep schedules the next one so this only starts the chain of sweeps if it isn't already going
+	err = step.ScheduleTokenSweeps()
+	if err != nil {
+		logger.Log(stepcurry.SeverityError, "Error scheduling token sweep", stepcurry.Fields{"error": err.Error()})
+	}
+
 	sc = step
 }
 
@@ -62,27 +95,72 @@ func inferBaseURL(projectID string, region string) (baseURL string) {
 
 // LinkAccount handles a request to link a Fitbit account
 func LinkAccount(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.StartFitbitOauthFlow).ServeHTTP(w, r)
+	sc.Handle(sc.StartFitbitOauthFlow).ServeHTTP(w, r)
 }
 
 // Challenge handles a request to create a new steps challenge
 func Challenge(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.Challenge).ServeHTTP(w, r)
+	sc.Handle(sc.Challenge).ServeHTTP(w, r)
 }
 
 // UpdateChallenge handles a request to update a challenge with latest standings
 func UpdateChallenge(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.UpdateChallenge).ServeHTTP(w, r)
+	sc.Handle(sc.UpdateChallenge).ServeHTTP(w, r)
 }
 
 // HandleFitbitAuth handles the fitbit oauth flow response
 func HandleFitbitAuth(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.HandleFitbitAuth).ServeHTTP(w, r)
+	sc.Handle(sc.HandleFitbitAuth).ServeHTTP(w, r)
 }
 
 // Standings handles a request for current challenge standings
 func Standings(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.Standings).ServeHTTP(w, r)
+	sc.Handle(sc.Standings).ServeHTTP(w, r)
+}
+
+// Leaderboard handles a request for standings across past challenges
+func Leaderboard(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Leaderboard).ServeHTTP(w, r)
+}
+
+// Badges handles a request for a user's badge collection
+func Badges(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Badges).ServeHTTP(w, r)
+}
+
+// Reminders handles a request to opt in or out of streak reminders
+func Reminders(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Reminders).ServeHTTP(w, r)
+}
+
+// Me handles a request for a user's private stats
+func Me(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Me).ServeHTTP(w, r)
+}
+
+// SweepTokens handles a request to refresh stored Fitbit tokens nearing expiry or idle for a long time
+func SweepTokens(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.SweepTokens).ServeHTTP(w, r)
+}
+
+// FitbitSubscription handles Fitbit subscriber verification and activity notifications
+func FitbitSubscription(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.FitbitSubscription).ServeHTTP(w, r)
+}
+
+// RecordActivity handles a request to record a user's activity following a Fitbit notification
+func RecordActivity(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.RecordActivity).ServeHTTP(w, r)
+}
+
+// PublishHome handles a request to publish a user's app home following an app_home_opened event
+func PublishHome(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.PublishHome).ServeHTTP(w, r)
+}
+
+// EvaluatePerfectMonth handles a request to award perfect month badges once a month of a channel is over
+func EvaluatePerfectMonth(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.EvaluatePerfectMonth).ServeHTTP(w, r)
 }
 
 // InvokeSlackAuth starts the oauth flow with slack
@@ -92,5 +170,20 @@ func InvokeSlackAuth(w http.ResponseWriter, r *http.Request) {
 
 // HandleSlackAuth handles the slack oauth flow response
 func HandleSlackAuth(w http.ResponseWriter, r *http.Request) {
-	stepcurry.Handler(sc.HandleSlackAuth).ServeHTTP(w, r)
+	sc.Handle(sc.HandleSlackAuth).ServeHTTP(w, r)
+}
+
+// Config handles a request to open the workspace settings modal
+func Config(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Config).ServeHTTP(w, r)
+}
+
+// Interaction handles slack interactions such as modal submissions and button clicks
+func Interaction(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Interaction).ServeHTTP(w, r)
+}
+
+// Events handles slack events such as users opening the app home
+func Events(w http.ResponseWriter, r *http.Request) {
+	sc.Handle(sc.Events).ServeHTTP(w, r)
 }
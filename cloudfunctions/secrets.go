@@ -3,28 +3,27 @@ package cloudfunctions
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
+	"github.com/alexandre-normand/stepcurry"
 	"google.golang.org/api/googleapi"
 	secretmanager "google.golang.org/api/secretmanager/v1"
 )
 
-// Secret names
+// Secret names. The app's credentials are loaded as appconfig secrets
 const (
-	slackAppIDKey         = "slackAppID"
-	slackTokenKey         = "slackToken"
-	slackClientIDKey      = "slackClientID"
-	slackClientSecretKey  = "slackClientSecret"
-	signingSecretKey      = "slackSigningSecret"
-	fitbitClientIDKey     = "fitbitClientID"
-	fitbitClientSecretKey = "fitbitClientSecret"
+	slackTokenKey = "slackToken"
 )
 
 // MultiTenantTokenManager holds data for a MultiTenantTokenManager
 type MultiTenantTokenManager struct {
 	projectID string
+	mu        sync.Mutex
+	service   *secretmanager.Service
 }
 
 // NewMultiTenantTokenManager creates a new instance of MultiTenantTokenManager
@@ -35,28 +34,43 @@ func NewMultiTenantTokenManager(projectID string) (mtTokenManager *MultiTenantTo
 	return mtTokenManager
 }
 
-// LoadToken loads a team slack token
-func (mtManager *MultiTenantTokenManager) LoadToken(teamID string) (token string, err error) {
-	ctx := context.Background()
-	ss, err := secretmanager.NewService(ctx)
+// secretManager returns the Secret Manager service, creating it on first use. It's shared by all calls rather than
+// created for every token loaded or saved
+func (mtManager *MultiTenantTokenManager) secretManager() (ss *secretmanager.Service, err error) {
+	mtManager.mu.Lock()
+	defer mtManager.mu.Unlock()
+
+	if mtManager.service == nil {
+		mtManager.service, err = secretmanager.NewService(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mtManager.service, nil
+}
+
+// LoadToken loads the slack token of a team or, for org-wide installations, of an enterprise
+func (mtManager *MultiTenantTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	ss, err := mtManager.secretManager()
 	if err != nil {
-		return "", err
+		return token, err
 	}
 
 	psvs := secretmanager.NewProjectsSecretsVersionsService(ss)
-	token, err = getSecret(psvs, mtManager.projectID, formatSecretKeyWithTeamNamespace(teamID, slackTokenKey))
+	secret, err := getSecret(psvs, mtManager.projectID, formatSecretKeyWithTeamNamespace(tenantID, slackTokenKey))
 
 	if err != nil {
-		return "", err
+		return token, err
 	}
 
-	return token, nil
+	return decodeSlackToken(secret)
 }
 
-// SaveToken saves a team slack token
-func (mtManager *MultiTenantTokenManager) SaveToken(teamID string, token string) (err error) {
-	ctx := context.Background()
-	ss, err := secretmanager.NewService(ctx)
+// SaveToken saves the slack token of an installation under its tenant ID. The secret is labeled with the team and
+// enterprise of the installation
+func (mtManager *MultiTenantTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	ss, err := mtManager.secretManager()
 	if err != nil {
 		return err
 	}
@@ -64,13 +78,13 @@ func (mtManager *MultiTenantTokenManager) SaveToken(teamID string, token string)
 	psvs := secretmanager.NewProjectsSecretsVersionsService(ss)
 	pss := secretmanager.NewProjectsSecretsService(ss)
 
-	tokenQualifiedKey := formatSecretKeyWithTeamNamespace(teamID, slackTokenKey)
+	tokenQualifiedKey := formatSecretKeyWithTeamNamespace(installation.TenantID(), slackTokenKey)
 	fullyQualifiedSecretName := formatSecretName(mtManager.projectID, tokenQualifiedKey)
 	_, err = getSecret(psvs, mtManager.projectID, tokenQualifiedKey)
 	if err != nil {
 		if apiError, ok := err.(*googleapi.Error); ok && apiError.Code == http.StatusNotFound {
 			// Create the secret
-			call := pss.Create(fmt.Sprintf("projects/%s", mtManager.projectID), &secretmanager.Secret{Labels: map[string]string{"team": strings.ToLower(teamID)}, Replication: &secretmanager.Replication{Automatic: new(secretmanager.Automatic)}})
+			call := pss.Create(fmt.Sprintf("projects/%s", mtManager.projectID), &secretmanager.Secret{Labels: installationLabels(installation), Replication: &secretmanager.Replication{Automatic: new(secretmanager.Automatic)}})
 			call = call.SecretId(tokenQualifiedKey)
 
 			_, err = call.Do()
@@ -85,51 +99,83 @@ func (mtManager *MultiTenantTokenManager) SaveToken(teamID string, token string)
 		}
 	}
 
-	call := pss.AddVersion(fullyQualifiedSecretName, &secretmanager.AddSecretVersionRequest{Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(token))}})
+	secret, err := encodeSlackToken(token)
+	if err != nil {
+		return err
+	}
+
+	call := pss.AddVersion(fullyQualifiedSecretName, &secretmanager.AddSecretVersionRequest{Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(secret))}})
 	_, err = call.Do()
 	return err
 }
 
-func loadSecrets(projectID string) (appID, slackClientID, slackClientSecret, slackSigningSecret, fitbitClientID, fitbitClientSecret string, err error) {
-	ctx := context.Background()
-	ss, err := secretmanager.NewService(ctx)
-	if err != nil {
-		return "", "", "", "", "", "", err
+// encodeSlackToken encodes a slack token as a secret. Tokens that don't rotate are kept as the plain access token like
+// they were before token rotation
+func encodeSlackToken(token stepcurry.SlackToken) (secret string, err error) {
+	if !token.Rotating() {
+		return token.AccessToken, nil
 	}
 
-	psvs := secretmanager.NewProjectsSecretsVersionsService(ss)
-
-	appID, err = getSecret(psvs, projectID, slackAppIDKey)
+	encoded, err := json.Marshal(token)
 	if err != nil {
-		return "", "", "", "", "", "", err
+		return "", err
 	}
 
-	slackSigningSecret, err = getSecret(psvs, projectID, signingSecretKey)
-	if err != nil {
-		return "", "", "", "", "", "", err
+	return string(encoded), nil
+}
+
+// decodeSlackToken decodes a slack token secret encoded with encodeSlackToken
+func decodeSlackToken(secret string) (token stepcurry.SlackToken, err error) {
+	if !strings.HasPrefix(secret, "{") {
+		return stepcurry.SlackToken{AccessToken: secret}, nil
 	}
 
-	slackClientID, err = getSecret(psvs, projectID, slackClientIDKey)
-	if err != nil {
-		return "", "", "", "", "", "", err
+	err = json.Unmarshal([]byte(secret), &token)
+	return token, err
+}
+
+// installationLabels returns the labels of the token secret of an installation
+func installationLabels(installation stepcurry.Installation) (labels map[string]string) {
+	labels = make(map[string]string)
+	if installation.TeamID != "" {
+		labels["team"] = strings.ToLower(installation.TeamID)
 	}
 
-	slackClientSecret, err = getSecret(psvs, projectID, slackClientSecretKey)
-	if err != nil {
-		return "", "", "", "", "", "", err
+	if installation.EnterpriseID != "" {
+		labels["enterprise"] = strings.ToLower(installation.EnterpriseID)
 	}
 
-	fitbitClientID, err = getSecret(psvs, projectID, fitbitClientIDKey)
+	return labels
+}
+
+// SecretManagerSource is an appconfig.SecretSource looking up secrets in Google Secret Manager
+type SecretManagerSource struct {
+	projectID string
+	psvs      *secretmanager.ProjectsSecretsVersionsService
+}
+
+// NewSecretManagerSource creates a new SecretManagerSource for the secrets of a project
+func NewSecretManagerSource(projectID string) (smSource *SecretManagerSource, err error) {
+	ss, err := secretmanager.NewService(context.Background())
 	if err != nil {
-		return "", "", "", "", "", "", err
+		return nil, err
 	}
 
-	fitbitClientSecret, err = getSecret(psvs, projectID, fitbitClientSecretKey)
+	return &SecretManagerSource{projectID: projectID, psvs: secretmanager.NewProjectsSecretsVersionsService(ss)}, nil
+}
+
+// LookupSecret returns the latest version of a secret. Secrets that don't exist or have no versions aren't found
+func (smSource *SecretManagerSource) LookupSecret(name string) (value string, found bool, err error) {
+	value, err = getSecret(smSource.psvs, smSource.projectID, name)
 	if err != nil {
-		return "", "", "", "", "", "", err
+		if apiError, ok := err.(*googleapi.Error); ok && apiError.Code == http.StatusNotFound {
+			return "", false, nil
+		}
+
+		return "", false, err
 	}
 
-	return appID, slackClientID, slackClientSecret, slackSigningSecret, fitbitClientID, fitbitClientSecret, nil
+	return value, true, nil
 }
 
 func getSecret(psvs *secretmanager.ProjectsSecretsVersionsService, projectID string, key string) (value string, err error) {
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	gax "github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
 	"google.golang.org/api/option"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"time"
 )
 
@@ -93,34 +96,104 @@ func NewTaskScheduler(gcpProject string, gcpLocation string, taskQueueName strin
 	return ctc, nil
 }
 
-// scheduleChallengeUpdate creates a new task to update a challenge at the given scheduled time
-func (sc *StepCurry) scheduleChallengeUpdate(challengeID ChallengeID, scheduledTime time.Time) (err error) {
-	queueID := sc.taskScheduler.GenerateQueueID()
+// cloudTasksScheduler is a Scheduler creating Cloud Tasks that deliver jobs as http requests to the app's handlers
+type cloudTasksScheduler struct {
+	taskScheduler TaskScheduler
+	baseURL       string
+	paths         Paths
+}
+
+// newCloudTasksScheduler creates a Scheduler creating tasks with taskScheduler. The tasks call the handlers mounted
+// under baseURL at the given paths
+func newCloudTasksScheduler(taskScheduler TaskScheduler, baseURL string, paths Paths) (scheduler *cloudTasksScheduler) {
+	return &cloudTasksScheduler{taskScheduler: taskScheduler, baseURL: baseURL, paths: paths}
+}
+
+// Schedule creates a new task to update a challenge at the given scheduled time
+func (cts *cloudTasksScheduler) Schedule(ctx context.Context, challengeID ChallengeID, scheduledTime time.Time) (err error) {
+	message, err := json.Marshal(challengeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = cts.taskScheduler.CreateTask(ctx, cts.newTaskRequest(cts.taskScheduler.GenerateQueueID(), "", cts.paths.UpdateChallenge, message, scheduledTime))
+
+	return err
+}
+
+// ScheduleTokenSweep creates a task for a token sweep. Tasks are named after the sweep time so that scheduling the
+// same sweep more than once results in a single task
+func (cts *cloudTasksScheduler) ScheduleTokenSweep(ctx context.Context, scheduledTime time.Time) (err error) {
+	queueID := cts.taskScheduler.GenerateQueueID()
+	name := fmt.Sprintf("%s/tasks/token-sweep-%d", queueID, scheduledTime.Unix())
+
+	_, err = cts.taskScheduler.CreateTask(ctx, cts.newTaskRequest(queueID, name, cts.paths.SweepTokens, nil, scheduledTime))
+	if status.Code(errors.Cause(err)) == codes.AlreadyExists {
+		return nil
+	}
+
+	return err
+}
+
+// ScheduleActivityUpdate creates a task recording the activity of a fitbit user at the given time
+func (cts *cloudTasksScheduler) ScheduleActivityUpdate(ctx context.Context, update ActivityUpdate, scheduledTime time.Time) (err error) {
+	message, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = cts.taskScheduler.CreateTask(ctx, cts.newTaskRequest(cts.taskScheduler.GenerateQueueID(), "", cts.paths.RecordActivity, message, scheduledTime))
+
+	return err
+}
+
+// ScheduleHomePublish creates a task publishing the app home of a user at the given time
+func (cts *cloudTasksScheduler) ScheduleHomePublish(ctx context.Context, update HomeUpdate, scheduledTime time.Time) (err error) {
+	message, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = cts.taskScheduler.CreateTask(ctx, cts.newTaskRequest(cts.taskScheduler.GenerateQueueID(), "", cts.paths.PublishHome, message, scheduledTime))
+
+	return err
+}
+
+// SchedulePerfectMonth creates a task evaluating perfect months of a channel at the given time. Tasks are named after
+// the channel and month so that scheduling the same evaluation more than once results in a single task
+func (cts *cloudTasksScheduler) SchedulePerfectMonth(ctx context.Context, evaluation PerfectMonthEvaluation, scheduledTime time.Time) (err error) {
+	message, err := json.Marshal(evaluation)
+	if err != nil {
+		return err
+	}
+
+	queueID := cts.taskScheduler.GenerateQueueID()
+	name := fmt.Sprintf("%s/tasks/%s", queueID, perfectMonthJobName(evaluation))
+
+	_, err = cts.taskScheduler.CreateTask(ctx, cts.newTaskRequest(queueID, name, cts.paths.PerfectMonth, message, scheduledTime))
+	if status.Code(errors.Cause(err)) == codes.AlreadyExists {
+		return nil
+	}
 
+	return err
+}
+
+// newTaskRequest creates the request for a task in a queue posting body to the handler at path at the scheduled time
+func (cts *cloudTasksScheduler) newTaskRequest(queueID string, name string, path string, body []byte, scheduledTime time.Time) (req *taskspb.CreateTaskRequest) {
 	scheduledTimestamp := timestamp.Timestamp{Seconds: scheduledTime.Unix()}
 
-	req := &taskspb.CreateTaskRequest{
+	return &taskspb.CreateTaskRequest{
 		Parent: queueID,
 		Task: &taskspb.Task{
+			Name: name,
 			PayloadType: &taskspb.Task_HttpRequest{
 				HttpRequest: &taskspb.HttpRequest{
 					HttpMethod: taskspb.HttpMethod_POST,
-					Url:        fmt.Sprintf("%s/%s", sc.baseURL, sc.paths.UpdateChallenge),
+					Url:        fmt.Sprintf("%s/%s", cts.baseURL, path),
+					Body:       body,
 				},
 			},
 			ScheduleTime: &scheduledTimestamp,
 		},
 	}
-
-	message, err := json.Marshal(challengeID)
-	if err != nil {
-		return err
-	}
-
-	req.Task.GetHttpRequest().Body = message
-
-	ctx := context.Background()
-	_, err = sc.taskScheduler.CreateTask(ctx, req)
-
-	return err
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry/appconfig"
+)
+
+const (
+	// envPrefix is the prefix of environment variables overriding settings. The variable name for a setting is its
+	// flag name in upper case with dashes replaced by underscores (i.e. STEPCURRY_BASE_URL for -base-url)
+	envPrefix = "STEPCURRY_"
+)
+
+// Storage backends
+const (
+	storageDatastore = "datastore"
+	storagePostgres  = "postgres"
+	storageSQLite    = "sqlite"
+	storageMemory    = "memory"
+)
+
+// Schedulers
+const (
+	schedulerCloudTasks = "cloudtasks"
+	schedulerLocal      = "local"
+)
+
+// config holds the configuration of a self-hosted Step Curry server. The app's credentials and base URL are the
+// settings shared with other entrypoints
+type config struct {
+	appconfig.Config
+	Addr            string
+	ShutdownTimeout time.Duration
+	Debug           bool
+	Storage         string
+	PostgresDSN     string
+	SQLitePath      string
+	Scheduler       string
+	PollInterval    time.Duration
+	GCPProjectID    string
+	GCPRegion       string
+	TaskQueue       string
+	SlackToken      string
+}
+
+// newFlagSet creates a FlagSet with all settings bound to the fields of cfg
+func newFlagSet(cfg *config) (fs *flag.FlagSet) {
+	fs = appconfig.NewFlagSet("stepcurry", &cfg.Config)
+
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "time to wait for in-flight requests on shutdown")
+	fs.BoolVar(&cfg.Debug, "debug", false, "enables debug logging, including slack api calls")
+	fs.StringVar(&cfg.Storage, "storage", storageDatastore, "storage backend, one of datastore, postgres, sqlite or memory. memory loses all data on exit and is meant for local development")
+	fs.StringVar(&cfg.PostgresDSN, "postgres-dsn", "", "postgres data source name, required with the postgres storage")
+	fs.StringVar(&cfg.SQLitePath, "sqlite-path", "stepcurry.db", "path of the sqlite database file, used with the sqlite storage")
+	fs.StringVar(&cfg.Scheduler, "scheduler", schedulerCloudTasks, "scheduler of challenge updates, one of cloudtasks or local. local persists jobs with the storage backend and runs them from a polling worker")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 10*time.Second, "interval at which the local scheduler polls for due jobs")
+	fs.StringVar(&cfg.GCPProjectID, "gcp-project-id", "", "gcp project of the datastore and cloud tasks queue")
+	fs.StringVar(&cfg.GCPRegion, "gcp-region", "", "gcp region of the cloud tasks queue")
+	fs.StringVar(&cfg.TaskQueue, "task-queue", "challenge-updates", "name of the cloud tasks queue for challenge updates")
+	fs.StringVar(&cfg.SlackToken, "slack-token", "", "slack bot token of the workspace")
+
+	return fs
+}
+
+// loadConfig loads the configuration from, in increasing order of precedence, defaults, a configuration file,
+// environment variables and command-line flags
+func loadConfig(args []string, getenv func(string) string) (cfg config, err error) {
+	err = appconfig.Parse(newFlagSet(&cfg), args, envPrefix, getenv, nil)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+// setting is the name and value of a setting checked by validate
+type setting struct {
+	name  string
+	value string
+}
+
+// validate returns an error listing all required settings that are missing
+func (cfg config) validate() (err error) {
+	required := []setting{}
+
+	if cfg.Storage == storageDatastore || cfg.Scheduler == schedulerCloudTasks {
+		required = append(required, setting{"gcp-project-id", cfg.GCPProjectID})
+	}
+
+	switch cfg.Scheduler {
+	case schedulerCloudTasks:
+		required = append(required, setting{"gcp-region", cfg.GCPRegion}, setting{"task-queue", cfg.TaskQueue})
+	case schedulerLocal:
+		if cfg.PollInterval <= 0 {
+			return fmt.Errorf("invalid poll-interval [%s], should be positive", cfg.PollInterval)
+		}
+	default:
+		return fmt.Errorf("unknown scheduler [%s], should be one of %s or %s", cfg.Scheduler, schedulerCloudTasks, schedulerLocal)
+	}
+
+	required = append(required, setting{"slack-token", cfg.SlackToken})
+
+	switch cfg.Storage {
+	case storageDatastore, storageMemory:
+	case storagePostgres:
+		required = append(required, setting{"postgres-dsn", cfg.PostgresDSN})
+	case storageSQLite:
+		required = append(required, setting{"sqlite-path", cfg.SQLitePath})
+	default:
+		return fmt.Errorf("unknown storage [%s], should be one of %s, %s, %s or %s", cfg.Storage, storageDatastore, storagePostgres, storageSQLite, storageMemory)
+	}
+
+	missing := cfg.Config.Missing()
+	for _, setting := range required {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+
+	return cfg.ValidateBaseURL()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry/appconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var requiredArgs = []string{"-base-url", "https://steps.example.com", "-gcp-project-id", "project", "-gcp-region", "us-central1", "-slack-app-id", "app", "-slack-client-id", "slackID", "-slack-client-secret", "slackSecret", "-slack-signing-secret", "signing", "-slack-token", "xoxb-token", "-fitbit-client-id", "fitbitID", "-fitbit-client-secret", "fitbitSecret"}
+
+func envFrom(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "stepcurry")
+	require.NoError(t, err)
+
+	path = filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigFromFlags(t *testing.T) {
+	cfg, err := loadConfig(append(requiredArgs, "-debug", "-fitbit-verification-code", "verifyme"), envFrom(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, config{Config: appconfig.Config{BaseURL: "https://steps.example.com", SlackAppID: "app", SlackClientID: "slackID", SlackClientSecret: "slackSecret", SlackSigningSecret: "signing", FitbitClientID: "fitbitID", FitbitClientSecret: "fitbitSecret", FitbitVerificationCode: "verifyme"}, Addr: ":8080", ShutdownTimeout: 15 * time.Second, Debug: true, Storage: "datastore", SQLitePath: "stepcurry.db", Scheduler: "cloudtasks", PollInterval: 10 * time.Second, GCPProjectID: "project", GCPRegion: "us-central1", TaskQueue: "challenge-updates", SlackToken: "xoxb-token"}, cfg)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path, cleanup := writeConfigFile(t, `{"addr": ":9090", "base-url": "https://file.example.com", "shutdown-timeout": "30s", "task-queue": "file-queue", "debug": true}`)
+	defer cleanup()
+
+	env := map[string]string{"STEPCURRY_CONFIG": path, "STEPCURRY_TASK_QUEUE": "env-queue", "STEPCURRY_ADDR": ":7070"}
+	cfg, err := loadConfig(append(requiredArgs, "-addr", ":6060"), envFrom(env))
+	require.NoError(t, err)
+
+	// Flags win over the environment which wins over the file
+	assert.Equal(t, ":6060", cfg.Addr)
+	assert.Equal(t, "env-queue", cfg.TaskQueue)
+	assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout)
+	assert.Equal(t, true, cfg.Debug)
+	assert.Equal(t, "https://steps.example.com", cfg.BaseURL)
+}
+
+func TestLoadConfigFromFileOnly(t *testing.T) {
+	path, cleanup := writeConfigFile(t, `{"base-url": "https://steps.example.com", "gcp-project-id": "project", "gcp-region": "us-central1", "slack-app-id": "app", "slack-client-id": "slackID", "slack-client-secret": "slackSecret", "slack-signing-secret": "signing", "slack-token": "xoxb-token", "fitbit-client-id": "fitbitID", "fitbit-client-secret": "fitbitSecret"}`)
+	defer cleanup()
+
+	cfg, err := loadConfig([]string{"-config", path}, envFrom(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://steps.example.com", cfg.BaseURL)
+	assert.Equal(t, "fitbitSecret", cfg.FitbitClientSecret)
+}
+
+func TestLoadConfigWithoutGCP(t *testing.T) {
+	args := []string{"-base-url", "https://steps.example.com", "-storage", "sqlite", "-scheduler", "local", "-poll-interval", "30s", "-slack-app-id", "app", "-slack-client-id", "slackID", "-slack-client-secret", "slackSecret", "-slack-signing-secret", "signing", "-slack-token", "xoxb-token", "-fitbit-client-id", "fitbitID", "-fitbit-client-secret", "fitbitSecret"}
+
+	cfg, err := loadConfig(args, envFrom(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, "local", cfg.Scheduler)
+	assert.Equal(t, 30*time.Second, cfg.PollInterval)
+	assert.Empty(t, cfg.GCPProjectID)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]struct {
+		args          []string
+		env           map[string]string
+		fileContent   string
+		expectedError string
+	}{
+		"MissingRequired": {
+			args:          []string{"-base-url", "https://steps.example.com", "-gcp-project-id", "project"},
+			expectedError: "missing required settings: slack-app-id, slack-client-id, slack-client-secret, slack-signing-secret, fitbit-client-id, fitbit-client-secret, gcp-region, slack-token",
+		},
+		"UnknownStorage": {
+			args:          append(requiredArgs, "-storage", "mongodb"),
+			expectedError: "unknown storage [mongodb], should be one of datastore, postgres, sqlite or memory",
+		},
+		"UnknownScheduler": {
+			args:          append(requiredArgs, "-scheduler", "cron"),
+			expectedError: "unknown scheduler [cron], should be one of cloudtasks or local",
+		},
+		"InvalidPollInterval": {
+			args:          append(requiredArgs, "-scheduler", "local", "-poll-interval", "0s"),
+			expectedError: "invalid poll-interval [0s], should be positive",
+		},
+		"MissingPostgresDSN": {
+			args:          append(requiredArgs, "-storage", "postgres"),
+			expectedError: "missing required settings: postgres-dsn",
+		},
+		"InvalidEnvValue": {
+			args:          requiredArgs,
+			env:           map[string]string{"STEPCURRY_SHUTDOWN_TIMEOUT": "soon"},
+			expectedError: "invalid value [soon] for STEPCURRY_SHUTDOWN_TIMEOUT: parse error",
+		},
+		"UnknownFileSetting": {
+			args:          requiredArgs,
+			fileContent:   `{"colour": "blue"}`,
+			expectedError: "unknown setting [colour] in configuration file",
+		},
+		"InvalidBaseURL": {
+			args:          append(requiredArgs, "-base-url", "steps.example.com"),
+			expectedError: "invalid base-url [steps.example.com], should be an absolute http(s) URL",
+		},
+		"MalformedFile": {
+			args:          requiredArgs,
+			fileContent:   `{"addr": `,
+			expectedError: "error decoding configuration file",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := tc.args
+			if tc.fileContent != "" {
+				path, cleanup := writeConfigFile(t, tc.fileContent)
+				defer cleanup()
+
+				args = append([]string{"-config", path}, args...)
+			}
+
+			_, err := loadConfig(args, envFrom(tc.env))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Command stepcurry runs Step Curry as a self-hosted http server, serving all of the app's handlers from a single
+// process instead of individual cloud functions
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/alexandre-normand/stepcurry/sqlstorage"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// healthPath is the path of the health endpoint
+	healthPath = "/healthz"
+)
+
+func main() {
+	// Entries are written as json with their severity and fields, like the ones of the app's handlers. Debug entries
+	// are only written once the configuration turns them on
+	logger := stepcurry.NewJSONLogger(os.Stderr, stepcurry.SeverityInfo)
+
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal(logger, "Failed to load configuration", err)
+	}
+
+	if cfg.Debug {
+		logger = stepcurry.NewJSONLogger(os.Stderr, stepcurry.SeverityDebug)
+	}
+
+	sc, localScheduler, err := newStepCurry(cfg, logger)
+	if err != nil {
+		fatal(logger, "Failed to initialize Step Curry", err)
+	}
+
+	// Every sweep schedules the next one so this only starts the chain of sweeps if it isn't already going
+	err = sc.ScheduleTokenSweeps()
+	if err != nil {
+		logger.Log(stepcurry.SeverityError, "Error scheduling token sweep", stepcurry.Fields{"error": err.Error()})
+	}
+
+	stopWorker := func() {}
+	if localScheduler != nil {
+		stopWorker = startWorker(localScheduler, sc, cfg.PollInterval, logger)
+	}
+
+	health := new(healthCheck)
+	mux := http.NewServeMux()
+	sc.RegisterHandlers(mux)
+	mux.Handle(healthPath, health)
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	server := &http.Server{Addr: cfg.Addr, Handler: mux}
+	logger.Log(stepcurry.SeverityInfo, fmt.Sprintf("Step Curry listening on [%s]", cfg.Addr), nil)
+	err = runServer(server, health, shutdown, cfg.ShutdownTimeout, logger)
+	stopWorker()
+	if err != nil {
+		fatal(logger, "Step Curry server failed", err)
+	}
+}
+
+// fatal logs an error that keeps the server from running and exits
+func fatal(logger stepcurry.Logger, message string, err error) {
+	logger.Log(stepcurry.SeverityError, message, stepcurry.Fields{"error": err.Error()})
+	os.Exit(1)
+}
+
+// newStepCurry creates a StepCurry instance for a single slack workspace from the configuration. The local scheduler
+// is returned when it's the configured scheduler so that its worker can be started
+func newStepCurry(cfg config, logger stepcurry.Logger) (sc *stepcurry.StepCurry, localScheduler *stepcurry.LocalScheduler, err error) {
+	storage, err := newStorage(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slackClient := slack.New(cfg.SlackToken, slack.OptionDebug(cfg.Debug))
+	router, err := stepcurry.NewSingleTenantRouter(slackClient, stepcurry.NewSlackAPIBotIdentificator(slackClient), slackClient, slackClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	router.UseStorage(storage)
+	router.UseViewOpener(slackClient)
+	router.UseUserGroupMemberFinder(slackClient)
+	router.UseHomePublisher(slackClient)
+	router.UseConversationJoiner(slackClient)
+
+	opts := []stepcurry.Option{stepcurry.OptionStorage(storage), stepcurry.OptionTeamRouter(router), stepcurry.OptionLogger(logger)}
+	if cfg.Scheduler == schedulerLocal {
+		localScheduler = stepcurry.NewLocalScheduler(storage, stepcurry.NewSystemClock())
+		opts = append(opts, stepcurry.OptionScheduler(localScheduler))
+	} else {
+		taskScheduler, err := stepcurry.NewTaskScheduler(cfg.GCPProjectID, cfg.GCPRegion, cfg.TaskQueue)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error initializing cloud tasks client: %s", err.Error())
+		}
+
+		opts = append(opts, stepcurry.OptionTaskScheduler(taskScheduler))
+	}
+
+	sc, err = cfg.New(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sc, localScheduler, nil
+}
+
+// newStorage creates the storage backend selected by the configuration
+func newStorage(cfg config, logger stepcurry.Logger) (storage stepcurry.Storage, err error) {
+	switch cfg.Storage {
+	case storagePostgres:
+		storage, err = sqlstorage.NewPostgres(cfg.PostgresDSN)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing postgres storage: %s", err.Error())
+		}
+
+		return storage, nil
+	case storageSQLite:
+		storage, err = sqlstorage.NewSQLite(cfg.SQLitePath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing sqlite storage: %s", err.Error())
+		}
+
+		return storage, nil
+	case storageMemory:
+		logger.Log(stepcurry.SeverityWarning, "Using in-memory storage, all data will be lost on exit", nil)
+		return stepcurry.NewMemoryStorage(), nil
+	default:
+		storer, err := stepcurry.NewDatastorer(cfg.GCPProjectID)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing datastore: %s", err.Error())
+		}
+
+		return stepcurry.NewDatastoreStorage(storer), nil
+	}
+}
+
+// startWorker starts running the jobs of the local scheduler every pollInterval. The returned function stops the
+// worker and waits for the job it's running, if any, to complete
+func startWorker(localScheduler *stepcurry.LocalScheduler, runner stepcurry.JobRunner, pollInterval time.Duration, logger stepcurry.Logger) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		localScheduler.Run(ctx, runner, pollInterval)
+		close(done)
+	}()
+
+	logger.Log(stepcurry.SeverityInfo, fmt.Sprintf("Local scheduler polling for due jobs every [%s]", pollInterval), nil)
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// healthCheck reports the server as healthy until it starts shutting down
+type healthCheck struct {
+	draining int32
+}
+
+// drain marks the server as shutting down
+func (hc *healthCheck) drain() {
+	atomic.StoreInt32(&hc.draining, 1)
+}
+
+func (hc *healthCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&hc.draining) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// runServer serves until the server fails or a signal is received on shutdown. On shutdown, the health check starts
+// failing and in-flight requests are given up to timeout to complete
+func runServer(server *http.Server, health *healthCheck, shutdown <-chan os.Signal, timeout time.Duration, logger stepcurry.Logger) (err error) {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		return err
+	case sig := <-shutdown:
+		logger.Log(stepcurry.SeverityInfo, fmt.Sprintf("Received [%s], shutting down", sig), nil)
+	}
+
+	health.drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = <-serveErr; err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
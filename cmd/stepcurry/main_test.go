@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck(t *testing.T) {
+	health := new(healthCheck)
+
+	w := httptest.NewRecorder()
+	health.ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	health.drain()
+
+	w = httptest.NewRecorder()
+	health.ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestRunServerGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	health := new(healthCheck)
+	mux := http.NewServeMux()
+	mux.Handle(healthPath, health)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	shutdown := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- runServer(&http.Server{Addr: addr, Handler: mux}, health, shutdown, 5*time.Second, stepcurry.NewJSONLogger(ioutil.Discard, stepcurry.SeverityInfo))
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + healthPath)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	slowResp := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			slowResp <- resp
+		}
+		close(slowResp)
+	}()
+
+	<-inFlight
+	shutdown <- syscall.SIGTERM
+
+	// The in-flight request completes even though shutdown has started
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		health.ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthPath, nil))
+		return w.Code == http.StatusServiceUnavailable
+	}, 5*time.Second, 10*time.Millisecond)
+	close(release)
+
+	resp := <-slowResp
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	require.NoError(t, <-result)
+}
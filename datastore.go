@@ -86,10 +86,24 @@ type Datastorer interface {
 	Get(c context.Context, k *datastore.Key, dest interface{}) (err error)
 	Run(ctx context.Context, q *datastore.Query) *datastore.Iterator
 	Put(c context.Context, k *datastore.Key, v interface{}) (key *datastore.Key, err error)
+	RunInTransaction(c context.Context, f func(tc context.Context) error) (err error)
+}
+
+// transactionContextKey is the context key under which the transaction of a context is stored
+type transactionContextKey struct{}
+
+// transactionFromContext returns the transaction stored in a context, if any
+func transactionFromContext(c context.Context) (tx *datastore.Transaction, ok bool) {
+	tx, ok = c.Value(transactionContextKey{}).(*datastore.Transaction)
+	return tx, ok
 }
 
 // Delete deletes the entity for the given key. See https://godoc.org/cloud.google.com/go/datastore#Client.Delete
 func (ds *gcdatastore) Delete(c context.Context, k *datastore.Key) (err error) {
+	if tx, ok := transactionFromContext(c); ok {
+		return tx.Delete(k)
+	}
+
 	return ds.tryWithRecovery(func() (err error) {
 		return ds.Client.Delete(c, k)
 	})
@@ -132,6 +146,10 @@ func shouldRetry(err error) bool {
 
 // Get loads the entity stored for key into dst. See https://godoc.org/cloud.google.com/go/datastore#Client.Get
 func (ds *gcdatastore) Get(c context.Context, k *datastore.Key, dest interface{}) (err error) {
+	if tx, ok := transactionFromContext(c); ok {
+		return tx.Get(k, dest)
+	}
+
 	return ds.tryWithRecovery(func() (err error) {
 		return ds.Client.Get(c, k, dest)
 	})
@@ -145,11 +163,34 @@ func (ds *gcdatastore) Run(c context.Context, q *datastore.Query) *datastore.Ite
 
 // Put saves the entity src into the datastore with the given key. See https://godoc.org/cloud.google.com/go/datastore#Client.Put
 func (ds *gcdatastore) Put(c context.Context, k *datastore.Key, v interface{}) (key *datastore.Key, err error) {
+	// Keys of entities put in a transaction are only final once the transaction commits. All entities are
+	// stored with complete keys so the given key is the one the entity will be stored under
+	if tx, ok := transactionFromContext(c); ok {
+		_, err = tx.Put(k, v)
+		return k, err
+	}
+
 	return ds.tryKeyOperationWithRecovery(func() (key *datastore.Key, err error) {
 		return ds.Client.Put(c, k, v)
 	})
 }
 
+// RunInTransaction runs f in a transaction. Get, Put and Delete calls made with the context given to f are part
+// of the transaction which commits if f returns nil. f may be called more than once if the transaction is retried
+// because of contention. Nested calls are part of the enclosing transaction. See
+// https://godoc.org/cloud.google.com/go/datastore#Client.RunInTransaction
+func (ds *gcdatastore) RunInTransaction(c context.Context, f func(tc context.Context) error) (err error) {
+	if _, ok := transactionFromContext(c); ok {
+		return f(c)
+	}
+
+	_, err = ds.Client.RunInTransaction(c, func(tx *datastore.Transaction) error {
+		return f(context.WithValue(c, transactionContextKey{}, tx))
+	})
+
+	return err
+}
+
 // NewKeyWithNamespace returns a new NameKey with a namespace
 func NewKeyWithNamespace(kind string, namespace string, id string, parent *datastore.Key) (key *datastore.Key) {
 	key = datastore.NameKey(kind, id, parent)
@@ -0,0 +1,28 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGcdatastoreRunInTransactionJoinsEnclosingTransaction(t *testing.T) {
+	// Starting an independent transaction needs a client so this would fail without one if the nested call didn't
+	// join the enclosing transaction
+	ds := &gcdatastore{}
+	tx := &datastore.Transaction{}
+	ctx := context.WithValue(context.Background(), transactionContextKey{}, tx)
+
+	called := false
+	err := ds.RunInTransaction(ctx, func(tc context.Context) error {
+		called = true
+		nestedTx, ok := transactionFromContext(tc)
+		require.True(t, ok)
+		assert.Same(t, tx, nestedTx)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+}
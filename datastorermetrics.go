@@ -69,6 +69,11 @@ func newDatastorerMethodTimeValueRecorders(appName string, meter metric.Meter) (
 	mRun := mt.NewInt64ValueRecorder(string(nRunValRecorder))
 	boundTimeValueRecorders["Run"] = mRun.Bind(label.String("name", appName))
 
+	nRunInTransactionValRecorder := []rune("Datastorer_RunInTransaction_ProcessingTimeMillis")
+	nRunInTransactionValRecorder[0] = unicode.ToLower(nRunInTransactionValRecorder[0])
+	mRunInTransaction := mt.NewInt64ValueRecorder(string(nRunInTransactionValRecorder))
+	boundTimeValueRecorders["RunInTransaction"] = mRunInTransaction.Bind(label.String("name", appName))
+
 	return boundTimeValueRecorders
 }
 
@@ -106,6 +111,11 @@ func newDatastorerMethodCounters(suffix string, appName string, meter metric.Met
 	cRun := mt.NewInt64Counter(string(nRunCounter))
 	boundCounters["Run"] = cRun.Bind(label.String("name", appName))
 
+	nRunInTransactionCounter := []rune("Datastorer_RunInTransaction_" + suffix)
+	nRunInTransactionCounter[0] = unicode.ToLower(nRunInTransactionCounter[0])
+	cRunInTransaction := mt.NewInt64Counter(string(nRunInTransactionCounter))
+	boundCounters["RunInTransaction"] = cRunInTransaction.Bind(label.String("name", appName))
+
 	return boundCounters
 }
 
@@ -212,3 +222,21 @@ func (_d DatastorerWithTelemetry) Run(ctx context.Context, q *datastore.Query) (
 	}()
 	return _d.base.Run(ctx, q)
 }
+
+// RunInTransaction implements Datastorer
+func (_d DatastorerWithTelemetry) RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["RunInTransaction"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["RunInTransaction"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["RunInTransaction"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.RunInTransaction(ctx, f)
+}
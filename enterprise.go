@@ -0,0 +1,74 @@
+package stepcurry
+
+import (
+	"encoding/json"
+)
+
+const (
+	enterpriseIDParam      = "enterprise_id"
+	enterpriseInstallParam = "is_enterprise_install"
+)
+
+// Installation identifies an installation of the app. Workspace installations have a team ID and, for workspaces
+// that are part of an Enterprise Grid org, an enterprise ID. Org-wide installations only have an enterprise ID
+type Installation struct {
+	EnterpriseID      string
+	TeamID            string
+	EnterpriseInstall bool
+}
+
+// TenantID returns the ID the installation's token, bot info and data are keyed by
+func (i Installation) TenantID() string {
+	return tenantID(i.EnterpriseID, i.TeamID, i.EnterpriseInstall)
+}
+
+// tenantID returns the ID an installation is keyed by. Org-wide installations are keyed by enterprise so that all the
+// workspaces of the org share the same data, which lets a channel shared between several of them run a single
+// challenge. Workspace installations are keyed by team, including those of workspaces that are part of an org
+func tenantID(enterpriseID string, teamID string, enterpriseInstall bool) string {
+	if enterpriseInstall && enterpriseID != "" {
+		return enterpriseID
+	}
+
+	return teamID
+}
+
+// commandTenantID returns the tenant ID of a slash command request
+func commandTenantID(params map[string]string) string {
+	return tenantID(params[enterpriseIDParam], params[teamIDParam], params[enterpriseInstallParam] == "true")
+}
+
+// enterpriseContext holds the Enterprise Grid fields of interaction and event payloads, which aren't decoded by
+// github.com/slack-go/slack. Interactions have an enterprise object while events have an enterprise ID and the
+// authorizations of the installations the event is delivered for
+type enterpriseContext struct {
+	EnterpriseID string `json:"enterprise_id"`
+	Enterprise   *struct {
+		ID string `json:"id"`
+	} `json:"enterprise"`
+	IsEnterpriseInstall bool `json:"is_enterprise_install"`
+	Authorizations      []struct {
+		IsEnterpriseInstall bool `json:"is_enterprise_install"`
+	} `json:"authorizations"`
+}
+
+// parseEnterpriseContext decodes the Enterprise Grid fields of an interaction or event payload
+func parseEnterpriseContext(payload []byte) (ec enterpriseContext, err error) {
+	err = json.Unmarshal(payload, &ec)
+	return ec, err
+}
+
+// tenantID returns the tenant ID of the request a payload is from given the team ID of the payload
+func (ec enterpriseContext) tenantID(teamID string) string {
+	enterpriseID := ec.EnterpriseID
+	if ec.Enterprise != nil {
+		enterpriseID = ec.Enterprise.ID
+	}
+
+	enterpriseInstall := ec.IsEnterpriseInstall
+	for _, authorization := range ec.Authorizations {
+		enterpriseInstall = enterpriseInstall || authorization.IsEnterpriseInstall
+	}
+
+	return tenantID(enterpriseID, teamID, enterpriseInstall)
+}
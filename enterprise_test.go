@@ -0,0 +1,235 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// tokenRecorder is a TokenSaver and TokenLoader keeping tokens in memory
+type tokenRecorder map[string]SlackToken
+
+func (tr tokenRecorder) SaveToken(installation Installation, token SlackToken) (err error) {
+	tr[installation.TenantID()] = token
+	return nil
+}
+
+func (tr tokenRecorder) LoadToken(tenantID string) (token SlackToken, err error) {
+	token, ok := tr[tenantID]
+	if !ok {
+		return token, fmt.Errorf("no token for tenant [%s]", tenantID)
+	}
+
+	return token, nil
+}
+
+// orgSlashCommand creates a signed slash command request from a user of a workspace of an org-wide installation
+func (h *lifecycleHarness) orgSlashCommand(command string, teamID string, userID string) (r *http.Request) {
+	params := url.Values{}
+	params.Set("command", command)
+	params.Set(enterpriseIDParam, "E1")
+	params.Set(enterpriseInstallParam, "true")
+	params.Set(teamIDParam, teamID)
+	params.Set(channelIDParam, lifecycleChannelID)
+	params.Set(userIDParam, userID)
+	params.Set(responseURLParam, h.responsesURL)
+
+	return h.signedRequest(command, params.Encode())
+}
+
+func TestCommandTenantID(t *testing.T) {
+	tests := map[string]struct {
+		params           map[string]string
+		expectedTenantID string
+	}{
+		"Workspace":        {params: map[string]string{teamIDParam: "T1"}, expectedTenantID: "T1"},
+		"OrgWorkspace":     {params: map[string]string{teamIDParam: "T1", enterpriseIDParam: "E1", enterpriseInstallParam: "false"}, expectedTenantID: "T1"},
+		"OrgInstall":       {params: map[string]string{teamIDParam: "T1", enterpriseIDParam: "E1", enterpriseInstallParam: "true"}, expectedTenantID: "E1"},
+		"OrgInstallNoTeam": {params: map[string]string{enterpriseIDParam: "E1", enterpriseInstallParam: "true"}, expectedTenantID: "E1"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedTenantID, commandTenantID(tc.params))
+		})
+	}
+}
+
+func TestEnterpriseContextTenantID(t *testing.T) {
+	tests := map[string]struct {
+		payload          string
+		expectedTenantID string
+	}{
+		"Interaction":           {payload: `{"type":"block_actions","team":{"id":"T1"},"enterprise":null,"is_enterprise_install":false}`, expectedTenantID: "T1"},
+		"OrgWorkspaceAction":    {payload: `{"type":"block_actions","team":{"id":"T1"},"enterprise":{"id":"E1","name":"Org"},"is_enterprise_install":false}`, expectedTenantID: "T1"},
+		"OrgInstallInteraction": {payload: `{"type":"block_actions","team":{"id":"T1"},"enterprise":{"id":"E1","name":"Org"},"is_enterprise_install":true}`, expectedTenantID: "E1"},
+		"Event":                 {payload: `{"type":"event_callback","team_id":"T1","authorizations":[{"team_id":"T1","is_enterprise_install":false}]}`, expectedTenantID: "T1"},
+		"OrgInstallEvent":       {payload: `{"type":"event_callback","team_id":"T1","enterprise_id":"E1","authorizations":[{"enterprise_id":"E1","team_id":null,"is_enterprise_install":true}]}`, expectedTenantID: "E1"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ec, err := parseEnterpriseContext([]byte(tc.payload))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedTenantID, ec.tenantID("T1"))
+		})
+	}
+}
+
+func TestHandleSlackAuth(t *testing.T) {
+	tests := map[string]struct {
+		authResponse        string
+		expectedTenantID    string
+		expectedToken       SlackToken
+		expectedBotInfo     BotInfo
+		expectedRedirectURL string
+	}{
+		"Workspace": {
+			authResponse:        `{"ok":true,"app_id":"A1","access_token":"xoxb-1","bot_user_id":"B1","team":{"id":"T1","name":"Team"},"enterprise":null,"is_enterprise_install":false}`,
+			expectedTenantID:    "T1",
+			expectedToken:       SlackToken{AccessToken: "xoxb-1"},
+			expectedBotInfo:     BotInfo{UserID: "B1", TeamID: "T1"},
+			expectedRedirectURL: "https://slack.com/app_redirect?app=roger&team=T1",
+		},
+		"OrgWorkspace": {
+			authResponse:        `{"ok":true,"app_id":"A1","access_token":"xoxb-1","bot_user_id":"B1","team":{"id":"T1","name":"Team"},"enterprise":{"id":"E1","name":"Org"},"is_enterprise_install":false}`,
+			expectedTenantID:    "T1",
+			expectedToken:       SlackToken{AccessToken: "xoxb-1"},
+			expectedBotInfo:     BotInfo{UserID: "B1", EnterpriseID: "E1", TeamID: "T1"},
+			expectedRedirectURL: "https://slack.com/app_redirect?app=roger&team=T1",
+		},
+		"RotatingToken": {
+			authResponse:        `{"ok":true,"app_id":"A1","access_token":"xoxe.xoxb-1","refresh_token":"xoxe-1-r1","expires_in":43200,"bot_user_id":"B1","team":{"id":"T1","name":"Team"},"enterprise":null,"is_enterprise_install":false}`,
+			expectedTenantID:    "T1",
+			expectedToken:       SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "xoxe-1-r1", Expiry: time.Date(2020, 6, 1, 21, 30, 0, 0, time.UTC)},
+			expectedBotInfo:     BotInfo{UserID: "B1", TeamID: "T1"},
+			expectedRedirectURL: "https://slack.com/app_redirect?app=roger&team=T1",
+		},
+		"OrgInstall": {
+			authResponse:        `{"ok":true,"app_id":"A1","access_token":"xoxb-1","bot_user_id":"B1","team":null,"enterprise":{"id":"E1","name":"Org"},"is_enterprise_install":true}`,
+			expectedTenantID:    "E1",
+			expectedToken:       SlackToken{AccessToken: "xoxb-1"},
+			expectedBotInfo:     BotInfo{UserID: "B1", EnterpriseID: "E1", EnterpriseInstall: true},
+			expectedRedirectURL: "https://slack.com/app_redirect?app=roger\"",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/oauth.v2.access", r.URL.Path)
+				w.Write([]byte(tc.authResponse))
+			}))
+			defer slackServer.Close()
+
+			h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC), OptionSlackBaseURL(slackServer.URL))
+			defer cleanup()
+
+			tokens := make(tokenRecorder)
+			h.sc.TeamRouter.(*SingleTenantRouter).TokenSaver = tokens
+
+			w := httptest.NewRecorder()
+			err := h.sc.HandleSlackAuth(w, httptest.NewRequest(http.MethodGet, "/?code=code1", nil))
+			require.NoError(t, err)
+
+			assert.Equal(t, tokenRecorder{tc.expectedTenantID: tc.expectedToken}, tokens)
+
+			botInfo, err := h.storage.GetBotInfo(context.Background(), tc.expectedTenantID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBotInfo, botInfo)
+
+			assert.Contains(t, w.Body.String(), tc.expectedRedirectURL)
+		})
+	}
+}
+
+func TestHandleSlackAuthWithoutTokenStore(t *testing.T) {
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"app_id":"A1","access_token":"xoxb-1","bot_user_id":"B1","team":{"id":"T1","name":"Team"},"enterprise":null,"is_enterprise_install":false}`))
+	}))
+	defer slackServer.Close()
+
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC), OptionSlackBaseURL(slackServer.URL))
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+slackAuthCallbackPath+"?code=code1", nil))
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	_, err := h.storage.GetBotInfo(context.Background(), "T1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestSharedChannelChallengeAcrossOrgWorkspaces(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+
+	// Alice's workspace and Bob's are both part of the org and share the channel
+	err := h.sc.Challenge(httptest.NewRecorder(), h.orgSlashCommand(commandChallenge, "T1", "U1"))
+	require.NoError(t, err)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.orgSlashCommand(commandChallenge, "T2", "U2"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U1> started a steps challenge!")
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":warning: There's already an active steps challenge so you know ¯\\_(ツ)_/¯", responses[0].Text)
+
+	challenges, err := h.storage.ListActiveChallenges(context.Background(), "E1")
+	require.NoError(t, err)
+	require.Len(t, challenges, 1)
+	assert.Equal(t, ChallengeID{TeamID: "E1", ChannelID: lifecycleChannelID, Date: "2020-06-01"}, challenges[0].ChallengeID)
+}
+
+func TestOrgInstallInteractionRoutedToEnterprise(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.addUser("U2", "Bob", "F2")
+	require.NoError(t, h.storage.PutChallenge(context.Background(), StepsChallenge{ChallengeID: ChallengeID{TeamID: "E1", ChannelID: lifecycleChannelID, Date: "2020-06-01"}, Active: true, CreatorID: "U1", ExplicitOptIn: true}))
+
+	var callback slack.InteractionCallback
+	callback.Type = slack.InteractionTypeBlockActions
+	callback.User.ID = "U2"
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: joinChallengeActionID, BlockID: "join", Value: "2020-06-01"}}
+	callback.Team.ID = "T2"
+	callback.Channel.ID = lifecycleChannelID
+	callback.ResponseURL = h.responsesURL
+
+	payload, err := json.Marshal(callback)
+	require.NoError(t, err)
+
+	// slack-go doesn't encode the enterprise fields of interactions so they're added to the payload
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &fields))
+	fields["enterprise"] = map[string]string{"id": "E1", "name": "Org"}
+	fields["is_enterprise_install"] = true
+	payload, err = json.Marshal(fields)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.signedRequest(interactionPath, url.Values{payloadParam: {string(payload)}}.Encode()))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stepsChallenge, err := h.storage.GetChallenge(context.Background(), ChallengeID{TeamID: "E1", ChannelID: lifecycleChannelID, Date: "2020-06-01"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"U2"}, stepsChallenge.Participants)
+}
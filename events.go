@@ -0,0 +1,65 @@
+package stepcurry
+
+import (
+	"encoding/json"
+	"github.com/slack-go/slack/slackevents"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// homeTab is the tab of app_home_opened events when users open the app home rather than the messages tab
+	homeTab = "home"
+)
+
+// Events handles the slack Events API requests. Slack sends all subscribed events to the same request url so
+// they're dispatched from here
+func (sc *StepCurry) Events(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Requests are already verified with the signing secret so the deprecated verification token isn't checked
+	event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		return newHttpError(err, "Error parsing slack event", http.StatusBadRequest)
+	}
+
+	enterprise, err := parseEnterpriseContext(body)
+	if err != nil {
+		return newHttpError(err, "Error parsing slack event enterprise", http.StatusBadRequest)
+	}
+	teamID := enterprise.tenantID(event.TeamID)
+
+	switch event.Type {
+	case slackevents.URLVerification:
+		verification := event.Data.(*slackevents.EventsAPIURLVerificationEvent)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(verification.Challenge))
+
+		return nil
+	case slackevents.CallbackEvent:
+		switch innerEvent := event.InnerEvent.Data.(type) {
+		case *slackevents.AppHomeOpenedEvent:
+			if innerEvent.Tab == homeTab {
+				return sc.openHome(r.Context(), teamID, innerEvent.User)
+			}
+
+			return nil
+		case *slackevents.AppUninstalledEvent, *slackevents.TokensRevokedEvent:
+			sc.log(r.Context()).with(Fields{teamIDField: teamID}).infof("Dropping services of team after [%s]", event.InnerEvent.Type)
+			sc.Invalidate(teamID)
+
+			return nil
+		}
+	}
+
+	sc.log(r.Context()).with(Fields{teamIDField: teamID}).debugf("Ignoring unsupported event of type [%s]", event.InnerEvent.Type)
+	return nil
+}
@@ -2,7 +2,6 @@ package stepcurry
 
 import (
 	"bytes"
-	"cloud.google.com/go/datastore"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -10,9 +9,7 @@ import (
 	"github.com/imroc/req"
 	"github.com/slack-go/slack"
 	"github.com/pkg/errors"
-	"google.golang.org/api/iterator"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"sort"
@@ -26,11 +23,16 @@ const (
 	defaultFitbitAPIBaseURL  = "https://api.fitbit.com"
 )
 
-// FitbitApiAcccess holds data for an authenticated fitbit user
+// FitbitApiAcccess holds data for an authenticated fitbit user. The token expiry is computed from the token lifetime
+// (expires_in) when the token is issued
 type FitbitApiAccess struct {
-	FitbitUser   string `datastore:"fitbitUser" json:"user_id,omitempty"`
-	Token        string `datastore:"accessToken,noindex" json:"access_token,omitempty"`
-	RefreshToken string `datastore:"refreshToken,noindex" json:"refresh_token,omitempty"`
+	FitbitUser   string    `datastore:"fitbitUser" json:"user_id,omitempty"`
+	Token        string    `datastore:"accessToken,noindex" json:"access_token,omitempty"`
+	RefreshToken string    `datastore:"refreshToken,noindex" json:"refresh_token,omitempty"`
+	ExpiresIn    int       `datastore:"-" json:"expires_in,omitempty"`
+	Expiry       time.Time `datastore:"expiry,noindex" json:"-"`
+	RefreshedAt  time.Time `datastore:"refreshedAt,noindex" json:"-"`
+	Subscribed   bool      `datastore:"subscribed,noindex" json:"-"`
 }
 
 // AuthIdentificationState holds data StepCurry requires to reconcile a oauth callback
@@ -69,6 +71,37 @@ type Goals struct {
 	Steps int `json:"steps,omitempty"`
 }
 
+// FitbitErrorResponse holds the errors returned by the Fitbit web API on failed requests. See details at
+// https://dev.fitbit.com/build/reference/web-api/troubleshooting-guide/error-messages/
+type FitbitErrorResponse struct {
+	Errors []FitbitError `json:"errors,omitempty"`
+}
+
+// FitbitError holds a single error returned by the Fitbit web API
+type FitbitError struct {
+	ErrorType string `json:"errorType,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// errInvalidGrant is the cause of errors refreshing a token that was revoked or is otherwise no longer valid
+var errInvalidGrant = errors.New("invalid_grant")
+
+// hasErrorType returns true if the Fitbit error response body includes an error of the given type
+func hasErrorType(body []byte, errorType string) bool {
+	var errorResp FitbitErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err != nil {
+		return false
+	}
+
+	for _, e := range errorResp.Errors {
+		if e.ErrorType == errorType {
+			return true
+		}
+	}
+
+	return false
+}
+
 // HandleFitbitAuth receives the oauth callback from Fitbit after a user has logged in and
 // consented to the access
 func (sc *StepCurry) HandleFitbitAuth(w http.ResponseWriter, r *http.Request) error {
@@ -97,12 +130,10 @@ func (sc *StepCurry) HandleFitbitAuth(w http.ResponseWriter, r *http.Request) er
 	}
 
 	ctx := context.Background()
-	var csrfToken CsrfToken
-	csrfKey := NewKeyWithNamespace("CsrfToken", authIDState.SlackTeam, authIDState.SlackUser, nil)
-	err = sc.storer.Get(ctx, csrfKey, &csrfToken)
+	csrfToken, err := sc.storage.GetCsrfToken(ctx, authIDState.SlackTeam, authIDState.SlackUser)
 
 	if err != nil {
-		if err == datastore.ErrNoSuchEntity {
+		if err == ErrNoSuchEntity {
 			return newHttpError(err, "CSRF token not found", http.StatusUnauthorized)
 		} else {
 			return newHttpError(err, fmt.Sprintf("Error fetching csrf token for user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
@@ -113,7 +144,7 @@ func (sc *StepCurry) HandleFitbitAuth(w http.ResponseWriter, r *http.Request) er
 		return newHttpError(errors.New("CSRF token mismatch"), "", http.StatusUnauthorized)
 	}
 
-	err = sc.storer.Delete(ctx, csrfKey)
+	err = sc.storage.DeleteCsrfToken(ctx, authIDState.SlackTeam, authIDState.SlackUser)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error deleting up csrf token for user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
 	}
@@ -123,23 +154,51 @@ func (sc *StepCurry) HandleFitbitAuth(w http.ResponseWriter, r *http.Request) er
 		return newHttpError(err, fmt.Sprintf("Error getting fitbit api access for user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
 	}
 
-	apiKey := datastore.NameKey("FitbitApiAccess", apiAccess.FitbitUser, nil)
-	_, err = sc.storer.Put(ctx, apiKey, &apiAccess)
+	if sc.subscriptionsEnabled() {
+		err = sc.subscribeToActivities(apiAccess)
+		if err != nil {
+			sc.log(r.Context()).with(Fields{teamIDField: authIDState.SlackTeam, userIDField: authIDState.SlackUser}).withError(err).warningf("Error subscribing user to activity notifications, steps will be polled")
+		} else {
+			apiAccess.Subscribed = true
+		}
+	}
+
+	err = sc.storage.PutFitbitApiAccess(ctx, apiAccess)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error persisting fitbit api access for fitbit user [%s]", apiAccess.FitbitUser), http.StatusInternalServerError)
 	}
 
 	clientAccess := ClientAccess{SlackUser: authIDState.SlackUser, SlackTeam: authIDState.SlackTeam, FitbitUser: apiAccess.FitbitUser}
-
-	k := NewKeyWithNamespace("ClientAccess", authIDState.SlackTeam, authIDState.SlackUser, nil)
-	_, err = sc.storer.Put(ctx, k, &clientAccess)
+	err = sc.storage.PutClientAccess(ctx, clientAccess)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error persisting fitbit user mapping for user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
 	}
 
+	// Make sure the token sweep is scheduled now that there's at least one token to keep fresh
+	err = sc.scheduleTokenSweep(sc.clock.Now())
+	if err != nil {
+		sc.log(r.Context()).withError(err).errorf("Error scheduling token sweep")
+	}
+
 	sc.instruments.accountLinkCompletedCount.Add(context.Background(), 1)
 
-	oauthCompleteMessage := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: "POW :boom: You've got your Fitbit account linked and ready for some challenges :wind_blowing_face::athletic_shoe:"}
+	svcs, err := sc.Route(authIDState.SlackTeam)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", authIDState.SlackTeam), http.StatusInternalServerError)
+	}
+
+	// Links started from the app home don't have a response url so the home is refreshed and the user is sent back to it
+	if authIDState.ResponseURL == "" {
+		err = sc.publishHome(svcs, authIDState.SlackTeam, authIDState.SlackUser)
+		if err != nil {
+			return newHttpError(err, fmt.Sprintf("Error publishing app home of user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(fmt.Sprintf("<html><head><meta http-equiv=\"refresh\" content=\"0;URL=slack://app?team=%s&id=%s&tab=home\"></head></html>", authIDState.SlackTeam, sc.slackAppID)))
+		return nil
+	}
+
+	oauthCompleteMessage := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(authIDState.SlackUser).render(msgLinkSuccess, nil)}
 	resp, err := req.Post(authIDState.ResponseURL, req.BodyJSON(&oauthCompleteMessage))
 	if err != nil || resp.Response().StatusCode != 200 {
 		if err != nil {
@@ -190,14 +249,17 @@ func (sc *StepCurry) exchangeAuthCodeForApiAccess(code string, state string) (ap
 	if err != nil {
 		return apiAccess, errors.Wrap(err, "error decoding api access response")
 	}
+	apiAccess.setExpiry(sc.clock.Now())
 
 	return apiAccess, nil
 }
 
-// UserSteps holds a slack user and its step count
+// UserSteps holds a slack user, its step count, its daily steps goal and the floors it climbed
 type UserSteps struct {
 	UserID string
 	Steps  int
+	Goal   int
+	Floors int
 }
 
 // byStepCount sorts by the step count
@@ -211,49 +273,73 @@ func (p byStepCount) Less(i, j int) bool {
 
 func (p byStepCount) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
-// getChallengeRankedSteps fetches the updated ranking of all fitbit users participating in a steps challenge
-func (sc *StepCurry) getChallengeRankedSteps(stepsChallenge StepsChallenge) (rankedUsers []UserSteps, err error) {
+// byFloorCount sorts by the floor count
+type byFloorCount []UserSteps
+
+func (p byFloorCount) Len() int { return len(p) }
+
+func (p byFloorCount) Less(i, j int) bool {
+	return p[i].Floors < p[j].Floors || (p[i].Floors == p[j].Floors && strings.Compare(p[i].UserID, p[j].UserID) > 0)
+}
+
+func (p byFloorCount) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// rankByMetric sorts users in descending order of the metric a challenge ranks participants by
+func rankByMetric(userSteps []UserSteps, metric string) {
+	if metric == metricFloors {
+		sort.Sort(sort.Reverse(byFloorCount(userSteps)))
+		return
+	}
+
+	sort.Sort(sort.Reverse(byStepCount(userSteps)))
+}
+
+// getChallengeRankedSteps fetches the updated ranking of all fitbit users participating in a steps challenge. Participants
+// whose steps couldn't be fetched are left out of the ranking and counted as unsynced
+func (sc *StepCurry) getChallengeRankedSteps(ctx context.Context, stepsChallenge StepsChallenge) (rankedUsers []UserSteps, unsyncedCount int, err error) {
 	userSteps := make([]UserSteps, 0)
 
 	localizedChallengeDate, _, err := localizeCreationTime(stepsChallenge.CreationTime, stepsChallenge.TimezoneID)
 	if err != nil {
-		return userSteps, errors.Wrapf(err, "error getting localized time for steps challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChannelID)
+		return userSteps, 0, errors.Wrapf(err, "error getting localized time for steps challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChannelID)
 	}
 
-	ctx := context.Background()
-	q := datastore.NewQuery("ClientAccess").Namespace(stepsChallenge.TeamID)
-	usersIterator := sc.storer.Run(ctx, q)
+	teamClientAccesses, err := sc.storage.ListClientAccesses(ctx, stepsChallenge.TeamID)
+	if err != nil {
+		return userSteps, 0, err
+	}
 
 	fitbitUsers := make(map[string]FitbitApiAccess)
-	var ca ClientAccess
-	for _, err := usersIterator.Next(&ca); err == nil; _, err = usersIterator.Next(&ca) {
-		if err != nil && err != iterator.Done {
-			return userSteps, err
-		}
-
-		k := datastore.NameKey("FitbitApiAccess", ca.FitbitUser, nil)
-		var apiAccess FitbitApiAccess
-		err := sc.storer.Get(ctx, k, &apiAccess)
+	clientAccesses := make(map[string]ClientAccess)
+	for _, ca := range teamClientAccesses {
+		apiAccess, err := sc.storage.GetFitbitApiAccess(ctx, ca.FitbitUser)
 		if err != nil {
-			return userSteps, err
+			return userSteps, 0, err
 		}
 
 		fitbitUsers[ca.SlackUser] = apiAccess
+		clientAccesses[ca.SlackUser] = ca
 	}
 
 	svcs, err := sc.Route(stepsChallenge.TeamID)
 	if err != nil {
-		return userSteps, errors.Wrapf(err, "error getting channel members for channel id [%s]", stepsChallenge.ChannelID)
+		return userSteps, 0, errors.Wrapf(err, "error getting channel members for channel id [%s]", stepsChallenge.ChannelID)
 	}
 
 	members, _, err := svcs.conversationMemberFinder.GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: stepsChallenge.ChannelID, Limit: 100000})
 	if err != nil {
-		return userSteps, errors.Wrapf(err, "error getting channel members for channel id [%s]", stepsChallenge.ChannelID)
+		return userSteps, 0, errors.Wrapf(err, "error getting channel members for channel id [%s]", stepsChallenge.ChannelID)
+	}
+
+	// With explicit opt-in, only channel members who joined the challenge take part in it
+	participants := make(map[string]bool)
+	for _, userID := range stepsChallenge.Participants {
+		participants[userID] = true
 	}
 
 	usersToFetch := make([]string, 0)
 	for _, userID := range members {
-		if _, ok := fitbitUsers[userID]; ok {
+		if _, ok := fitbitUsers[userID]; ok && (!stepsChallenge.ExplicitOptIn || participants[userID]) {
 			usersToFetch = append(usersToFetch, userID)
 		}
 	}
@@ -261,62 +347,94 @@ func (sc *StepCurry) getChallengeRankedSteps(stepsChallenge StepsChallenge) (ran
 	// TODO: Create a worker pool and submit the work with a parallelism of 4
 	for _, user := range usersToFetch {
 		apiAccess := fitbitUsers[user]
+		clientAccess := clientAccesses[user]
+
+		// Don't bother fetching steps with a link we know is broken, the user is told to link their account again
+		if clientAccess.LinkBroken {
+			unsyncedCount++
+
+			if err := sc.notifyBrokenLink(svcs, clientAccess); err != nil {
+				sc.log(ctx).with(Fields{userIDField: user}).withError(err).errorf("Error recording broken link notification")
+			}
+			continue
+		}
+
+		if activity, err := sc.getUserActivityWithCache(user, apiAccess, localizedChallengeDate); err != nil {
+			sc.log(ctx).with(Fields{userIDField: user}).withError(err).warningf("Error reading step count")
+			unsyncedCount++
 
-		if steps, err := sc.getUserSteps(user, apiAccess, localizedChallengeDate); err != nil {
-			log.Printf("Error reading step count for user [%s]: %s", user, err.Error())
+			if err := sc.recordFetchFailure(svcs, clientAccess, err); err != nil {
+				sc.log(ctx).with(Fields{userIDField: user}).withError(err).errorf("Error recording fetch failure")
+			}
 		} else {
-			userSteps = append(userSteps, UserSteps{UserID: user, Steps: steps})
+			userSteps = append(userSteps, UserSteps{UserID: user, Steps: activity.Steps, Goal: activity.Goal, Floors: activity.Floors})
+
+			if err := sc.recordFetchSuccess(clientAccess); err != nil {
+				sc.log(ctx).with(Fields{userIDField: user}).withError(err).errorf("Error recording fetch success")
+			}
 		}
 	}
 
-	sort.Sort(sort.Reverse(byStepCount(userSteps)))
-	return userSteps, nil
+	rankByMetric(userSteps, stepsChallenge.Metric)
+	return userSteps, unsyncedCount, nil
+}
+
+// getUserSteps retrieves the steps summary and daily steps goal for a given fitbit user using its access token
+func (sc *StepCurry) getUserSteps(slackUser string, apiAccess FitbitApiAccess, date time.Time) (steps int, goal int, err error) {
+	activitySummaryResp, err := sc.getActivitySummary(slackUser, apiAccess, date)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return activitySummaryResp.Summary.Steps, activitySummaryResp.Goals.Steps, nil
 }
 
-// getUserSteps retrieves the steps summary for a given fitbit user using its access token
-func (sc *StepCurry) getUserSteps(slackUser string, apiAccess FitbitApiAccess, date time.Time) (steps int, err error) {
+// getActivitySummary retrieves the activity summary of a given fitbit user for a day using its access token
+func (sc *StepCurry) getActivitySummary(slackUser string, apiAccess FitbitApiAccess, date time.Time) (activitySummaryResp ActivitySummaryResponse, err error) {
 	resp, err := sc.fetchActivitySummaryWithRefresh(slackUser, apiAccess, date)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error fetching activity summary for fitbit user [%s]", apiAccess.FitbitUser)
+		return activitySummaryResp, errors.Wrapf(err, "error fetching activity summary for fitbit user [%s]", apiAccess.FitbitUser)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return 0, fmt.Errorf("error getting activity summary [%s]: %s", resp.Status, body)
+		return activitySummaryResp, fmt.Errorf("error getting activity summary [%s]: %s", resp.Status, body)
 	}
 
-	var activitySummaryResp ActivitySummaryResponse
 	err = json.Unmarshal(body, &activitySummaryResp)
 	if err != nil {
-		return 0, errors.Wrap(err, "error decoding activity summary response")
+		return activitySummaryResp, errors.Wrap(err, "error decoding activity summary response")
 	}
 
-	return activitySummaryResp.Summary.Steps, nil
+	return activitySummaryResp, nil
 }
 
-// fetchActivitySummaryWithRefresh fetches a user's activity summary and handles expired tokens by refreshing the token
+// fetchActivitySummaryWithRefresh fetches a user's activity summary and handles expiring and expired tokens by refreshing the token
 // if necessary
 func (sc *StepCurry) fetchActivitySummaryWithRefresh(slackUser string, apiAccess FitbitApiAccess, date time.Time) (resp *http.Response, err error) {
+	// Refresh tokens about to expire ahead of time rather than waiting for a failed request
+	if apiAccess.expiresWithin(tokenRefreshMargin, sc.clock.Now()) {
+		newLogEntry(sc.logger).with(Fields{userIDField: slackUser}).debugf("Fitbit token expiring soon, refreshing...")
+
+		apiAccess, err = sc.refreshApiAccess(slackUser, apiAccess)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	resp, err = sc.fetchActivitySummary(apiAccess, date)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode == 401 {
-		log.Printf("Token expired for user [%s], refreshing...", slackUser)
+		newLogEntry(sc.logger).with(Fields{userIDField: slackUser}).infof("Fitbit token expired, refreshing...")
 
 		// Refresh token
-		apiAccess, err := sc.exchangeRefreshTokenForApiAccess(apiAccess.RefreshToken)
+		apiAccess, err := sc.refreshApiAccess(slackUser, apiAccess)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error refreshing token for user [%s]", slackUser)
-		}
-
-		ctx := context.Background()
-		k := datastore.NameKey("FitbitApiAccess", apiAccess.FitbitUser, nil)
-		_, err = sc.storer.Put(ctx, k, &apiAccess)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Error persisting fitbit api access for slack user [%s]", slackUser)
+			return nil, err
 		}
 
 		// Refetch activity summary
@@ -329,6 +447,67 @@ func (sc *StepCurry) fetchActivitySummaryWithRefresh(slackUser string, apiAccess
 	return resp, nil
 }
 
+// refreshApiAccess refreshes a user's expired api access. Fitbit refresh tokens can only be used once so concurrent
+// refreshes for the same user (from challenges in different channels updating at the same time, for example) would
+// break the link. To avoid that, the stored api access is read again and the new one persisted in a transaction.
+// The token exchange is skipped and the stored api access kept if it was already refreshed by someone else
+func (sc *StepCurry) refreshApiAccess(slackUser string, expiredAccess FitbitApiAccess) (apiAccess FitbitApiAccess, err error) {
+	// The exchanged api access is kept across attempts so that a retried transaction doesn't use the refresh token
+	// a second time
+	var exchangedAccess *FitbitApiAccess
+	err = sc.storage.RunInTransaction(context.Background(), func(tc context.Context) (err error) {
+		storedAccess, refreshed, err := sc.loadRefreshedApiAccess(tc, slackUser, expiredAccess)
+		if err != nil {
+			return err
+		}
+
+		if refreshed {
+			apiAccess = storedAccess
+			return nil
+		}
+
+		if exchangedAccess == nil {
+			newAccess, err := sc.exchangeRefreshTokenForApiAccess(expiredAccess.RefreshToken)
+			if err != nil {
+				return errors.Wrapf(err, "error refreshing token for user [%s]", slackUser)
+			}
+
+			newAccess.Subscribed = expiredAccess.Subscribed
+			exchangedAccess = &newAccess
+		}
+
+		err = sc.storage.PutFitbitApiAccess(tc, *exchangedAccess)
+		if err != nil {
+			return errors.Wrapf(err, "Error persisting fitbit api access for slack user [%s]", slackUser)
+		}
+
+		apiAccess = *exchangedAccess
+		return nil
+	})
+
+	return apiAccess, err
+}
+
+// loadRefreshedApiAccess loads the stored api access of a user and returns true if it was refreshed since
+// expiredAccess was read
+func (sc *StepCurry) loadRefreshedApiAccess(ctx context.Context, slackUser string, expiredAccess FitbitApiAccess) (storedAccess FitbitApiAccess, refreshed bool, err error) {
+	storedAccess, err = sc.storage.GetFitbitApiAccess(ctx, expiredAccess.FitbitUser)
+	if err == ErrNoSuchEntity {
+		return storedAccess, false, nil
+	}
+
+	if err != nil {
+		return storedAccess, false, errors.Wrapf(err, "error loading fitbit api access for slack user [%s]", slackUser)
+	}
+
+	if storedAccess.RefreshToken != expiredAccess.RefreshToken {
+		newLogEntry(sc.logger).with(Fields{userIDField: slackUser}).debugf("Fitbit token already refreshed, reusing it")
+		return storedAccess, true, nil
+	}
+
+	return storedAccess, false, nil
+}
+
 // fetchActivitySummary fetches a user's activity summary
 func (sc *StepCurry) fetchActivitySummary(apiAccess FitbitApiAccess, date time.Time) (resp *http.Response, err error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/1/user/%s/activities/date/%s.json", sc.fitbitAPIBaseURL, apiAccess.FitbitUser, date.Format(fitbitDateFormat)), nil)
@@ -372,6 +551,10 @@ func (sc *StepCurry) exchangeRefreshTokenForApiAccess(refreshToken string) (apiA
 
 	tokenBody, err := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if hasErrorType(tokenBody, errInvalidGrant.Error()) {
+			return apiAccess, errors.Wrapf(errInvalidGrant, "error getting refresh token [%s]: %s", resp.Status, tokenBody)
+		}
+
 		return apiAccess, fmt.Errorf("error getting refresh token [%s]: %s", resp.Status, tokenBody)
 	}
 
@@ -379,6 +562,7 @@ func (sc *StepCurry) exchangeRefreshTokenForApiAccess(refreshToken string) (apiA
 	if err != nil {
 		return apiAccess, errors.Wrap(err, "error decoding api access response")
 	}
+	apiAccess.setExpiry(sc.clock.Now())
 
 	return apiAccess, nil
 }
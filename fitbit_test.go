@@ -2,18 +2,23 @@ package stepcurry
 
 import (
 	"cloud.google.com/go/datastore"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestHandleFitbitAuthCallbackUrlParsing(t *testing.T) {
@@ -423,7 +428,7 @@ func TestHandleFitbitAuthCallbackWithErrorPersistingClientAccess(t *testing.T) {
 	}), mock.Anything).Return(nil)
 	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
 		return k.Namespace == "" && k.Name == "1020" && k.Parent == nil && k.Kind == "FitbitApiAccess"
-	}), &FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"}).Return(nil, fmt.Errorf("backend error"))
+	}), matchesIssuedApiAccess(FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"})).Return(nil, fmt.Errorf("backend error"))
 	defer storer.AssertExpectations(t)
 
 	messenger := &mocks.Messenger{}
@@ -492,7 +497,7 @@ func TestHandleFitbitAuthCallbackWithErrorSendingResultMessage(t *testing.T) {
 	}), mock.Anything).Return(nil)
 	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
 		return k.Namespace == "" && k.Name == "1020" && k.Parent == nil && k.Kind == "FitbitApiAccess"
-	}), &FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"}).Return(nil, nil)
+	}), matchesIssuedApiAccess(FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"})).Return(nil, nil)
 	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
 		return k.Namespace == "TSOMETHING" && k.Name == "UCODE" && k.Parent == nil && k.Kind == "ClientAccess"
 	}), &ClientAccess{SlackUser: "UCODE", FitbitUser: "1020", SlackTeam: "TSOMETHING"}).Return(nil, nil)
@@ -502,9 +507,14 @@ func TestHandleFitbitAuthCallbackWithErrorSendingResultMessage(t *testing.T) {
 	defer messenger.AssertExpectations(t)
 
 	taskScheduler := &mocks.TaskScheduler{}
+	taskScheduler.On("GenerateQueueID").Return("queue/path")
+	taskScheduler.On("CreateTask", mock.Anything, mock.MatchedBy(func(req *taskspb.CreateTaskRequest) bool {
+		return strings.HasPrefix(req.GetTask().GetName(), "queue/path/tasks/token-sweep-")
+	})).Return(nil, nil)
 	defer taskScheduler.AssertExpectations(t)
 
 	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "UCODE").Return(&slack.User{ID: "UCODE", Locale: "en-US"}, nil)
 	defer userInfoFinder.AssertExpectations(t)
 
 	conversationMemberFinder := &mocks.ConversationMemberFinder{}
@@ -533,7 +543,7 @@ func TestHandleFitbitAuthCallback(t *testing.T) {
 
 	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		apiAccess := FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"}
+		apiAccess := FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh", ExpiresIn: 28800}
 		body, _ := json.Marshal(apiAccess)
 		w.Write(body)
 	})
@@ -563,7 +573,7 @@ func TestHandleFitbitAuthCallback(t *testing.T) {
 	}), mock.Anything).Return(nil)
 	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
 		return k.Namespace == "" && k.Name == "1020" && k.Parent == nil && k.Kind == "FitbitApiAccess"
-	}), &FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh"}).Return(nil, nil)
+	}), matchesIssuedApiAccess(FitbitApiAccess{Token: "token", FitbitUser: "1020", RefreshToken: "refresh", ExpiresIn: 28800})).Return(nil, nil)
 	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
 		return k.Namespace == "TSOMETHING" && k.Name == "UCODE" && k.Parent == nil && k.Kind == "ClientAccess"
 	}), &ClientAccess{SlackUser: "UCODE", SlackTeam: "TSOMETHING", FitbitUser: "1020"}).Return(nil, nil)
@@ -574,9 +584,14 @@ func TestHandleFitbitAuthCallback(t *testing.T) {
 	defer messenger.AssertExpectations(t)
 
 	taskScheduler := &mocks.TaskScheduler{}
+	taskScheduler.On("GenerateQueueID").Return("queue/path")
+	taskScheduler.On("CreateTask", mock.Anything, mock.MatchedBy(func(req *taskspb.CreateTaskRequest) bool {
+		return strings.HasPrefix(req.GetTask().GetName(), "queue/path/tasks/token-sweep-")
+	})).Return(nil, nil)
 	defer taskScheduler.AssertExpectations(t)
 
 	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "UCODE").Return(&slack.User{ID: "UCODE", Locale: "en-US"}, nil)
 	defer userInfoFinder.AssertExpectations(t)
 
 	conversationMemberFinder := &mocks.ConversationMemberFinder{}
@@ -596,9 +611,261 @@ func TestHandleFitbitAuthCallback(t *testing.T) {
 	assert.Equal(t, "<html><head><meta http-equiv=\"refresh\" content=\"0;URL=slack://channel?team=TSOMETHING&id=CGEN\"></head></html>", string(rbody))
 }
 
+// matchesIssuedApiAccess matches a freshly issued api access on everything but the time it was issued at
+func matchesIssuedApiAccess(expected FitbitApiAccess) interface{} {
+	return mock.MatchedBy(func(apiAccess *FitbitApiAccess) bool {
+		expectedExpiry := time.Time{}
+		if expected.ExpiresIn > 0 {
+			expectedExpiry = apiAccess.RefreshedAt.Add(time.Duration(expected.ExpiresIn) * time.Second)
+		}
+
+		return apiAccess.FitbitUser == expected.FitbitUser && apiAccess.Token == expected.Token && apiAccess.RefreshToken == expected.RefreshToken &&
+			!apiAccess.RefreshedAt.IsZero() && apiAccess.Expiry.Equal(expectedExpiry)
+	})
+}
+
 func authIDStateToQueryParam(authIDState AuthIdentificationState) (queryParam string) {
 	json, _ := json.Marshal(authIDState)
 	queryParam = base64.URLEncoding.EncodeToString(json)
 
 	return queryParam
 }
+
+func TestFetchActivitySummaryWithRefresh(t *testing.T) {
+	tests := map[string]struct {
+		apiAccess             FitbitApiAccess
+		storedAccess          FitbitApiAccess
+		expectTokenExchange   bool
+		expectedStoredAccess  *FitbitApiAccess
+		expectedFetchedTokens []string
+	}{
+		"RefreshExpiredToken": {
+			apiAccess:             FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"},
+			storedAccess:          FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"},
+			expectTokenExchange:   true,
+			expectedStoredAccess:  &FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"},
+			expectedFetchedTokens: []string{"Bearer expired", "Bearer fresh"},
+		},
+		"RefreshExpiringTokenAheadOfTime": {
+			apiAccess:             FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expiring", RefreshToken: "refresh1", Expiry: time.Now().Add(time.Minute)},
+			storedAccess:          FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expiring", RefreshToken: "refresh1", Expiry: time.Now().Add(time.Minute)},
+			expectTokenExchange:   true,
+			expectedStoredAccess:  &FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"},
+			expectedFetchedTokens: []string{"Bearer fresh"},
+		},
+		"ReuseTokenRefreshedConcurrently": {
+			apiAccess:             FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"},
+			storedAccess:          FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "concurrent", RefreshToken: "refreshConcurrent"},
+			expectTokenExchange:   false,
+			expectedStoredAccess:  nil,
+			expectedFetchedTokens: []string{"Bearer expired", "Bearer concurrent"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			fetchedTokens := make([]string, 0)
+			mux.HandleFunc("/1/user/FITBITUSER/activities/date/2019-10-11.json", func(w http.ResponseWriter, r *http.Request) {
+				fetchedTokens = append(fetchedTokens, r.Header.Get("Authorization"))
+				if r.Header.Get("Authorization") == "Bearer expired" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 4000}}`)
+			})
+
+			tokenExchanges := 0
+			mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+				tokenExchanges++
+				r.ParseForm()
+				assert.Equal(t, "refresh1", r.Form.Get("refresh_token"))
+
+				body, _ := json.Marshal(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"})
+				w.Write(body)
+			})
+
+			storer := &mocks.Datastorer{}
+			storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+				return f(c)
+			})
+			storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+				return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+			}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				returnVal := args.Get(2).(*FitbitApiAccess)
+				*returnVal = tc.storedAccess
+			})
+			if tc.expectedStoredAccess != nil {
+				storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+				}), matchesIssuedApiAccess(*tc.expectedStoredAccess)).Return(nil, nil)
+			}
+			defer storer.AssertExpectations(t)
+
+			teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+			require.NoError(t, err)
+
+			steps, goal, err := sc.getUserSteps("U1", tc.apiAccess, time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+
+			assert.Equal(t, 4000, steps)
+			assert.Equal(t, 10000, goal)
+			assert.Equal(t, tc.expectedFetchedTokens, fetchedTokens)
+			if tc.expectTokenExchange {
+				assert.Equal(t, 1, tokenExchanges)
+			} else {
+				assert.Equal(t, 0, tokenExchanges)
+			}
+		})
+	}
+}
+
+func TestRefreshApiAccessWithInvalidGrant(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":[{"errorType":"invalid_grant","message":"Refresh token invalid"}],"success":false}`)
+	})
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	defer storer.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	_, err = sc.refreshApiAccess("U1", FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"})
+	require.Error(t, err)
+	assert.True(t, isInvalidGrant(err))
+}
+
+func TestConcurrentRefreshApiAccessReusesWinnerToken(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The first exchange only completes once the second refresh had time to start so that both refreshers read the
+	// same refresh token. A second exchange would be rejected since refresh tokens can only be used once
+	firstExchangeStarted := make(chan struct{})
+	secondRefreshStarted := make(chan struct{})
+	var mu sync.Mutex
+	exchanges := 0
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		exchanges++
+		exchange := exchanges
+		mu.Unlock()
+
+		r.ParseForm()
+		if exchange > 1 || r.Form.Get("refresh_token") != "refresh1" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"errorType":"invalid_grant","message":"Refresh token invalid"}],"success":false}`)
+			return
+		}
+
+		close(firstExchangeStarted)
+		<-secondRefreshStarted
+		time.Sleep(50 * time.Millisecond)
+
+		body, _ := json.Marshal(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"})
+		w.Write(body)
+	})
+
+	storage := NewMemoryStorage()
+	expiredAccess := FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"}
+	require.NoError(t, storage.PutFitbitApiAccess(context.Background(), expiredAccess))
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorage(storage), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	var winnerAccess FitbitApiAccess
+	var winnerErr error
+	winnerDone := make(chan struct{})
+	go func() {
+		winnerAccess, winnerErr = sc.refreshApiAccess("U1", expiredAccess)
+		close(winnerDone)
+	}()
+
+	<-firstExchangeStarted
+	close(secondRefreshStarted)
+	loserAccess, loserErr := sc.refreshApiAccess("U1", expiredAccess)
+	<-winnerDone
+
+	require.NoError(t, winnerErr)
+	require.NoError(t, loserErr)
+	assert.Equal(t, 1, exchanges)
+	assert.Equal(t, "fresh", winnerAccess.Token)
+	assert.Equal(t, "fresh", loserAccess.Token)
+	assert.Equal(t, "refresh2", loserAccess.RefreshToken)
+
+	storedAccess, err := storage.GetFitbitApiAccess(context.Background(), "FITBITUSER")
+	require.NoError(t, err)
+	assert.Equal(t, "refresh2", storedAccess.RefreshToken)
+}
+
+func TestRefreshApiAccessRetriedTransactionReusesExchangedToken(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	exchanges := 0
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		body, _ := json.Marshal(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"})
+		w.Write(body)
+	})
+
+	// The transaction is run twice as if the first attempt's commit had failed because of contention
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		err := f(c)
+		if err != nil {
+			return err
+		}
+
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*FitbitApiAccess)
+		*returnVal = FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"}
+	})
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+	}), matchesIssuedApiAccess(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2"})).Return(nil, nil).Twice()
+	defer storer.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	apiAccess, err := sc.refreshApiAccess("U1", FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expired", RefreshToken: "refresh1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, exchanges)
+	assert.Equal(t, "fresh", apiAccess.Token)
+}
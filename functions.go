@@ -1,7 +1,6 @@
 package stepcurry
 
 import (
-	"cloud.google.com/go/datastore"
 	"context"
 	cryptorand "crypto/rand"
 	"encoding/base64"
@@ -11,21 +10,12 @@ import (
 	"github.com/slack-go/slack"
 	"github.com/pkg/errors"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"time"
 )
 
-// Env variables, all mandatory
-const (
-	slackTokenEnvKey         = "SLACK_TOKEN"
-	slackSigningSecretEnvKey = "SLACK_SIGNING_SECRET"
-	fitbitClientIDEnvKey     = "FITBIT_CLIENT_ID"
-	fitbitClientSecretEnvKey = "FITBIT_CLIENT_SECRET"
-)
-
 // Slack parameters
 const (
 	textParam        = "text"
@@ -33,22 +23,42 @@ const (
 	channelIDParam   = "channel_id"
 	teamIDParam      = "team_id"
 	responseURLParam = "response_url"
+	triggerIDParam   = "trigger_id"
 )
 
 // Server paths
 const (
-	updateChallengePath = "UpdateChallenge"
-	oauthCallbackPath   = "HandleFitbitAuth"
-	linkAccountPath     = "LinkAccount"
-	startChallengePath  = "Challenge"
-	standingsPath       = "Standings"
+	updateChallengePath    = "UpdateChallenge"
+	oauthCallbackPath      = "HandleFitbitAuth"
+	linkAccountPath        = "LinkAccount"
+	startChallengePath     = "Challenge"
+	standingsPath          = "Standings"
+	leaderboardPath        = "Leaderboard"
+	badgesPath             = "Badges"
+	remindersPath          = "Reminders"
+	mePath                 = "Me"
+	sweepTokensPath        = "SweepTokens"
+	fitbitSubscriptionPath = "FitbitSubscription"
+	recordActivityPath     = "RecordActivity"
+	publishHomePath        = "PublishHome"
+	perfectMonthPath       = "EvaluatePerfectMonth"
+	installSlackPath       = "InvokeSlackAuth"
+	slackAuthCallbackPath  = "HandleSlackAuth"
+	configPath             = "Config"
+	interactionPath        = "Interaction"
+	eventsPath             = "Events"
 )
 
 // Slash command names
 const (
-	commandLinkFitbit = "/step-link"
-	commandChallenge  = "/step-challenge"
-	commandStandings  = "/step-standings"
+	commandLinkFitbit  = "/step-link"
+	commandChallenge   = "/step-challenge"
+	commandStandings   = "/step-standings"
+	commandLeaderboard = "/step-leaderboard"
+	commandBadges      = "/step-badges"
+	commandReminders   = "/step-reminders"
+	commandMe          = "/step-me"
+	commandConfig      = "/step-config"
 )
 
 // Date formats
@@ -61,21 +71,16 @@ const (
 	version = "1.0.0"
 )
 
-var updateBanners = [...]string{":rolled_up_newspaper: _Breaking news_, here are the current steps ranking",
-	":loudspeaker: Oh snap, look who's winning the race!",
-	":wind_blowing_face::athletic_shoe: _The more you take, the more you leave behind_...here's the latest steps count update",
-	":thinking_face: All truly great thoughts are conceived while walking (and you also get to stay competitive in this challenge)",
-	":fairy: Walking is a great adventure...and if you do it enough, you might get the top spot in this list"}
-
-var winnerAccouncementBanners = [...]string{":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:"}
-
 var selectionRandom = rand.New(rand.NewSource(time.Now().Unix()))
 
 // ClientAccess holds the data linking a slack user to their fitbit account
 type ClientAccess struct {
-	SlackUser  string `datastore:"slackUser"`
-	SlackTeam  string `datastore:"slackTeam"`
-	FitbitUser string `datastore:"fitbitUser"`
+	SlackUser          string `datastore:"slackUser"`
+	SlackTeam          string `datastore:"slackTeam"`
+	FitbitUser         string `datastore:"fitbitUser"`
+	FetchFailures      int    `datastore:"fetchFailures,noindex"`
+	LinkBroken         bool   `datastore:"linkBroken,noindex"`
+	BrokenLinkNotified bool   `datastore:"brokenLinkNotified,noindex"`
 }
 
 // ChallengeID holds the attributes composing a challenge identifier
@@ -92,16 +97,36 @@ type ChallengeID struct {
 // separately here and are explicit about keeping the timezone information
 type StepsChallenge struct {
 	ChallengeID
-	Active       bool        `datastore:"active"`
-	CreatorID    string      `datastore:"createdBy,noindex"`
-	CreationTime time.Time   `datastore:"creationTime"`
-	TimezoneID   string      `datastore:"timezoneID"`
-	RankedUsers  []UserSteps `datastore:"rankedUsers,noindex"`
+	Active        bool        `datastore:"active"`
+	CreatorID     string      `datastore:"createdBy,noindex"`
+	CreationTime  time.Time   `datastore:"creationTime"`
+	TimezoneID    string      `datastore:"timezoneID"`
+	RankedUsers   []UserSteps `datastore:"rankedUsers,noindex"`
+	// RemindersSent is no longer used since reminders are tracked per user but is kept so stored challenges still load
+	RemindersSent bool `datastore:"remindersSent,noindex"`
+	// Metric, ExplicitOptIn and Participants are set from the workspace settings when the challenge starts so that
+	// changing settings doesn't affect challenges in progress. Participants are only tracked with explicit opt-in
+	Metric        string   `datastore:"metric,noindex"`
+	ExplicitOptIn bool     `datastore:"explicitOptIn,noindex"`
+	Participants  []string `datastore:"participants,noindex"`
 }
 
-// BotInfo holds the bot info
+// BotInfo holds the bot info of an installation along with the enterprise and team it was installed on
 type BotInfo struct {
-	UserID string `datastore:"botUserID"`
+	UserID            string `datastore:"botUserID"`
+	EnterpriseID      string `datastore:"enterpriseID,noindex"`
+	TeamID            string `datastore:"teamID,noindex"`
+	EnterpriseInstall bool   `datastore:"enterpriseInstall,noindex"`
+}
+
+// Installation returns the installation a bot info was saved for. Bot infos saved before Enterprise Grid support have
+// no team ID so the tenant ID they're keyed by is used
+func (bi BotInfo) Installation(tenantID string) Installation {
+	if bi.TeamID == "" && bi.EnterpriseID == "" {
+		return Installation{TeamID: tenantID}
+	}
+
+	return Installation{EnterpriseID: bi.EnterpriseID, TeamID: bi.TeamID, EnterpriseInstall: bi.EnterpriseInstall}
 }
 
 // ActionResponse holds data for a response to a slash command or action
@@ -135,28 +160,22 @@ func (sc *StepCurry) StartFitbitOauthFlow(w http.ResponseWriter, r *http.Request
 	userID := params[userIDParam]
 	responseURL := params[responseURLParam]
 
-	csrfBytesArr := make([]byte, 16)
-	cryptorand.Read(csrfBytesArr)
-	csrfToken := CsrfToken{Csrf: csrfBytesArr}
-	authIDState := AuthIdentificationState{SlackUser: userID, SlackTeam: params[teamIDParam], SlackChannel: params[channelIDParam], ResponseURL: responseURL, CsrfToken: csrfToken}
-	oauthState, err := json.Marshal(authIDState)
+	authIDState, err := sc.newAuthIdentificationState(commandTenantID(params), userID, params[channelIDParam], responseURL)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error persisting csrf token for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	authorizeURL, err := sc.fitbitAuthorizeURL(authIDState)
 	if err != nil {
 		return newHttpError(err, "Error generating AuthIdentificationState", http.StatusInternalServerError)
 	}
 
-	csrfKey := NewKeyWithNamespace("CsrfToken", authIDState.SlackTeam, authIDState.SlackUser, nil)
-	ctx := context.Background()
-	_, err = sc.storer.Put(ctx, csrfKey, &csrfToken)
+	svcs, err := sc.Route(authIDState.SlackTeam)
 	if err != nil {
-		return newHttpError(err, fmt.Sprintf("Error persisting csrf token for user [%s]", authIDState.SlackUser), http.StatusInternalServerError)
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", authIDState.SlackTeam), http.StatusInternalServerError)
 	}
 
-	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, sc.paths.FitbitAuthCallback)
-	oauthLink := fmt.Sprintf("<%s/oauth2/authorize?response_type=code&client_id=%s&redirect_uri=%s&scope=activity&prompt=login_consent&state=%s", sc.fitbitAuthBaseURL, sc.fitbitClientID, url.QueryEscape(redirectURI), base64.URLEncoding.EncodeToString(oauthState))
-	oauthFlowMsg := fmt.Sprintf("%s|Head over> to Fitbit to login and authorize access to your account.\n\n"+
-		"If you consent, _Step Curry_ will use this to get your daily activity summary that will be shared in steps challenges you participate in. "+
-		"Note that you'll automatically be included in a steps challenge if you link your Fitbit account and are a "+
-		"member of a channel where a steps challenge is active.", oauthLink)
+	oauthFlowMsg := svcs.userMessages(authIDState.SlackUser).render(msgLinkPrompt, messageData{"AuthorizeURL": authorizeURL})
 	oauthFlowMessage := ActionResponse{ResponseType: "ephemeral", Text: oauthFlowMsg}
 	resp, err := req.Post(responseURL, req.BodyJSON(&oauthFlowMessage))
 	if err != nil || resp.Response().StatusCode != 200 {
@@ -170,6 +189,30 @@ func (sc *StepCurry) StartFitbitOauthFlow(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+// newAuthIdentificationState creates the state identifying a user through the Fitbit oauth flow along with a new csrf
+// token persisted to be checked when the flow completes. The response url is empty when the flow doesn't originate
+// from a slash command
+func (sc *StepCurry) newAuthIdentificationState(teamID string, userID string, channelID string, responseURL string) (authIDState AuthIdentificationState, err error) {
+	csrfBytesArr := make([]byte, 16)
+	cryptorand.Read(csrfBytesArr)
+	csrfToken := CsrfToken{Csrf: csrfBytesArr}
+	authIDState = AuthIdentificationState{SlackUser: userID, SlackTeam: teamID, SlackChannel: channelID, ResponseURL: responseURL, CsrfToken: csrfToken}
+
+	err = sc.storage.PutCsrfToken(context.Background(), teamID, userID, csrfToken)
+	return authIDState, err
+}
+
+// fitbitAuthorizeURL returns the Fitbit url a user heads to to authorize access to their account
+func (sc *StepCurry) fitbitAuthorizeURL(authIDState AuthIdentificationState) (authorizeURL string, err error) {
+	oauthState, err := json.Marshal(authIDState)
+	if err != nil {
+		return "", err
+	}
+
+	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, sc.paths.FitbitAuthCallback)
+	return fmt.Sprintf("%s/oauth2/authorize?response_type=code&client_id=%s&redirect_uri=%s&scope=activity&prompt=login_consent&state=%s", sc.fitbitAuthBaseURL, sc.fitbitClientID, url.QueryEscape(redirectURI), base64.URLEncoding.EncodeToString(oauthState)), nil
+}
+
 // parseSlackRequest parses a slack request parameters. Since slack request parameters have a single value,
 // the parsed query parameters are assumed to have a single value as well
 func parseSlackRequest(requestBody string) (params map[string]string, err error) {
@@ -209,26 +252,39 @@ func (sc *StepCurry) Challenge(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	channel := params[channelIDParam]
-	teamID := params[teamIDParam]
+	teamID := commandTenantID(params)
 	userID := params[userIDParam]
 	responseURL := params[responseURLParam]
+	ctx := withLogFields(r.Context(), Fields{teamIDField: teamID, channelIDField: channel, userIDField: userID})
 
-	timezoneID, location, err := sc.getChannelTimezone(channel)
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	timezoneID, location, err := sc.getChannelTimezone(svcs, channel)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error getting channel timezone for channel [%s]", channel), http.StatusInternalServerError)
 	}
 
 	// Write the initial challenge
-	creationTime := time.Now().In(location)
+	creationTime := sc.clock.Now().In(location)
 	challengeID := ChallengeID{ChannelID: channel, TeamID: teamID, Date: creationTime.Format(challengeDateFormat)}
 
+	denial, err := sc.checkStartChallenge(svcs, challengeID, userID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error checking if user [%s] can start challenge [%s]", userID, challengeID.Key()), http.StatusInternalServerError)
+	}
+
+	if denial != nil {
+		notAllowedMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(denial.message, denial.data)}
+		return sendResponse(responseURL, notAllowedMsg, "challenge not allowed")
+	}
+
 	// Check if the challenge exists first and return ephemeral message if it does
-	ctx := context.Background()
-	k := NewKeyWithNamespace("StepsChallenge", teamID, challengeID.Key(), nil)
-	var existingChallenge StepsChallenge
-	err = sc.storer.Get(ctx, k, &existingChallenge)
+	existingChallenge, err := sc.storage.GetChallenge(ctx, challengeID)
 	if err == nil && existingChallenge.Active {
-		membershipWarnMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: ":warning: There's already an active steps challenge so you know ¯\\_(ツ)_/¯"}
+		membershipWarnMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgChallengeAlreadyActive, nil)}
 		resp, err := req.Post(responseURL, req.BodyJSON(&membershipWarnMsg))
 		if err != nil || resp.Response().StatusCode != 200 {
 			if err != nil {
@@ -241,22 +297,31 @@ func (sc *StepCurry) Challenge(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	svcs, err := sc.Route(teamID)
-	if err != nil {
-		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	explicitOptIn := svcs.settings.OptInMode == optInExplicit
+	announcementOptions := make([]slack.MsgOption, 0)
+	if explicitOptIn {
+		announcement := svcs.messages.render(msgOptInStarted, messageData{"UserID": userID, "LinkCommand": sc.slashCommands.Link})
+		announcementOptions = append(announcementOptions, slack.MsgOptionText(announcement, false), slack.MsgOptionBlocks(slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", announcement, false, false), nil, nil), joinChallengeButton(svcs.messages, challengeID)))
+	} else {
+		announcement := svcs.messages.render(msgChallengeStarted, messageData{"UserID": userID, "LinkCommand": sc.slashCommands.Link})
+		announcementOptions = append(announcementOptions, slack.MsgOptionText(announcement, false))
+	}
+
+	_, _, err = svcs.messenger.PostMessage(channel, svcs.messages.postOptions(announcementOptions...)...)
+	// Public channels can be joined without an invite so the announcement is retried once the app is a member. Private
+	// channels and conversations aren't visible to the app (channel_not_found) and can't be joined
+	if err != nil && err.Error() == "not_in_channel" && sc.joinChannel(ctx, svcs, channel) {
+		_, _, err = svcs.messenger.PostMessage(channel, svcs.messages.postOptions(announcementOptions...)...)
 	}
 
-	_, _, err = svcs.messenger.PostMessage(channel, slack.MsgOptionText(fmt.Sprintf("<@%s> started a steps challenge! Get moving :wind_blowing_face::athletic_shoe:. If you haven't linked your fitbit account already, type `%s` and join in on the challenge.", userID, sc.slashCommands.Link), false))
 	if err != nil {
-		// TODO: consider an additional layered fallback strategy where we use https://godoc.org/github.com/slack-go/slack#Client.JoinConversation to try and join (that would work for public channels)
-		// before falling back to a message with instructions
 		if err.Error() == "channel_not_found" || err.Error() == "not_in_channel" {
 			botUserID, err := svcs.botIdentificator.GetBotID()
 			if err != nil {
 				return newHttpError(err, "Error getting bot info to send membership warning message", http.StatusInternalServerError)
 			}
 
-			membershipWarnMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: fmt.Sprintf("I can't start a challenge in a channel or conversation I'm not a member of. Add me, <@%s> and try again :bow:", botUserID)}
+			membershipWarnMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgNotAMember, messageData{"BotUserID": botUserID})}
 			resp, err := req.Post(responseURL, req.BodyJSON(&membershipWarnMsg))
 			if err != nil || resp.Response().StatusCode != 200 {
 				if err != nil {
@@ -272,14 +337,18 @@ func (sc *StepCurry) Challenge(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	stepsChallenge := StepsChallenge{ChallengeID: challengeID, Active: true, CreatorID: userID, CreationTime: creationTime, TimezoneID: timezoneID}
+	stepsChallenge := StepsChallenge{ChallengeID: challengeID, Active: true, CreatorID: userID, CreationTime: creationTime, TimezoneID: timezoneID, Metric: svcs.settings.Metric, ExplicitOptIn: explicitOptIn}
+	// The creator of a challenge with explicit opt-in joins it right away
+	if explicitOptIn {
+		stepsChallenge.Participants = []string{userID}
+	}
 
-	_, err = sc.storer.Put(ctx, k, &stepsChallenge)
+	err = sc.storage.PutChallenge(ctx, stepsChallenge)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error persisting challenge for team [%s] and channel [%s]", teamID, channel), http.StatusInternalServerError)
 	}
 
-	err = sc.scheduleChallengeUpdate(challengeID, time.Now())
+	err = sc.scheduleChallengeUpdate(challengeID, sc.clock.Now())
 	if err != nil {
 		return newHttpError(err, "Error scheduling task", http.StatusInternalServerError)
 	}
@@ -288,32 +357,24 @@ func (sc *StepCurry) Challenge(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// getChannelTimezone finds what should be the "master" timezone for a channel which informs the scheduling of the updates
-func (sc *StepCurry) getChannelTimezone(channelID string) (timezoneID string, location *time.Location, err error) {
-	// TODO: Replace this hardcoded value by logic to determine the winning channel timezone/location. This could be by
-	//   1. Getting the timezone from the slack user info and getting the most common one amongst participating fitbit users
-	//   2. If that fails because no one has linked their fitbit account yet, default to the channel creator and get their timezone from their user info
-	//   3. If all fails, return "America/Los_Angeles" for now
-	timezoneID = "America/Los_Angeles"
-	location, err = time.LoadLocation(timezoneID)
-	return timezoneID, location, err
+// getChannelTimezone finds what should be the "master" timezone for a channel which informs the scheduling of the updates.
+// Channels follow the timezone set in their workspace's settings
+func (sc *StepCurry) getChannelTimezone(svcs TeamServices, channelID string) (timezoneID string, location *time.Location, err error) {
+	return svcs.settings.location(svcs.logger)
 }
 
 // refreshChallenge gets updated step summaries from the fitbit API for all the fitbit users
 // part of a steps challenge and then renders and sends an updated ranking to the slack channel
-func (sc *StepCurry) refreshChallenge(stepsChallenge StepsChallenge) (err error) {
-	rankedUsers, err := sc.getChallengeRankedSteps(stepsChallenge)
+func (sc *StepCurry) refreshChallenge(ctx context.Context, stepsChallenge StepsChallenge) (err error) {
+	rankedUsers, unsyncedCount, err := sc.getChallengeRankedSteps(ctx, stepsChallenge)
 	if err != nil {
 		return errors.Wrap(err, "error getting activity summaries")
 	}
 
-	// Update the state
-	ctx := context.Background()
 	stepsChallenge.RankedUsers = rankedUsers
-	k := NewKeyWithNamespace("StepsChallenge", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key(), nil)
-	_, err = sc.storer.Put(ctx, k, &stepsChallenge)
+	streaks, err := sc.recordStreaks(stepsChallenge)
 	if err != nil {
-		return errors.Wrapf(err, "error persisting challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+		return errors.Wrapf(err, "error recording streaks for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
 	}
 
 	svcs, err := sc.Route(stepsChallenge.TeamID)
@@ -321,14 +382,26 @@ func (sc *StepCurry) refreshChallenge(stepsChallenge StepsChallenge) (err error)
 		return errors.Wrapf(err, "error getting api services for team ID [%s]", stepsChallenge.TeamID)
 	}
 
+	err = sc.sendStreakReminders(ctx, svcs, stepsChallenge, streaks)
+	if err != nil {
+		return errors.Wrapf(err, "error sending streak reminders for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	// Update the state
+	err = sc.storage.PutChallenge(ctx, stepsChallenge)
+	if err != nil {
+		return errors.Wrapf(err, "error persisting challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
 	renderBlocks := make([]slack.Block, 0)
-	renderedRanking := sc.renderStepsRanking(svcs, rankedUsers)
+	renderedRanking := sc.renderStepsRanking(svcs, stepsChallenge.Metric, rankedUsers, streaks)
 	if len(renderedRanking) > 0 {
-		bannerText := updateBanners[selectionRandom.Intn(len(updateBanners))]
+		bannerText := svcs.messages.render(msgUpdateBanner, nil)
 		renderBlocks = append(renderBlocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", bannerText, false, false), nil, nil))
 		renderBlocks = append(renderBlocks, renderedRanking...)
+		renderBlocks = append(renderBlocks, renderUnsyncedNote(svcs.messages, unsyncedCount)...)
 
-		_, _, err = svcs.messenger.PostMessage(stepsChallenge.ChannelID, slack.MsgOptionText(bannerText, false), slack.MsgOptionBlocks(renderBlocks...))
+		_, _, err = svcs.messenger.PostMessage(stepsChallenge.ChannelID, svcs.messages.postOptions(slack.MsgOptionText(bannerText, false), slack.MsgOptionBlocks(renderBlocks...))...)
 		if err != nil {
 			return errors.Wrap(err, "error sending slack message")
 		}
@@ -338,6 +411,23 @@ func (sc *StepCurry) refreshChallenge(stepsChallenge StepsChallenge) (err error)
 	return nil
 }
 
+// joinChannel joins a public channel and returns true if the app is now a member of it. Failures are logged since the
+// caller falls back to asking users to invite the app
+func (sc *StepCurry) joinChannel(ctx context.Context, svcs TeamServices, channelID string) (joined bool) {
+	if svcs.conversationJoiner == nil {
+		return false
+	}
+
+	_, _, _, err := svcs.conversationJoiner.JoinConversation(channelID)
+	if err != nil {
+		sc.log(ctx).with(Fields{channelIDField: channelID}).withError(err).warningf("Error joining channel, asking for an invite instead")
+		return false
+	}
+
+	sc.log(ctx).with(Fields{channelIDField: channelID}).infof("Joined channel to announce a challenge")
+	return true
+}
+
 // localizeCreationTime takes a creation time and a timezone id and localizes that time using the matching time.Location
 func localizeCreationTime(creationTime time.Time, timezoneID string) (localized time.Time, location *time.Location, err error) {
 	location, err = time.LoadLocation(timezoneID)
@@ -349,20 +439,63 @@ func localizeCreationTime(creationTime time.Time, timezoneID string) (localized
 }
 
 // wrapUpChallenge posts the winner of a challenge and marks the challenge as inactive
-func (sc *StepCurry) wrapUpChallenge(stepsChallenge StepsChallenge) (err error) {
-	rankedUsers, err := sc.getChallengeRankedSteps(stepsChallenge)
+func (sc *StepCurry) wrapUpChallenge(ctx context.Context, stepsChallenge StepsChallenge) (err error) {
+	rankedUsers, unsyncedCount, err := sc.getChallengeRankedSteps(ctx, stepsChallenge)
 	if err != nil {
 		return errors.Wrap(err, "error getting activity summaries")
 	}
 
-	// Update the state with the final ranking and mark the challenge as inactive
+	// Update the state with the final ranking and mark the challenge as inactive. Results are counted towards
+	// leaderboards in the same transaction so that they're counted exactly once, even when the final update gets
+	// retried after a failure or when challenges of the workspace wrapping up at the same time update the same
+	// leaderboards
+	wasActive := false
 	stepsChallenge.RankedUsers = rankedUsers
-	stepsChallenge.Active = false
-	ctx := context.Background()
-	k := NewKeyWithNamespace("StepsChallenge", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key(), nil)
-	_, err = sc.storer.Put(ctx, k, &stepsChallenge)
+	err = sc.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		storedChallenge, err := sc.storage.GetChallenge(tc, stepsChallenge.ChallengeID)
+		if err != nil {
+			return errors.Wrapf(err, "Error loading challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+		}
+
+		wasActive = storedChallenge.Active
+		if wasActive {
+			err = sc.recordLeaderboardResults(tc, stepsChallenge)
+			if err != nil {
+				return errors.Wrapf(err, "Error recording leaderboard results for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+			}
+		}
+
+		stepsChallenge.Active = false
+		err = sc.storage.PutChallenge(tc, stepsChallenge)
+		if err != nil {
+			return errors.Wrapf(err, "Error persisting final challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Perfect months are evaluated once the month is over rather than with a challenge. The evaluation is scheduled on
+	// every wrap up, including retried ones, and only happens once
+	err = sc.schedulePerfectMonth(stepsChallenge)
 	if err != nil {
-		return errors.Wrapf(err, "Error persisting final challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+		return errors.Wrapf(err, "Error scheduling perfect month evaluation for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	streaks, err := sc.recordStreaks(stepsChallenge)
+	if err != nil {
+		return errors.Wrapf(err, "Error recording streaks for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	// Only count results towards achievements once in case the final update gets retried
+	awards := make([]BadgeAward, 0)
+	if wasActive {
+		awards, err = sc.evaluateAchievements(stepsChallenge)
+		if err != nil {
+			return errors.Wrapf(err, "Error evaluating achievements for challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+		}
 	}
 
 	svcs, err := sc.Route(stepsChallenge.TeamID)
@@ -371,13 +504,15 @@ func (sc *StepCurry) wrapUpChallenge(stepsChallenge StepsChallenge) (err error)
 	}
 
 	renderBlocks := make([]slack.Block, 0)
-	renderedRanking := sc.renderStepsRanking(svcs, rankedUsers)
+	renderedRanking := sc.renderStepsRanking(svcs, stepsChallenge.Metric, rankedUsers, streaks)
 	if len(renderedRanking) > 0 {
-		bannerText := winnerAccouncementBanners[selectionRandom.Intn(len(winnerAccouncementBanners))]
+		bannerText := svcs.messages.render(msgWinnerBanner, nil)
 		renderBlocks = append(renderBlocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", bannerText, false, false), nil, nil))
 		renderBlocks = append(renderBlocks, renderedRanking...)
+		renderBlocks = append(renderBlocks, renderUnsyncedNote(svcs.messages, unsyncedCount)...)
+		renderBlocks = append(renderBlocks, renderBadgeAwards(svcs.messages, awards)...)
 
-		_, _, err = svcs.messenger.PostMessage(stepsChallenge.ChannelID, slack.MsgOptionText(bannerText, false), slack.MsgOptionBlocks(renderBlocks...))
+		_, _, err = svcs.messenger.PostMessage(stepsChallenge.ChannelID, svcs.messages.postOptions(slack.MsgOptionText(bannerText, false), slack.MsgOptionBlocks(renderBlocks...))...)
 		if err != nil {
 			return errors.Wrap(err, "error sending slack message")
 		}
@@ -396,8 +531,14 @@ func (sc *StepCurry) wrapUpChallenge(stepsChallenge StepsChallenge) (err error)
 	return nil
 }
 
-// renderStepsRanking renders the user steps ranking as slack blocks to me included in a slack message
-func (sc *StepCurry) renderStepsRanking(services TeamServices, rankedUsers []UserSteps) (renderBlocks []slack.Block) {
+// renderStepsRanking renders the user steps ranking as slack blocks to me included in a slack message. Users' streaks
+// are shown next to their step count, or floor count for challenges ranked by floors
+func (sc *StepCurry) renderStepsRanking(services TeamServices, metric string, rankedUsers []UserSteps, streaks map[string]UserStreaks) (renderBlocks []slack.Block) {
+	rankingTemplate := msgRanking
+	if metric == metricFloors {
+		rankingTemplate = msgFloorsRanking
+	}
+
 	renderBlocks = make([]slack.Block, 0)
 
 	if len(rankedUsers) == 0 {
@@ -411,16 +552,13 @@ func (sc *StepCurry) renderStepsRanking(services TeamServices, rankedUsers []Use
 		profileImage := ""
 		realName := ""
 		if err != nil {
-			log.Printf("Error getting user info for [%s]: [%s]", us.UserID, err.Error())
+			newLogEntry(services.logger).with(Fields{teamIDField: services.settings.TeamID, userIDField: us.UserID}).withError(err).warningf("Error getting user info for the ranking")
 		} else {
 			profileImage = userInfo.Profile.Image32
 			realName = userInfo.Profile.RealName
 		}
 
-		rankingText := fmt.Sprintf("_%s_ `%d` :athletic_shoe:", realName, us.Steps)
-		if rank == 1 {
-			rankingText = fmt.Sprintf("_%s_ `%d` :athletic_shoe: :tornado::rocket:", realName, us.Steps)
-		}
+		rankingText := services.messages.render(rankingTemplate, messageData{"Rank": rank, "Name": realName, "Steps": us.Steps, "Floors": us.Floors, "Streaks": renderStreaks(services.messages, streaks[us.UserID])})
 
 		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewImageBlockElement(profileImage, realName), slack.NewTextBlockObject("mrkdwn", rankingText, false, false)))
 		rank++
@@ -447,25 +585,29 @@ func (sc *StepCurry) Standings(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	channel := params[channelIDParam]
-	teamID := params[teamIDParam]
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
 	responseURL := params[responseURLParam]
+	ctx := withLogFields(r.Context(), Fields{teamIDField: teamID, channelIDField: channel, userIDField: userID})
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
 
-	_, location, err := sc.getChannelTimezone(channel)
+	_, location, err := sc.getChannelTimezone(svcs, channel)
 	if err != nil {
 		return newHttpError(err, fmt.Sprintf("Error getting channel timezone for channel [%s]", channel), http.StatusInternalServerError)
 	}
 
 	// Write the initial challenge
-	creationTime := time.Now().In(location)
+	creationTime := sc.clock.Now().In(location)
 	challengeID := ChallengeID{ChannelID: channel, TeamID: teamID, Date: creationTime.Format(challengeDateFormat)}
 
-	ctx := context.Background()
-	var stepsChallenge StepsChallenge
-	k := NewKeyWithNamespace("StepsChallenge", teamID, challengeID.Key(), nil)
-	err = sc.storer.Get(ctx, k, &stepsChallenge)
+	stepsChallenge, err := sc.storage.GetChallenge(ctx, challengeID)
 	// If the challenge doesn't exist and a message to the requester and return
-	if (err != nil && err == datastore.ErrNoSuchEntity) || (err == nil && !stepsChallenge.Active) {
-		noChallengeMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: fmt.Sprintf(":warning: There's no active challenge in this channel to report status on. Create one by using `%s`", sc.slashCommands.Challenge)}
+	if (err != nil && err == ErrNoSuchEntity) || (err == nil && !stepsChallenge.Active) {
+		noChallengeMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgNoActiveChallenge, messageData{"ChallengeCommand": sc.slashCommands.Challenge})}
 		resp, err := req.Post(responseURL, req.BodyJSON(&noChallengeMsg))
 		if err != nil || resp.Response().StatusCode != 200 {
 			if err != nil {
@@ -480,7 +622,7 @@ func (sc *StepCurry) Standings(w http.ResponseWriter, r *http.Request) error {
 		return newHttpError(err, fmt.Sprintf("Error fetching challenge with id [%s.%s]", teamID, challengeID.Key()), http.StatusInternalServerError)
 	}
 
-	err = sc.refreshChallenge(stepsChallenge)
+	err = sc.refreshChallenge(withLogFields(ctx, challengeLogFields(challengeID)), stepsChallenge)
 	if err != nil {
 		return newHttpError(err, "Error refreshing challenge status", http.StatusInternalServerError)
 	}
@@ -502,56 +644,75 @@ func (sc *StepCurry) UpdateChallenge(w http.ResponseWriter, r *http.Request) err
 		return newHttpError(err, "Error decoding challenge id from body", http.StatusInternalServerError)
 	}
 
+	err = sc.updateChallenge(r.Context(), challengeID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error updating challenge [%s.%s]", challengeID.TeamID, challengeID.Key()), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// updateChallenge posts an update of a challenge and schedules the next one. Updates are posted at the workspace's
+// cadence during the day of the challenge until quiet hours start in the evening. The last update of the day schedules
+// the final update announcing the winner the next morning when quiet hours end
+func (sc *StepCurry) updateChallenge(ctx context.Context, challengeID ChallengeID) (err error) {
+	ctx = withLogFields(ctx, challengeLogFields(challengeID))
+
 	// Get the full existing StepsChallenge
-	ctx := context.Background()
-	var stepsChallenge StepsChallenge
-	k := NewKeyWithNamespace("StepsChallenge", challengeID.TeamID, challengeID.Key(), nil)
-	err = sc.storer.Get(ctx, k, &stepsChallenge)
+	stepsChallenge, err := sc.storage.GetChallenge(ctx, challengeID)
 	// If the challenge doesn't exist, return and don't shedule a next update
-	if err != nil && err == datastore.ErrNoSuchEntity {
-		log.Printf("Challenge not found id [%s.%s]", challengeID.TeamID, challengeID.Key())
+	if err != nil && err == ErrNoSuchEntity {
+		sc.log(ctx).warningf("Challenge not found, no more updates scheduled")
 		return nil
 	} else if err != nil {
-		return newHttpError(err, fmt.Sprintf("Error loading existing challenge [%s.%s]", challengeID.TeamID, challengeID.Key()), http.StatusInternalServerError)
+		return errors.Wrapf(err, "error loading existing challenge [%s.%s]", challengeID.TeamID, challengeID.Key())
 	}
 
 	localizedCreationTime, location, err := localizeCreationTime(stepsChallenge.CreationTime, stepsChallenge.TimezoneID)
 	if err != nil {
-		return newHttpError(err, fmt.Sprintf("Error localizing challenge creation time for challenge [%s.%s]", challengeID.TeamID, challengeID.Key()), http.StatusInternalServerError)
+		return errors.Wrapf(err, "error localizing challenge creation time for challenge [%s.%s]", challengeID.TeamID, challengeID.Key())
+	}
+
+	svcs, err := sc.Route(challengeID.TeamID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting api services for team ID [%s]", challengeID.TeamID)
 	}
 
-	endScheduledDayUpdates := getLastDayUpdateTime(localizedCreationTime, location)
+	endScheduledDayUpdates := getLastDayUpdateTime(localizedCreationTime, location, svcs.settings.QuietHoursStart)
 
-	// The final update time is the next day at 8am. We start by setting the time right and then adding one day
-	finalChannelUpdateTime := getFinalUpdateTime(localizedCreationTime, location)
+	// The final update time is the next day when quiet hours end. We start by setting the time right and then adding one day
+	finalChannelUpdateTime := getFinalUpdateTime(localizedCreationTime, location, svcs.settings.QuietHoursEnd)
 
-	switch now := time.Now(); {
-	// We're still in day time during the day of the challenge so we keep posting updates and scheduling hourly refreshes
+	switch now := sc.clock.Now(); {
+	// We're still in day time during the day of the challenge so we keep posting updates and scheduling regular refreshes
 	case !now.After(endScheduledDayUpdates):
-		scheduledUpdate := now.Add(time.Duration(1) + time.Hour)
-		log.Printf("Challenge [%s.%s] scheduled for a regular update at [%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key(), scheduledUpdate)
+		scheduledUpdate := now.Add(time.Duration(1) + time.Duration(svcs.settings.UpdateIntervalMinutes)*time.Minute)
+		sc.log(ctx).infof("Challenge scheduled for a regular update at [%s]", scheduledUpdate)
 
-		err = sc.refreshChallenge(stepsChallenge)
+		err = sc.refreshChallenge(ctx, stepsChallenge)
 		if err != nil {
-			return newHttpError(err, "Error refreshing challenge status", http.StatusInternalServerError)
+			return errors.Wrap(err, "error refreshing challenge status")
 		}
 
 		err = sc.scheduleChallengeUpdate(challengeID, scheduledUpdate)
 		if err != nil {
-			return newHttpError(err, "Error scheduling next challenge update", http.StatusInternalServerError)
+			return errors.Wrap(err, "error scheduling next challenge update")
 		}
 	// We're after the end of day updates before the final update for the winner. Create the task to issue that final update
 	case now.After(endScheduledDayUpdates) && now.Before(finalChannelUpdateTime):
-		log.Printf("Challenge [%s.%s] scheduled for a final update at [%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key(), finalChannelUpdateTime)
+		sc.log(ctx).infof("Challenge scheduled for a final update at [%s]", finalChannelUpdateTime)
 
 		err = sc.scheduleChallengeUpdate(challengeID, finalChannelUpdateTime)
 		if err != nil {
-			return newHttpError(err, "Error scheduling next challenge update", http.StatusInternalServerError)
+			return errors.Wrap(err, "error scheduling next challenge update")
 		}
 	// We're on or after the scheduled final update time so we mark the challenge as inactive after posting the winnner
 	case !now.Before(finalChannelUpdateTime):
-		log.Printf("Wrapping up challenge [%s.%s], no more updates scheduled", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
-		sc.wrapUpChallenge(stepsChallenge)
+		sc.log(ctx).infof("Wrapping up challenge, no more updates scheduled")
+		err = sc.wrapUpChallenge(ctx, stepsChallenge)
+		if err != nil {
+			sc.log(ctx).withError(err).errorf("Error wrapping up challenge")
+		}
 	}
 
 	return nil
@@ -565,15 +726,15 @@ func (id ChallengeID) Key() string {
 }
 
 // getLastDayUpdateTime returns the local time of the last update for the day (the last one before we stop sending notifications because people might be sleeping)
-func getLastDayUpdateTime(creationTime time.Time, location *time.Location) (lastDayUpdateTime time.Time) {
+func getLastDayUpdateTime(creationTime time.Time, location *time.Location, quietHoursStart int) (lastDayUpdateTime time.Time) {
 	creationYear, creationMonth, creationDay := creationTime.Date()
-	return time.Date(creationYear, creationMonth, creationDay, 19, 0, 0, 0, location)
+	return time.Date(creationYear, creationMonth, creationDay, quietHoursStart, 0, 0, 0, location)
 }
 
 // getFinalUpdateTime returns the final challenge update that comes the morning the day after and announces the winner
-func getFinalUpdateTime(creationTime time.Time, location *time.Location) (finalUpdateTime time.Time) {
+func getFinalUpdateTime(creationTime time.Time, location *time.Location, quietHoursEnd int) (finalUpdateTime time.Time) {
 	creationYear, creationMonth, creationDay := creationTime.Date()
-	finalUpdateTime = time.Date(creationYear, creationMonth, creationDay, 8, 0, 0, 0, location)
+	finalUpdateTime = time.Date(creationYear, creationMonth, creationDay, quietHoursEnd, 0, 0, 0, location)
 	finalUpdateTime = finalUpdateTime.AddDate(0, 0, 1)
 
 	return
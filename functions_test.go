@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -175,6 +176,7 @@ func TestStartFitbitOauthFlowErrorSendingSlackMessage(t *testing.T) {
 	defer taskScheduler.AssertExpectations(t)
 
 	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
 	defer userInfoFinder.AssertExpectations(t)
 
 	conversationMemberFinder := &mocks.ConversationMemberFinder{}
@@ -224,6 +226,7 @@ func TestStartFitbitOauthFlow(t *testing.T) {
 	defer taskScheduler.AssertExpectations(t)
 
 	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
 	defer userInfoFinder.AssertExpectations(t)
 
 	conversationMemberFinder := &mocks.ConversationMemberFinder{}
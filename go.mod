@@ -5,16 +5,22 @@ go 1.13
 require (
 	cloud.google.com/go v0.56.0
 	cloud.google.com/go/datastore v1.1.0
+	github.com/BurntSushi/toml v0.3.1
 	github.com/golang/protobuf v1.3.5
 	github.com/googleapis/gax-go/v2 v2.0.5
 	github.com/imroc/req v0.2.4
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/pkg/errors v0.8.1
 	github.com/slack-go/slack v0.6.3
 	github.com/spf13/cast v1.3.0
 	github.com/stretchr/testify v1.7.0
 	go.opentelemetry.io/otel v0.17.0
 	go.opentelemetry.io/otel/metric v0.17.0
+	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
 	google.golang.org/api v0.25.0
 	google.golang.org/genproto v0.0.0-20200331122359-1ee6d9798940
+	google.golang.org/grpc v1.28.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -1,7 +1,6 @@
 package stepcurry
 
 import (
-	"log"
 	"net/http"
 )
 
@@ -29,21 +28,81 @@ func (e *httpError) Error() string {
 // Handler is a http.HandlerFunc that returns an error
 type Handler func(http.ResponseWriter, *http.Request) error
 
-// ServerHTTP runs the requestHandler and returns an error with the appropriate code if an error is returned
+// ServerHTTP runs the requestHandler and returns an error with the appropriate code if an error is returned. Errors
+// are logged with the default logger, see StepCurry.Handle to log them with StepCurry's
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := h(w, r)
+	loggingHandler{handler: h, logger: defaultLogger}.ServeHTTP(w, r)
+}
+
+// loggingHandler is a http.Handler running a Handler with the ID of the request attached to its context's log fields
+// and logging errors to logger
+type loggingHandler struct {
+	handler Handler
+	logger  Logger
+}
+
+func (lh loggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(withLogFields(r.Context(), Fields{requestIDField: requestID(r)}))
+
+	err := lh.handler(w, r)
 	if err != nil {
+		entry := newLogEntry(lh.logger).withContext(r.Context()).with(Fields{pathField: r.URL.Path})
 		if herr, ok := err.(*httpError); ok {
+			severity := SeverityError
+			if herr.code < http.StatusInternalServerError {
+				severity = SeverityWarning
+			}
+
 			if len(herr.message) > 0 {
-				log.Printf("%s: %s", herr.message, herr.Error())
+				entry.withError(herr.err).logf(severity, "%s", herr.message)
 			} else {
-				log.Printf("%s", herr.Error())
+				entry.withError(herr.err).logf(severity, "Error handling request")
 			}
 
 			http.Error(w, herr.Error(), herr.code)
 		} else {
-			log.Print(err.Error())
+			entry.withError(err).errorf("Error handling request")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
 }
+
+// Handle returns a http.Handler running h. The request's ID is attached to the log fields of its context and errors
+// are logged with StepCurry's logger
+func (sc *StepCurry) Handle(h Handler) http.Handler {
+	return loggingHandler{handler: h, logger: sc.logger}
+}
+
+// RegisterHandlers mounts all of the app's handlers on a ServeMux, each one under its configured path. This is useful
+// to serve the app from a single http server rather than as individual functions
+func (sc *StepCurry) RegisterHandlers(mux *http.ServeMux) {
+	handlers := map[string]http.Handler{
+		sc.paths.UpdateChallenge:    sc.Handle(sc.UpdateChallenge),
+		sc.paths.FitbitAuthCallback: sc.Handle(sc.HandleFitbitAuth),
+		sc.paths.LinkAccount:        sc.Handle(sc.StartFitbitOauthFlow),
+		sc.paths.StartChallenge:     sc.Handle(sc.Challenge),
+		sc.paths.Standings:          sc.Handle(sc.Standings),
+		sc.paths.Leaderboard:        sc.Handle(sc.Leaderboard),
+		sc.paths.Badges:             sc.Handle(sc.Badges),
+		sc.paths.Reminders:          sc.Handle(sc.Reminders),
+		sc.paths.Me:                 sc.Handle(sc.Me),
+		sc.paths.SweepTokens:        sc.Handle(sc.SweepTokens),
+		sc.paths.FitbitSubscription: sc.Handle(sc.FitbitSubscription),
+		sc.paths.RecordActivity:     sc.Handle(sc.RecordActivity),
+		sc.paths.PublishHome:        sc.Handle(sc.PublishHome),
+		sc.paths.PerfectMonth:       sc.Handle(sc.EvaluatePerfectMonth),
+		sc.paths.InstallSlack:       http.HandlerFunc(sc.InvokeSlackAuth),
+		sc.paths.SlackAuthCallback:  sc.Handle(sc.HandleSlackAuth),
+		sc.paths.Config:             sc.Handle(sc.Config),
+		sc.paths.Interaction:        sc.Handle(sc.Interaction),
+		sc.paths.Events:             sc.Handle(sc.Events),
+	}
+
+	for path, handler := range handlers {
+		if path == "" {
+			continue
+		}
+
+		mux.Handle("/"+path, handler)
+	}
+}
@@ -2,7 +2,9 @@ package stepcurry
 
 import (
 	"errors"
+	"github.com/alexandre-normand/stepcurry/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -61,3 +63,51 @@ func TestHandlerServing(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterHandlers(t *testing.T) {
+	tests := map[string]struct {
+		opts           []Option
+		path           string
+		expectedStatus int
+		expectedTarget string
+	}{
+		"DefaultPaths": {
+			path:           "/InvokeSlackAuth",
+			expectedStatus: http.StatusFound,
+			expectedTarget: "https://slack.com/oauth/v2/authorize?client_id=slackClientID&redirect_uri=https://stepcurry.com/HandleSlackAuth",
+		},
+		"CustomPaths": {
+			opts:           []Option{OptionPaths(Paths{InstallSlack: "install", SlackAuthCallback: "slack/callback"})},
+			path:           "/install",
+			expectedStatus: http.StatusFound,
+			expectedTarget: "https://slack.com/oauth/v2/authorize?client_id=slackClientID&redirect_uri=https://stepcurry.com/slack/callback",
+		},
+		"UnmountedPath": {
+			opts:           []Option{OptionPaths(Paths{InstallSlack: "install"})},
+			path:           "/InvokeSlackAuth",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			opts := append([]Option{OptionTeamRouter(teamRouter), OptionStorer(&mocks.Datastorer{}), OptionVerifier(&mocks.Verifier{}), OptionTaskScheduler(&mocks.TaskScheduler{})}, tc.opts...)
+			sc, err := New("https://stepcurry.com", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", opts...)
+			require.NoError(t, err)
+
+			mux := http.NewServeMux()
+			sc.RegisterHandlers(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedTarget != "" {
+				assert.True(t, strings.HasPrefix(w.Header().Get("Location"), tc.expectedTarget), w.Header().Get("Location"))
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+)
+
+// Action IDs of the app home buttons
+const (
+	linkFitbitActionID   = "link_fitbit"
+	unlinkFitbitActionID = "unlink_fitbit"
+	openSettingsActionID = "open_settings"
+)
+
+// UserHome holds everything shown on a user's app home tab
+type UserHome struct {
+	Linked       bool
+	Healthy      bool
+	Today        *DailySteps
+	Ranks        []ChallengeRank
+	MonthWins    int
+	Achievements UserAchievements
+	Admin        bool
+}
+
+// HomeUpdate identifies the app home of a user to publish following an app_home_opened event
+type HomeUpdate struct {
+	TeamID string `json:"teamID" datastore:"teamID"`
+	UserID string `json:"userID" datastore:"userID"`
+}
+
+// openHome schedules the publishing of the app home tab of a user when they open it. Slack expects events to be
+// acknowledged within 3 seconds so the home, which needs today's steps from Fitbit, is published later by PublishHome
+// rather than while handling the event
+func (sc *StepCurry) openHome(ctx context.Context, teamID string, userID string) (err error) {
+	err = sc.scheduler.ScheduleHomePublish(ctx, HomeUpdate{TeamID: teamID, UserID: userID}, sc.clock.Now())
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error scheduling app home publish of user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// PublishHome handles a request to publish the app home of a user. The requests are coming from updates scheduled
+// when users open their app home
+func (sc *StepCurry) PublishHome(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	var update HomeUpdate
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		return newHttpError(err, "Error decoding home update from body", http.StatusBadRequest)
+	}
+
+	err = sc.publishUserHome(update.TeamID, update.UserID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error publishing app home of user [%s]", update.UserID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// publishUserHome publishes the app home tab of a user of a team
+func (sc *StepCurry) publishUserHome(teamID string, userID string) (err error) {
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting api services for team id [%s]", teamID)
+	}
+
+	return sc.publishHome(svcs, teamID, userID)
+}
+
+// publishHome loads and publishes the app home tab of a user
+func (sc *StepCurry) publishHome(svcs TeamServices, teamID string, userID string) (err error) {
+	if svcs.homePublisher == nil {
+		return fmt.Errorf("no home publisher configured for team [%s]", teamID)
+	}
+
+	home, err := sc.loadUserHome(svcs, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	view := slack.HomeTabViewRequest{Type: slack.VTHomeTab, Blocks: slack.Blocks{BlockSet: sc.renderHome(svcs.userMessages(userID), home)}}
+	_, err = svcs.homePublisher.PublishView(userID, view, "")
+	if err != nil {
+		return errors.Wrapf(err, "error publishing app home of user [%s]", userID)
+	}
+
+	return nil
+}
+
+// loadUserHome gathers a user's link status, today's steps, active challenges, wins and badges. Failing to get
+// today's steps from Fitbit leaves them out rather than failing the whole home. The link is only shown as unhealthy
+// once it's known to be broken so that a transient Fitbit error doesn't prompt the user to link their account again
+func (sc *StepCurry) loadUserHome(svcs TeamServices, teamID string, userID string) (home UserHome, err error) {
+	ctx := context.Background()
+	_, location, err := svcs.settings.location(svcs.logger)
+	if err != nil {
+		return home, err
+	}
+	now := sc.clock.Now().In(location)
+
+	clientAccess, err := sc.storage.GetClientAccess(ctx, teamID, userID)
+	if err != nil && err != ErrNoSuchEntity {
+		return home, errors.Wrapf(err, "error loading fitbit user mapping for user [%s]", userID)
+	}
+
+	home.Linked = err == nil
+	home.Healthy = home.Linked && !clientAccess.LinkBroken
+	if home.Healthy {
+		apiAccess, err := sc.getFitbitApiAccess(clientAccess.FitbitUser)
+		if err == nil {
+			var steps, goal int
+			steps, goal, err = sc.getUserStepsWithCache(userID, apiAccess, now)
+			home.Today = &DailySteps{Date: now.Format(challengeDateFormat), Steps: steps, Goal: goal}
+		}
+
+		if err != nil {
+			newLogEntry(svcs.logger).with(Fields{teamIDField: teamID, userIDField: userID}).withError(err).warningf("Error getting today's steps")
+			home.Today = nil
+		}
+	}
+
+	challenges, err := sc.getActiveChallenges(teamID)
+	if err != nil {
+		return home, err
+	}
+	home.Ranks = getChallengeRanks(challenges, userID)
+
+	leaderboard, err := sc.storage.GetLeaderboard(ctx, teamID, scopeWorkspace, "", periodMonth, periodID(periodMonth, now))
+	if err != nil && err != ErrNoSuchEntity {
+		return home, errors.Wrapf(err, "error loading monthly leaderboard of team [%s]", teamID)
+	}
+
+	for _, record := range leaderboard.Standings {
+		if record.UserID == userID {
+			home.MonthWins = record.Wins
+		}
+	}
+
+	home.Achievements, err = sc.storage.GetUserAchievements(ctx, teamID, userID)
+	if err != nil && err != ErrNoSuchEntity {
+		return home, errors.Wrapf(err, "error loading achievements for user [%s]", userID)
+	}
+
+	home.Admin, err = isAdmin(svcs, userID)
+	if err != nil {
+		return home, err
+	}
+
+	return home, nil
+}
+
+// renderHome renders a user's app home tab as slack blocks
+func (sc *StepCurry) renderHome(messages *Messages, home UserHome) (renderBlocks []slack.Block) {
+	var linkStatus string
+	var linkButton *slack.ButtonBlockElement
+	switch {
+	case home.Healthy:
+		linkStatus = messages.render(msgLinkHealthy, nil)
+		linkButton = slack.NewButtonBlockElement(unlinkFitbitActionID, "", slack.NewTextBlockObject(slack.PlainTextType, messages.render(msgHomeUnlink, nil), false, false))
+		linkButton.Style = slack.StyleDanger
+	case home.Linked:
+		linkStatus = messages.render(msgLinkUnhealthy, messageData{"LinkCommand": sc.slashCommands.Link})
+	default:
+		linkStatus = messages.render(msgHomeNotLinked, nil)
+	}
+
+	if !home.Healthy {
+		linkButton = slack.NewButtonBlockElement(linkFitbitActionID, "", slack.NewTextBlockObject(slack.PlainTextType, messages.render(msgHomeLink, nil), false, false))
+		linkButton.Style = slack.StylePrimary
+	}
+
+	renderBlocks = []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", messages.render(msgStatsHeader, nil), false, false), nil, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", linkStatus, false, false), nil, slack.NewAccessory(linkButton)),
+	}
+
+	if home.Today != nil {
+		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", renderGoalProgress(messages, *home.Today), false, false)))
+	}
+
+	renderBlocks = append(renderBlocks,
+		slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", sc.renderChallengeRanks(messages, home.Ranks), false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", messages.render(msgHomeWins, messageData{"Wins": home.MonthWins, "LastWinDate": home.Achievements.LastWinDate}), false, false)),
+		slack.NewDividerBlock())
+
+	if len(home.Achievements.Badges) > 0 {
+		renderBlocks = append(renderBlocks, renderBadgeCollection(messages, messages.render(msgHomeBadges, nil), home.Achievements)...)
+	} else {
+		renderBlocks = append(renderBlocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", messages.render(msgHomeNoBadges, nil), false, false), nil, nil))
+	}
+
+	if home.Admin {
+		settingsButton := slack.NewButtonBlockElement(openSettingsActionID, "", slack.NewTextBlockObject(slack.PlainTextType, messages.render(msgHomeSettings, nil), false, false))
+		renderBlocks = append(renderBlocks,
+			slack.NewDividerBlock(),
+			slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", messages.render(msgHomeAdmin, nil), false, false), nil, nil),
+			slack.NewActionBlock("", settingsButton),
+			slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", messages.render(msgConfigUsage, messageData{"Command": sc.slashCommands.Config}), false, false)))
+	}
+
+	return renderBlocks
+}
+
+// unlinkFitbit removes the link between a user and their Fitbit account and refreshes their app home. The Fitbit api
+// access is kept since the same Fitbit account could be linked by the user in other workspaces
+func (sc *StepCurry) unlinkFitbit(teamID string, callback slack.InteractionCallback) (err error) {
+	userID := callback.User.ID
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	err = sc.storage.DeleteClientAccess(context.Background(), teamID, userID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error deleting fitbit user mapping for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	newLogEntry(sc.logger).with(Fields{teamIDField: teamID, userIDField: userID}).infof("User unlinked their Fitbit account")
+
+	err = sc.publishHome(svcs, teamID, userID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error publishing app home of user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// openLinkModal opens a modal with the Fitbit authorize url for users clicking the link button of their app home.
+// The csrf token is only minted once the user asks to link their account since it replaces the one of a link started
+// with the slash command, if any, so that only the latest link attempt can complete
+func (sc *StepCurry) openLinkModal(teamID string, callback slack.InteractionCallback) (err error) {
+	userID := callback.User.ID
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	if svcs.viewOpener == nil {
+		return newHttpError(fmt.Errorf("no view opener configured for team [%s]", teamID), "Error opening link modal", http.StatusInternalServerError)
+	}
+
+	authIDState, err := sc.newAuthIdentificationState(teamID, userID, "", "")
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error persisting csrf token for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	authorizeURL, err := sc.fitbitAuthorizeURL(authIDState)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error generating fitbit authorize url for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	_, err = svcs.viewOpener.OpenView(callback.TriggerID, renderLinkModal(svcs.userMessages(userID), authorizeURL))
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error opening link modal for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// renderLinkModal renders the modal prompting a user to head over to Fitbit to link their account
+func renderLinkModal(messages *Messages, authorizeURL string) (modal slack.ModalViewRequest) {
+	prompt := messages.render(msgLinkPrompt, messageData{"AuthorizeURL": authorizeURL})
+
+	return slack.ModalViewRequest{
+		Type:   slack.ViewType("modal"),
+		Title:  slack.NewTextBlockObject(slack.PlainTextType, messages.render(msgHomeLink, nil), false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", prompt, false, false), nil, nil)}},
+	}
+}
+
+// openSettingsFromHome opens the settings modal for admins clicking the settings shortcut of their app home
+func (sc *StepCurry) openSettingsFromHome(teamID string, callback slack.InteractionCallback) (err error) {
+	userID := callback.User.ID
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	admin, err := isAdmin(svcs, userID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error checking if user [%s] is an admin", userID), http.StatusInternalServerError)
+	}
+
+	// The shortcut is only shown to admins but a user could have lost their admin role since their home was published
+	if !admin {
+		return newHttpError(fmt.Errorf("user [%s] of team [%s] isn't an admin", userID, teamID), "Error opening settings modal", http.StatusForbidden)
+	}
+
+	err = sc.openSettingsModal(svcs, teamID, callback.TriggerID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error opening settings modal for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// appHomeOpened creates a signed app_home_opened event request for a user and tab
+func (h *lifecycleHarness) appHomeOpened(userID string, tab string) (r *http.Request) {
+	body := fmt.Sprintf(`{"token":"legacy","team_id":"%s","api_app_id":"A1","type":"event_callback","event":{"type":"app_home_opened","user":"%s","channel":"D1","tab":"%s","event_ts":"1591029000.000100"}}`, lifecycleTeamID, userID, tab)
+
+	return h.signedRequest(eventsPath, body)
+}
+
+// openHome sends an app_home_opened event for a user's home tab and runs the home publish it schedules
+func (h *lifecycleHarness) openHome(userID string) {
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.appHomeOpened(userID, homeTab))
+	require.Equal(h.t, http.StatusOK, w.Code)
+
+	_, err := h.scheduler.RunDue(h.mux)
+	require.NoError(h.t, err)
+}
+
+// publishedHome returns the json rendering of the blocks of the app home last published for a user
+func (h *lifecycleHarness) publishedHome(userID string) string {
+	h.slack.mu.Lock()
+	defer h.slack.mu.Unlock()
+
+	view, ok := h.slack.homes[userID]
+	require.True(h.t, ok, "no app home published for user [%s]", userID)
+	assert.Equal(h.t, slack.VTHomeTab, view.Type)
+
+	blocks, err := json.Marshal(view.Blocks)
+	require.NoError(h.t, err)
+
+	return string(blocks)
+}
+
+// homeAction creates a signed block action request from a button of a user's app home
+func (h *lifecycleHarness) homeAction(userID string, actionID string) (r *http.Request) {
+	var callback slack.InteractionCallback
+	callback.Type = slack.InteractionTypeBlockActions
+	callback.User.ID = userID
+	callback.TriggerID = "trigger-" + userID
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: actionID, BlockID: "home"}}
+
+	return h.interaction(callback)
+}
+
+func TestEventsURLVerification(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.signedRequest(eventsPath, `{"token":"legacy","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P", w.Body.String())
+}
+
+func TestEventsInvalidSignature(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	r := h.appHomeOpened("U1", homeTab)
+	r.Header.Set("X-Slack-Signature", "v0=bad")
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, h.slack.homes)
+}
+
+func TestAppHomeMessagesTabIgnored(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.appHomeOpened("U1", "messages"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, h.scheduler.Pending())
+	assert.Empty(t, h.slack.homes)
+}
+
+func TestAppHomePublishedAfterEvent(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.appHomeOpened("U1", homeTab))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The event is acknowledged before the home gets published
+	assert.Empty(t, h.slack.homes)
+	require.Len(t, h.scheduler.Pending(), 1)
+
+	_, err := h.scheduler.RunDue(h.mux)
+	require.NoError(t, err)
+	assert.Contains(t, h.publishedHome("U1"), linkFitbitActionID)
+}
+
+func TestAppHomeNotLinked(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	h.openHome("U1")
+
+	home := h.publishedHome("U1")
+	assert.Contains(t, home, "Link your Fitbit account to take part in steps challenges")
+	assert.Contains(t, home, linkFitbitActionID)
+	assert.NotContains(t, home, "/oauth2/authorize")
+	assert.Contains(t, home, "You're not part of any active challenge")
+	assert.Contains(t, home, "No challenge wins yet this month")
+	assert.Contains(t, home, "You haven't earned any badges yet")
+	assert.NotContains(t, home, unlinkFitbitActionID)
+	assert.NotContains(t, home, openSettingsActionID)
+
+	// Opening the home doesn't replace the csrf token of a link started with the slash command
+	_, err := h.storage.GetCsrfToken(context.Background(), lifecycleTeamID, "U1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestLinkFromAppHome(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.homeAction("U1", linkFitbitActionID))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Len(t, h.slack.views, 1)
+	modal, err := json.Marshal(h.slack.views[0].Blocks)
+	require.NoError(t, err)
+	assert.Contains(t, string(modal), "/oauth2/authorize?response_type=code")
+
+	_, err = h.storage.GetCsrfToken(context.Background(), lifecycleTeamID, "U1")
+	assert.NoError(t, err)
+}
+
+func TestAppHomeFitbitUnavailable(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.mu.Lock()
+	h.fitbitUnavailable = true
+	h.mu.Unlock()
+
+	h.openHome("U1")
+
+	// A transient Fitbit error leaves today's steps out without prompting the user to link their account again
+	home := h.publishedHome("U1")
+	assert.Contains(t, home, "Your Fitbit account is linked and syncing")
+	assert.Contains(t, home, unlinkFitbitActionID)
+	assert.NotContains(t, home, `"action_id":"`+linkFitbitActionID+`"`)
+	assert.NotContains(t, home, "steps today")
+}
+
+func TestAppHomeLinkedAdmin(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, mustLoadLocation(t, "America/Los_Angeles")))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.slack.admins["U1"] = true
+	h.setSteps("F1", 4200)
+
+	ctx := context.Background()
+	require.NoError(t, h.storage.PutChallenge(ctx, StepsChallenge{ChallengeID: ChallengeID{TeamID: lifecycleTeamID, ChannelID: "C2", Date: "2020-06-01"}, Active: true, CreatorID: "U2", RankedUsers: []UserSteps{{UserID: "U2", Steps: 5000}, {UserID: "U1", Steps: 4200}}}))
+	require.NoError(t, h.storage.PutLeaderboard(ctx, lifecycleTeamID, Leaderboard{Scope: scopeWorkspace, Period: periodMonth, PeriodID: "2020-06", Challenges: 3, Standings: []LeaderboardRecord{{UserID: "U2", Wins: 1}, {UserID: "U1", Wins: 2}}}))
+	require.NoError(t, h.storage.PutUserAchievements(ctx, lifecycleTeamID, UserAchievements{UserID: "U1", LastWinDate: "2020-05-31", Badges: []AwardedBadge{{BadgeID: badgeFirst20kDay, Count: 1, FirstAwarded: "2020-05-30"}}}))
+
+	h.openHome("U1")
+
+	home := h.publishedHome("U1")
+	assert.Contains(t, home, "Your Fitbit account is linked and syncing")
+	assert.Contains(t, home, unlinkFitbitActionID)
+	assert.NotContains(t, home, "/oauth2/authorize")
+	assert.Contains(t, home, "`4200` steps today")
+	assert.Contains(t, home, "#2 of 2 in \\u003c#C2\\u003e")
+	assert.Contains(t, home, "2 challenge wins this month, last one on 2020-05-31")
+	assert.Contains(t, home, "*First 20k day*")
+	assert.Contains(t, home, openSettingsActionID)
+	assert.Contains(t, home, "`/step-config restrict admins`")
+}
+
+func TestUnlinkFromAppHome(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.homeAction("U1", unlinkFitbitActionID))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ctx := context.Background()
+	_, err := h.storage.GetClientAccess(ctx, lifecycleTeamID, "U1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	// The api access is kept for the Fitbit account to be linked again or in other workspaces
+	_, err = h.storage.GetFitbitApiAccess(ctx, "F1")
+	assert.NoError(t, err)
+
+	home := h.publishedHome("U1")
+	assert.Contains(t, home, "Link your Fitbit account to take part in steps challenges")
+	assert.Contains(t, home, linkFitbitActionID)
+}
+
+func TestOpenSettingsFromAppHome(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+	h.slack.users["U2"] = "Bob"
+	h.slack.admins["U2"] = true
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.homeAction("U1", openSettingsActionID))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, h.slack.views)
+
+	w = httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.homeAction("U2", openSettingsActionID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, h.slack.views, 1)
+	assert.Equal(t, settingsCallbackID, h.slack.views[0].CallbackID)
+}
@@ -0,0 +1,137 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// payloadParam is the form parameter holding the json payload of an interaction
+	payloadParam = "payload"
+	// joinChallengeActionID is the action ID of the button to join a challenge with explicit opt-in
+	joinChallengeActionID = "join_challenge"
+)
+
+// Interaction handles the interactions of users with the app's modals and buttons. Slack sends all interactions to
+// the same request url so they're dispatched from here
+func (sc *StepCurry) Interaction(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	var callback slack.InteractionCallback
+	err = json.Unmarshal([]byte(params[payloadParam]), &callback)
+	if err != nil {
+		return newHttpError(err, "Error decoding interaction payload", http.StatusBadRequest)
+	}
+
+	enterprise, err := parseEnterpriseContext([]byte(params[payloadParam]))
+	if err != nil {
+		return newHttpError(err, "Error decoding interaction enterprise", http.StatusBadRequest)
+	}
+	teamID := enterprise.tenantID(callback.Team.ID)
+
+	switch {
+	case callback.Type == slack.InteractionTypeViewSubmission && callback.View.CallbackID == settingsCallbackID:
+		return sc.submitSettings(w, teamID, callback)
+	case callback.Type == slack.InteractionTypeBlockActions:
+		for _, action := range callback.ActionCallback.BlockActions {
+			switch action.ActionID {
+			case joinChallengeActionID:
+				return sc.joinChallenge(teamID, callback, action.Value)
+			case linkFitbitActionID:
+				return sc.openLinkModal(teamID, callback)
+			case unlinkFitbitActionID:
+				return sc.unlinkFitbit(teamID, callback)
+			case openSettingsActionID:
+				return sc.openSettingsFromHome(teamID, callback)
+			}
+		}
+	}
+
+	sc.log(r.Context()).with(Fields{teamIDField: teamID, channelIDField: callback.Channel.ID, userIDField: callback.User.ID}).debugf("Ignoring unsupported interaction of type [%s]", callback.Type)
+	return nil
+}
+
+// joinChallengeButton renders the button channel members click to join a challenge with explicit opt-in
+func joinChallengeButton(messages *Messages, challengeID ChallengeID) (block slack.Block) {
+	button := slack.NewButtonBlockElement(joinChallengeActionID, challengeID.Date, slack.NewTextBlockObject(slack.PlainTextType, messages.render(msgJoinChallenge, nil), false, false))
+	button.Style = slack.StylePrimary
+
+	return slack.NewActionBlock("", button)
+}
+
+// joinChallenge adds a user to the participants of a challenge with explicit opt-in and confirms it with an
+// ephemeral message
+func (sc *StepCurry) joinChallenge(teamID string, callback slack.InteractionCallback, date string) (err error) {
+	userID := callback.User.ID
+	challengeID := ChallengeID{TeamID: teamID, ChannelID: callback.Channel.ID, Date: date}
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	messages := svcs.userMessages(userID)
+
+	active := false
+	ctx := context.Background()
+	err = sc.storage.RunInTransaction(ctx, func(tc context.Context) error {
+		stepsChallenge, err := sc.storage.GetChallenge(tc, challengeID)
+		if err == ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		active = stepsChallenge.Active
+		if !active || containsString(stepsChallenge.Participants, userID) {
+			return nil
+		}
+
+		stepsChallenge.Participants = append(stepsChallenge.Participants, userID)
+		return sc.storage.PutChallenge(tc, stepsChallenge)
+	})
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error adding user [%s] to challenge [%s.%s]", userID, teamID, challengeID.Key()), http.StatusInternalServerError)
+	}
+
+	if !active {
+		noChallengeMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgNoActiveChallenge, messageData{"ChallengeCommand": sc.slashCommands.Challenge})}
+		return sendResponse(callback.ResponseURL, noChallengeMsg, "no active challenge")
+	}
+
+	_, err = sc.storage.GetClientAccess(ctx, teamID, userID)
+	if err != nil && err != ErrNoSuchEntity {
+		return newHttpError(err, fmt.Sprintf("Error loading fitbit user mapping for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	joinedMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgChallengeJoined, messageData{"Linked": err == nil, "LinkCommand": sc.slashCommands.Link})}
+	return sendResponse(callback.ResponseURL, joinedMsg, "challenge joined")
+}
+
+// containsString returns true if a value is in a slice
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
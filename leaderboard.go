@@ -0,0 +1,314 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/imroc/req"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Leaderboard scopes
+const (
+	scopeChannel   = "channel"
+	scopeWorkspace = "workspace"
+)
+
+// Leaderboard periods
+const (
+	periodMonth  = "month"
+	periodSeason = "season"
+	periodAll    = "all"
+)
+
+const (
+	// podiumSize is the number of top ranked participants considered to have made the podium of a challenge
+	podiumSize = 3
+	// maxLeaderboardEntries is the maximum number of participants rendered in a leaderboard message
+	maxLeaderboardEntries = 10
+)
+
+// Leaderboard holds the aggregated standings for a scope (channel or workspace) and period. It
+// is maintained incrementally as challenges are wrapped up so that reading it doesn't require
+// scanning past challenges
+type Leaderboard struct {
+	Scope      string              `datastore:"scope"`
+	ChannelID  string              `datastore:"channelID"`
+	Period     string              `datastore:"period"`
+	PeriodID   string              `datastore:"periodID"`
+	Challenges int                 `datastore:"challenges,noindex"`
+	Standings  []LeaderboardRecord `datastore:"standings,noindex"`
+}
+
+// LeaderboardRecord holds a participant's aggregated results
+type LeaderboardRecord struct {
+	UserID     string `datastore:"userID"`
+	Wins       int    `datastore:"wins"`
+	Podiums    int    `datastore:"podiums"`
+	TotalSteps int    `datastore:"totalSteps"`
+	Challenges int    `datastore:"challenges"`
+}
+
+// byLeaderboardRank sorts leaderboard records by wins, podium finishes and then total steps
+type byLeaderboardRank []LeaderboardRecord
+
+func (p byLeaderboardRank) Len() int { return len(p) }
+
+func (p byLeaderboardRank) Less(i, j int) bool {
+	if p[i].Wins != p[j].Wins {
+		return p[i].Wins > p[j].Wins
+	}
+
+	if p[i].Podiums != p[j].Podiums {
+		return p[i].Podiums > p[j].Podiums
+	}
+
+	if p[i].TotalSteps != p[j].TotalSteps {
+		return p[i].TotalSteps > p[j].TotalSteps
+	}
+
+	return strings.Compare(p[i].UserID, p[j].UserID) < 0
+}
+
+func (p byLeaderboardRank) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// LeaderboardName returns the name identifying the leaderboard of a team for a scope, channel and period identifier.
+// The channel is ignored for the workspace scope
+func LeaderboardName(scope string, channelID string, period string, periodID string) (name string) {
+	if scope == scopeChannel {
+		return fmt.Sprintf("%s:%s:%s:%s", scope, channelID, period, periodID)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", scope, period, periodID)
+}
+
+// leaderboardKey returns the key of the leaderboard for a scope, channel and period identifier
+func leaderboardKey(teamID string, scope string, channelID string, period string, periodID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("Leaderboard", teamID, LeaderboardName(scope, channelID, period, periodID), nil)
+}
+
+// periodID returns the identifier of the period a challenge date falls in. Months are identified as 2006-01,
+// seasons are calendar quarters identified as 2006-Q1 and the all-time period has a single constant identifier
+func periodID(period string, date time.Time) string {
+	switch period {
+	case periodMonth:
+		return date.Format("2006-01")
+	case periodSeason:
+		return fmt.Sprintf("%d-Q%d", date.Year(), (int(date.Month())-1)/3+1)
+	default:
+		return periodAll
+	}
+}
+
+// recordLeaderboardResults adds the final ranking of a challenge to all leaderboards it counts towards. Leaderboards
+// are read and updated in a transaction so that challenges wrapping up at the same time don't overwrite each other's
+// results. Callers run it in the transaction marking the challenge as wrapped up so that results are counted once
+func (sc *StepCurry) recordLeaderboardResults(ctx context.Context, stepsChallenge StepsChallenge) (err error) {
+	if len(stepsChallenge.RankedUsers) == 0 {
+		return nil
+	}
+
+	challengeDate, err := time.Parse(challengeDateFormat, stepsChallenge.Date)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing date of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	return sc.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		return sc.addLeaderboardResults(tc, stepsChallenge, challengeDate)
+	})
+}
+
+// addLeaderboardResults adds the final ranking of a challenge to the leaderboards of every scope and period
+func (sc *StepCurry) addLeaderboardResults(ctx context.Context, stepsChallenge StepsChallenge, challengeDate time.Time) (err error) {
+	for _, scope := range []string{scopeChannel, scopeWorkspace} {
+		for _, period := range []string{periodMonth, periodSeason, periodAll} {
+			pID := periodID(period, challengeDate)
+			name := LeaderboardName(scope, stepsChallenge.ChannelID, period, pID)
+
+			leaderboard, err := sc.storage.GetLeaderboard(ctx, stepsChallenge.TeamID, scope, stepsChallenge.ChannelID, period, pID)
+			if err != nil && err != ErrNoSuchEntity {
+				return errors.Wrapf(err, "error loading leaderboard [%s.%s]", stepsChallenge.TeamID, name)
+			}
+
+			if err == ErrNoSuchEntity {
+				leaderboard = Leaderboard{Scope: scope, Period: period, PeriodID: pID}
+				if scope == scopeChannel {
+					leaderboard.ChannelID = stepsChallenge.ChannelID
+				}
+			}
+
+			leaderboard.addResults(stepsChallenge.RankedUsers)
+
+			err = sc.storage.PutLeaderboard(ctx, stepsChallenge.TeamID, leaderboard)
+			if err != nil {
+				return errors.Wrapf(err, "error persisting leaderboard [%s.%s]", stepsChallenge.TeamID, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addResults merges the final ranking of a challenge into the leaderboard standings
+func (l *Leaderboard) addResults(rankedUsers []UserSteps) {
+	recordIndexByUser := make(map[string]int)
+	for i, r := range l.Standings {
+		recordIndexByUser[r.UserID] = i
+	}
+
+	for rank, us := range rankedUsers {
+		i, ok := recordIndexByUser[us.UserID]
+		if !ok {
+			l.Standings = append(l.Standings, LeaderboardRecord{UserID: us.UserID})
+			i = len(l.Standings) - 1
+			recordIndexByUser[us.UserID] = i
+		}
+
+		record := &l.Standings[i]
+		record.Challenges++
+		record.TotalSteps += us.Steps
+		if rank == 0 {
+			record.Wins++
+		}
+
+		if rank < podiumSize {
+			record.Podiums++
+		}
+	}
+
+	l.Challenges++
+	sort.Sort(byLeaderboardRank(l.Standings))
+}
+
+// parseLeaderboardArgs parses the optional scope and period arguments of the leaderboard slash command. Arguments
+// can be given in any order and default to the channel scope and current month
+func parseLeaderboardArgs(text string) (scope string, period string, err error) {
+	scope = scopeChannel
+	period = periodMonth
+
+	for _, arg := range strings.Fields(strings.ToLower(text)) {
+		switch arg {
+		case scopeChannel, scopeWorkspace:
+			scope = arg
+		case periodMonth, periodSeason, periodAll:
+			period = arg
+		default:
+			return "", "", fmt.Errorf("unknown leaderboard argument [%s]", arg)
+		}
+	}
+
+	return scope, period, nil
+}
+
+// Leaderboard handles an incoming slack request in response to a user invoking /step-leaderboard and responds
+// with the ranked standings across past challenges for the requested scope and period
+func (sc *StepCurry) Leaderboard(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Parse the slack payload to get the originating context (channel, user)
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	channel := params[channelIDParam]
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	scope, period, err := parseLeaderboardArgs(params[textParam])
+	if err != nil {
+		usage := fmt.Sprintf("%s [%s|%s] [%s|%s|%s]", sc.slashCommands.Leaderboard, scopeChannel, scopeWorkspace, periodMonth, periodSeason, periodAll)
+		usageMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgLeaderboardUsage, messageData{"Usage": usage})}
+		return sendResponse(responseURL, usageMsg, "leaderboard usage")
+	}
+
+	_, location, err := sc.getChannelTimezone(svcs, channel)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting channel timezone for channel [%s]", channel), http.StatusInternalServerError)
+	}
+
+	pID := periodID(period, sc.clock.Now().In(location))
+
+	ctx := context.Background()
+	leaderboard, err := sc.storage.GetLeaderboard(ctx, teamID, scope, channel, period, pID)
+	if err != nil && err != ErrNoSuchEntity {
+		return newHttpError(err, fmt.Sprintf("Error loading leaderboard [%s.%s]", teamID, LeaderboardName(scope, channel, period, pID)), http.StatusInternalServerError)
+	}
+
+	if err == ErrNoSuchEntity || len(leaderboard.Standings) == 0 {
+		noResultsMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgNoLeaderboardResults, messageData{"Scope": scope, "ChallengeCommand": sc.slashCommands.Challenge})}
+		return sendResponse(responseURL, noResultsMsg, "no leaderboard results")
+	}
+
+	bannerText := leaderboardBanner(svcs.messages, scope, period, pID, leaderboard.Challenges)
+	renderBlocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", bannerText, false, false), nil, nil)}
+	renderBlocks = append(renderBlocks, sc.renderLeaderboard(svcs, leaderboard.Standings)...)
+
+	leaderboardMsg := ActionResponse{ResponseType: "in_channel", ReplaceOriginal: false, Text: bannerText, Blocks: renderBlocks}
+	return sendResponse(responseURL, leaderboardMsg, "leaderboard")
+}
+
+// leaderboardBanner returns the header text of a rendered leaderboard
+func leaderboardBanner(messages *Messages, scope string, period string, periodID string, challengeCount int) string {
+	return messages.render(msgLeaderboardBanner, messageData{"Scope": scope, "Period": period, "PeriodID": periodID, "AllTime": period == periodAll, "Challenges": challengeCount})
+}
+
+// renderLeaderboard renders the leaderboard standings as slack blocks
+func (sc *StepCurry) renderLeaderboard(services TeamServices, standings []LeaderboardRecord) (renderBlocks []slack.Block) {
+	renderBlocks = make([]slack.Block, 0)
+
+	for i, record := range standings {
+		if i >= maxLeaderboardEntries {
+			break
+		}
+
+		userInfo, err := services.userInfoFinder.GetUserInfo(record.UserID)
+		profileImage := ""
+		realName := ""
+		if err != nil {
+			newLogEntry(services.logger).with(Fields{teamIDField: services.settings.TeamID, userIDField: record.UserID}).withError(err).warningf("Error getting user info for the leaderboard")
+		} else {
+			profileImage = userInfo.Profile.Image32
+			realName = userInfo.Profile.RealName
+		}
+
+		rankingText := services.messages.render(msgLeaderboardRanking, messageData{"Rank": i + 1, "Name": realName, "Wins": record.Wins, "Podiums": record.Podiums, "TotalSteps": record.TotalSteps, "Challenges": record.Challenges})
+		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewImageBlockElement(profileImage, realName), slack.NewTextBlockObject("mrkdwn", rankingText, false, false)))
+	}
+
+	return renderBlocks
+}
+
+// sendResponse posts a message to a slack response url
+func sendResponse(responseURL string, message ActionResponse, description string) (err error) {
+	resp, err := req.Post(responseURL, req.BodyJSON(&message))
+	if err != nil || resp.Response().StatusCode != 200 {
+		if err != nil {
+			return newHttpError(err, fmt.Sprintf("Error sending %s message", description), http.StatusInternalServerError)
+		} else {
+			return newHttpError(fmt.Errorf("Error writing %s message with error [%s]", description, resp.String()), "", http.StatusInternalServerError)
+		}
+	}
+
+	return nil
+}
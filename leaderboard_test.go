@@ -0,0 +1,319 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeriodID(t *testing.T) {
+	tests := map[string]struct {
+		period   string
+		date     time.Time
+		expected string
+	}{
+		"Month":        {period: periodMonth, date: time.Date(2020, 2, 11, 0, 0, 0, 0, time.UTC), expected: "2020-02"},
+		"SeasonFirst":  {period: periodSeason, date: time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC), expected: "2020-Q1"},
+		"SeasonSecond": {period: periodSeason, date: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC), expected: "2020-Q2"},
+		"SeasonLast":   {period: periodSeason, date: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), expected: "2020-Q4"},
+		"AllTime":      {period: periodAll, date: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), expected: "all"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, periodID(tc.period, tc.date))
+		})
+	}
+}
+
+func TestParseLeaderboardArgs(t *testing.T) {
+	tests := map[string]struct {
+		text           string
+		expectedScope  string
+		expectedPeriod string
+		expectedErr    string
+	}{
+		"Defaults":        {text: "", expectedScope: scopeChannel, expectedPeriod: periodMonth},
+		"ScopeOnly":       {text: "workspace", expectedScope: scopeWorkspace, expectedPeriod: periodMonth},
+		"PeriodOnly":      {text: "season", expectedScope: scopeChannel, expectedPeriod: periodSeason},
+		"ScopeAndPeriod":  {text: "Workspace all", expectedScope: scopeWorkspace, expectedPeriod: periodAll},
+		"ReversedOrder":   {text: "all  channel", expectedScope: scopeChannel, expectedPeriod: periodAll},
+		"UnknownArgument": {text: "channel weekly", expectedErr: "unknown leaderboard argument [weekly]"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			scope, period, err := parseLeaderboardArgs(tc.text)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedScope, scope)
+				assert.Equal(t, tc.expectedPeriod, period)
+			}
+		})
+	}
+}
+
+func TestLeaderboardAddResults(t *testing.T) {
+	leaderboard := Leaderboard{Challenges: 1, Standings: []LeaderboardRecord{{UserID: "U1", Wins: 1, Podiums: 1, TotalSteps: 10000, Challenges: 1}, {UserID: "U2", Podiums: 1, TotalSteps: 8000, Challenges: 1}}}
+
+	leaderboard.addResults([]UserSteps{{UserID: "U2", Steps: 12000}, {UserID: "U3", Steps: 11000}, {UserID: "U4", Steps: 9000}, {UserID: "U1", Steps: 5000}})
+
+	assert.Equal(t, 2, leaderboard.Challenges)
+	assert.Equal(t, []LeaderboardRecord{
+		{UserID: "U2", Wins: 1, Podiums: 2, TotalSteps: 20000, Challenges: 2},
+		{UserID: "U1", Wins: 1, Podiums: 1, TotalSteps: 15000, Challenges: 2},
+		{UserID: "U3", Wins: 0, Podiums: 1, TotalSteps: 11000, Challenges: 1},
+		{UserID: "U4", Wins: 0, Podiums: 1, TotalSteps: 9000, Challenges: 1},
+	}, leaderboard.Standings)
+}
+
+func TestRecordLeaderboardResults(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == "channel:CID:month:2019-10"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*Leaderboard)
+		*returnVal = Leaderboard{Scope: scopeChannel, ChannelID: "CID", Period: periodMonth, PeriodID: "2019-10", Challenges: 1, Standings: []LeaderboardRecord{{UserID: "U2", Wins: 1, Podiums: 1, TotalSteps: 100, Challenges: 1}}}
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name != "channel:CID:month:2019-10"
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == "channel:CID:month:2019-10"
+	}), &Leaderboard{Scope: scopeChannel, ChannelID: "CID", Period: periodMonth, PeriodID: "2019-10", Challenges: 2, Standings: []LeaderboardRecord{{UserID: "U2", Wins: 1, Podiums: 2, TotalSteps: 150, Challenges: 2}, {UserID: "U1", Wins: 1, Podiums: 1, TotalSteps: 200, Challenges: 1}}}).Return(nil, nil)
+	for _, name := range []string{"channel:CID:season:2019-Q4", "channel:CID:all:all"} {
+		expectedName := name
+		storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+			return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == expectedName
+		}), mock.MatchedBy(func(l *Leaderboard) bool {
+			return l.Scope == scopeChannel && l.ChannelID == "CID" && l.Challenges == 1 && len(l.Standings) == 2
+		})).Return(nil, nil)
+	}
+	for _, name := range []string{"workspace:month:2019-10", "workspace:season:2019-Q4", "workspace:all:all"} {
+		expectedName := name
+		storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+			return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == expectedName
+		}), mock.MatchedBy(func(l *Leaderboard) bool {
+			return l.Scope == scopeWorkspace && l.ChannelID == "" && l.Challenges == 1 && len(l.Standings) == 2
+		})).Return(nil, nil)
+	}
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.recordLeaderboardResults(context.Background(), StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 200}, {UserID: "U2", Steps: 50}}})
+	require.NoError(t, err)
+}
+
+func TestRecordLeaderboardResultsErrorLoadingLeaderboard(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == "channel:CID:month:2019-10"
+	}), mock.Anything).Return(fmt.Errorf("backend error"))
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.recordLeaderboardResults(context.Background(), StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 200}}})
+	assert.EqualError(t, err, "error loading leaderboard [TEAMID.channel:CID:month:2019-10]: backend error")
+}
+
+func TestRecordLeaderboardResultsConcurrently(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorage(storage), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	// Challenges of all channels wrap up at the same time and update the same workspace leaderboards
+	channels := []string{"C1", "C2", "C3", "C4", "C5"}
+	var wg sync.WaitGroup
+	for _, channelID := range channels {
+		wg.Add(1)
+		go func(channelID string) {
+			defer wg.Done()
+
+			err := sc.recordLeaderboardResults(context.Background(), StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: channelID, Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U" + channelID, Steps: 200}}})
+			assert.NoError(t, err)
+		}(channelID)
+	}
+	wg.Wait()
+
+	leaderboard, err := storage.GetLeaderboard(context.Background(), "TEAMID", scopeWorkspace, "", periodMonth, "2019-10")
+	require.NoError(t, err)
+	assert.Equal(t, len(channels), leaderboard.Challenges)
+	assert.Len(t, leaderboard.Standings, len(channels))
+}
+
+func TestLeaderboardInvalidArguments(t *testing.T) {
+	slackRequest := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		slackRequest = string(reqBody)
+		fmt.Fprintln(w, "OK")
+	}))
+	defer server.Close()
+
+	body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-leaderboard&text=yearly&response_url=%s&trigger_id=someTriggerID", server.URL)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	verifier := &mocks.Verifier{}
+	verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.Leaderboard(w, r)
+	require.NoError(t, err)
+
+	assert.Contains(t, slackRequest, "\"response_type\":\"ephemeral\"")
+	assert.Contains(t, slackRequest, "/step-leaderboard [channel|workspace] [month|season|all]")
+}
+
+func TestLeaderboardWithoutResults(t *testing.T) {
+	slackRequest := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		slackRequest = string(reqBody)
+		fmt.Fprintln(w, "OK")
+	}))
+	defer server.Close()
+
+	body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-leaderboard&text=workspace&response_url=%s&trigger_id=someTriggerID", server.URL)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	verifier := &mocks.Verifier{}
+	verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && strings.HasPrefix(k.Name, "workspace:month:")
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.Leaderboard(w, r)
+	require.NoError(t, err)
+
+	assert.Contains(t, slackRequest, "No challenge results recorded for this workspace leaderboard yet")
+}
+
+func TestLeaderboard(t *testing.T) {
+	slackRequest := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		slackRequest = string(reqBody)
+		fmt.Fprintln(w, "OK")
+	}))
+	defer server.Close()
+
+	body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-leaderboard&text=channel+all&response_url=%s&trigger_id=someTriggerID", server.URL)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	verifier := &mocks.Verifier{}
+	verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "Leaderboard" && k.Name == "channel:CID:all:all"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*Leaderboard)
+		*returnVal = Leaderboard{Scope: scopeChannel, ChannelID: "CID", Period: periodAll, PeriodID: "all", Challenges: 3, Standings: []LeaderboardRecord{{UserID: "U1", Wins: 2, Podiums: 3, TotalSteps: 45000, Challenges: 3}, {UserID: "U2", Wins: 1, Podiums: 2, TotalSteps: 30000, Challenges: 2}}}
+	})
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "U1").Return(&slack.User{Profile: slack.UserProfile{RealName: "Frans", Image32: "https://frans.png"}}, nil)
+	userInfoFinder.On("GetUserInfo", "U2").Return(nil, fmt.Errorf("user_not_found"))
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.Leaderboard(w, r)
+	require.NoError(t, err)
+
+	assert.Contains(t, slackRequest, "\"response_type\":\"in_channel\"")
+	assert.Contains(t, slackRequest, ":trophy: *Channel leaderboard* (all time, 3 challenges)")
+	assert.Contains(t, slackRequest, "*1.* _Frans_ :trophy: 2 :medal: 3 `45000` :athletic_shoe: in 3 challenges")
+	assert.Contains(t, slackRequest, "*2.* __ :trophy: 1 :medal: 2 `30000` :athletic_shoe: in 2 challenges")
+}
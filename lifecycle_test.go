@@ -0,0 +1,547 @@
+package stepcurry
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	lifecycleTeamID        = "T1"
+	lifecycleChannelID     = "C1"
+	lifecycleSigningSecret = "lifecycleSigningSecret"
+	lifecycleBotID         = "B1"
+)
+
+// postedMessage holds a message sent through fakeSlack
+type postedMessage struct {
+	channelID string
+	text      string
+	blocks    string
+}
+
+// fakeSlack implements the slack services of a team. Messages are recorded and posting to a channel can be made to
+// fail with an error
+type fakeSlack struct {
+	mu       sync.Mutex
+	users    map[string]string
+	locales  map[string]string
+	admins   map[string]bool
+	members  map[string][]string
+	groups   map[string][]string
+	postErrs map[string]error
+	private  map[string]bool
+	joined   []string
+	messages []postedMessage
+	views    []slack.ModalViewRequest
+	homes    map[string]slack.HomeTabViewRequest
+}
+
+func (fs *fakeSlack) PostMessage(channelID string, options ...slack.MsgOption) (channel string, timestamp string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.postErrs[channelID]; err != nil {
+		return "", "", err
+	}
+
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, "", options...)
+	if err != nil {
+		return "", "", err
+	}
+
+	fs.messages = append(fs.messages, postedMessage{channelID: channelID, text: values.Get("text"), blocks: values.Get("blocks")})
+	return channelID, strconv.Itoa(len(fs.messages)), nil
+}
+
+func (fs *fakeSlack) GetUserInfo(userID string) (userInfo *slack.User, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	realName, ok := fs.users[userID]
+	if !ok {
+		return nil, errors.New("user_not_found")
+	}
+
+	return &slack.User{ID: userID, Locale: fs.locales[userID], IsAdmin: fs.admins[userID], Profile: slack.UserProfile{RealName: realName}}, nil
+}
+
+func (fs *fakeSlack) OpenView(triggerID string, view slack.ModalViewRequest) (resp *slack.ViewResponse, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.views = append(fs.views, view)
+	return &slack.ViewResponse{}, nil
+}
+
+// JoinConversation joins a channel unless it's private. Joining clears the channel's scripted post error
+func (fs *fakeSlack) JoinConversation(channelID string) (channel *slack.Channel, warning string, warnings []string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.private[channelID] {
+		return nil, "", nil, errors.New("method_not_supported_for_channel_type")
+	}
+
+	delete(fs.postErrs, channelID)
+	fs.joined = append(fs.joined, channelID)
+	return &slack.Channel{}, "", nil, nil
+}
+
+func (fs *fakeSlack) PublishView(userID string, view slack.HomeTabViewRequest, hash string) (resp *slack.ViewResponse, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.homes[userID] = view
+	return &slack.ViewResponse{}, nil
+}
+
+func (fs *fakeSlack) GetBotID() (botUserID string, err error) {
+	return lifecycleBotID, nil
+}
+
+func (fs *fakeSlack) GetUsersInConversation(params *slack.GetUsersInConversationParameters) (members []string, cursor string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.members[params.ChannelID], "", nil
+}
+
+func (fs *fakeSlack) GetUserGroupMembers(userGroup string) (members []string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	members, ok := fs.groups[userGroup]
+	if !ok {
+		return nil, errors.New("no_such_subteam")
+	}
+
+	return members, nil
+}
+
+// messagesTo returns the messages posted to a channel
+func (fs *fakeSlack) messagesTo(channelID string) (messages []postedMessage) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	messages = make([]postedMessage, 0)
+	for _, m := range fs.messages {
+		if m.channelID == channelID {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// lifecycleHarness runs a StepCurry instance with in-memory storage and scheduling on a fake clock. Slack services
+// are faked and the Fitbit API and slack response urls are served by test servers
+type lifecycleHarness struct {
+	t         *testing.T
+	sc        *StepCurry
+	clock     *FakeClock
+	storage   *MemoryStorage
+	scheduler *MemoryTaskScheduler
+	mux       *http.ServeMux
+	slack     *fakeSlack
+
+	responsesURL string
+
+	mu                sync.Mutex
+	steps             map[string]int
+	floors            map[string]int
+	responses         []ActionResponse
+	fitbitUnavailable bool
+}
+
+func newLifecycleHarness(t *testing.T, start time.Time, opts ...Option) (h *lifecycleHarness, cleanup func()) {
+	h = &lifecycleHarness{t: t, clock: NewFakeClock(start), storage: NewMemoryStorage(), steps: make(map[string]int), floors: make(map[string]int)}
+	h.scheduler = NewMemoryTaskScheduler(h.clock)
+	h.slack = &fakeSlack{users: make(map[string]string), locales: make(map[string]string), admins: make(map[string]bool), members: make(map[string][]string), groups: make(map[string][]string), homes: make(map[string]slack.HomeTabViewRequest), postErrs: make(map[string]error), private: make(map[string]bool)}
+
+	fitbitServer := httptest.NewServer(http.HandlerFunc(h.serveFitbitActivity))
+	responseServer := httptest.NewServer(http.HandlerFunc(h.serveResponseURL))
+
+	teamRouter, err := NewSingleTenantRouter(h.slack, h.slack, h.slack, h.slack)
+	require.NoError(t, err)
+	teamRouter.UseViewOpener(h.slack)
+	teamRouter.UseUserGroupMemberFinder(h.slack)
+	teamRouter.UseHomePublisher(h.slack)
+	teamRouter.UseConversationJoiner(h.slack)
+
+	h.sc, err = New("https://stepcurry.example.com", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", append([]Option{OptionSlackVerifier(lifecycleSigningSecret), OptionStorage(h.storage), OptionTaskScheduler(h.scheduler), OptionTeamRouter(teamRouter), OptionClock(h.clock), OptionFitbitURLs(fitbitServer.URL, fitbitServer.URL)}, opts...)...)
+	require.NoError(t, err)
+
+	h.mux = http.NewServeMux()
+	h.sc.RegisterHandlers(h.mux)
+
+	h.responsesURL = responseServer.URL
+
+	return h, func() {
+		fitbitServer.Close()
+		responseServer.Close()
+	}
+}
+
+// serveFitbitActivity serves the daily activity summary of fitbit users with the steps set by setSteps
+func (h *lifecycleHarness) serveFitbitActivity(w http.ResponseWriter, r *http.Request) {
+	var fitbitUser, date string
+	_, err := fmt.Sscanf(strings.Replace(r.URL.Path, "/", " ", -1), " 1 user %s activities date %s", &fitbitUser, &date)
+	if err != nil || r.Header.Get("Authorization") != "Bearer token-"+fitbitUser {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.mu.Lock()
+	steps := h.steps[fitbitUser]
+	floors := h.floors[fitbitUser]
+	unavailable := h.fitbitUnavailable
+	h.mu.Unlock()
+
+	if unavailable {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ActivitySummaryResponse{Summary: Summary{Steps: steps, Floors: floors}, Goals: Goals{Steps: 10000}})
+}
+
+// serveResponseURL records the responses sent to slack response urls
+func (h *lifecycleHarness) serveResponseURL(w http.ResponseWriter, r *http.Request) {
+	var response ActionResponse
+	err := json.NewDecoder(r.Body).Decode(&response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	h.responses = append(h.responses, response)
+	h.mu.Unlock()
+}
+
+func (h *lifecycleHarness) setSteps(fitbitUser string, steps int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.steps[fitbitUser] = steps
+}
+
+func (h *lifecycleHarness) setFloors(fitbitUser string, floors int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.floors[fitbitUser] = floors
+}
+
+func (h *lifecycleHarness) receivedResponses() []ActionResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]ActionResponse(nil), h.responses...)
+}
+
+// addUser adds a slack user to the channel and links their fitbit account
+func (h *lifecycleHarness) addUser(slackUser string, realName string, fitbitUser string) {
+	h.slack.users[slackUser] = realName
+	h.slack.members[lifecycleChannelID] = append(h.slack.members[lifecycleChannelID], slackUser)
+
+	ctx := context.Background()
+	require.NoError(h.t, h.storage.PutClientAccess(ctx, ClientAccess{SlackUser: slackUser, SlackTeam: lifecycleTeamID, FitbitUser: fitbitUser}))
+	require.NoError(h.t, h.storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: fitbitUser, Token: "token-" + fitbitUser, RefreshToken: "refresh-" + fitbitUser, Expiry: h.clock.Now().AddDate(0, 0, 30), RefreshedAt: h.clock.Now()}))
+}
+
+// slashCommand creates a signed slash command request from a user in the test channel
+func (h *lifecycleHarness) slashCommand(command string, userID string) (r *http.Request) {
+	return h.slashCommandWithText(command, userID, "")
+}
+
+// slashCommandWithText creates a signed slash command request with arguments from a user in the test channel
+func (h *lifecycleHarness) slashCommandWithText(command string, userID string, text string) (r *http.Request) {
+	params := url.Values{}
+	params.Set("command", command)
+	params.Set(textParam, text)
+	params.Set(teamIDParam, lifecycleTeamID)
+	params.Set(channelIDParam, lifecycleChannelID)
+	params.Set(userIDParam, userID)
+	params.Set(responseURLParam, h.responsesURL)
+	params.Set(triggerIDParam, "trigger-"+userID)
+
+	return h.signedRequest(command, params.Encode())
+}
+
+// interaction creates a signed interaction request from a user in the test channel
+func (h *lifecycleHarness) interaction(callback slack.InteractionCallback) (r *http.Request) {
+	callback.Team.ID = lifecycleTeamID
+	callback.Channel.ID = lifecycleChannelID
+	callback.ResponseURL = h.responsesURL
+
+	payload, err := json.Marshal(callback)
+	require.NoError(h.t, err)
+
+	params := url.Values{}
+	params.Set(payloadParam, string(payload))
+
+	return h.signedRequest(interactionPath, params.Encode())
+}
+
+// signedRequest creates a request to a path with a body signed like slack does
+func (h *lifecycleHarness) signedRequest(path string, body string) (r *http.Request) {
+	// The slack verifier checks the request timestamp against the actual time rather than the app's clock
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(lifecycleSigningSecret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", ts, body)))
+
+	r = httptest.NewRequest(http.MethodPost, "/"+path, strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	return r
+}
+
+// runScheduledTasks moves the clock to each scheduled task in turn and dispatches it until no task is left. before is
+// called ahead of every dispatch with the time it's happening at
+func (h *lifecycleHarness) runScheduledTasks(before func(now time.Time)) (dispatched int) {
+	for {
+		next, ok := h.scheduler.NextScheduleTime()
+		if !ok {
+			return dispatched
+		}
+
+		if next.After(h.clock.Now()) {
+			h.clock.Set(next)
+		}
+
+		before(h.clock.Now())
+		n, err := h.scheduler.RunDue(h.mux)
+		require.NoError(h.t, err)
+		dispatched += n
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	require.NoError(t, err)
+
+	return location
+}
+
+func TestChallengeLifecycle(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.slack.members[lifecycleChannelID] = append(h.slack.members[lifecycleChannelID], "U3")
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	challengeID := ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"}
+	challenge, err := h.storage.GetChallenge(ctx, challengeID)
+	require.NoError(t, err)
+	assert.True(t, challenge.Active)
+	assert.Equal(t, "U1", challenge.CreatorID)
+	assert.Equal(t, "America/Los_Angeles", challenge.TimezoneID)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U1> started a steps challenge!")
+	require.Len(t, h.scheduler.Pending(), 1)
+
+	// Alice leads in the morning and Bob catches up in the afternoon
+	dispatchTimes := make([]time.Time, 0)
+	dispatched := h.runScheduledTasks(func(now time.Time) {
+		dispatchTimes = append(dispatchTimes, now)
+		hours := int(now.Sub(time.Date(2020, 6, 1, 9, 30, 0, 0, location)).Hours())
+		h.setSteps("F1", 2000+hours*500)
+		h.setSteps("F2", hours*1000)
+	})
+
+	// Hourly updates from 9:30 to 18:30, one evening task scheduling the final update, the final update at 8am
+	// the next morning and the perfect month evaluation once June is over
+	assert.Equal(t, 13, dispatched)
+	assert.Equal(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location), dispatchTimes[0].In(location))
+	assert.Equal(t, time.Date(2020, 6, 1, 18, 30, 0, 0, location), dispatchTimes[9].In(location))
+	assert.Equal(t, time.Date(2020, 6, 1, 19, 30, 0, 0, location), dispatchTimes[10].In(location))
+	assert.Equal(t, time.Date(2020, 6, 2, 8, 0, 0, 0, location), dispatchTimes[11].In(location))
+	assert.Equal(t, time.Date(2020, 7, 2, 0, 0, 0, 0, location), dispatchTimes[12].In(location))
+	assert.Empty(t, h.scheduler.Pending())
+
+	messages = h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 12)
+
+	firstUpdate := messages[1]
+	assert.Contains(t, firstUpdate.blocks, "_Alice_ `2000` :athletic_shoe: :tornado::rocket:")
+	assert.Contains(t, firstUpdate.blocks, "_Bob_ `0` :athletic_shoe:")
+
+	winnerAnnouncement := messages[11]
+	assert.Contains(t, winnerAnnouncement.text, "We have a winner")
+	assert.Contains(t, winnerAnnouncement.blocks, "_Bob_ `22000` :athletic_shoe: :tornado::rocket:")
+	assert.Contains(t, winnerAnnouncement.blocks, "_Alice_ `13000` :athletic_shoe:")
+
+	challenge, err = h.storage.GetChallenge(ctx, challengeID)
+	require.NoError(t, err)
+	assert.False(t, challenge.Active)
+	assert.Equal(t, []UserSteps{{UserID: "U2", Steps: 22000, Goal: 10000}, {UserID: "U1", Steps: 13000, Goal: 10000}}, challenge.RankedUsers)
+
+	leaderboard, err := h.storage.GetLeaderboard(ctx, lifecycleTeamID, scopeWorkspace, "", periodAll, periodID(periodAll, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, leaderboard.Challenges)
+	require.Len(t, leaderboard.Standings, 2)
+	assert.Equal(t, "U2", leaderboard.Standings[0].UserID)
+	assert.Equal(t, 1, leaderboard.Standings[0].Wins)
+
+	streaks, err := h.storage.GetUserStreaks(ctx, lifecycleTeamID, "U2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, streaks.GoalStreak)
+
+	activeChallenges, err := h.storage.ListActiveChallenges(ctx, lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Empty(t, activeChallenges)
+
+	assert.Empty(t, h.receivedResponses())
+}
+
+func TestChallengeAlreadyActive(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	h.clock.Advance(10 * time.Minute)
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, "ephemeral", responses[0].ResponseType)
+	assert.Contains(t, responses[0].Text, "There's already an active steps challenge")
+
+	assert.Len(t, h.slack.messagesTo(lifecycleChannelID), 1)
+	assert.Len(t, h.scheduler.Pending(), 1)
+}
+
+func TestChallengeBotNotInPublicChannel(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.slack.postErrs[lifecycleChannelID] = errors.New("not_in_channel")
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{lifecycleChannelID}, h.slack.joined)
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U1> started a steps challenge!")
+	assert.Empty(t, h.receivedResponses())
+
+	_, err = h.storage.GetChallenge(context.Background(), ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"})
+	assert.NoError(t, err)
+	assert.Len(t, h.scheduler.Pending(), 1)
+}
+
+func TestChallengeBotNotInPrivateChannel(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.slack.postErrs[lifecycleChannelID] = errors.New("channel_not_found")
+	h.slack.private[lifecycleChannelID] = true
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.joined)
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Contains(t, responses[0].Text, fmt.Sprintf("Add me, <@%s>", lifecycleBotID))
+
+	_, err = h.storage.GetChallenge(context.Background(), ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"})
+	assert.Equal(t, ErrNoSuchEntity, err)
+	assert.Empty(t, h.scheduler.Pending())
+}
+
+func TestChallengeBotCantJoinChannel(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.slack.postErrs[lifecycleChannelID] = errors.New("not_in_channel")
+	h.slack.private[lifecycleChannelID] = true
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Contains(t, responses[0].Text, fmt.Sprintf("Add me, <@%s>", lifecycleBotID))
+	assert.Empty(t, h.scheduler.Pending())
+}
+
+func TestStandings(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.setSteps("F1", 4321)
+
+	err := h.sc.Standings(httptest.NewRecorder(), h.slashCommand(commandStandings, "U1"))
+	require.NoError(t, err)
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Contains(t, responses[0].Text, "There's no active challenge in this channel")
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	h.clock.Advance(2 * time.Hour)
+	err = h.sc.Standings(httptest.NewRecorder(), h.slashCommand(commandStandings, "U1"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 2)
+	assert.Contains(t, messages[1].blocks, "_Alice_ `4321` :athletic_shoe: :tornado::rocket:")
+
+	// Standings don't schedule updates of their own
+	assert.Len(t, h.scheduler.Pending(), 1)
+	assert.Len(t, h.receivedResponses(), 1)
+}
+
+func TestUpdateChallengeNotFound(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	err := h.sc.scheduleChallengeUpdate(ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"}, h.clock.Now())
+	require.NoError(t, err)
+
+	dispatched := h.runScheduledTasks(func(now time.Time) {})
+	assert.Equal(t, 1, dispatched)
+	assert.Empty(t, h.scheduler.Pending())
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+}
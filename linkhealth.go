@@ -0,0 +1,83 @@
+package stepcurry
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// isInvalidGrant returns true if the error was caused by Fitbit rejecting a refresh token, in which case the user
+// has to link their account again
+func isInvalidGrant(err error) bool {
+	return errors.Cause(err) == errInvalidGrant
+}
+
+// recordFetchFailure increments the consecutive fetch failures of a user and marks their link as broken if the failure
+// means the link can't recover, in which case the user is notified. The broken link is persisted before notifying the
+// user so that failing to notify them doesn't lose it
+func (sc *StepCurry) recordFetchFailure(svcs TeamServices, clientAccess ClientAccess, fetchErr error) (err error) {
+	clientAccess.FetchFailures++
+	if isInvalidGrant(fetchErr) {
+		clientAccess.LinkBroken = true
+	}
+
+	err = sc.storage.PutClientAccess(context.Background(), clientAccess)
+	if err != nil {
+		return errors.Wrapf(err, "error persisting fitbit user mapping for user [%s]", clientAccess.SlackUser)
+	}
+
+	return sc.notifyBrokenLink(svcs, clientAccess)
+}
+
+// notifyBrokenLink sends a direct message to a user with a broken link asking them to link their account again, unless
+// they were already notified. Failures to send it are logged and the notification is attempted again with the next
+// update of a challenge the user is part of
+func (sc *StepCurry) notifyBrokenLink(svcs TeamServices, clientAccess ClientAccess) (err error) {
+	if !clientAccess.LinkBroken || clientAccess.BrokenLinkNotified {
+		return nil
+	}
+
+	messages := svcs.userMessages(clientAccess.SlackUser)
+	brokenLinkMsg := messages.render(msgBrokenLink, messageData{"LinkCommand": sc.slashCommands.Link})
+
+	// Posting to a user id delivers the message in the user's IM channel with the app
+	_, _, err = svcs.messenger.PostMessage(clientAccess.SlackUser, messages.postOptions(slack.MsgOptionText(brokenLinkMsg, false))...)
+	if err != nil {
+		newLogEntry(svcs.logger).with(Fields{teamIDField: clientAccess.SlackTeam, userIDField: clientAccess.SlackUser}).withError(err).warningf("Error notifying user of broken link")
+		return nil
+	}
+
+	clientAccess.BrokenLinkNotified = true
+	err = sc.storage.PutClientAccess(context.Background(), clientAccess)
+	if err != nil {
+		return errors.Wrapf(err, "error persisting broken link notification for user [%s]", clientAccess.SlackUser)
+	}
+
+	return nil
+}
+
+// recordFetchSuccess resets the consecutive fetch failures of a user, if any
+func (sc *StepCurry) recordFetchSuccess(clientAccess ClientAccess) (err error) {
+	if clientAccess.FetchFailures == 0 {
+		return nil
+	}
+
+	clientAccess.FetchFailures = 0
+	err = sc.storage.PutClientAccess(context.Background(), clientAccess)
+	if err != nil {
+		return errors.Wrapf(err, "error persisting fitbit user mapping for user [%s]", clientAccess.SlackUser)
+	}
+
+	return nil
+}
+
+// renderUnsyncedNote renders a note about participants whose steps couldn't be synced, if any
+func renderUnsyncedNote(messages *Messages, unsyncedCount int) (renderBlocks []slack.Block) {
+	renderBlocks = make([]slack.Block, 0)
+
+	if unsyncedCount > 0 {
+		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", messages.render(msgUnsyncedNote, messageData{"Count": unsyncedCount}), false, false)))
+	}
+
+	return renderBlocks
+}
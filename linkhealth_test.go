@@ -0,0 +1,223 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExchangeRefreshTokenErrors(t *testing.T) {
+	tests := map[string]struct {
+		status               int
+		body                 string
+		expectedInvalidGrant bool
+	}{
+		"InvalidGrant": {
+			status:               http.StatusBadRequest,
+			body:                 `{"errors":[{"errorType":"invalid_grant","message":"Refresh token invalid: abc. Visit https://dev.fitbit.com/docs/oauth2 for more information on the Fitbit Web API authorization process."}],"success":false}`,
+			expectedInvalidGrant: true,
+		},
+		"ServiceUnavailable": {
+			status:               http.StatusServiceUnavailable,
+			body:                 "come back again later",
+			expectedInvalidGrant: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorer(&mocks.Datastorer{}), OptionTaskScheduler(&mocks.TaskScheduler{}))
+			require.NoError(t, err)
+
+			_, err = sc.exchangeRefreshTokenForApiAccess("refresh")
+			require.Error(t, err)
+			assert.Equal(t, tc.expectedInvalidGrant, isInvalidGrant(errors.Wrap(err, "wrapped")))
+		})
+	}
+}
+
+func TestRecordFetchFailure(t *testing.T) {
+	tests := map[string]struct {
+		clientAccess       ClientAccess
+		fetchErr           error
+		expectNotification bool
+		expectedPuts       []ClientAccess
+	}{
+		"TransientFailure": {
+			clientAccess:       ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 1},
+			fetchErr:           errors.New("error getting activity summary [503 Service Unavailable]"),
+			expectNotification: false,
+			expectedPuts:       []ClientAccess{{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 2}},
+		},
+		"InvalidGrant": {
+			clientAccess:       ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1"},
+			fetchErr:           errors.Wrap(errInvalidGrant, "error refreshing token"),
+			expectNotification: true,
+			expectedPuts: []ClientAccess{
+				{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 1, LinkBroken: true},
+				{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 1, LinkBroken: true, BrokenLinkNotified: true},
+			},
+		},
+		"AlreadyNotified": {
+			clientAccess:       ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 1, LinkBroken: true, BrokenLinkNotified: true},
+			fetchErr:           errors.Wrap(errInvalidGrant, "error refreshing token"),
+			expectNotification: false,
+			expectedPuts:       []ClientAccess{{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 2, LinkBroken: true, BrokenLinkNotified: true}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			storer := &mocks.Datastorer{}
+			for i := range tc.expectedPuts {
+				storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Namespace == "TEAMID" && k.Kind == "ClientAccess" && k.Name == "U1"
+				}), &tc.expectedPuts[i]).Return(nil, nil).Once()
+			}
+			defer storer.AssertExpectations(t)
+
+			messenger := &mocks.Messenger{}
+			userInfoFinder := &mocks.UserInfoFinder{}
+			if tc.expectNotification {
+				messenger.On("PostMessage", "U1", mock.Anything).Return("", "", nil)
+				userInfoFinder.On("GetUserInfo", "U1").Return(&slack.User{ID: "U1"}, nil)
+			}
+			defer messenger.AssertExpectations(t)
+			defer userInfoFinder.AssertExpectations(t)
+
+			teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, messenger, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+			require.NoError(t, err)
+
+			svcs, err := sc.Route("TEAMID")
+			require.NoError(t, err)
+
+			err = sc.recordFetchFailure(svcs, tc.clientAccess, tc.fetchErr)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRecordFetchFailureWithNotificationError(t *testing.T) {
+	// The broken link is kept without marking the user as notified so that the notification is attempted again
+	storer := &mocks.Datastorer{}
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "ClientAccess" && k.Name == "U1"
+	}), &ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 1, LinkBroken: true}).Return(nil, nil).Once()
+	defer storer.AssertExpectations(t)
+
+	messenger := &mocks.Messenger{}
+	messenger.On("PostMessage", "U1", mock.Anything).Return("", "", fmt.Errorf("channel_not_found"))
+	defer messenger.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "U1").Return(&slack.User{ID: "U1"}, nil)
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, messenger, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	logger := &recordingLogger{}
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}), OptionLogger(logger))
+	require.NoError(t, err)
+
+	svcs, err := sc.Route("TEAMID")
+	require.NoError(t, err)
+
+	err = sc.recordFetchFailure(svcs, ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1"}, errInvalidGrant)
+	require.NoError(t, err)
+
+	require.Len(t, logger.entries, 1)
+	assert.Equal(t, recordedEntry{severity: SeverityWarning, message: "Error notifying user of broken link", fields: Fields{teamIDField: "TEAMID", userIDField: "U1", errorField: "channel_not_found"}}, logger.entries[0])
+}
+
+func TestBrokenLinkNotificationRetriedOnChallengeUpdate(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.slack.locales["U2"] = "fr-FR"
+
+	// Bob's link was found broken but notifying him failed
+	ctx := context.Background()
+	require.NoError(t, h.storage.PutClientAccess(ctx, ClientAccess{SlackUser: "U2", SlackTeam: lifecycleTeamID, FitbitUser: "F2", FetchFailures: 1, LinkBroken: true}))
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	h.clock.Advance(2 * time.Hour)
+	err = h.sc.Standings(httptest.NewRecorder(), h.slashCommand(commandStandings, "U1"))
+	require.NoError(t, err)
+
+	notifications := h.slack.messagesTo("U2")
+	require.Len(t, notifications, 1)
+	assert.Contains(t, notifications[0].text, "J'ai perdu l'accès à votre compte Fitbit")
+
+	clientAccess, err := h.storage.GetClientAccess(ctx, lifecycleTeamID, "U2")
+	require.NoError(t, err)
+	assert.True(t, clientAccess.BrokenLinkNotified)
+
+	// Once notified, users aren't told again
+	err = h.sc.Standings(httptest.NewRecorder(), h.slashCommand(commandStandings, "U1"))
+	require.NoError(t, err)
+	assert.Len(t, h.slack.messagesTo("U2"), 1)
+}
+
+func TestRecordFetchSuccess(t *testing.T) {
+	storer := &mocks.Datastorer{}
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "ClientAccess" && k.Name == "U1"
+	}), &ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1"}).Return(nil, nil).Once()
+	defer storer.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	// No failures to reset so nothing gets persisted
+	err = sc.recordFetchSuccess(ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1"})
+	require.NoError(t, err)
+
+	err = sc.recordFetchSuccess(ClientAccess{SlackUser: "U1", SlackTeam: "TEAMID", FitbitUser: "F1", FetchFailures: 3})
+	require.NoError(t, err)
+}
+
+func TestRenderUnsyncedNote(t *testing.T) {
+	assert.Len(t, renderUnsyncedNote(DefaultMessages(), 0), 0)
+
+	blocks := renderUnsyncedNote(DefaultMessages(), 1)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, ":warning: 1 participant couldn't be synced", blocks[0].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text)
+
+	blocks = renderUnsyncedNote(DefaultMessages(), 3)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, ":warning: 3 participants couldn't be synced", blocks[0].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text)
+}
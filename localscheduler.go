@@ -0,0 +1,192 @@
+package stepcurry
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// jobLease is how long a claimed job is kept from other workers. A job that's still around once its lease
+	// expires is considered failed and runs again
+	jobLease = 5 * time.Minute
+	// maxJobAttempts is the number of times a job runs before it's given up on
+	maxJobAttempts = 5
+	// jobBatchSize is the maximum number of due jobs loaded at once
+	jobBatchSize = 50
+)
+
+// LocalScheduler is a Scheduler persisting jobs with a Storage and running them with a polling worker rather than
+// relying on a hosted queue. Jobs survive restarts and servers sharing the same storage can all run a worker: before
+// running a job, a worker claims it by pushing its scheduled time past a lease in a transaction so that no other
+// worker runs it at the same time. Failed jobs run again once their lease expires, up to maxJobAttempts times
+type LocalScheduler struct {
+	storage Storage
+	clock   Clock
+	logger  Logger
+}
+
+var _ Scheduler = (*LocalScheduler)(nil)
+
+// NewLocalScheduler creates a new LocalScheduler persisting jobs with storage and telling when they're due with clock
+func NewLocalScheduler(storage Storage, clock Clock) (scheduler *LocalScheduler) {
+	return &LocalScheduler{storage: storage, clock: clock, logger: defaultLogger}
+}
+
+// UseLogger sets the logger the scheduler logs job failures with
+func (ls *LocalScheduler) UseLogger(logger Logger) {
+	ls.logger = logger
+}
+
+// Schedule schedules an update of a challenge at the given time
+func (ls *LocalScheduler) Schedule(ctx context.Context, challengeID ChallengeID, scheduledTime time.Time) (err error) {
+	return ls.schedule(ctx, challengeUpdateJob(challengeID, scheduledTime))
+}
+
+// ScheduleTokenSweep schedules a token sweep at the given time
+func (ls *LocalScheduler) ScheduleTokenSweep(ctx context.Context, scheduledTime time.Time) (err error) {
+	return ls.schedule(ctx, tokenSweepJob(scheduledTime))
+}
+
+// ScheduleActivityUpdate schedules the recording of a fitbit user's activity at the given time
+func (ls *LocalScheduler) ScheduleActivityUpdate(ctx context.Context, update ActivityUpdate, scheduledTime time.Time) (err error) {
+	return ls.schedule(ctx, activityUpdateJob(update, scheduledTime))
+}
+
+// ScheduleHomePublish schedules the publishing of a user's app home at the given time
+func (ls *LocalScheduler) ScheduleHomePublish(ctx context.Context, update HomeUpdate, scheduledTime time.Time) (err error) {
+	return ls.schedule(ctx, homePublishJob(update, scheduledTime))
+}
+
+// SchedulePerfectMonth schedules the evaluation of perfect months of a channel at the given time
+func (ls *LocalScheduler) SchedulePerfectMonth(ctx context.Context, evaluation PerfectMonthEvaluation, scheduledTime time.Time) (err error) {
+	return ls.schedule(ctx, perfectMonthJob(evaluation, scheduledTime))
+}
+
+// schedule persists a job unless a job with the same name is already scheduled
+func (ls *LocalScheduler) schedule(ctx context.Context, job Job) (err error) {
+	return ls.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		_, err = ls.storage.GetJob(tc, job.Name)
+		if err == nil {
+			return nil
+		}
+
+		if err != ErrNoSuchEntity {
+			return errors.Wrapf(err, "error loading job [%s]", job.Name)
+		}
+
+		err = ls.storage.PutJob(tc, job)
+		if err != nil {
+			return errors.Wrapf(err, "error persisting job [%s]", job.Name)
+		}
+
+		return nil
+	})
+}
+
+// Run polls for due jobs every pollInterval and runs them with runner until ctx is done
+func (ls *LocalScheduler) Run(ctx context.Context, runner JobRunner, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := ls.RunDue(ctx, runner)
+		if err != nil {
+			newLogEntry(ls.logger).withError(err).errorf("Error running due jobs")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs all jobs due at the clock's current time with runner and returns the number of jobs that ran
+// successfully. Jobs scheduled while running are also run if they're due. Job failures are logged and don't
+// interrupt the run
+func (ls *LocalScheduler) RunDue(ctx context.Context, runner JobRunner) (ran int, err error) {
+	for {
+		jobs, err := ls.storage.ListDueJobs(ctx, ls.clock.Now(), jobBatchSize)
+		if err != nil {
+			return ran, errors.Wrap(err, "error loading due jobs")
+		}
+
+		claimedAny := false
+		for _, job := range jobs {
+			claimed, err := ls.claim(ctx, &job)
+			if err != nil {
+				return ran, err
+			}
+
+			if !claimed {
+				continue
+			}
+
+			claimedAny = true
+			if ls.runJob(ctx, runner, job) {
+				ran++
+			}
+		}
+
+		if !claimedAny {
+			return ran, nil
+		}
+	}
+}
+
+// claim takes a lease on a due job. It returns false if the job was claimed or completed by another worker in the
+// meantime
+func (ls *LocalScheduler) claim(ctx context.Context, job *Job) (claimed bool, err error) {
+	err = ls.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		claimed = false
+
+		stored, err := ls.storage.GetJob(tc, job.Name)
+		if err == ErrNoSuchEntity {
+			return nil
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "error loading job [%s]", job.Name)
+		}
+
+		now := ls.clock.Now()
+		if stored.ScheduledTime.After(now) {
+			return nil
+		}
+
+		stored.ScheduledTime = now.Add(jobLease)
+		stored.Attempts++
+		err = ls.storage.PutJob(tc, stored)
+		if err != nil {
+			return errors.Wrapf(err, "error claiming job [%s]", job.Name)
+		}
+
+		*job = stored
+		claimed = true
+		return nil
+	})
+
+	return claimed, err
+}
+
+// runJob runs a claimed job and deletes it once it's done or out of attempts. It returns true if the job ran
+// successfully
+func (ls *LocalScheduler) runJob(ctx context.Context, runner JobRunner, job Job) (succeeded bool) {
+	err := runner.RunJob(ctx, job)
+	if err != nil {
+		if job.Attempts < maxJobAttempts {
+			newLogEntry(ls.logger).with(Fields{jobField: job.Name}).withError(err).warningf("Error running job on attempt [%d], retrying in [%s]", job.Attempts, jobLease)
+			return false
+		}
+
+		newLogEntry(ls.logger).with(Fields{jobField: job.Name}).withError(err).errorf("Giving up on job after [%d] attempts", job.Attempts)
+	}
+
+	if deleteErr := ls.storage.DeleteJob(ctx, job.Name); deleteErr != nil {
+		newLogEntry(ls.logger).with(Fields{jobField: job.Name}).withError(deleteErr).errorf("Error deleting job, it will run again once its lease expires")
+	}
+
+	return err == nil
+}
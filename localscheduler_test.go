@@ -0,0 +1,222 @@
+package stepcurry
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// jobRunnerFunc adapts a function to a JobRunner
+type jobRunnerFunc func(ctx context.Context, job Job) (err error)
+
+func (f jobRunnerFunc) RunJob(ctx context.Context, job Job) (err error) {
+	return f(ctx, job)
+}
+
+func TestLocalSchedulerRunDue(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	storage := NewMemoryStorage()
+	scheduler := NewLocalScheduler(storage, clock)
+	ctx := context.Background()
+
+	challengeID := ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-01"}
+	require.NoError(t, scheduler.Schedule(ctx, challengeID, start.Add(time.Hour)))
+	require.NoError(t, scheduler.ScheduleTokenSweep(ctx, start))
+	// Scheduling the same sweep again results in a single job
+	require.NoError(t, scheduler.ScheduleTokenSweep(ctx, start))
+
+	ran := make([]Job, 0)
+	runner := jobRunnerFunc(func(ctx context.Context, job Job) error {
+		ran = append(ran, job)
+
+		// Jobs scheduled while running run in the same pass when they're due
+		if job.Kind == JobKindTokenSweep {
+			return scheduler.Schedule(ctx, challengeID, clock.Now())
+		}
+
+		return nil
+	})
+
+	n, err := scheduler.RunDue(ctx, runner)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, ran, 2)
+	assert.Equal(t, JobKindTokenSweep, ran[0].Kind)
+	assert.Equal(t, 1, ran[0].Attempts)
+	assert.Equal(t, JobKindChallengeUpdate, ran[1].Kind)
+	assert.Equal(t, challengeID, ran[1].ChallengeID)
+
+	n, err = scheduler.RunDue(ctx, runner)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	clock.Advance(time.Hour)
+	n, err = scheduler.RunDue(ctx, runner)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	pending, err := storage.ListDueJobs(ctx, start.Add(24*time.Hour), jobBatchSize)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestLocalSchedulerJobsSurviveRestarts(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	challengeID := ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-01"}
+	require.NoError(t, NewLocalScheduler(storage, clock).Schedule(ctx, challengeID, start.Add(time.Minute)))
+
+	clock.Advance(time.Minute)
+	ran := make([]ChallengeID, 0)
+	n, err := NewLocalScheduler(storage, clock).RunDue(ctx, jobRunnerFunc(func(ctx context.Context, job Job) error {
+		ran = append(ran, job.ChallengeID)
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []ChallengeID{challengeID}, ran)
+}
+
+func TestLocalSchedulerRetriesFailedJobs(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	storage := NewMemoryStorage()
+	scheduler := NewLocalScheduler(storage, clock)
+	ctx := context.Background()
+
+	require.NoError(t, scheduler.ScheduleTokenSweep(ctx, start))
+
+	attempts := 0
+	failing := jobRunnerFunc(func(ctx context.Context, job Job) error {
+		attempts++
+		return errors.New("failed")
+	})
+
+	n, err := scheduler.RunDue(ctx, failing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, attempts)
+
+	// The failed job is leased and doesn't run again until its lease expires
+	n, err = scheduler.RunDue(ctx, failing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempts)
+
+	for i := 1; i < maxJobAttempts; i++ {
+		clock.Advance(jobLease)
+		_, err = scheduler.RunDue(ctx, failing)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, maxJobAttempts, attempts)
+
+	// Out of attempts, the job is given up on
+	_, err = storage.GetJob(ctx, tokenSweepJob(start).Name)
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestLocalSchedulerSkipsJobsClaimedElsewhere(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	storage := NewMemoryStorage()
+	scheduler := NewLocalScheduler(storage, clock)
+	ctx := context.Background()
+
+	require.NoError(t, scheduler.ScheduleTokenSweep(ctx, start))
+	job, err := storage.GetJob(ctx, tokenSweepJob(start).Name)
+	require.NoError(t, err)
+
+	claimed, err := scheduler.claim(ctx, &job)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, start.Add(jobLease), job.ScheduledTime)
+
+	claimed, err = scheduler.claim(ctx, &Job{Name: job.Name})
+	require.NoError(t, err)
+	assert.False(t, claimed)
+}
+
+func TestLocalSchedulerRunStopsWhenDone(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	scheduler := NewLocalScheduler(NewMemoryStorage(), clock)
+	require.NoError(t, scheduler.ScheduleTokenSweep(context.Background(), clock.Now()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx, jobRunnerFunc(func(ctx context.Context, job Job) error {
+			cancel()
+			return nil
+		}), time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler didn't stop after its context was done")
+	}
+}
+
+func TestRunJobUnknownKind(t *testing.T) {
+	sc := &StepCurry{}
+
+	err := sc.RunJob(context.Background(), Job{Name: "mystery", Kind: "mystery"})
+	require.EqualError(t, err, "unknown kind [mystery] for job [mystery]")
+}
+
+func TestChallengeLifecycleWithLocalScheduler(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, location)
+	clock := NewFakeClock(start)
+	storage := NewMemoryStorage()
+	scheduler := NewLocalScheduler(storage, clock)
+
+	h, cleanup := newLifecycleHarness(t, start, OptionClock(clock), OptionStorage(storage), OptionScheduler(scheduler))
+	defer cleanup()
+	h.clock = clock
+	h.storage = storage
+
+	h.addUser("U1", "Alice", "F1")
+	h.setSteps("F1", 12000)
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+	assert.Empty(t, h.scheduler.Pending())
+
+	ctx := context.Background()
+	ran := 0
+	for {
+		next, err := storage.ListDueJobs(ctx, start.Add(48*time.Hour), 1)
+		require.NoError(t, err)
+		if len(next) == 0 {
+			break
+		}
+
+		if next[0].ScheduledTime.After(clock.Now()) {
+			clock.Set(next[0].ScheduledTime)
+		}
+
+		n, err := scheduler.RunDue(ctx, h.sc)
+		require.NoError(t, err)
+		ran += n
+	}
+
+	assert.Equal(t, 12, ran)
+
+	challenge, err := storage.GetChallenge(ctx, ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"})
+	require.NoError(t, err)
+	assert.False(t, challenge.Active)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 12)
+	assert.Contains(t, messages[11].text, "We have a winner")
+}
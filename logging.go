@@ -0,0 +1,240 @@
+package stepcurry
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity is the level of a log entry
+type Severity int
+
+// Severities, from least to most severe
+const (
+	SeverityDebug Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+)
+
+// String returns the name of the severity as recognized by Cloud Logging
+func (s Severity) String() string {
+	switch s {
+	case SeverityDebug:
+		return "DEBUG"
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityError:
+		return "ERROR"
+	default:
+		return "DEFAULT"
+	}
+}
+
+// Log field names
+const (
+	requestIDField    = "requestID"
+	teamIDField       = "teamID"
+	channelIDField    = "channelID"
+	challengeKeyField = "challengeKey"
+	userIDField       = "userID"
+	fitbitUserField   = "fitbitUser"
+	jobField          = "job"
+	pathField         = "path"
+	errorField        = "error"
+)
+
+// Request headers the request ID of a log entry is taken from, in order of preference. Cloud Functions and Cloud Run
+// set the trace context header on every request
+const (
+	cloudTraceContextHeader = "X-Cloud-Trace-Context"
+	requestIDHeader         = "X-Request-Id"
+)
+
+// Fields holds the structured fields of a log entry
+type Fields map[string]interface{}
+
+// Logger defines the interface for structured, leveled logging
+type Logger interface {
+	// Log logs a message at a severity along with fields
+	Log(severity Severity, message string, fields Fields)
+}
+
+// JSONLogger is a Logger writing entries as json objects, one per line, in the structured logging format of Cloud
+// Logging. See https://cloud.google.com/logging/docs/structured-logging
+type JSONLogger struct {
+	w           io.Writer
+	minSeverity Severity
+	clock       Clock
+	mu          sync.Mutex
+}
+
+// NewJSONLogger creates a new JSONLogger writing entries of minSeverity or more to w
+func NewJSONLogger(w io.Writer, minSeverity Severity) (logger *JSONLogger) {
+	return &JSONLogger{w: w, minSeverity: minSeverity, clock: NewSystemClock()}
+}
+
+// defaultLogger is the logger used when none is set
+var defaultLogger Logger = NewJSONLogger(os.Stderr, SeverityInfo)
+
+// Log writes an entry with the severity, message and time along with fields. Fields named like the entry's own are
+// ignored
+func (l *JSONLogger) Log(severity Severity, message string, fields Fields) {
+	if severity < l.minSeverity {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for name, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[name] = value
+	}
+	entry["severity"] = severity.String()
+	entry["message"] = message
+	entry["time"] = l.clock.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"severity": SeverityError.String(), "message": fmt.Sprintf("Error encoding log entry [%s]: %s", message, err.Error())})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(append(line, '\n'))
+}
+
+// OptionLogger sets the logger of StepCurry. The logger is also handed to the team router and scheduler when they
+// log. Defaults to a JSONLogger writing entries of SeverityInfo or more to stderr
+func OptionLogger(logger Logger) Option {
+	return func(sc *StepCurry) (err error) {
+		sc.logger = logger
+		return nil
+	}
+}
+
+// loggerUser is implemented by the team routers and schedulers that log
+type loggerUser interface {
+	UseLogger(logger Logger)
+}
+
+// logFieldsContextKey is the context key of the log fields of a request
+type logFieldsContextKey struct{}
+
+// withLogFields returns a context carrying fields on top of the ones ctx already carries. Entries logged for the
+// context include them
+func withLogFields(ctx context.Context, fields Fields) context.Context {
+	merged := make(Fields)
+	for name, value := range logFields(ctx) {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		if value != "" {
+			merged[name] = value
+		}
+	}
+
+	return context.WithValue(ctx, logFieldsContextKey{}, merged)
+}
+
+// logFields returns the log fields carried by a context
+func logFields(ctx context.Context) (fields Fields) {
+	fields, _ = ctx.Value(logFieldsContextKey{}).(Fields)
+	return fields
+}
+
+// challengeLogFields returns the log fields identifying a challenge
+func challengeLogFields(challengeID ChallengeID) (fields Fields) {
+	return Fields{teamIDField: challengeID.TeamID, channelIDField: challengeID.ChannelID, challengeKeyField: challengeID.Key()}
+}
+
+// requestID returns the ID of a request, taken from its trace context or request ID header if set and generated
+// otherwise
+func requestID(r *http.Request) (id string) {
+	if traceContext := r.Header.Get(cloudTraceContextHeader); traceContext != "" {
+		return strings.SplitN(traceContext, "/", 2)[0]
+	}
+
+	if id = r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	b := make([]byte, 8)
+	cryptorand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logEntry builds a log entry with fields
+type logEntry struct {
+	logger Logger
+	fields Fields
+}
+
+// newLogEntry creates a log entry for logger. The default logger is used if logger is nil
+func newLogEntry(logger Logger) logEntry {
+	if logger == nil {
+		logger = defaultLogger
+	}
+
+	return logEntry{logger: logger}
+}
+
+// log returns a log entry with the fields carried by ctx
+func (sc *StepCurry) log(ctx context.Context) logEntry {
+	return newLogEntry(sc.logger).withContext(ctx)
+}
+
+// withContext returns a copy of the entry with the fields carried by ctx added
+func (e logEntry) withContext(ctx context.Context) logEntry {
+	return e.with(logFields(ctx))
+}
+
+// with returns a copy of the entry with fields added
+func (e logEntry) with(fields Fields) logEntry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for name, value := range e.fields {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+
+	return logEntry{logger: e.logger, fields: merged}
+}
+
+// withError returns a copy of the entry with an error field
+func (e logEntry) withError(err error) logEntry {
+	return e.with(Fields{errorField: err.Error()})
+}
+
+func (e logEntry) logf(severity Severity, format string, args ...interface{}) {
+	e.logger.Log(severity, fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e logEntry) debugf(format string, args ...interface{}) {
+	e.logf(SeverityDebug, format, args...)
+}
+
+func (e logEntry) infof(format string, args ...interface{}) {
+	e.logf(SeverityInfo, format, args...)
+}
+
+func (e logEntry) warningf(format string, args ...interface{}) {
+	e.logf(SeverityWarning, format, args...)
+}
+
+func (e logEntry) errorf(format string, args ...interface{}) {
+	e.logf(SeverityError, format, args...)
+}
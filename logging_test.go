@@ -0,0 +1,208 @@
+package stepcurry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedEntry is a log entry recorded by a recordingLogger
+type recordedEntry struct {
+	severity Severity
+	message  string
+	fields   Fields
+}
+
+// recordingLogger is a Logger recording the entries it's given
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []recordedEntry
+}
+
+func (rl *recordingLogger) Log(severity Severity, message string, fields Fields) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.entries = append(rl.entries, recordedEntry{severity: severity, message: message, fields: fields})
+}
+
+func TestJSONLoggerFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, SeverityInfo)
+	logger.clock = NewFakeClock(time.Date(2020, time.March, 3, 10, 30, 0, 0, time.UTC))
+
+	logger.Log(SeverityWarning, "Something happened", Fields{teamIDField: "T1", challengeKeyField: "C1-2020-03-03", "attempt": 2, errorField: errors.New("boom")})
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, map[string]interface{}{"severity": "WARNING", "message": "Something happened", "time": "2020-03-03T10:30:00Z", "teamID": "T1", "challengeKey": "C1-2020-03-03", "attempt": float64(2), "error": "boom"}, entry)
+	assert.True(t, strings.HasSuffix(buf.String(), "}\n"))
+}
+
+func TestJSONLoggerEntryFieldsTakePrecedence(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, SeverityDebug)
+
+	logger.Log(SeverityError, "Real message", Fields{"severity": "DEBUG", "message": "fake"})
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["severity"])
+	assert.Equal(t, "Real message", entry["message"])
+}
+
+func TestJSONLoggerMinSeverity(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, SeverityInfo)
+
+	logger.Log(SeverityDebug, "Dropped", nil)
+	logger.Log(SeverityInfo, "Kept", nil)
+	logger.Log(SeverityError, "Kept too", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"message":"Kept"`)
+	assert.Contains(t, lines[1], `"message":"Kept too"`)
+}
+
+func TestSeverityString(t *testing.T) {
+	assert.Equal(t, "DEBUG", SeverityDebug.String())
+	assert.Equal(t, "INFO", SeverityInfo.String())
+	assert.Equal(t, "WARNING", SeverityWarning.String())
+	assert.Equal(t, "ERROR", SeverityError.String())
+	assert.Equal(t, "DEFAULT", Severity(42).String())
+}
+
+func TestRequestID(t *testing.T) {
+	tests := map[string]struct {
+		headers    map[string]string
+		expectedID string
+	}{
+		"TraceContext": {
+			headers:    map[string]string{cloudTraceContextHeader: "105445aa7843bc8bf206b12000100000/1;o=1", requestIDHeader: "req-1"},
+			expectedID: "105445aa7843bc8bf206b12000100000",
+		},
+		"RequestIDHeader": {
+			headers:    map[string]string{requestIDHeader: "req-1"},
+			expectedID: "req-1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+
+			assert.Equal(t, tc.expectedID, requestID(r))
+		})
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	first := requestID(httptest.NewRequest(http.MethodGet, "/", nil))
+	second := requestID(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Len(t, first, 16)
+	assert.NotEqual(t, first, second)
+}
+
+func TestWithLogFields(t *testing.T) {
+	ctx := withLogFields(context.Background(), Fields{requestIDField: "req-1", teamIDField: "T1"})
+	ctx = withLogFields(ctx, Fields{teamIDField: "T2", channelIDField: "C1", userIDField: ""})
+
+	assert.Equal(t, Fields{requestIDField: "req-1", teamIDField: "T2", channelIDField: "C1"}, logFields(ctx))
+	assert.Nil(t, logFields(context.Background()))
+}
+
+func TestLogEntryFields(t *testing.T) {
+	logger := &recordingLogger{}
+	ctx := withLogFields(context.Background(), challengeLogFields(ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-03-03"}))
+
+	newLogEntry(logger).withContext(ctx).with(Fields{userIDField: "U1"}).withError(errors.New("boom")).warningf("Error for [%d]", 1)
+
+	require.Len(t, logger.entries, 1)
+	assert.Equal(t, recordedEntry{severity: SeverityWarning, message: "Error for [1]", fields: Fields{teamIDField: "T1", channelIDField: "C1", challengeKeyField: ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-03-03"}.Key(), userIDField: "U1", errorField: "boom"}}, logger.entries[0])
+}
+
+func TestLoggingHandler(t *testing.T) {
+	tests := map[string]struct {
+		handler          Handler
+		expectedCode     int
+		expectedEntries  int
+		expectedSeverity Severity
+		expectedMessage  string
+	}{
+		"NoError": {
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return nil
+			},
+			expectedCode: http.StatusOK,
+		},
+		"ClientError": {
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return newHttpError(errors.New("bad param"), "Invalid request", http.StatusBadRequest)
+			},
+			expectedCode:     http.StatusBadRequest,
+			expectedEntries:  1,
+			expectedSeverity: SeverityWarning,
+			expectedMessage:  "Invalid request",
+		},
+		"ServerError": {
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return errors.New("bad param")
+			},
+			expectedCode:     http.StatusInternalServerError,
+			expectedEntries:  1,
+			expectedSeverity: SeverityError,
+			expectedMessage:  "Error handling request",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			r := httptest.NewRequest(http.MethodPost, "/Challenge", nil)
+			r.Header.Set(requestIDHeader, "req-1")
+			w := httptest.NewRecorder()
+
+			loggingHandler{handler: func(w http.ResponseWriter, r *http.Request) error {
+				assert.Equal(t, Fields{requestIDField: "req-1"}, logFields(r.Context()))
+				return tc.handler(w, r)
+			}, logger: logger}.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			require.Len(t, logger.entries, tc.expectedEntries)
+			if tc.expectedEntries > 0 {
+				assert.Equal(t, tc.expectedSeverity, logger.entries[0].severity)
+				assert.Equal(t, tc.expectedMessage, logger.entries[0].message)
+				assert.Equal(t, Fields{requestIDField: "req-1", pathField: "/Challenge", errorField: "bad param"}, logger.entries[0].fields)
+			}
+		})
+	}
+}
+
+func TestOptionLoggerSetsRouterAndSchedulerLoggers(t *testing.T) {
+	logger := &recordingLogger{}
+	storage := NewMemoryStorage()
+	router, err := NewSingleTenantRouter(nil, nil, nil, nil)
+	require.NoError(t, err)
+	scheduler := NewLocalScheduler(storage, NewSystemClock())
+
+	sc, err := New("https://stepcurry.example.com", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionSlackVerifier("secret"), OptionStorage(storage), OptionTeamRouter(router), OptionScheduler(scheduler), OptionLogger(logger))
+	require.NoError(t, err)
+
+	assert.Equal(t, logger, sc.logger)
+	assert.Equal(t, logger, router.logger)
+	assert.Equal(t, logger, scheduler.logger)
+}
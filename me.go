@@ -0,0 +1,291 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// trendDays is the number of days, including today, shown in a user's steps trend
+	trendDays = 7
+)
+
+// sparklineBars holds the characters used to render a steps trend, from lowest to highest
+var sparklineBars = []rune("▁▂▃▄▅▆▇█")
+
+// DailySteps holds a user's step count and steps goal for a given day
+type DailySteps struct {
+	Date  string
+	Steps int
+	Goal  int
+}
+
+// ChallengeRank holds a user's position in the last known ranking of an active challenge
+type ChallengeRank struct {
+	ChannelID    string
+	Rank         int
+	Participants int
+	Steps        int
+}
+
+// UserStats holds the private stats of a linked user. The trend is ordered from the oldest day to today
+type UserStats struct {
+	Healthy bool
+	Trend   []DailySteps
+	Ranks   []ChallengeRank
+}
+
+// today returns the latest day of the trend
+func (us UserStats) today() (today DailySteps, ok bool) {
+	if len(us.Trend) == 0 {
+		return today, false
+	}
+
+	return us.Trend[len(us.Trend)-1], true
+}
+
+// getChallengeRanks returns the user's rank in each of the challenges they're part of
+func getChallengeRanks(challenges []StepsChallenge, userID string) (ranks []ChallengeRank) {
+	ranks = make([]ChallengeRank, 0)
+
+	for _, challenge := range challenges {
+		for i, us := range challenge.RankedUsers {
+			if us.UserID == userID {
+				ranks = append(ranks, ChallengeRank{ChannelID: challenge.ChannelID, Rank: i + 1, Participants: len(challenge.RankedUsers), Steps: us.Steps})
+				break
+			}
+		}
+	}
+
+	return ranks
+}
+
+// getActiveChallenges returns all active challenges of a team
+func (sc *StepCurry) getActiveChallenges(teamID string) (challenges []StepsChallenge, err error) {
+	challenges, err = sc.storage.ListActiveChallenges(context.Background(), teamID)
+	if err != nil {
+		return challenges, errors.Wrapf(err, "error loading active challenges for team [%s]", teamID)
+	}
+
+	return challenges, nil
+}
+
+// getUserTrend fetches a user's steps for the last trendDays days ending on the given day. Today's steps are fetched
+// first and any failure to get them is returned as an error since it means the link with Fitbit isn't healthy.
+// Previous days are then fetched concurrently, from the activity cache when fresh, to keep /step-me within slack's
+// response deadline. Failures on previous days are logged and those days are left out of the trend
+func (sc *StepCurry) getUserTrend(userID string, fitbitUser string, today time.Time) (trend []DailySteps, err error) {
+	trend = make([]DailySteps, 0)
+
+	apiAccess, err := sc.getFitbitApiAccess(fitbitUser)
+	if err != nil {
+		return trend, err
+	}
+
+	steps, goal, err := sc.getUserStepsWithCache(userID, apiAccess, today)
+	if err != nil {
+		return trend, err
+	}
+	todaySteps := DailySteps{Date: today.Format(challengeDateFormat), Steps: steps, Goal: goal}
+
+	// The token might have been refreshed while fetching today's steps and refresh tokens can only be used once so we
+	// load the api access again to make sure we're using the latest one
+	apiAccess, err = sc.getFitbitApiAccess(fitbitUser)
+	if err != nil {
+		return trend, err
+	}
+
+	// Today's steps might have come from the activity cache without using the token so an expiring one is refreshed
+	// here rather than by each of the concurrent fetches below
+	if apiAccess.expiresWithin(tokenRefreshMargin, sc.clock.Now()) {
+		apiAccess, err = sc.refreshApiAccess(userID, apiAccess)
+		if err != nil {
+			return trend, err
+		}
+	}
+
+	// Each day is written to its own slot so the trend stays ordered without locking
+	previousDays := make([]*DailySteps, trendDays-1)
+	var wg sync.WaitGroup
+	for i := range previousDays {
+		wg.Add(1)
+		go func(i int, date time.Time) {
+			defer wg.Done()
+
+			steps, goal, err := sc.getUserStepsWithCache(userID, apiAccess, date)
+			if err != nil {
+				newLogEntry(sc.logger).with(Fields{userIDField: userID}).withError(err).warningf("Error reading step count on [%s]", date.Format(challengeDateFormat))
+				return
+			}
+
+			previousDays[i] = &DailySteps{Date: date.Format(challengeDateFormat), Steps: steps, Goal: goal}
+		}(i, today.AddDate(0, 0, i-len(previousDays)))
+	}
+	wg.Wait()
+
+	for _, day := range previousDays {
+		if day != nil {
+			trend = append(trend, *day)
+		}
+	}
+
+	return append(trend, todaySteps), nil
+}
+
+// getFitbitApiAccess loads the stored api access of a fitbit user
+func (sc *StepCurry) getFitbitApiAccess(fitbitUser string) (apiAccess FitbitApiAccess, err error) {
+	apiAccess, err = sc.storage.GetFitbitApiAccess(context.Background(), fitbitUser)
+	if err != nil {
+		return apiAccess, errors.Wrapf(err, "error loading fitbit api access for fitbit user [%s]", fitbitUser)
+	}
+
+	return apiAccess, nil
+}
+
+// Me handles an incoming slack request in response to a user invoking /step-me and responds privately with
+// the user's steps and goal progress for today, their rank in active challenges, their trend over the last days
+// and the health of their Fitbit link
+func (sc *StepCurry) Me(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Parse the slack payload to get the originating context (channel, user)
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	channel := params[channelIDParam]
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	messages := svcs.userMessages(userID)
+
+	ctx := context.Background()
+	clientAccess, err := sc.storage.GetClientAccess(ctx, teamID, userID)
+	if err == ErrNoSuchEntity {
+		notLinkedMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgNotLinked, messageData{"LinkCommand": sc.slashCommands.Link})}
+		return sendResponse(responseURL, notLinkedMsg, "not linked")
+	} else if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error loading fitbit user mapping for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	_, location, err := sc.getChannelTimezone(svcs, channel)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting channel timezone for channel [%s]", channel), http.StatusInternalServerError)
+	}
+
+	stats := UserStats{Healthy: !clientAccess.LinkBroken}
+	if stats.Healthy {
+		stats.Trend, err = sc.getUserTrend(userID, clientAccess.FitbitUser, sc.clock.Now().In(location))
+		if err != nil {
+			sc.log(r.Context()).with(Fields{teamIDField: teamID, channelIDField: channel, userIDField: userID}).withError(err).warningf("Error getting steps trend")
+			stats.Healthy = false
+		}
+	}
+
+	challenges, err := sc.getActiveChallenges(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error loading active challenges for team [%s]", teamID), http.StatusInternalServerError)
+	}
+	stats.Ranks = getChallengeRanks(challenges, userID)
+
+	statsMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgStatsSummary, nil), Blocks: sc.renderUserStats(messages, stats)}
+	return sendResponse(responseURL, statsMsg, "user stats")
+}
+
+// renderUserStats renders a user's private stats as slack blocks
+func (sc *StepCurry) renderUserStats(messages *Messages, stats UserStats) (renderBlocks []slack.Block) {
+	renderBlocks = []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", messages.render(msgStatsHeader, nil), false, false), nil, nil)}
+
+	if today, ok := stats.today(); ok {
+		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", renderGoalProgress(messages, today), false, false)))
+	}
+
+	renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", sc.renderChallengeRanks(messages, stats.Ranks), false, false)))
+
+	if len(stats.Trend) > 0 {
+		renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", renderTrend(messages, stats.Trend), false, false)))
+	}
+
+	linkStatus := messages.render(msgLinkHealthy, nil)
+	if !stats.Healthy {
+		linkStatus = messages.render(msgLinkUnhealthy, messageData{"LinkCommand": sc.slashCommands.Link})
+	}
+	renderBlocks = append(renderBlocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", linkStatus, false, false)))
+
+	return renderBlocks
+}
+
+// renderGoalProgress renders today's steps and progress towards the user's daily goal
+func renderGoalProgress(messages *Messages, today DailySteps) string {
+	percent := 0
+	if today.Goal > 0 {
+		percent = today.Steps * 100 / today.Goal
+	}
+
+	return messages.render(msgGoalProgress, messageData{"Steps": today.Steps, "Goal": today.Goal, "Percent": percent})
+}
+
+// renderChallengeRanks renders a user's rank in active challenges
+func (sc *StepCurry) renderChallengeRanks(messages *Messages, ranks []ChallengeRank) string {
+	if len(ranks) == 0 {
+		return messages.render(msgNoChallengeRanks, messageData{"ChallengeCommand": sc.slashCommands.Challenge})
+	}
+
+	lines := make([]string, 0, len(ranks))
+	for _, rank := range ranks {
+		lines = append(lines, messages.render(msgChallengeRank, messageData{"Rank": rank.Rank, "Participants": rank.Participants, "ChannelID": rank.ChannelID, "Steps": rank.Steps}))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// renderTrend renders a user's steps trend as a sparkline along with the average steps and the number of days
+// the goal was hit
+func renderTrend(messages *Messages, trend []DailySteps) string {
+	maxSteps := 0
+	totalSteps := 0
+	goalHits := 0
+	for _, day := range trend {
+		totalSteps = totalSteps + day.Steps
+		if day.Steps > maxSteps {
+			maxSteps = day.Steps
+		}
+
+		if day.Goal > 0 && day.Steps >= day.Goal {
+			goalHits++
+		}
+	}
+
+	var sparkline strings.Builder
+	for _, day := range trend {
+		bar := 0
+		if maxSteps > 0 {
+			bar = day.Steps * (len(sparklineBars) - 1) / maxSteps
+		}
+		sparkline.WriteRune(sparklineBars[bar])
+	}
+
+	return messages.render(msgTrend, messageData{"Days": len(trend), "Sparkline": sparkline.String(), "AverageSteps": totalSteps / len(trend), "GoalHits": goalHits})
+}
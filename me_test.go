@@ -0,0 +1,238 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetChallengeRanks(t *testing.T) {
+	challenges := []StepsChallenge{
+		{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "C1", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U2", Steps: 12000}, {UserID: "U1", Steps: 9000}, {UserID: "U3", Steps: 100}}},
+		{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "C2", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U2", Steps: 12000}}},
+		{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "C3", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 9000}}},
+	}
+
+	assert.Equal(t, []ChallengeRank{{ChannelID: "C1", Rank: 2, Participants: 3, Steps: 9000}, {ChannelID: "C3", Rank: 1, Participants: 1, Steps: 9000}}, getChallengeRanks(challenges, "U1"))
+	assert.Equal(t, []ChallengeRank{}, getChallengeRanks(challenges, "U4"))
+}
+
+func TestRenderGoalProgress(t *testing.T) {
+	assert.Equal(t, ":athletic_shoe: `5000` steps today, `50%` of your `10000` steps goal", renderGoalProgress(DefaultMessages(), DailySteps{Steps: 5000, Goal: 10000}))
+	assert.Equal(t, ":athletic_shoe: `12000` steps today, `120%` of your `10000` steps goal :dart:", renderGoalProgress(DefaultMessages(), DailySteps{Steps: 12000, Goal: 10000}))
+	assert.Equal(t, ":athletic_shoe: `5000` steps today (no daily steps goal set on Fitbit)", renderGoalProgress(DefaultMessages(), DailySteps{Steps: 5000}))
+}
+
+func TestRenderTrend(t *testing.T) {
+	trend := []DailySteps{{Steps: 0, Goal: 10000}, {Steps: 7000, Goal: 10000}, {Steps: 14000, Goal: 10000}, {Steps: 11000, Goal: 10000}}
+
+	assert.Equal(t, ":chart_with_upwards_trend: Last 4 days `▁▄█▆` averaging `8000` steps, goal hit on 2 of them", renderTrend(DefaultMessages(), trend))
+}
+
+func TestGetUserTrend(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/1/user/FITBITUSER/activities/date/", func(w http.ResponseWriter, r *http.Request) {
+		date := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1/user/FITBITUSER/activities/date/"), ".json")
+		switch date {
+		case "2019-10-09":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "come back again later")
+		case "2019-10-11":
+			fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 4000}}`)
+		default:
+			fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 11000}}`)
+		}
+	})
+
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*FitbitApiAccess)
+		*returnVal = FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token", RefreshToken: "refresh"}
+	})
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	trend, err := sc.getUserTrend("U1", "FITBITUSER", time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	assert.Equal(t, []DailySteps{
+		{Date: "2019-10-05", Steps: 11000, Goal: 10000},
+		{Date: "2019-10-06", Steps: 11000, Goal: 10000},
+		{Date: "2019-10-07", Steps: 11000, Goal: 10000},
+		{Date: "2019-10-08", Steps: 11000, Goal: 10000},
+		{Date: "2019-10-10", Steps: 11000, Goal: 10000},
+		{Date: "2019-10-11", Steps: 4000, Goal: 10000},
+	}, trend)
+}
+
+func TestGetUserTrendWithUnhealthyLink(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/1/user/FITBITUSER/activities/date/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "nope")
+	})
+
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Kind == "FitbitApiAccess" && k.Name == "FITBITUSER"
+	}), mock.Anything).Return(nil).Once()
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	_, err = sc.getUserTrend("U1", "FITBITUSER", time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC))
+	require.Error(t, err)
+}
+
+func TestRenderUserStats(t *testing.T) {
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorer(&mocks.Datastorer{}), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	blocks := sc.renderUserStats(DefaultMessages(), UserStats{Healthy: false})
+	require.Len(t, blocks, 3)
+
+	blocks = sc.renderUserStats(DefaultMessages(), UserStats{Healthy: true, Trend: []DailySteps{{Steps: 5000, Goal: 10000}}, Ranks: []ChallengeRank{{ChannelID: "C1", Rank: 1, Participants: 2, Steps: 5000}}})
+	require.Len(t, blocks, 5)
+}
+
+func TestMeWithoutLinkedAccount(t *testing.T) {
+	slackRequest := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		slackRequest = string(reqBody)
+		fmt.Fprintln(w, "OK")
+	}))
+	defer server.Close()
+
+	body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-me&text=&response_url=%s&trigger_id=someTriggerID", server.URL)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	verifier := &mocks.Verifier{}
+	verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "ClientAccess" && k.Name == "frans"
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+	defer userInfoFinder.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	err = sc.Me(w, r)
+	require.NoError(t, err)
+
+	assert.Equal(t, "{\"response_type\":\"ephemeral\",\"text\":\":link: You haven't linked your Fitbit account yet. Use `/step-link` to get started\",\"replace_original\":false}", slackRequest)
+}
+
+func TestGetUserTrendRefreshesExpiringTokenOnce(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var mu sync.Mutex
+	fetchedTokens := make([]string, 0)
+	mux.HandleFunc("/1/user/FITBITUSER/activities/date/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetchedTokens = append(fetchedTokens, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 11000}}`)
+	})
+
+	exchanges := 0
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		exchanges++
+		mu.Unlock()
+
+		body, _ := json.Marshal(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "fresh", RefreshToken: "refresh2", ExpiresIn: 28800})
+		w.Write(body)
+	})
+
+	now := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "expiring", RefreshToken: "refresh1", Expiry: now.Add(time.Minute), Subscribed: true}))
+
+	// Today's steps are served from the activity cache without using the token
+	require.NoError(t, storage.PutDailyActivity(ctx, DailyActivity{FitbitUser: "FITBITUSER", Date: "2019-10-11", Steps: 4000, Goal: 10000, UpdatedAt: now}))
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionVerifier(&mocks.Verifier{}), OptionStorage(storage), OptionTaskScheduler(&mocks.TaskScheduler{}), OptionClock(NewFakeClock(now)))
+	require.NoError(t, err)
+
+	trend, err := sc.getUserTrend("U1", "FITBITUSER", now)
+	require.NoError(t, err)
+
+	assert.Len(t, trend, trendDays)
+	assert.Equal(t, 1, exchanges)
+	assert.Len(t, fetchedTokens, trendDays-1)
+	for _, token := range fetchedTokens {
+		assert.Equal(t, "Bearer fresh", token)
+	}
+}
@@ -0,0 +1,726 @@
+package stepcurry
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryTransactionContextKey is the context key holding the memoryTransaction a context is part of
+type memoryTransactionContextKey struct{}
+
+// memoryTransaction holds how to undo the writes made as part of a MemoryStorage transaction, in the order they
+// were made
+type memoryTransaction struct {
+	undos []func()
+}
+
+// memoryData holds the entities of a MemoryStorage keyed by their identifiers
+type memoryData struct {
+	challenges        map[string]StepsChallenge
+	clientAccesses    map[string]ClientAccess
+	fitbitApiAccesses map[string]FitbitApiAccess
+	csrfTokens        map[string]CsrfToken
+	botInfos          map[string]BotInfo
+	secrets           map[string]Secret
+	workspaceMessages map[string]WorkspaceMessages
+	settings          map[string]WorkspaceSettings
+	leaderboards      map[string]Leaderboard
+	achievements      map[string]UserAchievements
+	streaks           map[string]UserStreaks
+	dailyActivities   map[string]DailyActivity
+	jobs              map[string]Job
+}
+
+// MemoryStorage is a Storage keeping all entities in memory. It's meant for tests and local development and is safe
+// for concurrent use. Transactions are serialized and a failed transaction rolls back the writes made as part of it.
+// Writes made outside of the transaction while it runs, including from other goroutines, are kept
+type MemoryStorage struct {
+	mu   sync.Mutex
+	txMu sync.Mutex
+	data memoryData
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// NewMemoryStorage creates a new empty MemoryStorage
+func NewMemoryStorage() (storage *MemoryStorage) {
+	return &MemoryStorage{data: newMemoryData()}
+}
+
+func newMemoryData() memoryData {
+	return memoryData{
+		challenges:        make(map[string]StepsChallenge),
+		clientAccesses:    make(map[string]ClientAccess),
+		fitbitApiAccesses: make(map[string]FitbitApiAccess),
+		csrfTokens:        make(map[string]CsrfToken),
+		botInfos:          make(map[string]BotInfo),
+		secrets:           make(map[string]Secret),
+		workspaceMessages: make(map[string]WorkspaceMessages),
+		settings:          make(map[string]WorkspaceSettings),
+		leaderboards:      make(map[string]Leaderboard),
+		achievements:      make(map[string]UserAchievements),
+		streaks:           make(map[string]UserStreaks),
+		dailyActivities:   make(map[string]DailyActivity),
+		jobs:              make(map[string]Job),
+	}
+}
+
+// The copy functions keep callers from modifying stored entities through shared slices
+func copyChallenge(challenge StepsChallenge) StepsChallenge {
+	if challenge.RankedUsers != nil {
+		challenge.RankedUsers = append([]UserSteps(nil), challenge.RankedUsers...)
+	}
+
+	if challenge.Participants != nil {
+		challenge.Participants = append([]string(nil), challenge.Participants...)
+	}
+
+	return challenge
+}
+
+func copySecret(secret Secret) Secret {
+	secret.Ciphertext = append([]byte(nil), secret.Ciphertext...)
+	return secret
+}
+
+func copyCsrfToken(csrfToken CsrfToken) CsrfToken {
+	if csrfToken.Csrf != nil {
+		csrfToken.Csrf = append([]byte(nil), csrfToken.Csrf...)
+	}
+
+	return csrfToken
+}
+
+func copyLeaderboard(leaderboard Leaderboard) Leaderboard {
+	if leaderboard.Standings != nil {
+		leaderboard.Standings = append([]LeaderboardRecord(nil), leaderboard.Standings...)
+	}
+
+	return leaderboard
+}
+
+func copyWorkspaceMessages(workspaceMessages WorkspaceMessages) WorkspaceMessages {
+	if workspaceMessages.Templates != nil {
+		workspaceMessages.Templates = append([]MessageTemplate(nil), workspaceMessages.Templates...)
+	}
+
+	return workspaceMessages
+}
+
+func copyWorkspaceSettings(settings WorkspaceSettings) WorkspaceSettings {
+	if settings.ChannelRestrictions != nil {
+		restrictions := make([]ChannelRestriction, len(settings.ChannelRestrictions))
+		for i, r := range settings.ChannelRestrictions {
+			r.UserGroups = append([]string(nil), r.UserGroups...)
+			restrictions[i] = r
+		}
+		settings.ChannelRestrictions = restrictions
+	}
+
+	return settings
+}
+
+func copyAchievements(achievements UserAchievements) UserAchievements {
+	if achievements.Badges != nil {
+		achievements.Badges = append([]AwardedBadge(nil), achievements.Badges...)
+	}
+
+	return achievements
+}
+
+// memoryKey joins the parts of an entity identifier into a map key
+func memoryKey(parts ...string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = strconv.Quote(part)
+	}
+
+	return strings.Join(quoted, "/")
+}
+
+// RunInTransaction implements Storage. Nested calls run as part of the enclosing transaction
+func (ms *MemoryStorage) RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	if ctx.Value(memoryTransactionContextKey{}) != nil {
+		return f(ctx)
+	}
+
+	ms.txMu.Lock()
+	defer ms.txMu.Unlock()
+
+	tx := &memoryTransaction{}
+	err = f(context.WithValue(ctx, memoryTransactionContextKey{}, tx))
+	if err != nil {
+		ms.mu.Lock()
+		for i := len(tx.undos) - 1; i >= 0; i-- {
+			tx.undos[i]()
+		}
+		ms.mu.Unlock()
+	}
+
+	return err
+}
+
+// logUndo records how to undo a write made as part of a transaction, if any, so that rolling back the transaction
+// only reverts its own writes. It must be called with ms.mu held, before the write
+func logUndo(ctx context.Context, undo func()) {
+	if tx, ok := ctx.Value(memoryTransactionContextKey{}).(*memoryTransaction); ok {
+		tx.undos = append(tx.undos, undo)
+	}
+}
+
+// GetChallenge implements Storage
+func (ms *MemoryStorage) GetChallenge(ctx context.Context, challengeID ChallengeID) (challenge StepsChallenge, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	challenge, ok := ms.data.challenges[memoryKey(challengeID.TeamID, challengeID.ChannelID, challengeID.Date)]
+	if !ok {
+		return StepsChallenge{}, ErrNoSuchEntity
+	}
+
+	return copyChallenge(challenge), nil
+}
+
+// PutChallenge implements Storage
+func (ms *MemoryStorage) PutChallenge(ctx context.Context, challenge StepsChallenge) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(challenge.TeamID, challenge.ChannelID, challenge.Date)
+	previous, existed := ms.data.challenges[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.challenges[key] = previous
+		} else {
+			delete(ms.data.challenges, key)
+		}
+	})
+
+	ms.data.challenges[key] = copyChallenge(challenge)
+	return nil
+}
+
+// ListActiveChallenges implements Storage. Challenges are ordered by channel and date
+func (ms *MemoryStorage) ListActiveChallenges(ctx context.Context, teamID string) (challenges []StepsChallenge, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	challenges = make([]StepsChallenge, 0)
+	for _, challenge := range ms.data.challenges {
+		if challenge.TeamID == teamID && challenge.Active {
+			challenges = append(challenges, copyChallenge(challenge))
+		}
+	}
+
+	sort.Slice(challenges, func(i, j int) bool {
+		if challenges[i].ChannelID != challenges[j].ChannelID {
+			return challenges[i].ChannelID < challenges[j].ChannelID
+		}
+
+		return challenges[i].Date < challenges[j].Date
+	})
+
+	return challenges, nil
+}
+
+// GetClientAccess implements Storage
+func (ms *MemoryStorage) GetClientAccess(ctx context.Context, teamID string, slackUser string) (clientAccess ClientAccess, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	clientAccess, ok := ms.data.clientAccesses[memoryKey(teamID, slackUser)]
+	if !ok {
+		return ClientAccess{}, ErrNoSuchEntity
+	}
+
+	return clientAccess, nil
+}
+
+// PutClientAccess implements Storage
+func (ms *MemoryStorage) PutClientAccess(ctx context.Context, clientAccess ClientAccess) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(clientAccess.SlackTeam, clientAccess.SlackUser)
+	previous, existed := ms.data.clientAccesses[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.clientAccesses[key] = previous
+		} else {
+			delete(ms.data.clientAccesses, key)
+		}
+	})
+
+	ms.data.clientAccesses[key] = clientAccess
+	return nil
+}
+
+// DeleteClientAccess implements Storage
+func (ms *MemoryStorage) DeleteClientAccess(ctx context.Context, teamID string, slackUser string) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, slackUser)
+	previous, existed := ms.data.clientAccesses[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.clientAccesses[key] = previous
+		}
+	})
+
+	delete(ms.data.clientAccesses, key)
+	return nil
+}
+
+// ListClientAccesses implements Storage. Client accesses are ordered by slack user
+func (ms *MemoryStorage) ListClientAccesses(ctx context.Context, teamID string) (clientAccesses []ClientAccess, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	clientAccesses = make([]ClientAccess, 0)
+	for _, clientAccess := range ms.data.clientAccesses {
+		if clientAccess.SlackTeam == teamID {
+			clientAccesses = append(clientAccesses, clientAccess)
+		}
+	}
+
+	sort.Slice(clientAccesses, func(i, j int) bool {
+		return clientAccesses[i].SlackUser < clientAccesses[j].SlackUser
+	})
+
+	return clientAccesses, nil
+}
+
+// GetFitbitApiAccess implements Storage
+func (ms *MemoryStorage) GetFitbitApiAccess(ctx context.Context, fitbitUser string) (apiAccess FitbitApiAccess, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	apiAccess, ok := ms.data.fitbitApiAccesses[fitbitUser]
+	if !ok {
+		return FitbitApiAccess{}, ErrNoSuchEntity
+	}
+
+	return apiAccess, nil
+}
+
+// PutFitbitApiAccess implements Storage
+func (ms *MemoryStorage) PutFitbitApiAccess(ctx context.Context, apiAccess FitbitApiAccess) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := apiAccess.FitbitUser
+	previous, existed := ms.data.fitbitApiAccesses[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.fitbitApiAccesses[key] = previous
+		} else {
+			delete(ms.data.fitbitApiAccesses, key)
+		}
+	})
+
+	ms.data.fitbitApiAccesses[key] = apiAccess
+	return nil
+}
+
+// ListFitbitApiAccesses implements Storage. Api accesses are ordered by fitbit user
+func (ms *MemoryStorage) ListFitbitApiAccesses(ctx context.Context) (apiAccesses []FitbitApiAccess, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	apiAccesses = make([]FitbitApiAccess, 0, len(ms.data.fitbitApiAccesses))
+	for _, apiAccess := range ms.data.fitbitApiAccesses {
+		apiAccesses = append(apiAccesses, apiAccess)
+	}
+
+	sort.Slice(apiAccesses, func(i, j int) bool {
+		return apiAccesses[i].FitbitUser < apiAccesses[j].FitbitUser
+	})
+
+	return apiAccesses, nil
+}
+
+// GetCsrfToken implements Storage
+func (ms *MemoryStorage) GetCsrfToken(ctx context.Context, teamID string, slackUser string) (csrfToken CsrfToken, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	csrfToken, ok := ms.data.csrfTokens[memoryKey(teamID, slackUser)]
+	if !ok {
+		return CsrfToken{}, ErrNoSuchEntity
+	}
+
+	return copyCsrfToken(csrfToken), nil
+}
+
+// PutCsrfToken implements Storage
+func (ms *MemoryStorage) PutCsrfToken(ctx context.Context, teamID string, slackUser string, csrfToken CsrfToken) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, slackUser)
+	previous, existed := ms.data.csrfTokens[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.csrfTokens[key] = previous
+		} else {
+			delete(ms.data.csrfTokens, key)
+		}
+	})
+
+	ms.data.csrfTokens[key] = copyCsrfToken(csrfToken)
+	return nil
+}
+
+// DeleteCsrfToken implements Storage
+func (ms *MemoryStorage) DeleteCsrfToken(ctx context.Context, teamID string, slackUser string) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, slackUser)
+	previous, existed := ms.data.csrfTokens[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.csrfTokens[key] = previous
+		}
+	})
+
+	delete(ms.data.csrfTokens, key)
+	return nil
+}
+
+// GetBotInfo implements Storage
+func (ms *MemoryStorage) GetBotInfo(ctx context.Context, teamID string) (botInfo BotInfo, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	botInfo, ok := ms.data.botInfos[teamID]
+	if !ok {
+		return BotInfo{}, ErrNoSuchEntity
+	}
+
+	return botInfo, nil
+}
+
+// PutBotInfo implements Storage
+func (ms *MemoryStorage) PutBotInfo(ctx context.Context, teamID string, botInfo BotInfo) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := teamID
+	previous, existed := ms.data.botInfos[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.botInfos[key] = previous
+		} else {
+			delete(ms.data.botInfos, key)
+		}
+	})
+
+	ms.data.botInfos[key] = botInfo
+	return nil
+}
+
+// GetSecret implements Storage
+func (ms *MemoryStorage) GetSecret(ctx context.Context, teamID string, name string) (secret Secret, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	secret, ok := ms.data.secrets[memoryKey(teamID, name)]
+	if !ok {
+		return Secret{}, ErrNoSuchEntity
+	}
+
+	return copySecret(secret), nil
+}
+
+// PutSecret implements Storage
+func (ms *MemoryStorage) PutSecret(ctx context.Context, teamID string, secret Secret) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, secret.Name)
+	previous, existed := ms.data.secrets[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.secrets[key] = previous
+		} else {
+			delete(ms.data.secrets, key)
+		}
+	})
+
+	ms.data.secrets[key] = copySecret(secret)
+	return nil
+}
+
+// GetWorkspaceMessages implements Storage
+func (ms *MemoryStorage) GetWorkspaceMessages(ctx context.Context, teamID string) (workspaceMessages WorkspaceMessages, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	workspaceMessages, ok := ms.data.workspaceMessages[teamID]
+	if !ok {
+		return WorkspaceMessages{}, ErrNoSuchEntity
+	}
+
+	return copyWorkspaceMessages(workspaceMessages), nil
+}
+
+// PutWorkspaceMessages implements Storage
+func (ms *MemoryStorage) PutWorkspaceMessages(ctx context.Context, workspaceMessages WorkspaceMessages) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := workspaceMessages.TeamID
+	previous, existed := ms.data.workspaceMessages[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.workspaceMessages[key] = previous
+		} else {
+			delete(ms.data.workspaceMessages, key)
+		}
+	})
+
+	ms.data.workspaceMessages[key] = copyWorkspaceMessages(workspaceMessages)
+	return nil
+}
+
+// GetWorkspaceSettings implements Storage
+func (ms *MemoryStorage) GetWorkspaceSettings(ctx context.Context, teamID string) (settings WorkspaceSettings, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	settings, ok := ms.data.settings[teamID]
+	if !ok {
+		return WorkspaceSettings{}, ErrNoSuchEntity
+	}
+
+	return copyWorkspaceSettings(settings), nil
+}
+
+// PutWorkspaceSettings implements Storage
+func (ms *MemoryStorage) PutWorkspaceSettings(ctx context.Context, settings WorkspaceSettings) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := settings.TeamID
+	previous, existed := ms.data.settings[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.settings[key] = previous
+		} else {
+			delete(ms.data.settings, key)
+		}
+	})
+
+	ms.data.settings[key] = copyWorkspaceSettings(settings)
+	return nil
+}
+
+// GetLeaderboard implements Storage
+func (ms *MemoryStorage) GetLeaderboard(ctx context.Context, teamID string, scope string, channelID string, period string, periodID string) (leaderboard Leaderboard, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	leaderboard, ok := ms.data.leaderboards[memoryKey(teamID, LeaderboardName(scope, channelID, period, periodID))]
+	if !ok {
+		return Leaderboard{}, ErrNoSuchEntity
+	}
+
+	return copyLeaderboard(leaderboard), nil
+}
+
+// PutLeaderboard implements Storage
+func (ms *MemoryStorage) PutLeaderboard(ctx context.Context, teamID string, leaderboard Leaderboard) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, LeaderboardName(leaderboard.Scope, leaderboard.ChannelID, leaderboard.Period, leaderboard.PeriodID))
+	previous, existed := ms.data.leaderboards[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.leaderboards[key] = previous
+		} else {
+			delete(ms.data.leaderboards, key)
+		}
+	})
+
+	ms.data.leaderboards[key] = copyLeaderboard(leaderboard)
+	return nil
+}
+
+// GetUserAchievements implements Storage
+func (ms *MemoryStorage) GetUserAchievements(ctx context.Context, teamID string, userID string) (achievements UserAchievements, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	achievements, ok := ms.data.achievements[memoryKey(teamID, userID)]
+	if !ok {
+		return UserAchievements{}, ErrNoSuchEntity
+	}
+
+	return copyAchievements(achievements), nil
+}
+
+// PutUserAchievements implements Storage
+func (ms *MemoryStorage) PutUserAchievements(ctx context.Context, teamID string, achievements UserAchievements) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, achievements.UserID)
+	previous, existed := ms.data.achievements[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.achievements[key] = previous
+		} else {
+			delete(ms.data.achievements, key)
+		}
+	})
+
+	ms.data.achievements[key] = copyAchievements(achievements)
+	return nil
+}
+
+// GetUserStreaks implements Storage
+func (ms *MemoryStorage) GetUserStreaks(ctx context.Context, teamID string, userID string) (streaks UserStreaks, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	streaks, ok := ms.data.streaks[memoryKey(teamID, userID)]
+	if !ok {
+		return UserStreaks{}, ErrNoSuchEntity
+	}
+
+	return streaks, nil
+}
+
+// PutUserStreaks implements Storage
+func (ms *MemoryStorage) PutUserStreaks(ctx context.Context, teamID string, streaks UserStreaks) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(teamID, streaks.UserID)
+	previous, existed := ms.data.streaks[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.streaks[key] = previous
+		} else {
+			delete(ms.data.streaks, key)
+		}
+	})
+
+	ms.data.streaks[key] = streaks
+	return nil
+}
+
+// GetDailyActivity implements Storage
+func (ms *MemoryStorage) GetDailyActivity(ctx context.Context, fitbitUser string, date string) (activity DailyActivity, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	activity, ok := ms.data.dailyActivities[memoryKey(fitbitUser, date)]
+	if !ok {
+		return DailyActivity{}, ErrNoSuchEntity
+	}
+
+	return activity, nil
+}
+
+// PutDailyActivity implements Storage
+func (ms *MemoryStorage) PutDailyActivity(ctx context.Context, activity DailyActivity) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(activity.FitbitUser, activity.Date)
+	previous, existed := ms.data.dailyActivities[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.dailyActivities[key] = previous
+		} else {
+			delete(ms.data.dailyActivities, key)
+		}
+	})
+
+	ms.data.dailyActivities[key] = activity
+	return nil
+}
+
+// GetJob implements Storage
+func (ms *MemoryStorage) GetJob(ctx context.Context, name string) (job Job, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	job, ok := ms.data.jobs[name]
+	if !ok {
+		return Job{}, ErrNoSuchEntity
+	}
+
+	return job, nil
+}
+
+// PutJob implements Storage
+func (ms *MemoryStorage) PutJob(ctx context.Context, job Job) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := job.Name
+	previous, existed := ms.data.jobs[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.jobs[key] = previous
+		} else {
+			delete(ms.data.jobs, key)
+		}
+	})
+
+	ms.data.jobs[key] = job
+	return nil
+}
+
+// DeleteJob implements Storage
+func (ms *MemoryStorage) DeleteJob(ctx context.Context, name string) (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := name
+	previous, existed := ms.data.jobs[key]
+	logUndo(ctx, func() {
+		if existed {
+			ms.data.jobs[key] = previous
+		}
+	})
+
+	delete(ms.data.jobs, key)
+	return nil
+}
+
+// ListDueJobs implements Storage
+func (ms *MemoryStorage) ListDueJobs(ctx context.Context, now time.Time, limit int) (jobs []Job, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	jobs = make([]Job, 0)
+	for _, job := range ms.data.jobs {
+		if !job.ScheduledTime.After(now) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].ScheduledTime.Equal(jobs[j].ScheduledTime) {
+			return jobs[i].ScheduledTime.Before(jobs[j].ScheduledTime)
+		}
+
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	return jobs, nil
+}
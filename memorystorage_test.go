@@ -0,0 +1,214 @@
+package stepcurry
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorageChallenges(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	_, err := storage.GetChallenge(ctx, ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-01"})
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	creationTime := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	challenges := []StepsChallenge{
+		{ChallengeID: ChallengeID{TeamID: "T1", ChannelID: "C2", Date: "2020-06-01"}, Active: true, CreationTime: creationTime},
+		{ChallengeID: ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-02"}, Active: true, CreationTime: creationTime},
+		{ChallengeID: ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-01"}, Active: false, CreationTime: creationTime},
+		{ChallengeID: ChallengeID{TeamID: "T2", ChannelID: "C1", Date: "2020-06-01"}, Active: true, CreationTime: creationTime},
+	}
+	for _, challenge := range challenges {
+		require.NoError(t, storage.PutChallenge(ctx, challenge))
+	}
+
+	active, err := storage.ListActiveChallenges(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, []StepsChallenge{challenges[1], challenges[0]}, active)
+
+	challenge, err := storage.GetChallenge(ctx, challenges[2].ChallengeID)
+	require.NoError(t, err)
+	assert.Equal(t, challenges[2], challenge)
+}
+
+func TestMemoryStorageReturnsCopies(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	challengeID := ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2020-06-01"}
+	rankedUsers := []UserSteps{{UserID: "U1", Steps: 1000}}
+	require.NoError(t, storage.PutChallenge(ctx, StepsChallenge{ChallengeID: challengeID, RankedUsers: rankedUsers}))
+
+	rankedUsers[0].Steps = 2000
+	challenge, err := storage.GetChallenge(ctx, challengeID)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, challenge.RankedUsers[0].Steps)
+
+	challenge.RankedUsers[0].Steps = 3000
+	challenge, err = storage.GetChallenge(ctx, challengeID)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, challenge.RankedUsers[0].Steps)
+}
+
+func TestMemoryStorageAccesses(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, storage.PutClientAccess(ctx, ClientAccess{SlackTeam: "T1", SlackUser: "U2", FitbitUser: "F2"}))
+	require.NoError(t, storage.PutClientAccess(ctx, ClientAccess{SlackTeam: "T1", SlackUser: "U1", FitbitUser: "F1"}))
+	require.NoError(t, storage.PutClientAccess(ctx, ClientAccess{SlackTeam: "T2", SlackUser: "U3", FitbitUser: "F3"}))
+
+	clientAccesses, err := storage.ListClientAccesses(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, []ClientAccess{{SlackTeam: "T1", SlackUser: "U1", FitbitUser: "F1"}, {SlackTeam: "T1", SlackUser: "U2", FitbitUser: "F2"}}, clientAccesses)
+
+	require.NoError(t, storage.DeleteClientAccess(ctx, "T1", "U2"))
+	_, err = storage.GetClientAccess(ctx, "T1", "U2")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	require.NoError(t, storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: "F2", Token: "t2"}))
+	require.NoError(t, storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: "F1", Token: "t1"}))
+
+	apiAccesses, err := storage.ListFitbitApiAccesses(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []FitbitApiAccess{{FitbitUser: "F1", Token: "t1"}, {FitbitUser: "F2", Token: "t2"}}, apiAccesses)
+
+	require.NoError(t, storage.PutCsrfToken(ctx, "T1", "U1", CsrfToken{Csrf: []byte("csrf")}))
+	csrfToken, err := storage.GetCsrfToken(ctx, "T1", "U1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("csrf"), csrfToken.Csrf)
+
+	require.NoError(t, storage.DeleteCsrfToken(ctx, "T1", "U1"))
+	_, err = storage.GetCsrfToken(ctx, "T1", "U1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	_, err = storage.GetSecret(ctx, "T1", "slackToken")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	require.NoError(t, storage.PutSecret(ctx, "T1", Secret{Name: "slackToken", Ciphertext: []byte("sealed")}))
+	secret, err := storage.GetSecret(ctx, "T1", "slackToken")
+	require.NoError(t, err)
+	assert.Equal(t, Secret{Name: "slackToken", Ciphertext: []byte("sealed")}, secret)
+
+	_, err = storage.GetSecret(ctx, "T2", "slackToken")
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestMemoryStorageLeaderboards(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	leaderboard := Leaderboard{Scope: scopeChannel, ChannelID: "C1", Period: periodMonth, PeriodID: "2020-06", Challenges: 1, Standings: []LeaderboardRecord{{UserID: "U1", Wins: 1}}}
+	require.NoError(t, storage.PutLeaderboard(ctx, "T1", leaderboard))
+
+	loaded, err := storage.GetLeaderboard(ctx, "T1", scopeChannel, "C1", periodMonth, "2020-06")
+	require.NoError(t, err)
+	assert.Equal(t, leaderboard, loaded)
+
+	_, err = storage.GetLeaderboard(ctx, "T1", scopeChannel, "C2", periodMonth, "2020-06")
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestMemoryStorageWorkspaceMessages(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	_, err := storage.GetWorkspaceMessages(ctx, "T1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	workspaceMessages := WorkspaceMessages{TeamID: "T1", BotName: "Coach", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "Linked!"}}}
+	require.NoError(t, storage.PutWorkspaceMessages(ctx, workspaceMessages))
+
+	loaded, err := storage.GetWorkspaceMessages(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, workspaceMessages, loaded)
+
+	// Changing the loaded templates doesn't change the stored ones
+	loaded.Templates[0].Text = "Changed"
+	loaded, err = storage.GetWorkspaceMessages(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, "Linked!", loaded.Templates[0].Text)
+}
+
+func TestMemoryStorageWorkspaceSettings(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	_, err := storage.GetWorkspaceSettings(ctx, "T1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	settings := WorkspaceSettings{TeamID: "T1", Timezone: "Europe/Paris", Metric: metricFloors}
+	require.NoError(t, storage.PutWorkspaceSettings(ctx, settings))
+
+	loaded, err := storage.GetWorkspaceSettings(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, settings, loaded)
+}
+
+func TestMemoryStorageTransactionRollback(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: "F1", Token: "before"}))
+
+	err := storage.RunInTransaction(ctx, func(tc context.Context) error {
+		require.NoError(t, storage.PutFitbitApiAccess(tc, FitbitApiAccess{FitbitUser: "F1", Token: "after"}))
+
+		return storage.RunInTransaction(tc, func(nested context.Context) error {
+			require.NoError(t, storage.PutUserStreaks(nested, "T1", UserStreaks{UserID: "U1", GoalStreak: 2}))
+			return errors.New("failed")
+		})
+	})
+	require.EqualError(t, err, "failed")
+
+	apiAccess, err := storage.GetFitbitApiAccess(ctx, "F1")
+	require.NoError(t, err)
+	assert.Equal(t, "before", apiAccess.Token)
+
+	_, err = storage.GetUserStreaks(ctx, "T1", "U1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	err = storage.RunInTransaction(ctx, func(tc context.Context) error {
+		return storage.PutFitbitApiAccess(tc, FitbitApiAccess{FitbitUser: "F1", Token: "after"})
+	})
+	require.NoError(t, err)
+
+	apiAccess, err = storage.GetFitbitApiAccess(ctx, "F1")
+	require.NoError(t, err)
+	assert.Equal(t, "after", apiAccess.Token)
+}
+
+func TestMemoryStorageTransactionRollbackKeepsOtherWrites(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, storage.PutCsrfToken(ctx, "T1", "U1", CsrfToken{Csrf: []byte("before")}))
+
+	written := make(chan struct{})
+	err := storage.RunInTransaction(ctx, func(tc context.Context) error {
+		require.NoError(t, storage.DeleteCsrfToken(tc, "T1", "U1"))
+
+		// Writes made outside of the transaction while it runs aren't rolled back with it
+		go func() {
+			storage.PutFitbitApiAccess(context.Background(), FitbitApiAccess{FitbitUser: "F1", Token: "concurrent"})
+			close(written)
+		}()
+		<-written
+
+		return errors.New("failed")
+	})
+	require.EqualError(t, err, "failed")
+
+	csrfToken, err := storage.GetCsrfToken(ctx, "T1", "U1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("before"), csrfToken.Csrf)
+
+	apiAccess, err := storage.GetFitbitApiAccess(ctx, "F1")
+	require.NoError(t, err)
+	assert.Equal(t, "concurrent", apiAccess.Token)
+}
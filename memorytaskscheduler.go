@@ -0,0 +1,200 @@
+package stepcurry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	gax "github.com/googleapis/gax-go/v2"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	memoryQueueID = "projects/local/locations/memory/queues/stepcurry"
+)
+
+// MemoryTaskScheduler is a TaskScheduler keeping tasks in memory. Tasks are dispatched to an http.Handler when
+// they're due according to a Clock rather than delivered by a queue. Like Cloud Tasks, creating a task with the name
+// of an existing one fails with codes.AlreadyExists. It's meant for tests and local development and is safe for
+// concurrent use
+type MemoryTaskScheduler struct {
+	mu      sync.Mutex
+	clock   Clock
+	pending []*taskspb.Task
+	names   map[string]bool
+	created int
+}
+
+var _ TaskScheduler = (*MemoryTaskScheduler)(nil)
+
+// NewMemoryTaskScheduler creates a new MemoryTaskScheduler telling when tasks are due with the given clock
+func NewMemoryTaskScheduler(clock Clock) (scheduler *MemoryTaskScheduler) {
+	return &MemoryTaskScheduler{clock: clock, pending: make([]*taskspb.Task, 0), names: make(map[string]bool)}
+}
+
+// Connect does nothing as there's no backend to connect to
+func (mts *MemoryTaskScheduler) Connect() (err error) {
+	return nil
+}
+
+// GenerateQueueID returns the fixed id of the in-memory queue
+func (mts *MemoryTaskScheduler) GenerateQueueID() (queueID string) {
+	return memoryQueueID
+}
+
+// CreateTask adds a task to the pending tasks. Tasks without a name get a generated one and tasks without a schedule
+// time are due immediately
+func (mts *MemoryTaskScheduler) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest, opts ...gax.CallOption) (task *taskspb.Task, err error) {
+	if req.Task.GetHttpRequest() == nil {
+		return nil, status.Error(codes.InvalidArgument, "only http tasks are supported")
+	}
+
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	task = proto.Clone(req.Task).(*taskspb.Task)
+	mts.created++
+	if task.Name == "" {
+		task.Name = fmt.Sprintf("%s/tasks/%d", req.Parent, mts.created)
+	}
+
+	if mts.names[task.Name] {
+		return nil, status.Errorf(codes.AlreadyExists, "task [%s] already exists", task.Name)
+	}
+
+	if task.ScheduleTime == nil {
+		now := mts.clock.Now()
+		task.ScheduleTime = &timestamp.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
+	}
+
+	mts.names[task.Name] = true
+	mts.pending = append(mts.pending, task)
+	sort.SliceStable(mts.pending, func(i, j int) bool {
+		return scheduleTime(mts.pending[i]).Before(scheduleTime(mts.pending[j]))
+	})
+
+	return proto.Clone(task).(*taskspb.Task), nil
+}
+
+// Pending returns the tasks not dispatched yet, ordered by schedule time
+func (mts *MemoryTaskScheduler) Pending() (tasks []*taskspb.Task) {
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	tasks = make([]*taskspb.Task, 0, len(mts.pending))
+	for _, task := range mts.pending {
+		tasks = append(tasks, proto.Clone(task).(*taskspb.Task))
+	}
+
+	return tasks
+}
+
+// NextScheduleTime returns the schedule time of the next pending task. ok is false if no task is pending
+func (mts *MemoryTaskScheduler) NextScheduleTime() (next time.Time, ok bool) {
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	if len(mts.pending) == 0 {
+		return time.Time{}, false
+	}
+
+	return scheduleTime(mts.pending[0]), true
+}
+
+// RunDue dispatches all tasks due at the clock's current time to handler, in schedule order. Tasks created while
+// dispatching are also dispatched if they're due. A task failing with a non-2xx status is put back in the pending
+// tasks and stops the run with an error
+func (mts *MemoryTaskScheduler) RunDue(handler http.Handler) (dispatched int, err error) {
+	for {
+		task, ok := mts.popDue()
+		if !ok {
+			return dispatched, nil
+		}
+
+		err = dispatchTask(handler, task)
+		if err != nil {
+			mts.mu.Lock()
+			mts.pending = append([]*taskspb.Task{task}, mts.pending...)
+			mts.mu.Unlock()
+
+			return dispatched, err
+		}
+
+		dispatched++
+	}
+}
+
+// popDue removes and returns the next pending task if it's due
+func (mts *MemoryTaskScheduler) popDue() (task *taskspb.Task, ok bool) {
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	if len(mts.pending) == 0 || scheduleTime(mts.pending[0]).After(mts.clock.Now()) {
+		return nil, false
+	}
+
+	task = mts.pending[0]
+	mts.pending = mts.pending[1:]
+
+	return task, true
+}
+
+// dispatchTask serves the http request of a task with handler
+func dispatchTask(handler http.Handler, task *taskspb.Task) (err error) {
+	httpRequest := task.GetHttpRequest()
+	r, err := http.NewRequest(httpRequest.HttpMethod.String(), httpRequest.Url, bytes.NewReader(httpRequest.Body))
+	if err != nil {
+		return fmt.Errorf("error creating request for task [%s]: %s", task.Name, err.Error())
+	}
+
+	for name, value := range httpRequest.Headers {
+		r.Header.Set(name, value)
+	}
+
+	w := &taskResponseRecorder{header: make(http.Header), code: http.StatusOK}
+	handler.ServeHTTP(w, r)
+
+	if w.code < 200 || w.code > 299 {
+		return fmt.Errorf("task [%s] failed with status [%d]: %s", task.Name, w.code, w.body.String())
+	}
+
+	return nil
+}
+
+// taskResponseRecorder is a minimal http.ResponseWriter capturing the response to a dispatched task
+type taskResponseRecorder struct {
+	header      http.Header
+	code        int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rr *taskResponseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *taskResponseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.body.Write(b)
+}
+
+func (rr *taskResponseRecorder) WriteHeader(code int) {
+	if rr.wroteHeader {
+		return
+	}
+
+	rr.wroteHeader = true
+	rr.code = code
+}
+
+// scheduleTime returns the schedule time of a task
+func scheduleTime(task *taskspb.Task) time.Time {
+	return time.Unix(task.ScheduleTime.GetSeconds(), int64(task.ScheduleTime.GetNanos()))
+}
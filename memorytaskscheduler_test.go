@@ -0,0 +1,113 @@
+package stepcurry
+
+import (
+	"context"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newMemoryTestTask(name string, path string, body string, scheduledTime time.Time) *taskspb.CreateTaskRequest {
+	return &taskspb.CreateTaskRequest{
+		Parent: memoryQueueID,
+		Task: &taskspb.Task{
+			Name: name,
+			PayloadType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{HttpMethod: taskspb.HttpMethod_POST, Url: "https://stepcurry.example.com" + path, Body: []byte(body)},
+			},
+			ScheduleTime: &timestamp.Timestamp{Seconds: scheduledTime.Unix()},
+		},
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour), clock.Now())
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
+
+func TestMemoryTaskSchedulerRunDue(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	scheduler := NewMemoryTaskScheduler(clock)
+	ctx := context.Background()
+
+	_, err := scheduler.CreateTask(ctx, newMemoryTestTask("", "/later", "b", start.Add(time.Hour)))
+	require.NoError(t, err)
+	task, err := scheduler.CreateTask(ctx, newMemoryTestTask("", "/now", "a", start))
+	require.NoError(t, err)
+	assert.Equal(t, memoryQueueID+"/tasks/2", task.Name)
+
+	next, ok := scheduler.NextScheduleTime()
+	require.True(t, ok)
+	assert.Equal(t, start.Unix(), next.Unix())
+
+	received := make([]string, 0)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.Method+" "+r.URL.Path+" "+string(body))
+
+		// Tasks created while dispatching run in the same pass when they're due
+		if r.URL.Path == "/now" {
+			_, err := scheduler.CreateTask(ctx, newMemoryTestTask("", "/chained", "c", clock.Now()))
+			require.NoError(t, err)
+		}
+	})
+
+	dispatched, err := scheduler.RunDue(handler)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+	assert.Equal(t, []string{"POST /now a", "POST /chained c"}, received)
+	require.Len(t, scheduler.Pending(), 1)
+
+	clock.Advance(time.Hour)
+	dispatched, err = scheduler.RunDue(handler)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+	assert.Equal(t, "POST /later b", received[2])
+
+	_, ok = scheduler.NextScheduleTime()
+	assert.False(t, ok)
+}
+
+func TestMemoryTaskSchedulerDuplicateName(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	scheduler := NewMemoryTaskScheduler(NewFakeClock(start))
+	ctx := context.Background()
+
+	_, err := scheduler.CreateTask(ctx, newMemoryTestTask(memoryQueueID+"/tasks/sweep", "/sweep", "", start))
+	require.NoError(t, err)
+
+	_, err = scheduler.CreateTask(ctx, newMemoryTestTask(memoryQueueID+"/tasks/sweep", "/sweep", "", start))
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Len(t, scheduler.Pending(), 1)
+}
+
+func TestMemoryTaskSchedulerFailedTaskStaysPending(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	scheduler := NewMemoryTaskScheduler(NewFakeClock(start))
+
+	_, err := scheduler.CreateTask(context.Background(), newMemoryTestTask("", "/fail", "", start))
+	require.NoError(t, err)
+
+	dispatched, err := scheduler.RunDue(Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return newHttpError(assert.AnError, "failing", http.StatusInternalServerError)
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed with status [500]")
+	assert.Equal(t, 0, dispatched)
+	assert.Len(t, scheduler.Pending(), 1)
+}
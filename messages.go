@@ -0,0 +1,483 @@
+package stepcurry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Message template names. These are the names under which a workspace can override a message
+const (
+	msgLinkPrompt             = "linkPrompt"
+	msgLinkSuccess            = "linkSuccess"
+	msgBrokenLink             = "brokenLink"
+	msgChallengeStarted       = "challengeStarted"
+	msgChallengeAlreadyActive = "challengeAlreadyActive"
+	msgNotAMember             = "notAMember"
+	msgNoActiveChallenge      = "noActiveChallenge"
+	msgUpdateBanner           = "updateBanner"
+	msgWinnerBanner           = "winnerBanner"
+	msgRanking                = "ranking"
+	msgStreaks                = "streaks"
+	msgUnsyncedNote           = "unsyncedNote"
+	msgBadgeAwards            = "badgeAwards"
+	msgBadgesUsage            = "badgesUsage"
+	msgNoBadges               = "noBadges"
+	msgBadgeCollection        = "badgeCollection"
+	msgBadge                  = "badge"
+	msgFirst20kDay            = "badgeFirst20kDay"
+	msgFirst20kDayDesc        = "badgeFirst20kDayDescription"
+	msgWinStreak              = "badgeWinStreak"
+	msgWinStreakDesc          = "badgeWinStreakDescription"
+	msgPerfectMonth           = "badgePerfectMonth"
+	msgPerfectMonthDesc       = "badgePerfectMonthDescription"
+	msgPersonalBest           = "badgePersonalBest"
+	msgPersonalBestDesc       = "badgePersonalBestDescription"
+	msgLeaderboardUsage       = "leaderboardUsage"
+	msgNoLeaderboardResults   = "noLeaderboardResults"
+	msgLeaderboardBanner      = "leaderboardBanner"
+	msgLeaderboardRanking     = "leaderboardRanking"
+	msgStreakReminder         = "streakReminder"
+	msgRemindersUsage         = "remindersUsage"
+	msgRemindersOn            = "remindersOn"
+	msgRemindersOff           = "remindersOff"
+	msgNotLinked              = "notLinked"
+	msgStatsSummary           = "statsSummary"
+	msgStatsHeader            = "statsHeader"
+	msgGoalProgress           = "goalProgress"
+	msgNoChallengeRanks       = "noChallengeRanks"
+	msgChallengeRank          = "challengeRank"
+	msgTrend                  = "trend"
+	msgLinkHealthy            = "linkHealthy"
+	msgLinkUnhealthy          = "linkUnhealthy"
+	msgConfigAdminOnly        = "configAdminOnly"
+	msgChallengeNotAllowed    = "challengeNotAllowed"
+	msgOptInStarted           = "optInStarted"
+	msgJoinChallenge          = "joinChallenge"
+	msgChallengeJoined        = "challengeJoined"
+	msgFloorsRanking          = "floorsRanking"
+	msgChannelRestricted      = "channelRestricted"
+	msgDailyCapReached        = "dailyCapReached"
+	msgRestrictionSaved       = "restrictionSaved"
+	msgRestrictionRemoved     = "restrictionRemoved"
+	msgConfigUsage            = "configUsage"
+	msgHomeNotLinked          = "homeNotLinked"
+	msgHomeLink               = "homeLink"
+	msgHomeUnlink             = "homeUnlink"
+	msgHomeWins               = "homeWins"
+	msgHomeBadges             = "homeBadges"
+	msgHomeNoBadges           = "homeNoBadges"
+	msgHomeAdmin              = "homeAdmin"
+	msgHomeSettings           = "homeSettings"
+)
+
+// defaultMessageTemplates holds the text/template definitions of the messages sent by Step Curry when a workspace
+// doesn't override them. The fields available to each template are the ones referenced by its default definition
+var defaultMessageTemplates = map[string]string{
+	msgLinkPrompt: "<{{.AuthorizeURL}}|Head over> to Fitbit to login and authorize access to your account.\n\n" +
+		"If you consent, _Step Curry_ will use this to get your daily activity summary that will be shared in steps challenges you participate in. " +
+		"Note that you'll automatically be included in a steps challenge if you link your Fitbit account and are a " +
+		"member of a channel where a steps challenge is active.",
+	msgLinkSuccess:            "POW :boom: You've got your Fitbit account linked and ready for some challenges :wind_blowing_face::athletic_shoe:",
+	msgBrokenLink:             ":broken_heart: I lost access to your Fitbit account so you're missing from steps challenges. Use `{{.LinkCommand}}` to link it again and get back in the race :athletic_shoe:",
+	msgChallengeStarted:       "<@{{.UserID}}> started a steps challenge! Get moving :wind_blowing_face::athletic_shoe:. If you haven't linked your fitbit account already, type `{{.LinkCommand}}` and join in on the challenge.",
+	msgChallengeAlreadyActive: ":warning: There's already an active steps challenge so you know ¯\\_(ツ)_/¯",
+	msgNotAMember:             "I can't start a challenge in a channel or conversation I'm not a member of. Add me, <@{{.BotUserID}}> and try again :bow:",
+	msgNoActiveChallenge:      ":warning: There's no active challenge in this channel to report status on. Create one by using `{{.ChallengeCommand}}`",
+	msgUpdateBanner: `{{pick ":rolled_up_newspaper: _Breaking news_, here are the current steps ranking"` +
+		` ":loudspeaker: Oh snap, look who's winning the race!"` +
+		` ":wind_blowing_face::athletic_shoe: _The more you take, the more you leave behind_...here's the latest steps count update"` +
+		` ":thinking_face: All truly great thoughts are conceived while walking (and you also get to stay competitive in this challenge)"` +
+		` ":fairy: Walking is a great adventure...and if you do it enough, you might get the top spot in this list"}}`,
+	msgWinnerBanner:         ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:",
+	msgRanking:              "_{{.Name}}_ `{{number .Steps}}` :athletic_shoe:{{if eq .Rank 1}} :tornado::rocket:{{end}}{{.Streaks}}",
+	msgStreaks:              "{{if .ParticipationStreak}} :fire: {{.ParticipationStreak}}{{end}}{{if .GoalStreak}} :dart: {{.GoalStreak}}{{end}}",
+	msgUnsyncedNote:         ":warning: {{.Count}} {{if eq .Count 1}}participant{{else}}participants{{end}} couldn't be synced",
+	msgBadgeAwards:          ":sports_medal: *New badges unlocked*{{range .Awards}}\n{{.Badge.Emoji}} <@{{.UserID}}> earned *{{.Badge.Name}}*{{end}}",
+	msgBadgesUsage:          ":warning: I didn't get that. Try `{{.Command}}` or `{{.Command}} @someone`",
+	msgNoBadges:             "<@{{.UserID}}> hasn't earned any badges yet. Keep on walking :athletic_shoe:",
+	msgBadgeCollection:      ":sports_medal: Badge collection of <@{{.UserID}}>",
+	msgBadge:                "{{.Emoji}} *{{.Name}}*{{if gt .Count 1}} x{{.Count}}{{end}} _{{.Description}}_ (first earned on {{.FirstAwarded}})",
+	msgFirst20kDay:          "First 20k day",
+	msgFirst20kDayDesc:      "Walked 20,000 steps in a challenge",
+	msgWinStreak:            "5-day win streak",
+	msgWinStreakDesc:        "Won challenges 5 days in a row",
+	msgPerfectMonth:         "Perfect month",
+	msgPerfectMonthDesc:     "Took part in every challenge of the month in a channel",
+	msgPersonalBest:         "Personal best",
+	msgPersonalBestDesc:     "Beat your personal best step count",
+	msgLeaderboardUsage:     ":warning: I didn't get that. Try `{{.Usage}}`",
+	msgNoLeaderboardResults: ":warning: No challenge results recorded for this {{.Scope}} leaderboard yet. Start a challenge with `{{.ChallengeCommand}}` to get on the board",
+	msgLeaderboardBanner:    ":trophy: *{{title .Scope}} leaderboard* ({{if .AllTime}}all time{{else}}{{.Period}} {{.PeriodID}}{{end}}, {{.Challenges}} challenges)",
+	msgLeaderboardRanking:   "*{{.Rank}}.* _{{.Name}}_ :trophy: {{.Wins}} :medal: {{.Podiums}} `{{number .TotalSteps}}` :athletic_shoe: in {{.Challenges}} challenges",
+	msgStreakReminder:       ":fire: Your {{.GoalStreak}}-day goal streak is at risk! You're at `{{number .Steps}}` of your `{{number .Goal}}` steps goal today, there's still time for a walk :athletic_shoe:\n\n_Use `{{.OffCommand}}` to stop these reminders._",
+	msgRemindersUsage:       ":warning: I didn't get that. Try `{{.OnCommand}}` or `{{.OffCommand}}`",
+	msgRemindersOn:          ":bell: Got it! I'll send you a reminder around {{.Hour}}:00 when your goal streak is at risk",
+	msgRemindersOff:         ":no_bell: Got it, no more streak reminders",
+	msgNotLinked:            ":link: You haven't linked your Fitbit account yet. Use `{{.LinkCommand}}` to get started",
+	msgStatsSummary:         ":bar_chart: Your Step Curry stats",
+	msgStatsHeader:          ":bar_chart: *Your Step Curry stats*",
+	msgGoalProgress:         ":athletic_shoe: `{{number .Steps}}` steps today{{if .Goal}}, `{{.Percent}}%` of your `{{number .Goal}}` steps goal{{if ge .Steps .Goal}} :dart:{{end}}{{else}} (no daily steps goal set on Fitbit){{end}}",
+	msgNoChallengeRanks:     ":checkered_flag: You're not part of any active challenge. Start one with `{{.ChallengeCommand}}`",
+	msgChallengeRank:        ":checkered_flag: #{{.Rank}} of {{.Participants}} in <#{{.ChannelID}}> with `{{number .Steps}}` steps",
+	msgTrend:                ":chart_with_upwards_trend: Last {{.Days}} days `{{.Sparkline}}` averaging `{{number .AverageSteps}}` steps, goal hit on {{.GoalHits}} of them",
+	msgLinkHealthy:          ":white_check_mark: Your Fitbit account is linked and syncing",
+	msgLinkUnhealthy:        ":warning: I couldn't get your latest data from Fitbit. Try linking your account again with `{{.LinkCommand}}`",
+	msgConfigAdminOnly:      ":lock: Only workspace admins can change the Step Curry settings with `{{.Command}}`",
+	msgChallengeNotAllowed:  ":lock: Only workspace admins can start challenges in this workspace",
+	msgOptInStarted:         "<@{{.UserID}}> started a steps challenge! Hit *Join* to take part :wind_blowing_face::athletic_shoe:. If you haven't linked your fitbit account already, type `{{.LinkCommand}}` too.",
+	msgJoinChallenge:        "Join",
+	msgChallengeJoined:      ":athletic_shoe: You're in! Get moving{{if not .Linked}} and link your Fitbit account with `{{.LinkCommand}}` so your steps count{{end}}",
+	msgFloorsRanking:        "_{{.Name}}_ `{{number .Floors}}` :mountain:{{if eq .Rank 1}} :tornado::rocket:{{end}}{{.Streaks}}",
+	msgChannelRestricted:    ":lock: Only {{if .UserGroups}}members of {{.UserGroups}} and {{end}}workspace admins can start challenges in this channel",
+	msgDailyCapReached:      ":hourglass: You've already started {{.Count}} challenges today, that's the daily limit of this workspace. Try again tomorrow :bow:",
+	msgRestrictionSaved:     ":lock: From now on, only {{if .UserGroups}}members of {{.UserGroups}} and {{end}}workspace admins can start challenges in this channel",
+	msgRestrictionRemoved:   ":unlock: Anyone allowed by the workspace settings can start challenges in this channel again",
+	msgConfigUsage:          "Use `{{.Command}}` to change the workspace settings, `{{.Command}} restrict admins` or `{{.Command}} restrict @group...` to restrict who can start challenges in this channel and `{{.Command}} unrestrict` to lift the restriction",
+	msgHomeNotLinked:        ":link: Link your Fitbit account to take part in steps challenges",
+	msgHomeLink:             "Link Fitbit",
+	msgHomeUnlink:           "Unlink Fitbit",
+	msgHomeWins:             ":trophy: {{if .Wins}}{{.Wins}} challenge wins this month{{else}}No challenge wins yet this month{{end}}{{if .LastWinDate}}, last one on {{.LastWinDate}}{{end}}",
+	msgHomeBadges:           ":sports_medal: *Your badges*",
+	msgHomeNoBadges:         ":sports_medal: You haven't earned any badges yet. Keep on walking :athletic_shoe:",
+	msgHomeAdmin:            ":gear: *Workspace admin*",
+	msgHomeSettings:         "Workspace settings",
+}
+
+// Languages of the message catalogs
+const (
+	languageEnglish  = "en"
+	languageFrench   = "fr"
+	languageJapanese = "ja"
+)
+
+// messageCatalogs holds the definitions of the message templates by language. Messages missing from a catalog fall
+// back to their english definition
+var messageCatalogs = map[string]map[string]string{
+	languageEnglish:  defaultMessageTemplates,
+	languageFrench:   frenchMessageTemplates,
+	languageJapanese: japaneseMessageTemplates,
+}
+
+// numberGroupSeparators holds the separator of digit groups in numbers rendered in each language. English numbers
+// aren't grouped
+var numberGroupSeparators = map[string]string{
+	languageEnglish:  "",
+	languageFrench:   " ",
+	languageJapanese: ",",
+}
+
+// messageFuncs holds the functions available to message templates
+var messageFuncs = template.FuncMap{
+	// pick returns one of its arguments at random and is meant for messages with variations like banners
+	"pick": func(choices ...string) string {
+		if len(choices) == 0 {
+			return ""
+		}
+
+		return choices[selectionRandom.Intn(len(choices))]
+	},
+	"title": strings.Title,
+}
+
+// defaultCatalogs holds the parsed message templates of each language
+var defaultCatalogs = mustParseMessageCatalogs()
+
+// messageData holds the values referenced by a message template
+type messageData map[string]interface{}
+
+// MessageTemplate holds a workspace's definition of one of the message templates. A template without a language
+// applies to all languages
+type MessageTemplate struct {
+	Name     string `datastore:"name,noindex"`
+	Language string `datastore:"language,noindex"`
+	Text     string `datastore:"text,noindex"`
+}
+
+// WorkspaceMessages holds a workspace's customizations of the messages Step Curry sends. The bot name and icon
+// emoji replace the app's default ones on posted messages (this requires the chat:write.customize scope) and
+// templates replace the default definition of messages with the same name. Messages are sent in the default
+// language unless a user's slack locale has a matching catalog
+type WorkspaceMessages struct {
+	TeamID          string            `datastore:"teamID"`
+	DefaultLanguage string            `datastore:"defaultLanguage,noindex"`
+	BotName         string            `datastore:"botName,noindex"`
+	BotIconEmoji    string            `datastore:"botIconEmoji,noindex"`
+	Templates       []MessageTemplate `datastore:"templates,noindex"`
+}
+
+// Messages renders the messages of a workspace in a language and holds the persona the bot posts them as
+type Messages struct {
+	catalogs     map[string]*template.Template
+	language     string
+	botName      string
+	botIconEmoji string
+	logger       Logger
+}
+
+// DefaultMessages returns the english messages of a workspace without customizations
+func DefaultMessages() (messages *Messages) {
+	return &Messages{catalogs: defaultCatalogs, language: languageEnglish}
+}
+
+// NewMessages returns the messages of a workspace with its customizations applied on top of the defaults. An error
+// is returned if the default language or the language of a template isn't supported, if a template has a name that
+// isn't one of the default messages or if it doesn't parse
+func NewMessages(workspaceMessages WorkspaceMessages) (messages *Messages, err error) {
+	messages = &Messages{catalogs: defaultCatalogs, language: languageEnglish, botName: workspaceMessages.BotName, botIconEmoji: workspaceMessages.BotIconEmoji}
+	if workspaceMessages.DefaultLanguage != "" {
+		if _, ok := defaultCatalogs[workspaceMessages.DefaultLanguage]; !ok {
+			return nil, unsupportedLanguageError(workspaceMessages.DefaultLanguage)
+		}
+
+		messages.language = workspaceMessages.DefaultLanguage
+	}
+
+	if len(workspaceMessages.Templates) == 0 {
+		return messages, nil
+	}
+
+	for _, mt := range workspaceMessages.Templates {
+		if _, ok := defaultMessageTemplates[mt.Name]; !ok {
+			return nil, fmt.Errorf("unknown message template [%s], should be one of %s", mt.Name, strings.Join(MessageTemplateNames(), ", "))
+		}
+
+		if _, ok := defaultCatalogs[mt.Language]; mt.Language != "" && !ok {
+			return nil, unsupportedLanguageError(mt.Language)
+		}
+	}
+
+	messages.catalogs = make(map[string]*template.Template)
+	for language, templates := range defaultCatalogs {
+		messages.catalogs[language], err = templates.Clone()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error cloning default message templates for language [%s]", language)
+		}
+
+		// Templates for all languages are applied first so that the ones for a specific language take precedence
+		for _, templateLanguage := range []string{"", language} {
+			for _, mt := range workspaceMessages.Templates {
+				if mt.Language != templateLanguage {
+					continue
+				}
+
+				_, err = messages.catalogs[language].New(mt.Name).Parse(mt.Text)
+				if err != nil {
+					return nil, errors.Wrapf(err, "error parsing message template [%s] of team [%s]", mt.Name, workspaceMessages.TeamID)
+				}
+			}
+		}
+	}
+
+	return messages, nil
+}
+
+func unsupportedLanguageError(language string) error {
+	return fmt.Errorf("unsupported language [%s], should be one of %s", language, strings.Join(Languages(), ", "))
+}
+
+// Languages returns the sorted languages messages can be sent in
+func Languages() (languages []string) {
+	languages = make([]string, 0, len(messageCatalogs))
+	for language := range messageCatalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// forLocale returns the messages in the language of a slack locale such as fr-CA. The messages are returned unchanged
+// if there's no catalog for the locale's language
+func (m *Messages) forLocale(locale string) *Messages {
+	if m == nil {
+		m = DefaultMessages()
+	}
+
+	language := strings.ToLower(locale)
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+
+	if _, ok := m.catalogs[language]; !ok {
+		return m
+	}
+
+	localized := *m
+	localized.language = language
+	return &localized
+}
+
+// userMessages returns the messages of a team in the language of a user's slack locale. The workspace's default
+// language is used if the user's info can't be fetched
+func (svcs TeamServices) userMessages(userID string) *Messages {
+	userInfo, err := svcs.userInfoFinder.GetUserInfo(userID)
+	if err != nil {
+		newLogEntry(svcs.logger).with(Fields{teamIDField: svcs.settings.TeamID, userIDField: userID}).withError(err).warningf("Error getting locale of user, using the workspace's default language")
+		return svcs.messages.withLogger(svcs.logger)
+	}
+
+	return svcs.messages.forLocale(userInfo.Locale).withLogger(svcs.logger)
+}
+
+// withLogger returns a copy of the messages logging rendering errors to logger
+func (m *Messages) withLogger(logger Logger) *Messages {
+	if m == nil {
+		m = DefaultMessages()
+	}
+
+	withLogger := *m
+	withLogger.logger = logger
+	return &withLogger
+}
+
+// loadMessages loads the message customizations of a team. Customizations that fail to parse are ignored in favor
+// of the defaults so that a bad template doesn't keep the bot from talking to a workspace
+func loadMessages(storage Storage, teamID string, logger Logger) (messages *Messages, err error) {
+	workspaceMessages, err := storage.GetWorkspaceMessages(context.Background(), teamID)
+	if err == ErrNoSuchEntity {
+		return DefaultMessages(), nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error loading message customizations for team [%s]", teamID)
+	}
+
+	messages, err = NewMessages(workspaceMessages)
+	if err != nil {
+		newLogEntry(logger).with(Fields{teamIDField: teamID}).withError(err).warningf("Error applying message customizations, using the defaults")
+		return DefaultMessages(), nil
+	}
+
+	return messages, nil
+}
+
+// SaveWorkspaceMessages validates and persists the message customizations of a workspace. The team's routed services
+// are invalidated so that the new messages apply to the next request
+func (sc *StepCurry) SaveWorkspaceMessages(ctx context.Context, workspaceMessages WorkspaceMessages) (err error) {
+	_, err = NewMessages(workspaceMessages)
+	if err != nil {
+		return err
+	}
+
+	err = sc.storage.PutWorkspaceMessages(ctx, workspaceMessages)
+	if err != nil {
+		return err
+	}
+
+	sc.Invalidate(workspaceMessages.TeamID)
+	return nil
+}
+
+// MessageTemplateNames returns the sorted names of the message templates a workspace can override
+func MessageTemplateNames() (names []string) {
+	names = make([]string, 0, len(defaultMessageTemplates))
+	for name := range defaultMessageTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DefaultMessageTemplate returns the default definition of a message template
+func DefaultMessageTemplate(name string) (text string, ok bool) {
+	text, ok = defaultMessageTemplates[name]
+	return text, ok
+}
+
+func mustParseMessageCatalogs() (catalogs map[string]*template.Template) {
+	catalogs = make(map[string]*template.Template)
+	for language, definitions := range messageCatalogs {
+		separator := numberGroupSeparators[language]
+
+		// Referencing a value that isn't given to a message is an error so that a template with a typo falls back
+		// to the default instead of rendering as <no value>
+		templates := template.New("").Funcs(messageFuncs).Funcs(template.FuncMap{"number": func(n int) string { return formatNumber(n, separator) }}).Option("missingkey=error")
+		for _, catalog := range []map[string]string{defaultMessageTemplates, definitions} {
+			for name, text := range catalog {
+				template.Must(templates.New(name).Parse(text))
+			}
+		}
+
+		catalogs[language] = templates
+	}
+
+	return catalogs
+}
+
+// formatNumber formats an integer with its digits grouped by thousands with a separator
+func formatNumber(n int, separator string) string {
+	digits := strconv.Itoa(n)
+	if separator == "" {
+		return digits
+	}
+
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteString(separator)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	return sign + grouped.String()
+}
+
+// render renders a message. If a workspace's template fails to render, the message falls back to its default
+// definition so that a bad customization doesn't keep the message from being sent
+func (m *Messages) render(name string, data messageData) (text string) {
+	if m == nil {
+		m = DefaultMessages()
+	}
+
+	text, err := executeMessageTemplate(m.catalogs[m.language], name, data)
+	if err == nil {
+		return text
+	}
+
+	newLogEntry(m.logger).withError(err).warningf("Error rendering message template [%s] in [%s], falling back to the default", name, m.language)
+	text, err = executeMessageTemplate(defaultCatalogs[m.language], name, data)
+	if err != nil {
+		newLogEntry(m.logger).withError(err).errorf("Error rendering default message template [%s] in [%s]", name, m.language)
+	}
+
+	return text
+}
+
+func executeMessageTemplate(templates *template.Template, name string, data messageData) (text string, err error) {
+	var buf bytes.Buffer
+	err = templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// postOptions returns the options to post a message as the workspace's bot persona, followed by the given options
+func (m *Messages) postOptions(options ...slack.MsgOption) []slack.MsgOption {
+	personaOptions := make([]slack.MsgOption, 0, len(options)+2)
+	if m == nil {
+		return append(personaOptions, options...)
+	}
+
+	if m.botName != "" {
+		personaOptions = append(personaOptions, slack.MsgOptionUsername(m.botName))
+	}
+
+	if m.botIconEmoji != "" {
+		personaOptions = append(personaOptions, slack.MsgOptionIconEmoji(m.botIconEmoji))
+	}
+
+	return append(personaOptions, options...)
+}
@@ -0,0 +1,71 @@
+package stepcurry
+
+// frenchMessageTemplates holds the french translations of the message templates
+var frenchMessageTemplates = map[string]string{
+	msgLinkPrompt: "<{{.AuthorizeURL}}|Rendez-vous> sur Fitbit pour vous connecter et autoriser l'accès à votre compte.\n\n" +
+		"Si vous y consentez, _Step Curry_ s'en servira pour obtenir le résumé de votre activité quotidienne qui sera partagé dans les défis de pas auxquels vous participez. " +
+		"Notez que vous serez automatiquement inclus dans un défi de pas si vous liez votre compte Fitbit et êtes " +
+		"membre d'un canal où un défi de pas est en cours.",
+	msgLinkSuccess:            "POW :boom: Votre compte Fitbit est lié et prêt pour les défis :wind_blowing_face::athletic_shoe:",
+	msgBrokenLink:             ":broken_heart: J'ai perdu l'accès à votre compte Fitbit, vous ne figurez donc plus dans les défis de pas. Utilisez `{{.LinkCommand}}` pour le lier de nouveau et revenir dans la course :athletic_shoe:",
+	msgChallengeStarted:       "<@{{.UserID}}> a lancé un défi de pas! Bougez-vous :wind_blowing_face::athletic_shoe:. Si vous n'avez pas encore lié votre compte Fitbit, tapez `{{.LinkCommand}}` et joignez-vous au défi.",
+	msgChallengeAlreadyActive: ":warning: Il y a déjà un défi de pas en cours, juste pour que vous le sachiez ¯\\_(ツ)_/¯",
+	msgNotAMember:             "Je ne peux pas lancer de défi dans un canal ou une conversation dont je ne suis pas membre. Ajoutez-moi, <@{{.BotUserID}}>, et réessayez :bow:",
+	msgNoActiveChallenge:      ":warning: Il n'y a aucun défi en cours dans ce canal. Lancez-en un avec `{{.ChallengeCommand}}`",
+	msgUpdateBanner: `{{pick ":rolled_up_newspaper: _Dernière heure_, voici le classement actuel des pas"` +
+		` ":loudspeaker: Oh là là, regardez qui mène la course!"` +
+		` ":wind_blowing_face::athletic_shoe: _Plus on en fait, plus on en laisse derrière soi_... voici le dernier décompte des pas"` +
+		` ":thinking_face: Toutes les grandes pensées naissent en marchant (et en plus, vous restez dans la course)"` +
+		` ":fairy: La marche est une grande aventure... et avec assez de pas, vous pourriez prendre la tête de cette liste"}}`,
+	msgWinnerBanner:         ":rolled_up_newspaper: Nous avons un gagnant pour le défi de pas d'hier! :tada:",
+	msgUnsyncedNote:         ":warning: {{.Count}} {{if eq .Count 1}}participant n'a pas pu être synchronisé{{else}}participants n'ont pas pu être synchronisés{{end}}",
+	msgBadgeAwards:          ":sports_medal: *Nouveaux badges débloqués*{{range .Awards}}\n{{.Badge.Emoji}} <@{{.UserID}}> a obtenu *{{.Badge.Name}}*{{end}}",
+	msgBadgesUsage:          ":warning: Je n'ai pas compris. Essayez `{{.Command}}` ou `{{.Command}} @quelqu'un`",
+	msgNoBadges:             "<@{{.UserID}}> n'a encore obtenu aucun badge. Continuez de marcher :athletic_shoe:",
+	msgBadgeCollection:      ":sports_medal: Collection de badges de <@{{.UserID}}>",
+	msgBadge:                "{{.Emoji}} *{{.Name}}*{{if gt .Count 1}} x{{.Count}}{{end}} _{{.Description}}_ (obtenu pour la première fois le {{.FirstAwarded}})",
+	msgFirst20kDay:          "Première journée à 20 000 pas",
+	msgFirst20kDayDesc:      "20 000 pas parcourus lors d'un défi",
+	msgWinStreak:            "5 victoires d'affilée",
+	msgWinStreakDesc:        "Défis gagnés 5 jours de suite",
+	msgPerfectMonth:         "Mois parfait",
+	msgPerfectMonthDesc:     "Participation à tous les défis du mois dans un canal",
+	msgPersonalBest:         "Record personnel",
+	msgPersonalBestDesc:     "Vous avez battu votre record personnel de pas",
+	msgLeaderboardUsage:     ":warning: Je n'ai pas compris. Essayez `{{.Usage}}`",
+	msgNoLeaderboardResults: ":warning: Aucun résultat de défi n'a encore été enregistré pour le classement {{if eq .Scope \"channel\"}}du canal{{else}}de l'espace de travail{{end}}. Lancez un défi avec `{{.ChallengeCommand}}` pour y figurer",
+	msgLeaderboardBanner:    ":trophy: *Classement {{if eq .Scope \"channel\"}}du canal{{else}}de l'espace de travail{{end}}* ({{if .AllTime}}depuis toujours{{else}}{{if eq .Period \"month\"}}mois{{else}}saison{{end}} {{.PeriodID}}{{end}}, {{.Challenges}} défis)",
+	msgLeaderboardRanking:   "*{{.Rank}}.* _{{.Name}}_ :trophy: {{.Wins}} :medal: {{.Podiums}} `{{number .TotalSteps}}` :athletic_shoe: en {{.Challenges}} défis",
+	msgStreakReminder:       ":fire: Votre série de {{.GoalStreak}} jours d'objectif atteint est menacée! Vous êtes à `{{number .Steps}}` pas sur votre objectif de `{{number .Goal}}` aujourd'hui, il est encore temps d'aller marcher :athletic_shoe:\n\n_Utilisez `{{.OffCommand}}` pour ne plus recevoir ces rappels._",
+	msgRemindersUsage:       ":warning: Je n'ai pas compris. Essayez `{{.OnCommand}}` ou `{{.OffCommand}}`",
+	msgRemindersOn:          ":bell: C'est noté! Je vous enverrai un rappel vers {{.Hour}} h quand votre série d'objectifs sera menacée",
+	msgRemindersOff:         ":no_bell: C'est noté, plus de rappels de série",
+	msgNotLinked:            ":link: Vous n'avez pas encore lié votre compte Fitbit. Utilisez `{{.LinkCommand}}` pour commencer",
+	msgStatsSummary:         ":bar_chart: Vos statistiques Step Curry",
+	msgStatsHeader:          ":bar_chart: *Vos statistiques Step Curry*",
+	msgGoalProgress:         ":athletic_shoe: `{{number .Steps}}` pas aujourd'hui{{if .Goal}}, `{{.Percent}} %` de votre objectif de `{{number .Goal}}` pas{{if ge .Steps .Goal}} :dart:{{end}}{{else}} (aucun objectif de pas quotidien défini sur Fitbit){{end}}",
+	msgNoChallengeRanks:     ":checkered_flag: Vous ne participez à aucun défi en cours. Lancez-en un avec `{{.ChallengeCommand}}`",
+	msgChallengeRank:        ":checkered_flag: {{.Rank}}{{if eq .Rank 1}}er{{else}}e{{end}} sur {{.Participants}} dans <#{{.ChannelID}}> avec `{{number .Steps}}` pas",
+	msgTrend:                ":chart_with_upwards_trend: {{.Days}} derniers jours `{{.Sparkline}}` avec une moyenne de `{{number .AverageSteps}}` pas, objectif atteint {{.GoalHits}} fois",
+	msgLinkHealthy:          ":white_check_mark: Votre compte Fitbit est lié et synchronisé",
+	msgLinkUnhealthy:        ":warning: Je n'ai pas pu obtenir vos dernières données de Fitbit. Essayez de lier votre compte de nouveau avec `{{.LinkCommand}}`",
+	msgConfigAdminOnly:      ":lock: Seuls les administrateurs de l'espace de travail peuvent modifier les réglages de Step Curry avec `{{.Command}}`",
+	msgChallengeNotAllowed:  ":lock: Seuls les administrateurs peuvent lancer des défis dans cet espace de travail",
+	msgOptInStarted:         "<@{{.UserID}}> a lancé un défi de pas! Cliquez sur *Participer* pour en faire partie :wind_blowing_face::athletic_shoe:. Si vous n'avez pas encore lié votre compte Fitbit, tapez aussi `{{.LinkCommand}}`.",
+	msgJoinChallenge:        "Participer",
+	msgChallengeJoined:      ":athletic_shoe: Vous êtes dans la course! Bougez-vous{{if not .Linked}} et liez votre compte Fitbit avec `{{.LinkCommand}}` pour que vos pas comptent{{end}}",
+	msgFloorsRanking:        "_{{.Name}}_ `{{number .Floors}}` :mountain:{{if eq .Rank 1}} :tornado::rocket:{{end}}{{.Streaks}}",
+	msgChannelRestricted:    ":lock: Seuls {{if .UserGroups}}les membres de {{.UserGroups}} et {{end}}les administrateurs peuvent lancer des défis dans ce canal",
+	msgDailyCapReached:      ":hourglass: Vous avez déjà lancé {{.Count}} défis aujourd'hui, c'est la limite quotidienne de cet espace de travail. Réessayez demain :bow:",
+	msgRestrictionSaved:     ":lock: Désormais, seuls {{if .UserGroups}}les membres de {{.UserGroups}} et {{end}}les administrateurs peuvent lancer des défis dans ce canal",
+	msgRestrictionRemoved:   ":unlock: Toute personne autorisée par les réglages de l'espace de travail peut de nouveau lancer des défis dans ce canal",
+	msgConfigUsage:          "Utilisez `{{.Command}}` pour modifier les réglages, `{{.Command}} restrict admins` ou `{{.Command}} restrict @groupe...` pour restreindre qui peut lancer des défis dans ce canal et `{{.Command}} unrestrict` pour lever la restriction",
+	msgHomeNotLinked:        ":link: Liez votre compte Fitbit pour participer aux défis de pas",
+	msgHomeLink:             "Lier Fitbit",
+	msgHomeUnlink:           "Délier Fitbit",
+	msgHomeWins:             ":trophy: {{if .Wins}}{{.Wins}} défis gagnés ce mois-ci{{else}}Aucun défi gagné ce mois-ci{{end}}{{if .LastWinDate}}, le dernier le {{.LastWinDate}}{{end}}",
+	msgHomeBadges:           ":sports_medal: *Vos badges*",
+	msgHomeNoBadges:         ":sports_medal: Vous n'avez pas encore gagné de badge. Continuez de marcher :athletic_shoe:",
+	msgHomeAdmin:            ":gear: *Administration de l'espace de travail*",
+	msgHomeSettings:         "Réglages de l'espace de travail",
+}
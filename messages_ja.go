@@ -0,0 +1,71 @@
+package stepcurry
+
+// japaneseMessageTemplates holds the japanese translations of the message templates
+var japaneseMessageTemplates = map[string]string{
+	msgLinkPrompt: "<{{.AuthorizeURL}}|こちら>からFitbitにログインして、アカウントへのアクセスを許可してください。\n\n" +
+		"許可すると、_Step Curry_ はあなたの毎日のアクティビティの概要を取得し、参加している歩数チャレンジで共有します。" +
+		"Fitbitアカウントを連携していて、歩数チャレンジが開催中のチャンネルのメンバーであれば、" +
+		"自動的にチャレンジに参加することになります。",
+	msgLinkSuccess:            "やった :boom: Fitbitアカウントの連携が完了しました。チャレンジの準備は万端です :wind_blowing_face::athletic_shoe:",
+	msgBrokenLink:             ":broken_heart: Fitbitアカウントにアクセスできなくなったため、歩数チャレンジから外れています。`{{.LinkCommand}}` でもう一度連携して、レースに戻りましょう :athletic_shoe:",
+	msgChallengeStarted:       "<@{{.UserID}}> さんが歩数チャレンジを始めました！さあ歩きましょう :wind_blowing_face::athletic_shoe:。まだFitbitアカウントを連携していない方は `{{.LinkCommand}}` と入力してチャレンジに参加してください。",
+	msgChallengeAlreadyActive: ":warning: すでに歩数チャレンジが開催中です ¯\\_(ツ)_/¯",
+	msgNotAMember:             "メンバーになっていないチャンネルや会話ではチャレンジを始められません。<@{{.BotUserID}}> を追加してから、もう一度お試しください :bow:",
+	msgNoActiveChallenge:      ":warning: このチャンネルで開催中のチャレンジはありません。`{{.ChallengeCommand}}` で始めましょう",
+	msgUpdateBanner: `{{pick ":rolled_up_newspaper: _速報_、現在の歩数ランキングです"` +
+		` ":loudspeaker: おっと、レースをリードしているのは誰でしょう！"` +
+		` ":wind_blowing_face::athletic_shoe: _歩けば歩くほど、後に残る足跡も増える_…最新の歩数をお届けします"` +
+		` ":thinking_face: 真に偉大な考えはすべて歩きながら生まれる（しかもチャレンジでも有利になれます）"` +
+		` ":fairy: 歩くことは素晴らしい冒険…たくさん歩けば、このリストのトップに立てるかも"}}`,
+	msgWinnerBanner:         ":rolled_up_newspaper: 昨日の歩数チャレンジの勝者が決まりました！ :tada:",
+	msgUnsyncedNote:         ":warning: {{.Count}}人の参加者を同期できませんでした",
+	msgBadgeAwards:          ":sports_medal: *新しいバッジを獲得*{{range .Awards}}\n{{.Badge.Emoji}} <@{{.UserID}}> さんが *{{.Badge.Name}}* を獲得しました{{end}}",
+	msgBadgesUsage:          ":warning: よくわかりませんでした。`{{.Command}}` または `{{.Command}} @だれか` を試してください",
+	msgNoBadges:             "<@{{.UserID}}> さんはまだバッジを獲得していません。歩き続けましょう :athletic_shoe:",
+	msgBadgeCollection:      ":sports_medal: <@{{.UserID}}> さんのバッジコレクション",
+	msgBadge:                "{{.Emoji}} *{{.Name}}*{{if gt .Count 1}} x{{.Count}}{{end}} _{{.Description}}_ （初獲得日 {{.FirstAwarded}}）",
+	msgFirst20kDay:          "はじめての2万歩",
+	msgFirst20kDayDesc:      "チャレンジで20,000歩を歩いた",
+	msgWinStreak:            "5日連続優勝",
+	msgWinStreakDesc:        "5日連続でチャレンジに優勝した",
+	msgPerfectMonth:         "皆勤賞",
+	msgPerfectMonthDesc:     "チャンネルのその月のチャレンジすべてに参加した",
+	msgPersonalBest:         "自己ベスト",
+	msgPersonalBestDesc:     "歩数の自己ベストを更新した",
+	msgLeaderboardUsage:     ":warning: よくわかりませんでした。`{{.Usage}}` を試してください",
+	msgNoLeaderboardResults: ":warning: この{{if eq .Scope \"channel\"}}チャンネル{{else}}ワークスペース{{end}}のリーダーボードにはまだチャレンジの結果がありません。`{{.ChallengeCommand}}` でチャレンジを始めてランクインしましょう",
+	msgLeaderboardBanner:    ":trophy: *{{if eq .Scope \"channel\"}}チャンネル{{else}}ワークスペース{{end}}リーダーボード* （{{if .AllTime}}全期間{{else}}{{if eq .Period \"month\"}}月間{{else}}シーズン{{end}} {{.PeriodID}}{{end}}、チャレンジ{{.Challenges}}回）",
+	msgLeaderboardRanking:   "*{{.Rank}}.* _{{.Name}}_ :trophy: {{.Wins}} :medal: {{.Podiums}} `{{number .TotalSteps}}` :athletic_shoe: （チャレンジ{{.Challenges}}回）",
+	msgStreakReminder:       ":fire: {{.GoalStreak}}日連続の目標達成記録が途切れそうです！今日は `{{number .Goal}}` 歩の目標に対して `{{number .Steps}}` 歩です。まだ散歩する時間はありますよ :athletic_shoe:\n\n_リマインダーを止めるには `{{.OffCommand}}` を使ってください。_",
+	msgRemindersUsage:       ":warning: よくわかりませんでした。`{{.OnCommand}}` または `{{.OffCommand}}` を試してください",
+	msgRemindersOn:          ":bell: 了解です！目標達成の連続記録が途切れそうなときは、{{.Hour}}時ごろにリマインダーを送ります",
+	msgRemindersOff:         ":no_bell: 了解です。連続記録のリマインダーはもう送りません",
+	msgNotLinked:            ":link: まだFitbitアカウントが連携されていません。`{{.LinkCommand}}` で始めましょう",
+	msgStatsSummary:         ":bar_chart: あなたのStep Curry統計",
+	msgStatsHeader:          ":bar_chart: *あなたのStep Curry統計*",
+	msgGoalProgress:         ":athletic_shoe: 今日は `{{number .Steps}}` 歩{{if .Goal}}、`{{number .Goal}}` 歩の目標の `{{.Percent}}%`{{if ge .Steps .Goal}} :dart:{{end}}{{else}}（Fitbitで1日の歩数目標が設定されていません）{{end}}",
+	msgNoChallengeRanks:     ":checkered_flag: 開催中のチャレンジに参加していません。`{{.ChallengeCommand}}` で始めましょう",
+	msgChallengeRank:        ":checkered_flag: <#{{.ChannelID}}> で{{.Participants}}人中{{.Rank}}位、`{{number .Steps}}` 歩",
+	msgTrend:                ":chart_with_upwards_trend: 過去{{.Days}}日間 `{{.Sparkline}}` 平均 `{{number .AverageSteps}}` 歩、目標達成は{{.GoalHits}}日",
+	msgLinkHealthy:          ":white_check_mark: Fitbitアカウントは連携済みで、同期されています",
+	msgLinkUnhealthy:        ":warning: Fitbitから最新のデータを取得できませんでした。`{{.LinkCommand}}` でアカウントをもう一度連携してみてください",
+	msgConfigAdminOnly:      ":lock: `{{.Command}}` でStep Curryの設定を変更できるのはワークスペースの管理者だけです",
+	msgChallengeNotAllowed:  ":lock: このワークスペースでチャレンジを始められるのは管理者だけです",
+	msgOptInStarted:         "<@{{.UserID}}> さんが歩数チャレンジを始めました！*参加する* を押して参加しましょう :wind_blowing_face::athletic_shoe:。まだFitbitアカウントを連携していない方は `{{.LinkCommand}}` も入力してください。",
+	msgJoinChallenge:        "参加する",
+	msgChallengeJoined:      ":athletic_shoe: 参加しました！さあ歩きましょう{{if not .Linked}}。歩数を反映させるには `{{.LinkCommand}}` でFitbitアカウントを連携してください{{end}}",
+	msgFloorsRanking:        "_{{.Name}}_ `{{number .Floors}}` :mountain:{{if eq .Rank 1}} :tornado::rocket:{{end}}{{.Streaks}}",
+	msgChannelRestricted:    ":lock: このチャンネルでチャレンジを始められるのは{{if .UserGroups}}{{.UserGroups}} のメンバーと{{end}}管理者だけです",
+	msgDailyCapReached:      ":hourglass: 今日はもう {{.Count}} 件のチャレンジを始めました。これがこのワークスペースの1日の上限です。また明日どうぞ :bow:",
+	msgRestrictionSaved:     ":lock: これからこのチャンネルでチャレンジを始められるのは{{if .UserGroups}}{{.UserGroups}} のメンバーと{{end}}管理者だけです",
+	msgRestrictionRemoved:   ":unlock: ワークスペースの設定で許可された人は、またこのチャンネルでチャレンジを始められます",
+	msgConfigUsage:          "`{{.Command}}` で設定を変更、`{{.Command}} restrict admins` または `{{.Command}} restrict @グループ...` でこのチャンネルでチャレンジを始められる人を制限、`{{.Command}} unrestrict` で制限を解除できます",
+	msgHomeNotLinked:        ":link: Fitbitアカウントを連携して歩数チャレンジに参加しましょう",
+	msgHomeLink:             "Fitbitを連携",
+	msgHomeUnlink:           "Fitbitの連携を解除",
+	msgHomeWins:             ":trophy: {{if .Wins}}今月のチャレンジ優勝 {{.Wins}} 回{{else}}今月はまだチャレンジ優勝なし{{end}}{{if .LastWinDate}}、最後の優勝は {{.LastWinDate}}{{end}}",
+	msgHomeBadges:           ":sports_medal: *あなたのバッジ*",
+	msgHomeNoBadges:         ":sports_medal: まだバッジを獲得していません。歩き続けましょう :athletic_shoe:",
+	msgHomeAdmin:            ":gear: *ワークスペース管理*",
+	msgHomeSettings:         "ワークスペースの設定",
+}
@@ -0,0 +1,243 @@
+package stepcurry
+
+import (
+	"context"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDefaultMessages(t *testing.T) {
+	messages := DefaultMessages()
+
+	assert.Equal(t, "<@U1> started a steps challenge! Get moving :wind_blowing_face::athletic_shoe:. If you haven't linked your fitbit account already, type `/step-link` and join in on the challenge.", messages.render(msgChallengeStarted, messageData{"UserID": "U1", "LinkCommand": "/step-link"}))
+	assert.Equal(t, "_Alice_ `2000` :athletic_shoe: :tornado::rocket: :fire: 3", messages.render(msgRanking, messageData{"Rank": 1, "Name": "Alice", "Steps": 2000, "Streaks": " :fire: 3"}))
+	assert.Equal(t, "_Bob_ `1000` :athletic_shoe:", messages.render(msgRanking, messageData{"Rank": 2, "Name": "Bob", "Steps": 1000, "Streaks": ""}))
+	assert.Contains(t, []string{":rolled_up_newspaper: _Breaking news_, here are the current steps ranking",
+		":loudspeaker: Oh snap, look who's winning the race!",
+		":wind_blowing_face::athletic_shoe: _The more you take, the more you leave behind_...here's the latest steps count update",
+		":thinking_face: All truly great thoughts are conceived while walking (and you also get to stay competitive in this challenge)",
+		":fairy: Walking is a great adventure...and if you do it enough, you might get the top spot in this list"}, messages.render(msgUpdateBanner, nil))
+}
+
+func TestMessageTemplateNames(t *testing.T) {
+	names := MessageTemplateNames()
+	assert.Len(t, names, len(defaultMessageTemplates))
+	assert.Contains(t, names, msgLinkPrompt)
+
+	text, ok := DefaultMessageTemplate(msgWinnerBanner)
+	assert.True(t, ok)
+	assert.Equal(t, ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:", text)
+}
+
+func TestNewMessagesWithOverrides(t *testing.T) {
+	messages, err := NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{
+		{Name: msgChallengeStarted, Text: ":runner: <@{{.UserID}}> kicked off a walkathon, link with `{{.LinkCommand}}` to join"},
+		{Name: msgWinnerBanner, Text: `{{pick ":trophy: And the winner is"}}`},
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":runner: <@U1> kicked off a walkathon, link with `/step-link` to join", messages.render(msgChallengeStarted, messageData{"UserID": "U1", "LinkCommand": "/step-link"}))
+	assert.Equal(t, ":trophy: And the winner is", messages.render(msgWinnerBanner, nil))
+
+	// Messages that aren't overridden keep their default definition
+	assert.Equal(t, "POW :boom: You've got your Fitbit account linked and ready for some challenges :wind_blowing_face::athletic_shoe:", messages.render(msgLinkSuccess, nil))
+
+	// Overrides don't leak to the defaults
+	assert.Equal(t, ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:", DefaultMessages().render(msgWinnerBanner, nil))
+}
+
+func TestNewMessagesInvalidTemplates(t *testing.T) {
+	_, err := NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: "unknown", Text: "hi"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown message template [unknown], should be one of")
+
+	_, err = NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "{{.Oops"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error parsing message template [linkSuccess] of team [T1]")
+}
+
+func TestRenderFallsBackToDefault(t *testing.T) {
+	messages, err := NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: msgNotAMember, Text: "Invite {{.Bot}} first"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "I can't start a challenge in a channel or conversation I'm not a member of. Add me, <@B1> and try again :bow:", messages.render(msgNotAMember, messageData{"BotUserID": "B1"}))
+}
+
+func TestPostOptions(t *testing.T) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", "C1", "", DefaultMessages().postOptions(slack.MsgOptionText("hello", false))...)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", values.Get("text"))
+	assert.Empty(t, values.Get("username"))
+	assert.Empty(t, values.Get("icon_emoji"))
+
+	messages, err := NewMessages(WorkspaceMessages{TeamID: "T1", BotName: "Coach", BotIconEmoji: ":runner:"})
+	require.NoError(t, err)
+
+	_, values, err = slack.UnsafeApplyMsgOptions("", "C1", "", messages.postOptions(slack.MsgOptionText("hello", false))...)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", values.Get("text"))
+	assert.Equal(t, "Coach", values.Get("username"))
+	assert.Equal(t, ":runner:", values.Get("icon_emoji"))
+}
+
+func TestLoadMessages(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	messages, err := loadMessages(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMessages(), messages)
+
+	// Stored customizations that don't parse are ignored
+	require.NoError(t, storage.PutWorkspaceMessages(ctx, WorkspaceMessages{TeamID: "T1", BotName: "Coach", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "{{"}}}))
+	messages, err = loadMessages(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMessages(), messages)
+
+	require.NoError(t, storage.PutWorkspaceMessages(ctx, WorkspaceMessages{TeamID: "T1", BotName: "Coach", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "Linked!"}}}))
+	messages, err = loadMessages(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Coach", messages.botName)
+	assert.Equal(t, "Linked!", messages.render(msgLinkSuccess, nil))
+}
+
+func TestSaveWorkspaceMessages(t *testing.T) {
+	storage := NewMemoryStorage()
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(&SingleTenantRouter{}), OptionSlackVerifier("signingSecret"), OptionStorage(storage), OptionScheduler(NewLocalScheduler(storage, NewSystemClock())))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = sc.SaveWorkspaceMessages(ctx, WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "{{"}}})
+	require.Error(t, err)
+
+	_, err = storage.GetWorkspaceMessages(ctx, "T1")
+	assert.Equal(t, ErrNoSuchEntity, err)
+
+	workspaceMessages := WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: msgLinkSuccess, Text: "Linked!"}}}
+	require.NoError(t, sc.SaveWorkspaceMessages(ctx, workspaceMessages))
+
+	stored, err := storage.GetWorkspaceMessages(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, workspaceMessages, stored)
+}
+
+func TestChallengeWithWorkspaceMessages(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	err := h.sc.SaveWorkspaceMessages(context.Background(), WorkspaceMessages{TeamID: lifecycleTeamID, Templates: []MessageTemplate{
+		{Name: msgChallengeStarted, Text: ":runner: <@{{.UserID}}> kicked off a walkathon"},
+		{Name: msgChallengeAlreadyActive, Text: "Easy there, one walkathon at a time"},
+	}})
+	require.NoError(t, err)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ":runner: <@U1> kicked off a walkathon", messages[0].text)
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, "Easy there, one walkathon at a time", responses[0].Text)
+}
+
+func TestFormatNumber(t *testing.T) {
+	assert.Equal(t, "12345", formatNumber(12345, ""))
+	assert.Equal(t, "12 345", formatNumber(12345, " "))
+	assert.Equal(t, "1,234,567", formatNumber(1234567, ","))
+	assert.Equal(t, "999", formatNumber(999, " "))
+	assert.Equal(t, "0", formatNumber(0, ","))
+	assert.Equal(t, "-12 345", formatNumber(-12345, " "))
+}
+
+func TestMessageCatalogs(t *testing.T) {
+	assert.Equal(t, []string{"en", "fr", "ja"}, Languages())
+
+	for language, catalog := range messageCatalogs {
+		for name := range catalog {
+			_, ok := defaultMessageTemplates[name]
+			assert.True(t, ok, "message [%s] of language [%s] isn't a default message", name, language)
+		}
+	}
+}
+
+func TestLocalizedMessages(t *testing.T) {
+	french := DefaultMessages().forLocale("fr-CA")
+	assert.Equal(t, "_Alice_ `12 345` :athletic_shoe: :tornado::rocket:", french.render(msgRanking, messageData{"Rank": 1, "Name": "Alice", "Steps": 12345, "Streaks": ""}))
+	assert.Equal(t, "<@U1> a lancé un défi de pas! Bougez-vous :wind_blowing_face::athletic_shoe:. Si vous n'avez pas encore lié votre compte Fitbit, tapez `/step-link` et joignez-vous au défi.", french.render(msgChallengeStarted, messageData{"UserID": "U1", "LinkCommand": "/step-link"}))
+	assert.Equal(t, ":checkered_flag: 1er sur 3 dans <#C1> avec `12 345` pas", french.render(msgChallengeRank, messageData{"Rank": 1, "Participants": 3, "ChannelID": "C1", "Steps": 12345}))
+
+	japanese := DefaultMessages().forLocale("ja-JP")
+	assert.Equal(t, "_Alice_ `12,345` :athletic_shoe: :tornado::rocket:", japanese.render(msgRanking, messageData{"Rank": 1, "Name": "Alice", "Steps": 12345, "Streaks": ""}))
+	assert.Equal(t, ":rolled_up_newspaper: 昨日の歩数チャレンジの勝者が決まりました！ :tada:", japanese.render(msgWinnerBanner, nil))
+
+	// Locales without a catalog keep the workspace's language
+	assert.Equal(t, ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:", DefaultMessages().forLocale("de-DE").render(msgWinnerBanner, nil))
+	assert.Equal(t, ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:", DefaultMessages().forLocale("").render(msgWinnerBanner, nil))
+}
+
+func TestNewMessagesWithDefaultLanguage(t *testing.T) {
+	messages, err := NewMessages(WorkspaceMessages{TeamID: "T1", DefaultLanguage: languageFrench})
+	require.NoError(t, err)
+	assert.Equal(t, ":rolled_up_newspaper: Nous avons un gagnant pour le défi de pas d'hier! :tada:", messages.render(msgWinnerBanner, nil))
+	assert.Equal(t, ":rolled_up_newspaper: We have a winner for yesterday's steps challenge! :tada:", messages.forLocale("en-US").render(msgWinnerBanner, nil))
+
+	_, err = NewMessages(WorkspaceMessages{TeamID: "T1", DefaultLanguage: "de"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported language [de], should be one of en, fr, ja")
+}
+
+func TestNewMessagesWithLanguageOverrides(t *testing.T) {
+	messages, err := NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{
+		{Name: msgWinnerBanner, Language: languageFrench, Text: ":trophy: Et le gagnant est"},
+		{Name: msgWinnerBanner, Text: ":trophy: And the winner is"},
+	}})
+	require.NoError(t, err)
+
+	// Overrides for a language take precedence over the ones for all languages
+	assert.Equal(t, ":trophy: And the winner is", messages.render(msgWinnerBanner, nil))
+	assert.Equal(t, ":trophy: And the winner is", messages.forLocale("ja-JP").render(msgWinnerBanner, nil))
+	assert.Equal(t, ":trophy: Et le gagnant est", messages.forLocale("fr-FR").render(msgWinnerBanner, nil))
+
+	_, err = NewMessages(WorkspaceMessages{TeamID: "T1", Templates: []MessageTemplate{{Name: msgWinnerBanner, Language: "de", Text: "Und der Gewinner ist"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported language [de]")
+}
+
+func TestChallengeInUserLocale(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceMessages(context.Background(), WorkspaceMessages{TeamID: lifecycleTeamID, DefaultLanguage: languageJapanese}))
+
+	h.slack.users["U2"] = "Bob"
+	h.slack.locales["U2"] = "fr-CA"
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	// The announcement goes to the channel in the workspace's language and the warning to the requester in theirs
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U1> さんが歩数チャレンジを始めました！")
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":warning: Il y a déjà un défi de pas en cours, juste pour que vous le sachiez ¯\\_(ツ)_/¯", responses[0].Text)
+}
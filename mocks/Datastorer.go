@@ -105,3 +105,17 @@ func (_m *Datastorer) Run(ctx context.Context, q *datastore.Query) *datastore.It
 
 	return r0
 }
+
+// RunInTransaction provides a mock function with given fields: c, f
+func (_m *Datastorer) RunInTransaction(c context.Context, f func(context.Context) error) error {
+	ret := _m.Called(c, f)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(context.Context) error) error); ok {
+		r0 = rf(c, f)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
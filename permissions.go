@@ -0,0 +1,211 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Subcommands of /step-config to manage channel restrictions
+const (
+	restrictSubcommand   = "restrict"
+	unrestrictSubcommand = "unrestrict"
+	restrictAdminsArg    = "admins"
+)
+
+// userGroupMentionRegex matches an escaped user group mention like <!subteam^S0123|@moderators>
+var userGroupMentionRegex = regexp.MustCompile(`^<!subteam\^([A-Z0-9]+)(\|[^>]*)?>$`)
+
+// permissionDenial holds the message explaining to a user why they're not allowed to do something
+type permissionDenial struct {
+	message string
+	data    messageData
+}
+
+// lazyAdminCheck checks if a user is an admin on first use only so that the user info is only fetched when a
+// permission depends on it
+type lazyAdminCheck struct {
+	svcs    TeamServices
+	userID  string
+	checked bool
+	admin   bool
+}
+
+func (c *lazyAdminCheck) isAdmin() (admin bool, err error) {
+	if !c.checked {
+		c.admin, err = isAdmin(c.svcs, c.userID)
+		if err != nil {
+			return false, err
+		}
+		c.checked = true
+	}
+
+	return c.admin, nil
+}
+
+// checkStartChallenge checks if a user is allowed to start a challenge in a channel on the given date. Channel
+// restrictions take precedence over the workspace's challenge starters and users who aren't admins can't start more
+// challenges in a day than the workspace's daily cap. A nil denial means the user is allowed
+func (sc *StepCurry) checkStartChallenge(svcs TeamServices, challengeID ChallengeID, userID string) (denial *permissionDenial, err error) {
+	admin := &lazyAdminCheck{svcs: svcs, userID: userID}
+
+	if restriction, ok := svcs.settings.channelRestriction(challengeID.ChannelID); ok {
+		allowed, err := admin.isAdmin()
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			allowed = isUserGroupMember(svcs, restriction.UserGroups, userID)
+		}
+
+		if !allowed {
+			return &permissionDenial{message: msgChannelRestricted, data: messageData{"UserGroups": renderUserGroups(restriction.UserGroups)}}, nil
+		}
+	} else if svcs.settings.ChallengeStarters == startersAdmins {
+		allowed, err := admin.isAdmin()
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			return &permissionDenial{message: msgChallengeNotAllowed}, nil
+		}
+	}
+
+	if svcs.settings.DailyChallengeCap == 0 {
+		return nil, nil
+	}
+
+	exempt, err := admin.isAdmin()
+	if err != nil || exempt {
+		return nil, err
+	}
+
+	// Challenges stay active until the morning after they started so the user's challenges of the day are all active
+	challenges, err := sc.getActiveChallenges(challengeID.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	started := 0
+	for _, c := range challenges {
+		if c.CreatorID == userID && c.Date == challengeID.Date {
+			started++
+		}
+	}
+
+	if started >= svcs.settings.DailyChallengeCap {
+		return &permissionDenial{message: msgDailyCapReached, data: messageData{"Count": started}}, nil
+	}
+
+	return nil, nil
+}
+
+// isUserGroupMember returns true if a user is a member of any of the user groups. User groups whose members can't be
+// looked up (because the app is missing the usergroups:read scope, for example) are logged and treated as not
+// including the user so that restricted channels deny rather than fail
+func isUserGroupMember(svcs TeamServices, userGroups []string, userID string) (member bool) {
+	if len(userGroups) == 0 {
+		return false
+	}
+
+	if svcs.userGroupMemberFinder == nil {
+		newLogEntry(svcs.logger).with(Fields{teamIDField: svcs.settings.TeamID, userIDField: userID}).warningf("No user group member finder configured, can't check if the user is a member of %v", userGroups)
+		return false
+	}
+
+	for _, userGroup := range userGroups {
+		members, err := svcs.userGroupMemberFinder.GetUserGroupMembers(userGroup)
+		if err != nil {
+			newLogEntry(svcs.logger).with(Fields{teamIDField: svcs.settings.TeamID, userIDField: userID}).withError(err).warningf("Error getting members of user group [%s]", userGroup)
+			continue
+		}
+
+		if containsString(members, userID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderUserGroups renders user groups as mentions
+func renderUserGroups(userGroups []string) string {
+	mentions := make([]string, len(userGroups))
+	for i, userGroup := range userGroups {
+		mentions[i] = fmt.Sprintf("<!subteam^%s>", userGroup)
+	}
+
+	return strings.Join(mentions, ", ")
+}
+
+// parseRestriction parses the arguments of the restrict subcommand into the restriction of a channel. It takes either
+// admins or one or more user group mentions
+func parseRestriction(channelID string, args []string) (restriction ChannelRestriction, err error) {
+	restriction = ChannelRestriction{ChannelID: channelID}
+	if len(args) == 1 && args[0] == restrictAdminsArg {
+		return restriction, nil
+	}
+
+	if len(args) == 0 {
+		return restriction, fmt.Errorf("missing user groups")
+	}
+
+	for _, arg := range args {
+		match := userGroupMentionRegex.FindStringSubmatch(arg)
+		if match == nil {
+			return restriction, fmt.Errorf("invalid user group [%s]", arg)
+		}
+
+		restriction.UserGroups = append(restriction.UserGroups, match[1])
+	}
+
+	return restriction, nil
+}
+
+// configureChannel handles the /step-config subcommands restricting who can start challenges in the channel they're
+// invoked from. It's only called for admins
+func (sc *StepCurry) configureChannel(svcs TeamServices, params map[string]string, args []string) (err error) {
+	teamID := commandTenantID(params)
+	channelID := params[channelIDParam]
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+	messages := svcs.userMessages(userID)
+
+	var restriction *ChannelRestriction
+	confirmation := ""
+	switch {
+	case args[0] == restrictSubcommand:
+		r, err := parseRestriction(channelID, args[1:])
+		if err != nil {
+			break
+		}
+
+		restriction = &r
+		confirmation = messages.render(msgRestrictionSaved, messageData{"UserGroups": renderUserGroups(r.UserGroups)})
+	case args[0] == unrestrictSubcommand && len(args) == 1:
+		confirmation = messages.render(msgRestrictionRemoved, nil)
+	}
+
+	if confirmation == "" {
+		usageMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgConfigUsage, messageData{"Command": sc.slashCommands.Config})}
+		return sendResponse(responseURL, usageMsg, "config usage")
+	}
+
+	settings, err := loadSettings(sc.storage, teamID, sc.logger)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error loading settings for team [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	err = sc.SaveWorkspaceSettings(context.Background(), settings.withChannelRestriction(channelID, restriction))
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error saving restriction of channel [%s] for team [%s]", channelID, teamID), http.StatusInternalServerError)
+	}
+
+	sc.log(context.Background()).with(Fields{teamIDField: teamID, channelIDField: channelID, userIDField: userID}).infof("Challenge restriction of channel updated")
+	confirmationMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: confirmation}
+	return sendResponse(responseURL, confirmationMsg, "channel restriction")
+}
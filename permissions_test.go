@@ -0,0 +1,179 @@
+package stepcurry
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRestriction(t *testing.T) {
+	tests := map[string]struct {
+		args                []string
+		expectedRestriction ChannelRestriction
+		expectError         bool
+	}{
+		"Admins":        {args: []string{"admins"}, expectedRestriction: ChannelRestriction{ChannelID: "C1"}},
+		"UserGroup":     {args: []string{"<!subteam^S123|@moderators>"}, expectedRestriction: ChannelRestriction{ChannelID: "C1", UserGroups: []string{"S123"}}},
+		"UserGroups":    {args: []string{"<!subteam^S123|@moderators>", "<!subteam^S456>"}, expectedRestriction: ChannelRestriction{ChannelID: "C1", UserGroups: []string{"S123", "S456"}}},
+		"MissingGroups": {args: []string{}, expectError: true},
+		"NotAUserGroup": {args: []string{"<@U1>"}, expectError: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			restriction, err := parseRestriction("C1", tc.args)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedRestriction, restriction)
+			}
+		})
+	}
+}
+
+func TestChallengeInChannelRestrictedToAdmins(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, ChannelRestrictions: []ChannelRestriction{{ChannelID: lifecycleChannelID}}}))
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.slack.admins["U2"] = true
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":lock: Only workspace admins can start challenges in this channel", responses[0].Text)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U2> started a steps challenge!")
+}
+
+func TestChallengeInChannelRestrictedToUserGroups(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, ChannelRestrictions: []ChannelRestriction{{ChannelID: lifecycleChannelID, UserGroups: []string{"S1", "S2"}}}}))
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.slack.groups["S1"] = []string{"U3"}
+	h.slack.groups["S2"] = []string{"U2"}
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":lock: Only members of <!subteam^S1>, <!subteam^S2> and workspace admins can start challenges in this channel", responses[0].Text)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U2> started a steps challenge!")
+}
+
+func TestChallengeInChannelRestrictedToUserGroupWithFailingLookup(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, ChannelRestrictions: []ChannelRestriction{{ChannelID: lifecycleChannelID, UserGroups: []string{"S1"}}}}))
+
+	// The fake slack fails lookups of unknown user groups like slack does when the app is missing a scope
+	h.addUser("U1", "Alice", "F1")
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":lock: Only members of <!subteam^S1> and workspace admins can start challenges in this channel", responses[0].Text)
+}
+
+func TestChallengeDailyCap(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, DailyChallengeCap: 1}))
+
+	h.addUser("U1", "Alice", "F1")
+
+	// A challenge started by the same user in another channel today counts toward the cap
+	ctx := context.Background()
+	require.NoError(t, h.storage.PutChallenge(ctx, StepsChallenge{ChallengeID: ChallengeID{TeamID: lifecycleTeamID, ChannelID: "C2", Date: "2020-06-01"}, Active: true, CreatorID: "U1"}))
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":hourglass: You've already started 1 challenges today, that's the daily limit of this workspace. Try again tomorrow :bow:", responses[0].Text)
+
+	// Admins aren't capped
+	h.slack.admins["U1"] = true
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U1> started a steps challenge!")
+}
+
+func TestConfigRestrictAndUnrestrictChannel(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	h.slack.users["U1"] = "Alice"
+	h.slack.admins["U1"] = true
+
+	ctx := context.Background()
+	err := h.sc.Config(httptest.NewRecorder(), h.slashCommandWithText(commandConfig, "U1", "restrict <!subteam^S123|@moderators>"))
+	require.NoError(t, err)
+
+	settings, err := h.storage.GetWorkspaceSettings(ctx, lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Equal(t, []ChannelRestriction{{ChannelID: lifecycleChannelID, UserGroups: []string{"S123"}}}, settings.ChannelRestrictions)
+
+	err = h.sc.Config(httptest.NewRecorder(), h.slashCommandWithText(commandConfig, "U1", "unrestrict"))
+	require.NoError(t, err)
+
+	settings, err = h.storage.GetWorkspaceSettings(ctx, lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Empty(t, settings.ChannelRestrictions)
+
+	err = h.sc.Config(httptest.NewRecorder(), h.slashCommandWithText(commandConfig, "U1", "restrict everyone"))
+	require.NoError(t, err)
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 3)
+	assert.Equal(t, ":lock: From now on, only members of <!subteam^S123> and workspace admins can start challenges in this channel", responses[0].Text)
+	assert.Equal(t, ":unlock: Anyone allowed by the workspace settings can start challenges in this channel again", responses[1].Text)
+	assert.Contains(t, responses[2].Text, "`/step-config restrict admins`")
+	assert.Empty(t, h.slack.views)
+}
@@ -0,0 +1,119 @@
+package stepcurry
+
+import (
+	"container/list"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRouterCacheTTL is how long the services of a team are kept before being loaded again
+	defaultRouterCacheTTL = time.Hour
+	// defaultRouterCacheSize is the number of teams whose services are kept before evicting the least recently used
+	defaultRouterCacheSize = 1000
+)
+
+// teamServicesCache is a concurrency-safe cache of team services that expires entries after a TTL and evicts the least
+// recently used ones past its size. Concurrent misses for the same team share a single load
+type teamServicesCache struct {
+	ttl     time.Duration
+	size    int
+	clock   Clock
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recency holds the cached entries, most recently used first
+	recency *list.List
+	// generations is incremented on invalidation so that loads started before an invalidation aren't cached
+	generations map[string]uint64
+	loads       singleflight.Group
+}
+
+// cachedTeamServices holds the services of a team along with when they expire
+type cachedTeamServices struct {
+	teamID  string
+	svcs    TeamServices
+	expires time.Time
+}
+
+// newTeamServicesCache creates a new teamServicesCache
+func newTeamServicesCache(ttl time.Duration, size int, clock Clock) (cache *teamServicesCache) {
+	return &teamServicesCache{ttl: ttl, size: size, clock: clock, entries: make(map[string]*list.Element), recency: list.New(), generations: make(map[string]uint64)}
+}
+
+// get returns the services of a team, calling load to get them if they aren't cached or have expired. Services are
+// cached for the TTL or until the expiry returned by load, if it's sooner
+func (c *teamServicesCache) get(teamID string, load func() (svcs TeamServices, expiry time.Time, err error)) (svcs TeamServices, err error) {
+	c.mu.Lock()
+	if element, ok := c.entries[teamID]; ok {
+		entry := element.Value.(*cachedTeamServices)
+		if c.clock.Now().Before(entry.expires) {
+			c.recency.MoveToFront(element)
+			c.mu.Unlock()
+			return entry.svcs, nil
+		}
+
+		c.remove(element)
+	}
+	generation := c.generations[teamID]
+	c.mu.Unlock()
+
+	loaded, err, _ := c.loads.Do(teamID, func() (interface{}, error) {
+		svcs, expiry, err := load()
+		if err != nil {
+			return svcs, err
+		}
+
+		c.put(teamID, svcs, expiry, generation)
+		return svcs, nil
+	})
+	if err != nil {
+		return svcs, err
+	}
+
+	return loaded.(TeamServices), nil
+}
+
+// put caches the services of a team unless the team was invalidated since generation, evicting the least recently
+// used teams past the cache size
+func (c *teamServicesCache) put(teamID string, svcs TeamServices, expiry time.Time, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[teamID] != generation {
+		return
+	}
+
+	if element, ok := c.entries[teamID]; ok {
+		c.remove(element)
+	}
+
+	expires := c.clock.Now().Add(c.ttl)
+	if !expiry.IsZero() && expiry.Before(expires) {
+		expires = expiry
+	}
+
+	c.entries[teamID] = c.recency.PushFront(&cachedTeamServices{teamID: teamID, svcs: svcs, expires: expires})
+	for c.recency.Len() > c.size {
+		c.remove(c.recency.Back())
+	}
+}
+
+// invalidate drops the cached services of a team. Loads in flight for the team aren't cached and the next get loads
+// the services again
+func (c *teamServicesCache) invalidate(teamID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[teamID]++
+	c.loads.Forget(teamID)
+	if element, ok := c.entries[teamID]; ok {
+		c.remove(element)
+	}
+}
+
+// remove drops a cached entry. It must be called with the lock held
+func (c *teamServicesCache) remove(element *list.Element) {
+	c.recency.Remove(element)
+	delete(c.entries, element.Value.(*cachedTeamServices).teamID)
+}
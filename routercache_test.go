@@ -0,0 +1,231 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTokenLoader is a TokenLoader counting the tokens loaded per team. Loads wait for release to be closed
+type countingTokenLoader struct {
+	mu      sync.Mutex
+	loads   map[string]int
+	release chan struct{}
+}
+
+func newCountingTokenLoader() (loader *countingTokenLoader) {
+	loader = &countingTokenLoader{loads: make(map[string]int), release: make(chan struct{})}
+	close(loader.release)
+
+	return loader
+}
+
+func (ctl *countingTokenLoader) LoadToken(teamID string) (token SlackToken, err error) {
+	<-ctl.release
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	if teamID == "T404" {
+		return token, fmt.Errorf("no token for team [%s]", teamID)
+	}
+
+	ctl.loads[teamID]++
+	return SlackToken{AccessToken: fmt.Sprintf("xoxb-%s-%d", teamID, ctl.loads[teamID])}, nil
+}
+
+func (ctl *countingTokenLoader) loadCount(teamID string) int {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	return ctl.loads[teamID]
+}
+
+func newTestMultiTenantRouter(t *testing.T, clock Clock, ttl time.Duration, size int, teamIDs ...string) (mtRouter *MultiTenantRouter, loader *countingTokenLoader, storage *MemoryStorage) {
+	storage = NewMemoryStorage()
+	for _, teamID := range teamIDs {
+		require.NoError(t, storage.PutBotInfo(context.Background(), teamID, BotInfo{UserID: "B" + teamID, TeamID: teamID}))
+	}
+
+	loader = newCountingTokenLoader()
+	mtRouter, err := NewMultiTenantRouter("project", storage, loader, nil, false)
+	require.NoError(t, err)
+	mtRouter.clock = clock
+	mtRouter.UseCacheLimits(ttl, size)
+
+	return mtRouter, loader, storage
+}
+
+func TestMultiTenantRouterCachesServices(t *testing.T) {
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10, "T1")
+
+	svcs, err := mtRouter.Route("T1")
+	require.NoError(t, err)
+	botID, err := svcs.botIdentificator.GetBotID()
+	require.NoError(t, err)
+	assert.Equal(t, "BT1", botID)
+
+	_, err = mtRouter.Route("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, loader.loadCount("T1"))
+}
+
+func TestMultiTenantRouterSingleFlightLoading(t *testing.T) {
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10, "T1")
+	loader.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mtRouter.Route("T1")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Give the requests a chance to pile up on the load in flight
+	time.Sleep(20 * time.Millisecond)
+	close(loader.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, loader.loadCount("T1"))
+}
+
+func TestMultiTenantRouterExpiresServices(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, clock, time.Hour, 10, "T1")
+
+	_, err := mtRouter.Route("T1")
+	require.NoError(t, err)
+
+	clock.Advance(59 * time.Minute)
+	_, err = mtRouter.Route("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, loader.loadCount("T1"))
+
+	clock.Advance(time.Minute)
+	_, err = mtRouter.Route("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, loader.loadCount("T1"))
+}
+
+func TestMultiTenantRouterEvictsLeastRecentlyUsed(t *testing.T) {
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 2, "T1", "T2", "T3")
+
+	for _, teamID := range []string{"T1", "T2", "T1", "T3", "T1", "T2"} {
+		_, err := mtRouter.Route(teamID)
+		require.NoError(t, err)
+	}
+
+	// T2 was the least recently used when T3 was routed and T3 when T2 came back
+	assert.Equal(t, 1, loader.loadCount("T1"))
+	assert.Equal(t, 2, loader.loadCount("T2"))
+	assert.Equal(t, 1, loader.loadCount("T3"))
+}
+
+func TestMultiTenantRouterInvalidate(t *testing.T) {
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10, "T1", "T2")
+
+	for _, teamID := range []string{"T1", "T2"} {
+		_, err := mtRouter.Route(teamID)
+		require.NoError(t, err)
+	}
+
+	mtRouter.Invalidate("T1")
+
+	for _, teamID := range []string{"T1", "T2"} {
+		_, err := mtRouter.Route(teamID)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, loader.loadCount("T1"))
+	assert.Equal(t, 1, loader.loadCount("T2"))
+}
+
+func TestMultiTenantRouterDoesntCacheLoadsInvalidatedInFlight(t *testing.T) {
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10, "T1")
+	loader.release = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := mtRouter.Route("T1")
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	mtRouter.Invalidate("T1")
+	close(loader.release)
+	<-done
+
+	_, err := mtRouter.Route("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, loader.loadCount("T1"))
+}
+
+func TestMultiTenantRouterDoesntCacheErrors(t *testing.T) {
+	mtRouter, _, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10)
+
+	_, err := mtRouter.Route("T404")
+	assert.Error(t, err)
+
+	_, err = mtRouter.Route("T1")
+	assert.Error(t, err)
+}
+
+func TestMultiTenantRouterPicksUpSavedSettings(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10)
+	mtRouter.storage = h.storage
+	require.NoError(t, h.storage.PutBotInfo(context.Background(), lifecycleTeamID, BotInfo{UserID: lifecycleBotID}))
+	h.sc.TeamRouter = mtRouter
+
+	svcs, err := h.sc.Route(lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Equal(t, defaultTimezone, svcs.settings.Timezone)
+
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, Timezone: "Asia/Tokyo"}))
+
+	svcs, err = h.sc.Route(lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", svcs.settings.Timezone)
+
+	require.NoError(t, h.sc.SaveWorkspaceMessages(context.Background(), WorkspaceMessages{TeamID: lifecycleTeamID, BotName: "Stepper"}))
+
+	_, err = h.sc.Route(lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, loader.loadCount(lifecycleTeamID))
+}
+
+func TestAppUninstalledInvalidatesTeam(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	mtRouter, loader, _ := newTestMultiTenantRouter(t, NewSystemClock(), time.Hour, 10)
+	mtRouter.storage = h.storage
+	require.NoError(t, h.storage.PutBotInfo(context.Background(), lifecycleTeamID, BotInfo{UserID: lifecycleBotID}))
+	h.sc.TeamRouter = mtRouter
+
+	_, err := h.sc.Route(lifecycleTeamID)
+	require.NoError(t, err)
+
+	for _, event := range []string{`{"type":"app_uninstalled"}`, `{"type":"tokens_revoked","tokens":{"bot":["B1"]}}`} {
+		body := fmt.Sprintf(`{"token":"legacy","team_id":"%s","api_app_id":"A1","type":"event_callback","event":%s}`, lifecycleTeamID, event)
+		w := httptest.NewRecorder()
+		h.mux.ServeHTTP(w, h.signedRequest(eventsPath, body))
+		require.Equal(t, 200, w.Code)
+
+		_, err = h.sc.Route(lifecycleTeamID)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 3, loader.loadCount(lifecycleTeamID))
+}
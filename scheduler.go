@@ -0,0 +1,122 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Job kinds
+const (
+	JobKindChallengeUpdate = "challengeUpdate"
+	JobKindTokenSweep      = "tokenSweep"
+	JobKindActivityUpdate  = "activityUpdate"
+	JobKindHomePublish     = "homePublish"
+	JobKindPerfectMonth    = "perfectMonth"
+)
+
+// Scheduler defines the interface for scheduling work to run at a later time
+type Scheduler interface {
+	// Schedule schedules an update of a challenge at the given time
+	Schedule(ctx context.Context, challengeID ChallengeID, scheduledTime time.Time) (err error)
+	// ScheduleTokenSweep schedules a token sweep at the given time. Scheduling a sweep more than once for the same
+	// time results in a single sweep
+	ScheduleTokenSweep(ctx context.Context, scheduledTime time.Time) (err error)
+	// ScheduleActivityUpdate schedules the recording of a fitbit user's activity for a day at the given time
+	ScheduleActivityUpdate(ctx context.Context, update ActivityUpdate, scheduledTime time.Time) (err error)
+	// ScheduleHomePublish schedules the publishing of a user's app home at the given time
+	ScheduleHomePublish(ctx context.Context, update HomeUpdate, scheduledTime time.Time) (err error)
+	// SchedulePerfectMonth schedules the evaluation of perfect months of a channel at the given time. Scheduling an
+	// evaluation more than once for the same channel and month results in a single evaluation
+	SchedulePerfectMonth(ctx context.Context, evaluation PerfectMonthEvaluation, scheduledTime time.Time) (err error)
+}
+
+// OptionScheduler sets a scheduler as the implementation on StepCurry. This is an alternative to OptionTaskScheduler
+// for running scheduled work without Cloud Tasks
+func OptionScheduler(scheduler Scheduler) Option {
+	return func(sc *StepCurry) (err error) {
+		sc.scheduler = scheduler
+		return nil
+	}
+}
+
+// Job holds work scheduled to run at a later time
+type Job struct {
+	Name          string                 `datastore:"name"`
+	Kind          string                 `datastore:"kind,noindex"`
+	ChallengeID   ChallengeID            `datastore:"challengeID,noindex"`
+	Activity      ActivityUpdate         `datastore:"activity,noindex"`
+	Home          HomeUpdate             `datastore:"home,noindex"`
+	PerfectMonth  PerfectMonthEvaluation `datastore:"perfectMonth,noindex"`
+	ScheduledTime time.Time              `datastore:"scheduledTime"`
+	Attempts      int                    `datastore:"attempts,noindex"`
+}
+
+// challengeUpdateJob returns the job updating a challenge at the given time
+func challengeUpdateJob(challengeID ChallengeID, scheduledTime time.Time) (job Job) {
+	name := fmt.Sprintf("challenge-update-%s-%s-%s-%d", challengeID.TeamID, challengeID.ChannelID, challengeID.Date, scheduledTime.Unix())
+	return Job{Name: name, Kind: JobKindChallengeUpdate, ChallengeID: challengeID, ScheduledTime: scheduledTime}
+}
+
+// tokenSweepJob returns the job sweeping tokens at the given time
+func tokenSweepJob(scheduledTime time.Time) (job Job) {
+	return Job{Name: fmt.Sprintf("token-sweep-%d", scheduledTime.Unix()), Kind: JobKindTokenSweep, ScheduledTime: scheduledTime}
+}
+
+// activityUpdateJob returns the job recording the activity of a fitbit user at the given time
+func activityUpdateJob(update ActivityUpdate, scheduledTime time.Time) (job Job) {
+	name := fmt.Sprintf("activity-update-%s-%s-%d", update.FitbitUser, update.Date, scheduledTime.Unix())
+	return Job{Name: name, Kind: JobKindActivityUpdate, Activity: update, ScheduledTime: scheduledTime}
+}
+
+// homePublishJob returns the job publishing the app home of a user at the given time
+func homePublishJob(update HomeUpdate, scheduledTime time.Time) (job Job) {
+	name := fmt.Sprintf("home-publish-%s-%s-%d", update.TeamID, update.UserID, scheduledTime.Unix())
+	return Job{Name: name, Kind: JobKindHomePublish, Home: update, ScheduledTime: scheduledTime}
+}
+
+// perfectMonthJob returns the job evaluating perfect months of a channel at the given time. The job is named after
+// the channel and month so that it's only scheduled once
+func perfectMonthJob(evaluation PerfectMonthEvaluation, scheduledTime time.Time) (job Job) {
+	return Job{Name: perfectMonthJobName(evaluation), Kind: JobKindPerfectMonth, PerfectMonth: evaluation, ScheduledTime: scheduledTime}
+}
+
+// perfectMonthJobName returns the name of the job evaluating perfect months of a channel
+func perfectMonthJobName(evaluation PerfectMonthEvaluation) (name string) {
+	return fmt.Sprintf("perfect-month-%s-%s-%s", evaluation.TeamID, evaluation.ChannelID, evaluation.Month)
+}
+
+// JobRunner defines the interface for running scheduled jobs
+type JobRunner interface {
+	// RunJob runs a job
+	RunJob(ctx context.Context, job Job) (err error)
+}
+
+// RunJob runs a job scheduled by a Scheduler that doesn't deliver jobs as http requests to the app's handlers
+func (sc *StepCurry) RunJob(ctx context.Context, job Job) (err error) {
+	switch job.Kind {
+	case JobKindChallengeUpdate:
+		return sc.updateChallenge(context.Background(), job.ChallengeID)
+	case JobKindTokenSweep:
+		return sc.runTokenSweep()
+	case JobKindActivityUpdate:
+		return sc.recordDailyActivity(job.Activity.FitbitUser, job.Activity.Date)
+	case JobKindHomePublish:
+		return sc.publishUserHome(job.Home.TeamID, job.Home.UserID)
+	case JobKindPerfectMonth:
+		return sc.evaluatePerfectMonth(job.PerfectMonth)
+	default:
+		return fmt.Errorf("unknown kind [%s] for job [%s]", job.Kind, job.Name)
+	}
+}
+
+// scheduleChallengeUpdate schedules an update of a challenge at the given time
+func (sc *StepCurry) scheduleChallengeUpdate(challengeID ChallengeID, scheduledTime time.Time) (err error) {
+	return sc.scheduler.Schedule(context.Background(), challengeID, scheduledTime)
+}
+
+// scheduleTokenSweep schedules the token sweep following the given time. Sweeps are scheduled at fixed times so that
+// scheduling the same sweep more than once results in a single sweep
+func (sc *StepCurry) scheduleTokenSweep(after time.Time) (err error) {
+	return sc.scheduler.ScheduleTokenSweep(context.Background(), after.Add(tokenSweepInterval).Truncate(tokenSweepInterval))
+}
@@ -0,0 +1,179 @@
+package stepcurry
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using https://raw.githubusercontent.com/alexandre-normand/slackscot/master/opentelemetry.template template
+
+//go:generate gowrap gen -p github.com/alexandre-normand/stepcurry -i Scheduler -t https://raw.githubusercontent.com/alexandre-normand/slackscot/master/opentelemetry.template -o schedulermetrics.go
+
+import (
+	"context"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SchedulerWithTelemetry implements Scheduler interface with all methods wrapped
+// with open telemetry metrics
+type SchedulerWithTelemetry struct {
+	base                     Scheduler
+	methodCounters           map[string]metric.BoundInt64Counter
+	errCounters              map[string]metric.BoundInt64Counter
+	methodTimeValueRecorders map[string]metric.BoundInt64ValueRecorder
+}
+
+// NewSchedulerWithTelemetry returns an instance of the Scheduler decorated with open telemetry timing and count metrics
+func NewSchedulerWithTelemetry(base Scheduler, name string, meter metric.Meter) SchedulerWithTelemetry {
+	return SchedulerWithTelemetry{
+		base:                     base,
+		methodCounters:           newSchedulerMethodCounters("Calls", name, meter),
+		errCounters:              newSchedulerMethodCounters("Errors", name, meter),
+		methodTimeValueRecorders: newSchedulerMethodTimeValueRecorders(name, meter),
+	}
+}
+
+func newSchedulerMethodTimeValueRecorders(appName string, meter metric.Meter) (boundTimeValueRecorders map[string]metric.BoundInt64ValueRecorder) {
+	boundTimeValueRecorders = make(map[string]metric.BoundInt64ValueRecorder)
+	mt := metric.Must(meter)
+	nScheduleValRecorder := []rune("Scheduler_Schedule_ProcessingTimeMillis")
+	nScheduleValRecorder[0] = unicode.ToLower(nScheduleValRecorder[0])
+	mSchedule := mt.NewInt64ValueRecorder(string(nScheduleValRecorder))
+	boundTimeValueRecorders["Schedule"] = mSchedule.Bind(label.String("name", appName))
+	nScheduleTokenSweepValRecorder := []rune("Scheduler_ScheduleTokenSweep_ProcessingTimeMillis")
+	nScheduleTokenSweepValRecorder[0] = unicode.ToLower(nScheduleTokenSweepValRecorder[0])
+	mScheduleTokenSweep := mt.NewInt64ValueRecorder(string(nScheduleTokenSweepValRecorder))
+	boundTimeValueRecorders["ScheduleTokenSweep"] = mScheduleTokenSweep.Bind(label.String("name", appName))
+	nScheduleActivityUpdateValRecorder := []rune("Scheduler_ScheduleActivityUpdate_ProcessingTimeMillis")
+	nScheduleActivityUpdateValRecorder[0] = unicode.ToLower(nScheduleActivityUpdateValRecorder[0])
+	mScheduleActivityUpdate := mt.NewInt64ValueRecorder(string(nScheduleActivityUpdateValRecorder))
+	boundTimeValueRecorders["ScheduleActivityUpdate"] = mScheduleActivityUpdate.Bind(label.String("name", appName))
+	nScheduleHomePublishValRecorder := []rune("Scheduler_ScheduleHomePublish_ProcessingTimeMillis")
+	nScheduleHomePublishValRecorder[0] = unicode.ToLower(nScheduleHomePublishValRecorder[0])
+	mScheduleHomePublish := mt.NewInt64ValueRecorder(string(nScheduleHomePublishValRecorder))
+	boundTimeValueRecorders["ScheduleHomePublish"] = mScheduleHomePublish.Bind(label.String("name", appName))
+
+	nSchedulePerfectMonthValRecorder := []rune("Scheduler_SchedulePerfectMonth_ProcessingTimeMillis")
+	nSchedulePerfectMonthValRecorder[0] = unicode.ToLower(nSchedulePerfectMonthValRecorder[0])
+	mSchedulePerfectMonth := mt.NewInt64ValueRecorder(string(nSchedulePerfectMonthValRecorder))
+	boundTimeValueRecorders["SchedulePerfectMonth"] = mSchedulePerfectMonth.Bind(label.String("name", appName))
+	return boundTimeValueRecorders
+}
+
+func newSchedulerMethodCounters(suffix string, appName string, meter metric.Meter) (boundCounters map[string]metric.BoundInt64Counter) {
+	boundCounters = make(map[string]metric.BoundInt64Counter)
+	mt := metric.Must(meter)
+	nScheduleCounter := []rune("Scheduler_Schedule_" + suffix)
+	nScheduleCounter[0] = unicode.ToLower(nScheduleCounter[0])
+	cSchedule := mt.NewInt64Counter(string(nScheduleCounter))
+	boundCounters["Schedule"] = cSchedule.Bind(label.String("name", appName))
+	nScheduleTokenSweepCounter := []rune("Scheduler_ScheduleTokenSweep_" + suffix)
+	nScheduleTokenSweepCounter[0] = unicode.ToLower(nScheduleTokenSweepCounter[0])
+	cScheduleTokenSweep := mt.NewInt64Counter(string(nScheduleTokenSweepCounter))
+	boundCounters["ScheduleTokenSweep"] = cScheduleTokenSweep.Bind(label.String("name", appName))
+	nScheduleActivityUpdateCounter := []rune("Scheduler_ScheduleActivityUpdate_" + suffix)
+	nScheduleActivityUpdateCounter[0] = unicode.ToLower(nScheduleActivityUpdateCounter[0])
+	cScheduleActivityUpdate := mt.NewInt64Counter(string(nScheduleActivityUpdateCounter))
+	boundCounters["ScheduleActivityUpdate"] = cScheduleActivityUpdate.Bind(label.String("name", appName))
+	nScheduleHomePublishCounter := []rune("Scheduler_ScheduleHomePublish_" + suffix)
+	nScheduleHomePublishCounter[0] = unicode.ToLower(nScheduleHomePublishCounter[0])
+	cScheduleHomePublish := mt.NewInt64Counter(string(nScheduleHomePublishCounter))
+	boundCounters["ScheduleHomePublish"] = cScheduleHomePublish.Bind(label.String("name", appName))
+
+	nSchedulePerfectMonthCounter := []rune("Scheduler_SchedulePerfectMonth_" + suffix)
+	nSchedulePerfectMonthCounter[0] = unicode.ToLower(nSchedulePerfectMonthCounter[0])
+	cSchedulePerfectMonth := mt.NewInt64Counter(string(nSchedulePerfectMonthCounter))
+	boundCounters["SchedulePerfectMonth"] = cSchedulePerfectMonth.Bind(label.String("name", appName))
+	return boundCounters
+}
+
+// Schedule implements Scheduler
+func (_d SchedulerWithTelemetry) Schedule(ctx context.Context, challengeID ChallengeID, scheduledTime time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["Schedule"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["Schedule"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["Schedule"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.Schedule(ctx, challengeID, scheduledTime)
+}
+
+// ScheduleTokenSweep implements Scheduler
+func (_d SchedulerWithTelemetry) ScheduleTokenSweep(ctx context.Context, scheduledTime time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ScheduleTokenSweep"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ScheduleTokenSweep"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ScheduleTokenSweep"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ScheduleTokenSweep(ctx, scheduledTime)
+}
+
+// ScheduleActivityUpdate implements Scheduler
+func (_d SchedulerWithTelemetry) ScheduleActivityUpdate(ctx context.Context, update ActivityUpdate, scheduledTime time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ScheduleActivityUpdate"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ScheduleActivityUpdate"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ScheduleActivityUpdate"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ScheduleActivityUpdate(ctx, update, scheduledTime)
+}
+
+// ScheduleHomePublish implements Scheduler
+func (_d SchedulerWithTelemetry) ScheduleHomePublish(ctx context.Context, update HomeUpdate, scheduledTime time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ScheduleHomePublish"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ScheduleHomePublish"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ScheduleHomePublish"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ScheduleHomePublish(ctx, update, scheduledTime)
+}
+
+// SchedulePerfectMonth implements Scheduler
+func (_d SchedulerWithTelemetry) SchedulePerfectMonth(ctx context.Context, evaluation PerfectMonthEvaluation, scheduledTime time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["SchedulePerfectMonth"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["SchedulePerfectMonth"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["SchedulePerfectMonth"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.SchedulePerfectMonth(ctx, evaluation, scheduledTime)
+}
@@ -1,9 +1,58 @@
 package stepcurry
 
+import (
+	"time"
+)
+
+const (
+	// slackTokenRefreshMargin is how long before their expiry rotating slack tokens are refreshed
+	slackTokenRefreshMargin = 10 * time.Minute
+)
+
+// SlackToken holds a slack bot token. Tokens of apps with token rotation expire and are refreshed with their refresh
+// token while tokens of apps without token rotation have no refresh token and never expire
+type SlackToken struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Rotating returns true if the token expires and can be refreshed
+func (t SlackToken) Rotating() bool {
+	return t.RefreshToken != "" && !t.Expiry.IsZero()
+}
+
+// NeedsRefresh returns true if a rotating token expires within the refresh margin
+func (t SlackToken) NeedsRefresh(now time.Time) bool {
+	return t.Rotating() && !now.Add(slackTokenRefreshMargin).Before(t.Expiry)
+}
+
+// Secret holds a secret of a team, such as its slack token, encrypted before being handed to storage so that storage
+// backends only ever see the ciphertext
+type Secret struct {
+	Name       string `datastore:"name"`
+	Ciphertext []byte `datastore:"ciphertext,noindex"`
+}
+
 type TokenLoader interface {
-	LoadToken(teamID string) (token string, err error)
+	// LoadToken loads the slack token of a tenant. See Installation.TenantID
+	LoadToken(tenantID string) (token SlackToken, err error)
 }
 
 type TokenSaver interface {
-	SaveToken(teamID string, token string) (err error)
+	// SaveToken saves the slack token of an installation. It's loaded back with the installation's tenant ID
+	SaveToken(installation Installation, token SlackToken) (err error)
+}
+
+// TokenForgetter is implemented by TokenLoaders caching tokens. Routers invalidating a tenant make the loader forget
+// its cached token so that the next route loads it again
+type TokenForgetter interface {
+	// Forget drops the cached token of a tenant
+	Forget(tenantID string)
+}
+
+// TokenRefresher defines the interface for refreshing rotating slack tokens
+type TokenRefresher interface {
+	// RefreshToken exchanges a refresh token for a new token. See https://api.slack.com/authentication/rotation
+	RefreshToken(refreshToken string) (authResp SlackAuthResponse, err error)
 }
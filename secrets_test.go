@@ -0,0 +1,135 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTokenRefresher is a TokenRefresher issuing tokens numbered by refresh
+type fakeTokenRefresher struct {
+	mu        sync.Mutex
+	refreshed []string
+	err       error
+}
+
+func (ftr *fakeTokenRefresher) RefreshToken(refreshToken string) (authResp SlackAuthResponse, err error) {
+	ftr.mu.Lock()
+	defer ftr.mu.Unlock()
+
+	if ftr.err != nil {
+		return authResp, ftr.err
+	}
+
+	ftr.refreshed = append(ftr.refreshed, refreshToken)
+	n := len(ftr.refreshed)
+	return SlackAuthResponse{Ok: true, AccessToken: fmt.Sprintf("xoxe.xoxb-%d", n), RefreshToken: fmt.Sprintf("xoxe-1-r%d", n), ExpiresIn: 43200}, nil
+}
+
+func TestSlackTokenNeedsRefresh(t *testing.T) {
+	now := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		token        SlackToken
+		needsRefresh bool
+	}{
+		"NotRotating":     {token: SlackToken{AccessToken: "xoxb-1"}, needsRefresh: false},
+		"FarFromExpiry":   {token: SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "r", Expiry: now.Add(time.Hour)}, needsRefresh: false},
+		"WithinMargin":    {token: SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "r", Expiry: now.Add(slackTokenRefreshMargin)}, needsRefresh: true},
+		"Expired":         {token: SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "r", Expiry: now.Add(-time.Minute)}, needsRefresh: true},
+		"NoRefreshToken":  {token: SlackToken{AccessToken: "xoxe.xoxb-1", Expiry: now.Add(-time.Minute)}, needsRefresh: false},
+		"JustAfterMargin": {token: SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "r", Expiry: now.Add(slackTokenRefreshMargin + time.Second)}, needsRefresh: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.needsRefresh, tc.token.NeedsRefresh(now))
+		})
+	}
+}
+
+func TestSlackTokenRefresher(t *testing.T) {
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "/api/oauth.v2.access", r.URL.Path)
+		assert.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+		assert.Equal(t, "slackClientID", r.Form.Get("client_id"))
+
+		if r.Form.Get("refresh_token") != "xoxe-1-r1" {
+			w.Write([]byte(`{"ok":false,"error":"invalid_refresh_token"}`))
+			return
+		}
+
+		w.Write([]byte(`{"ok":true,"access_token":"xoxe.xoxb-2","refresh_token":"xoxe-1-r2","expires_in":43200,"token_type":"bot"}`))
+	}))
+	defer slackServer.Close()
+
+	refresher := NewSlackTokenRefresher(slackServer.URL, "slackClientID", "slackClientSecret")
+
+	authResp, err := refresher.RefreshToken("xoxe-1-r1")
+	require.NoError(t, err)
+	now := time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)
+	assert.Equal(t, SlackToken{AccessToken: "xoxe.xoxb-2", RefreshToken: "xoxe-1-r2", Expiry: now.Add(12 * time.Hour)}, authResp.Token(now))
+
+	_, err = refresher.RefreshToken("xoxe-1-r0")
+	assert.EqualError(t, err, "invalid_refresh_token")
+}
+
+func TestMultiTenantRouterRefreshesRotatingTokens(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.PutBotInfo(context.Background(), "E1", BotInfo{UserID: "B1", EnterpriseID: "E1", EnterpriseInstall: true}))
+
+	tokens := tokenRecorder{"E1": SlackToken{AccessToken: "xoxe.xoxb-0", RefreshToken: "xoxe-1-r0", Expiry: clock.Now().Add(time.Hour)}}
+	refresher := &fakeTokenRefresher{}
+
+	mtRouter, err := NewMultiTenantRouter("project", storage, tokens, tokens, false)
+	require.NoError(t, err)
+	mtRouter.clock = clock
+	mtRouter.UseCacheLimits(24*time.Hour, 10)
+	mtRouter.UseTokenRefresher(refresher)
+
+	// The token isn't due for a refresh yet
+	svcs, err := mtRouter.Route("E1")
+	require.NoError(t, err)
+	assert.Empty(t, refresher.refreshed)
+
+	// The services are rebuilt once the token is due for a refresh even though the cache TTL is longer
+	clock.Advance(time.Hour - slackTokenRefreshMargin)
+	refreshedSvcs, err := mtRouter.Route("E1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xoxe-1-r0"}, refresher.refreshed)
+	assert.Equal(t, tokenRecorder{"E1": SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "xoxe-1-r1", Expiry: clock.Now().Add(12 * time.Hour)}}, tokens)
+	assert.NotSame(t, svcs.userInfoFinder, refreshedSvcs.userInfoFinder)
+
+	// The refreshed services are cached
+	_, err = mtRouter.Route("E1")
+	require.NoError(t, err)
+	assert.Len(t, refresher.refreshed, 1)
+
+	// A failed refresh fails the routing rather than using an expired token
+	refresher.err = fmt.Errorf("invalid_refresh_token")
+	clock.Advance(12 * time.Hour)
+	_, err = mtRouter.Route("E1")
+	assert.Error(t, err)
+}
+
+func TestMultiTenantRouterWithoutTokenRefresher(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.PutBotInfo(context.Background(), "T1", BotInfo{UserID: "B1"}))
+
+	tokens := tokenRecorder{"T1": SlackToken{AccessToken: "xoxe.xoxb-0", RefreshToken: "xoxe-1-r0", Expiry: clock.Now().Add(time.Minute)}}
+	mtRouter, err := NewMultiTenantRouter("project", storage, tokens, tokens, false)
+	require.NoError(t, err)
+	mtRouter.clock = clock
+
+	_, err = mtRouter.Route("T1")
+	assert.EqualError(t, err, "token of tenant [T1] is due for a refresh but no token refresher is configured")
+}
@@ -0,0 +1,116 @@
+package secretstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultTokenCacheTTL is how long CachingTokenManager keeps tokens before loading them again
+	DefaultTokenCacheTTL = 6 * time.Hour
+)
+
+// CachingTokenManager is a TokenLoader and TokenSaver that caches the tokens of another, slower, one. Saved tokens are
+// written through and cached. Rotating tokens due for a refresh are loaded again rather than served from the cache in
+// case another instance already refreshed them. Concurrent loads for the same tenant share a single load
+type CachingTokenManager struct {
+	loader stepcurry.TokenLoader
+	saver  stepcurry.TokenSaver
+	ttl    time.Duration
+	clock  stepcurry.Clock
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	// generations is incremented when a tenant's token is saved or forgotten so that loads started before aren't cached
+	generations map[string]uint64
+	loads       singleflight.Group
+}
+
+var _ stepcurry.TokenForgetter = (*CachingTokenManager)(nil)
+
+// cachedToken holds a cached token along with when it expires from the cache
+type cachedToken struct {
+	token   stepcurry.SlackToken
+	expires time.Time
+}
+
+// NewCachingTokenManager creates a new CachingTokenManager caching tokens of loader for ttl. saver can be nil for
+// read-only loaders in which case saving tokens fails
+func NewCachingTokenManager(loader stepcurry.TokenLoader, saver stepcurry.TokenSaver, ttl time.Duration) (cManager *CachingTokenManager) {
+	return &CachingTokenManager{loader: loader, saver: saver, ttl: ttl, clock: stepcurry.NewSystemClock(), tokens: make(map[string]cachedToken), generations: make(map[string]uint64)}
+}
+
+// LoadToken returns the cached token of a tenant or loads it if it isn't cached, has expired or is due for a refresh
+func (cManager *CachingTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	now := cManager.clock.Now()
+
+	cManager.mu.Lock()
+	cached, ok := cManager.tokens[tenantID]
+	generation := cManager.generations[tenantID]
+	cManager.mu.Unlock()
+
+	if ok && now.Before(cached.expires) && !cached.token.NeedsRefresh(now) {
+		return cached.token, nil
+	}
+
+	loaded, err, _ := cManager.loads.Do(tenantID, func() (interface{}, error) {
+		token, err := cManager.loader.LoadToken(tenantID)
+		if err != nil {
+			return token, err
+		}
+
+		cManager.put(tenantID, token, generation)
+		return token, nil
+	})
+	if err != nil {
+		return token, err
+	}
+
+	return loaded.(stepcurry.SlackToken), nil
+}
+
+// SaveToken saves the token of an installation with the underlying saver and caches it
+func (cManager *CachingTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	if cManager.saver == nil {
+		return errors.Errorf("can't save slack token of tenant [%s], tokens are read-only", installation.TenantID())
+	}
+
+	err = cManager.saver.SaveToken(installation, token)
+	if err != nil {
+		return err
+	}
+
+	cManager.mu.Lock()
+	defer cManager.mu.Unlock()
+
+	tenantID := installation.TenantID()
+	cManager.generations[tenantID]++
+	cManager.loads.Forget(tenantID)
+	cManager.tokens[tenantID] = cachedToken{token: token, expires: cManager.clock.Now().Add(cManager.ttl)}
+	return nil
+}
+
+// Forget drops the cached token of a tenant so that it's loaded again on the next LoadToken
+func (cManager *CachingTokenManager) Forget(tenantID string) {
+	cManager.mu.Lock()
+	defer cManager.mu.Unlock()
+
+	cManager.generations[tenantID]++
+	delete(cManager.tokens, tenantID)
+	cManager.loads.Forget(tenantID)
+}
+
+// put caches the token of a tenant unless it was saved or forgotten since generation
+func (cManager *CachingTokenManager) put(tenantID string, token stepcurry.SlackToken, generation uint64) {
+	cManager.mu.Lock()
+	defer cManager.mu.Unlock()
+
+	if cManager.generations[tenantID] != generation {
+		return
+	}
+
+	cManager.tokens[tenantID] = cachedToken{token: token, expires: cManager.clock.Now().Add(cManager.ttl)}
+}
@@ -0,0 +1,225 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingTokenManager is a TokenLoader and TokenSaver keeping tokens in memory and counting loads per tenant. Loads
+// wait for release to be closed
+type countingTokenManager struct {
+	mu      sync.Mutex
+	tokens  map[string]stepcurry.SlackToken
+	loads   map[string]int
+	release chan struct{}
+}
+
+func newCountingTokenManager() (ctManager *countingTokenManager) {
+	ctManager = &countingTokenManager{tokens: make(map[string]stepcurry.SlackToken), loads: make(map[string]int), release: make(chan struct{})}
+	close(ctManager.release)
+
+	return ctManager
+}
+
+func (ctManager *countingTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	<-ctManager.release
+
+	ctManager.mu.Lock()
+	defer ctManager.mu.Unlock()
+
+	ctManager.loads[tenantID]++
+	token, ok := ctManager.tokens[tenantID]
+	if !ok {
+		return token, fmt.Errorf("no token for tenant [%s]", tenantID)
+	}
+
+	return token, nil
+}
+
+func (ctManager *countingTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	ctManager.mu.Lock()
+	defer ctManager.mu.Unlock()
+
+	ctManager.tokens[installation.TenantID()] = token
+	return nil
+}
+
+func (ctManager *countingTokenManager) loadCount(tenantID string) int {
+	ctManager.mu.Lock()
+	defer ctManager.mu.Unlock()
+
+	return ctManager.loads[tenantID]
+}
+
+func newTestCachingTokenManager(clock stepcurry.Clock) (cManager *CachingTokenManager, backend *countingTokenManager) {
+	backend = newCountingTokenManager()
+	cManager = NewCachingTokenManager(backend, backend, time.Hour)
+	cManager.clock = clock
+
+	return cManager, backend
+}
+
+func TestCachingTokenManagerCachesTokens(t *testing.T) {
+	clock := stepcurry.NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	cManager, backend := newTestCachingTokenManager(clock)
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+
+	for i := 0; i < 3; i++ {
+		token, err := cManager.LoadToken("T1")
+		require.NoError(t, err)
+		assert.Equal(t, "xoxb-1", token.AccessToken)
+	}
+	assert.Equal(t, 1, backend.loadCount("T1"))
+
+	clock.Advance(time.Hour)
+	_, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerDoesntCacheErrors(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+
+	_, err := cManager.LoadToken("T1")
+	assert.Error(t, err)
+
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+	token, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-1", token.AccessToken)
+}
+
+func TestCachingTokenManagerWritesThrough(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+
+	require.NoError(t, cManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"}))
+	assert.Equal(t, stepcurry.SlackToken{AccessToken: "xoxb-1"}, backend.tokens["T1"])
+
+	token, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-1", token.AccessToken)
+	assert.Equal(t, 0, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerReloadsTokensDueForRefresh(t *testing.T) {
+	clock := stepcurry.NewFakeClock(time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	cManager, backend := newTestCachingTokenManager(clock)
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "xoxe-1-r1", Expiry: clock.Now().Add(30 * time.Minute)}
+
+	_, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+
+	// Another instance refreshes the token once it's within the refresh margin
+	clock.Advance(25 * time.Minute)
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxe.xoxb-2", RefreshToken: "xoxe-1-r2", Expiry: clock.Now().Add(12 * time.Hour)}
+
+	token, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxe.xoxb-2", token.AccessToken)
+	assert.Equal(t, 2, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerSingleFlightLoading(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+	backend.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cManager.LoadToken("T1")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Give the loads a chance to pile up on the load in flight
+	time.Sleep(20 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerForget(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+
+	_, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+
+	cManager.Forget("T1")
+	_, err = cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerForgetDuringLoad(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+	backend.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := cManager.LoadToken("T1")
+		assert.NoError(t, err)
+	}()
+
+	// Give the load a chance to start before forgetting the token it's loading
+	time.Sleep(20 * time.Millisecond)
+	cManager.Forget("T1")
+	close(backend.release)
+	wg.Wait()
+
+	backend.mu.Lock()
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-2"}
+	backend.mu.Unlock()
+
+	token, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-2", token.AccessToken)
+	assert.Equal(t, 2, backend.loadCount("T1"))
+}
+
+func TestCachingTokenManagerForgottenOnRouterInvalidate(t *testing.T) {
+	cManager, backend := newTestCachingTokenManager(stepcurry.NewSystemClock())
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-1"}
+
+	storage := stepcurry.NewMemoryStorage()
+	require.NoError(t, storage.PutBotInfo(context.Background(), "T1", stepcurry.BotInfo{UserID: "BT1", TeamID: "T1"}))
+
+	router, err := stepcurry.NewMultiTenantRouter("project", storage, cManager, cManager, false)
+	require.NoError(t, err)
+
+	_, err = router.Route("T1")
+	require.NoError(t, err)
+
+	// A token replaced by a reinstall is picked up by the next route
+	backend.tokens["T1"] = stepcurry.SlackToken{AccessToken: "xoxb-2"}
+	router.Invalidate("T1")
+
+	_, err = router.Route("T1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.loadCount("T1"))
+
+	token, err := cManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-2", token.AccessToken)
+}
+
+func TestCachingTokenManagerReadOnly(t *testing.T) {
+	cManager := NewCachingTokenManager(NewEnvTokenLoader("SLACK_TOKEN", func(string) string { return "xoxb-1" }), nil, time.Hour)
+
+	err := cManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-2"})
+	assert.EqualError(t, err, "can't save slack token of tenant [T1], tokens are read-only")
+}
@@ -0,0 +1,35 @@
+package secretstore
+
+import (
+	"strings"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+)
+
+// EnvTokenLoader is a TokenLoader reading slack tokens from environment variables. The token of a tenant is read from
+// <prefix>_<TENANT ID> and falls back to <prefix> so that single-tenant deployments only need to set the latter.
+// Tokens read from the environment can't be rotating tokens since refreshed tokens couldn't be saved back
+type EnvTokenLoader struct {
+	prefix string
+	getenv func(string) string
+}
+
+// NewEnvTokenLoader creates a new EnvTokenLoader reading variables with getenv, usually os.Getenv
+func NewEnvTokenLoader(prefix string, getenv func(string) string) (envLoader *EnvTokenLoader) {
+	return &EnvTokenLoader{prefix: prefix, getenv: getenv}
+}
+
+// LoadToken loads the slack token of a tenant
+func (envLoader *EnvTokenLoader) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	tenantVar := envLoader.prefix + "_" + strings.ToUpper(tenantID)
+	if value := envLoader.getenv(tenantVar); value != "" {
+		return stepcurry.SlackToken{AccessToken: value}, nil
+	}
+
+	if value := envLoader.getenv(envLoader.prefix); value != "" {
+		return stepcurry.SlackToken{AccessToken: value}, nil
+	}
+
+	return token, errors.Errorf("no slack token for tenant [%s], neither %s nor %s is set", tenantID, tenantVar, envLoader.prefix)
+}
@@ -0,0 +1,26 @@
+package secretstore
+
+import (
+	"testing"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvTokenLoader(t *testing.T) {
+	env := map[string]string{"SLACK_TOKEN": "xoxb-default", "SLACK_TOKEN_T2": "xoxb-2"}
+	envLoader := NewEnvTokenLoader("SLACK_TOKEN", func(name string) string { return env[name] })
+
+	token, err := envLoader.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, stepcurry.SlackToken{AccessToken: "xoxb-default"}, token)
+
+	token, err = envLoader.LoadToken("t2")
+	require.NoError(t, err)
+	assert.Equal(t, stepcurry.SlackToken{AccessToken: "xoxb-2"}, token)
+
+	delete(env, "SLACK_TOKEN")
+	_, err = envLoader.LoadToken("T1")
+	assert.EqualError(t, err, "no slack token for tenant [T1], neither SLACK_TOKEN_T1 nor SLACK_TOKEN is set")
+}
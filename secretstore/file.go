@@ -0,0 +1,99 @@
+package secretstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+)
+
+// FileTokenManager is a TokenLoader and TokenSaver keeping slack tokens in a json file mapping tenant IDs to their
+// token. It's meant for single-process, self-hosted deployments: the file is only readable by its owner but tokens
+// aren't encrypted
+type FileTokenManager struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileTokenManager creates a new FileTokenManager keeping tokens in the file at path. The file is created on the
+// first saved token
+func NewFileTokenManager(path string) (fManager *FileTokenManager) {
+	return &FileTokenManager{path: path}
+}
+
+// LoadToken loads the slack token of a tenant
+func (fManager *FileTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	fManager.mu.Lock()
+	defer fManager.mu.Unlock()
+
+	tokens, err := fManager.read()
+	if err != nil {
+		return token, err
+	}
+
+	token, ok := tokens[tenantID]
+	if !ok {
+		return token, errors.Errorf("no slack token for tenant [%s] in %s", tenantID, fManager.path)
+	}
+
+	return token, nil
+}
+
+// SaveToken saves the slack token of an installation under its tenant ID. The file is replaced atomically so that
+// it's never left partially written
+func (fManager *FileTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	fManager.mu.Lock()
+	defer fManager.mu.Unlock()
+
+	tokens, err := fManager.read()
+	if err != nil {
+		return err
+	}
+
+	tokens[installation.TenantID()] = token
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fManager.path), filepath.Base(fManager.path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "error saving slack tokens to %s", fManager.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error saving slack tokens to %s", fManager.path)
+	}
+
+	err = os.Rename(tmp.Name(), fManager.path)
+	return errors.Wrapf(err, "error saving slack tokens to %s", fManager.path)
+}
+
+// read reads all tokens from the file. A missing file holds no tokens
+func (fManager *FileTokenManager) read() (tokens map[string]stepcurry.SlackToken, err error) {
+	tokens = make(map[string]stepcurry.SlackToken)
+
+	content, err := ioutil.ReadFile(fManager.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading slack tokens from %s", fManager.path)
+	}
+
+	err = json.Unmarshal(content, &tokens)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid slack tokens file %s", fManager.path)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,59 @@
+package secretstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTokenManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secretstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	fManager := NewFileTokenManager(path)
+
+	_, err = fManager.LoadToken("T1")
+	assert.EqualError(t, err, "no slack token for tenant [T1] in "+path)
+
+	rotating := stepcurry.SlackToken{AccessToken: "xoxe.xoxb-2", RefreshToken: "xoxe-1-r2", Expiry: time.Date(2020, 6, 1, 21, 30, 0, 0, time.UTC)}
+	require.NoError(t, fManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"}))
+	require.NoError(t, fManager.SaveToken(stepcurry.Installation{EnterpriseID: "E1", EnterpriseInstall: true}, rotating))
+
+	// Tokens are read back from the file by other instances
+	other := NewFileTokenManager(path)
+	token, err := other.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, stepcurry.SlackToken{AccessToken: "xoxb-1"}, token)
+
+	token, err = other.LoadToken("E1")
+	require.NoError(t, err)
+	assert.Equal(t, rotating, token)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFileTokenManagerInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secretstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte("xoxb-1"), 0600))
+
+	_, err = NewFileTokenManager(path).LoadToken("T1")
+	assert.Error(t, err)
+}
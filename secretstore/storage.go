@@ -0,0 +1,106 @@
+// Package secretstore implements Step Curry's TokenLoader and TokenSaver on top of secret backends other than Google
+// Secret Manager: the Step Curry storage with encryption, a local file or the environment for single-tenant
+// self-hosting and HashiCorp Vault. CachingTokenManager wraps any of them to avoid hitting the backend on every request
+package secretstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// slackTokenSecretName is the name of the secret holding the slack token of a tenant
+	slackTokenSecretName = "slackToken"
+	// EncryptionKeySize is the size of the AES-256 keys encrypting secrets kept in storage
+	EncryptionKeySize = 32
+)
+
+// StorageTokenManager is a TokenLoader and TokenSaver keeping slack tokens in the Step Curry storage. Tokens are
+// encrypted with AES-GCM before being handed to storage and authenticated with their tenant ID so that a token copied
+// over to another tenant fails to decrypt
+type StorageTokenManager struct {
+	storage stepcurry.Storage
+	aead    cipher.AEAD
+}
+
+// NewStorageTokenManager creates a new StorageTokenManager encrypting tokens with a key of EncryptionKeySize bytes
+func NewStorageTokenManager(storage stepcurry.Storage, key []byte) (stManager *StorageTokenManager, err error) {
+	if len(key) != EncryptionKeySize {
+		return nil, errors.Errorf("encryption key must be %d bytes but is %d", EncryptionKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StorageTokenManager{storage: storage, aead: aead}, nil
+}
+
+// DecodeEncryptionKey decodes a base64-encoded encryption key, as generated with `openssl rand -base64 32`
+func DecodeEncryptionKey(encoded string) (key []byte, err error) {
+	key, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base64 encryption key")
+	}
+
+	if len(key) != EncryptionKeySize {
+		return nil, errors.Errorf("encryption key must be %d bytes but is %d", EncryptionKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// LoadToken loads and decrypts the slack token of a tenant
+func (stManager *StorageTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	secret, err := stManager.storage.GetSecret(context.Background(), tenantID, slackTokenSecretName)
+	if err != nil {
+		return token, errors.Wrapf(err, "error loading slack token of tenant [%s]", tenantID)
+	}
+
+	nonceSize := stManager.aead.NonceSize()
+	if len(secret.Ciphertext) < nonceSize {
+		return token, errors.Errorf("slack token of tenant [%s] is corrupted", tenantID)
+	}
+
+	plaintext, err := stManager.aead.Open(nil, secret.Ciphertext[:nonceSize], secret.Ciphertext[nonceSize:], []byte(tenantID))
+	if err != nil {
+		return token, errors.Wrapf(err, "error decrypting slack token of tenant [%s]", tenantID)
+	}
+
+	err = json.Unmarshal(plaintext, &token)
+	return token, err
+}
+
+// SaveToken encrypts and saves the slack token of an installation under its tenant ID
+func (stManager *StorageTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, stManager.aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+
+	tenantID := installation.TenantID()
+	ciphertext := stManager.aead.Seal(nonce, nonce, plaintext, []byte(tenantID))
+
+	err = stManager.storage.PutSecret(context.Background(), tenantID, stepcurry.Secret{Name: slackTokenSecretName, Ciphertext: ciphertext})
+	return errors.Wrapf(err, "error saving slack token of tenant [%s]", tenantID)
+}
@@ -0,0 +1,82 @@
+package secretstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorageTokenManager(t *testing.T) (stManager *StorageTokenManager, storage *stepcurry.MemoryStorage) {
+	storage = stepcurry.NewMemoryStorage()
+	stManager, err := NewStorageTokenManager(storage, bytes.Repeat([]byte{7}, EncryptionKeySize))
+	require.NoError(t, err)
+
+	return stManager, storage
+}
+
+func TestStorageTokenManagerSavesAndLoadsTokens(t *testing.T) {
+	stManager, storage := newTestStorageTokenManager(t)
+
+	token := stepcurry.SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "xoxe-1-r1", Expiry: time.Date(2020, 6, 1, 21, 30, 0, 0, time.UTC)}
+	require.NoError(t, stManager.SaveToken(stepcurry.Installation{EnterpriseID: "E1", EnterpriseInstall: true}, token))
+
+	loaded, err := stManager.LoadToken("E1")
+	require.NoError(t, err)
+	assert.Equal(t, token, loaded)
+
+	secret, err := storage.GetSecret(context.Background(), "E1", slackTokenSecretName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(secret.Ciphertext), "xoxe.xoxb-1")
+}
+
+func TestStorageTokenManagerMissingToken(t *testing.T) {
+	stManager, _ := newTestStorageTokenManager(t)
+
+	_, err := stManager.LoadToken("T1")
+	assert.Error(t, err)
+}
+
+func TestStorageTokenManagerRejectsTokensOfOtherTenants(t *testing.T) {
+	stManager, storage := newTestStorageTokenManager(t)
+	require.NoError(t, stManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"}))
+
+	secret, err := storage.GetSecret(context.Background(), "T1", slackTokenSecretName)
+	require.NoError(t, err)
+	require.NoError(t, storage.PutSecret(context.Background(), "T2", secret))
+
+	_, err = stManager.LoadToken("T2")
+	assert.Error(t, err)
+}
+
+func TestStorageTokenManagerRejectsOtherKeys(t *testing.T) {
+	stManager, storage := newTestStorageTokenManager(t)
+	require.NoError(t, stManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"}))
+
+	otherManager, err := NewStorageTokenManager(storage, bytes.Repeat([]byte{8}, EncryptionKeySize))
+	require.NoError(t, err)
+
+	_, err = otherManager.LoadToken("T1")
+	assert.Error(t, err)
+}
+
+func TestNewStorageTokenManagerInvalidKey(t *testing.T) {
+	_, err := NewStorageTokenManager(stepcurry.NewMemoryStorage(), []byte("short"))
+	assert.EqualError(t, err, "encryption key must be 32 bytes but is 5")
+}
+
+func TestDecodeEncryptionKey(t *testing.T) {
+	key, err := DecodeEncryptionKey("BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{7}, EncryptionKeySize), key)
+
+	_, err = DecodeEncryptionKey("c2hvcnQ=")
+	assert.EqualError(t, err, "encryption key must be 32 bytes but is 5")
+
+	_, err = DecodeEncryptionKey("not base64!")
+	assert.Error(t, err)
+}
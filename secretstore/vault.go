@@ -0,0 +1,124 @@
+package secretstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// vaultTokenHeader is the header authenticating requests to vault
+	vaultTokenHeader = "X-Vault-Token"
+	// vaultRequestTimeout bounds the time spent on a request to vault
+	vaultRequestTimeout = 10 * time.Second
+)
+
+// VaultTokenManager is a TokenLoader and TokenSaver keeping slack tokens in a HashiCorp Vault KV version 2 secrets
+// engine. The token of a tenant is kept at <pathPrefix>/<tenant ID> in the engine mounted at mount. See
+// https://www.vaultproject.io/api-docs/secret/kv/kv-v2
+type VaultTokenManager struct {
+	address    string
+	vaultToken string
+	mount      string
+	pathPrefix string
+	client     *http.Client
+}
+
+// vaultSecret is the body of KV version 2 writes and the data of its reads
+type vaultSecret struct {
+	Data stepcurry.SlackToken `json:"data"`
+}
+
+// vaultReadResponse is the response of KV version 2 reads
+type vaultReadResponse struct {
+	Data vaultSecret `json:"data"`
+}
+
+// NewVaultTokenManager creates a new VaultTokenManager for the vault server at address (i.e. http://127.0.0.1:8200)
+// authenticating with vaultToken
+func NewVaultTokenManager(address string, vaultToken string, mount string, pathPrefix string) (vManager *VaultTokenManager) {
+	return &VaultTokenManager{address: strings.TrimSuffix(address, "/"), vaultToken: vaultToken, mount: strings.Trim(mount, "/"), pathPrefix: strings.Trim(pathPrefix, "/"), client: &http.Client{Timeout: vaultRequestTimeout}}
+}
+
+// LoadToken loads the slack token of a tenant
+func (vManager *VaultTokenManager) LoadToken(tenantID string) (token stepcurry.SlackToken, err error) {
+	body, err := vManager.do(http.MethodGet, tenantID, nil)
+	if err != nil {
+		return token, errors.Wrapf(err, "error loading slack token of tenant [%s] from vault", tenantID)
+	}
+
+	var readResp vaultReadResponse
+	err = json.Unmarshal(body, &readResp)
+	if err != nil {
+		return token, errors.Wrapf(err, "error decoding slack token of tenant [%s] from vault", tenantID)
+	}
+
+	if readResp.Data.Data.AccessToken == "" {
+		return token, errors.Errorf("vault secret of tenant [%s] has no slack token", tenantID)
+	}
+
+	return readResp.Data.Data, nil
+}
+
+// SaveToken saves the slack token of an installation under its tenant ID as a new version of its secret
+func (vManager *VaultTokenManager) SaveToken(installation stepcurry.Installation, token stepcurry.SlackToken) (err error) {
+	content, err := json.Marshal(vaultSecret{Data: token})
+	if err != nil {
+		return err
+	}
+
+	_, err = vManager.do(http.MethodPost, installation.TenantID(), content)
+	return errors.Wrapf(err, "error saving slack token of tenant [%s] to vault", installation.TenantID())
+}
+
+// do sends a request for the secret of a tenant and returns the response body
+func (vManager *VaultTokenManager) do(method string, tenantID string, content []byte) (body []byte, err error) {
+	secretURL := fmt.Sprintf("%s/v1/%s/data/%s", vManager.address, vManager.mount, vManager.secretPath(tenantID))
+
+	req, err := http.NewRequest(method, secretURL, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(vaultTokenHeader, vManager.vaultToken)
+	if content != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := vManager.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, stepcurry.ErrNoSuchEntity
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("vault error [%s]: %s", resp.Status, body)
+	}
+
+	return body, nil
+}
+
+// secretPath returns the path of the secret of a tenant within the secrets engine
+func (vManager *VaultTokenManager) secretPath(tenantID string) (path string) {
+	if vManager.pathPrefix == "" {
+		return tenantID
+	}
+
+	return vManager.pathPrefix + "/" + tenantID
+}
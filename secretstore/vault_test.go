@@ -0,0 +1,117 @@
+package secretstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Environment variables of a vault dev server to run the tests against (i.e. started with `vault server -dev`). Tests
+// against a vault server are skipped when they're not set
+const (
+	vaultAddrEnv  = "VAULT_ADDR"
+	vaultTokenEnv = "VAULT_TOKEN"
+)
+
+// fakeVault is a minimal KV version 2 secrets engine mounted at secret/
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]json.RawMessage
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(vaultTokenHeader) != "root" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := fv.secrets[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(`{"data":{"data":` + string(data) + `,"metadata":{"version":1}}}`))
+	case http.MethodPost:
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		content, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(content, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fv.secrets[r.URL.Path] = body.Data
+		w.Write([]byte(`{"data":{"version":1}}`))
+	}
+}
+
+func testVaultTokenManager(t *testing.T, vManager *VaultTokenManager) {
+	_, err := vManager.LoadToken("T404")
+	assert.Error(t, err)
+
+	rotating := stepcurry.SlackToken{AccessToken: "xoxe.xoxb-1", RefreshToken: "xoxe-1-r1", Expiry: time.Date(2020, 6, 1, 21, 30, 0, 0, time.UTC)}
+	require.NoError(t, vManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"}))
+	require.NoError(t, vManager.SaveToken(stepcurry.Installation{EnterpriseID: "E1", EnterpriseInstall: true}, rotating))
+
+	token, err := vManager.LoadToken("T1")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-1", token.AccessToken)
+	assert.False(t, token.Rotating())
+
+	token, err = vManager.LoadToken("E1")
+	require.NoError(t, err)
+	assert.Equal(t, rotating.AccessToken, token.AccessToken)
+	assert.Equal(t, rotating.RefreshToken, token.RefreshToken)
+	assert.True(t, rotating.Expiry.Equal(token.Expiry))
+}
+
+func TestVaultTokenManager(t *testing.T) {
+	vault := &fakeVault{secrets: make(map[string]json.RawMessage)}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	testVaultTokenManager(t, NewVaultTokenManager(server.URL+"/", "root", "secret", "stepcurry/tokens/"))
+
+	_, ok := vault.secrets["/v1/secret/data/stepcurry/tokens/T1"]
+	assert.True(t, ok)
+}
+
+func TestVaultTokenManagerPermissionDenied(t *testing.T) {
+	server := httptest.NewServer(&fakeVault{secrets: make(map[string]json.RawMessage)})
+	defer server.Close()
+
+	vManager := NewVaultTokenManager(server.URL, "wrong", "secret", "")
+
+	_, err := vManager.LoadToken("T1")
+	assert.Contains(t, err.Error(), "403 Forbidden")
+
+	err = vManager.SaveToken(stepcurry.Installation{TeamID: "T1"}, stepcurry.SlackToken{AccessToken: "xoxb-1"})
+	assert.Contains(t, err.Error(), "permission denied")
+}
+
+func TestVaultTokenManagerWithDevServer(t *testing.T) {
+	address := os.Getenv(vaultAddrEnv)
+	vaultToken := os.Getenv(vaultTokenEnv)
+	if address == "" || vaultToken == "" {
+		t.Skipf("%s and %s not set", vaultAddrEnv, vaultTokenEnv)
+	}
+
+	// Dev servers mount a KV version 2 engine at secret/
+	testVaultTokenManager(t, NewVaultTokenManager(address, vaultToken, "secret", "stepcurry-test/"+time.Now().Format("20060102150405.000000000")))
+}
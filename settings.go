@@ -0,0 +1,558 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics challenge participants can be ranked by
+const (
+	metricSteps  = "steps"
+	metricFloors = "floors"
+)
+
+// Opt-in modes
+const (
+	// optInAutomatic includes every channel member with a linked Fitbit account in a challenge
+	optInAutomatic = "automatic"
+	// optInExplicit only includes the channel members who joined a challenge
+	optInExplicit = "explicit"
+)
+
+// Who's allowed to start challenges
+const (
+	startersEveryone = "everyone"
+	startersAdmins   = "admins"
+)
+
+// Default settings
+const (
+	defaultTimezone              = "America/Los_Angeles"
+	defaultUpdateIntervalMinutes = 60
+	defaultQuietHoursStart       = 19
+	defaultQuietHoursEnd         = 8
+)
+
+// Settings modal identifiers. The block IDs are the names of the settings so that validation errors can be shown
+// next to the setting they're about
+const (
+	settingsCallbackID        = "step-config"
+	settingsValueActionID     = "value"
+	settingTimezone           = "timezone"
+	settingUpdateInterval     = "updateInterval"
+	settingQuietHoursStart    = "quietHoursStart"
+	settingQuietHoursEnd      = "quietHoursEnd"
+	settingMetric             = "metric"
+	settingOptInMode          = "optInMode"
+	settingLanguage           = "language"
+	settingChallengeStarters  = "challengeStarters"
+	settingDailyChallengeCap  = "dailyChallengeCap"
+	settingChannelRestriction = "channelRestrictions"
+	minUpdateIntervalMinutes  = 30
+	maxUpdateIntervalMinutes  = 240
+	minQuietHoursEnd          = 5
+	maxQuietHoursEnd          = 11
+	maxQuietHoursStart        = 23
+	maxDailyChallengeCap      = 10
+	commandConfigDescription  = "Step Curry settings"
+	commandConfigSubmitButton = "Save"
+)
+
+// updateIntervalOptions are the challenge update cadences offered in the settings modal, in minutes
+var updateIntervalOptions = []int{30, 60, 120, 240}
+
+// dailyChallengeCapOptions are the caps on challenges started per user per day offered in the settings modal. Zero
+// means there's no cap
+var dailyChallengeCapOptions = []int{0, 1, 2, 3, 5}
+
+// languageNames are the names of the supported languages as shown in the settings modal
+var languageNames = map[string]string{
+	languageEnglish:  "English",
+	languageFrench:   "Français",
+	languageJapanese: "日本語",
+}
+
+// WorkspaceSettings holds the settings of a workspace. Settings left to their zero value take their default
+type WorkspaceSettings struct {
+	TeamID string `datastore:"teamID"`
+	// Timezone is the IANA timezone of challenges. It sets the day of a challenge and the hours it's updated at
+	Timezone string `datastore:"timezone,noindex"`
+	// UpdateIntervalMinutes is the cadence of challenge updates during the day
+	UpdateIntervalMinutes int `datastore:"updateIntervalMinutes,noindex"`
+	// QuietHoursStart is the local hour at which challenge updates stop for the day
+	QuietHoursStart int `datastore:"quietHoursStart,noindex"`
+	// QuietHoursEnd is the local hour the morning after a challenge at which its winner is announced
+	QuietHoursEnd int `datastore:"quietHoursEnd,noindex"`
+	// Metric is what challenge participants are ranked by, steps or floors
+	Metric string `datastore:"metric,noindex"`
+	// OptInMode is automatic if every linked channel member takes part in challenges or explicit if they have to join
+	OptInMode string `datastore:"optInMode,noindex"`
+	// Language is the language of messages that aren't responses to a user. Empty keeps the default language of the
+	// workspace's messages
+	Language string `datastore:"language,noindex"`
+	// ChallengeStarters is who's allowed to start challenges, everyone or admins
+	ChallengeStarters string `datastore:"challengeStarters,noindex"`
+	// DailyChallengeCap is the number of challenges a user who isn't an admin can start in a day. Zero means no cap
+	DailyChallengeCap int `datastore:"dailyChallengeCap,noindex"`
+	// ChannelRestrictions restrict who can start challenges in some channels, overriding ChallengeStarters
+	ChannelRestrictions []ChannelRestriction `datastore:"channelRestrictions,noindex"`
+}
+
+// ChannelRestriction restricts starting challenges in a channel to members of user groups. Admins are always allowed
+// so a restriction without user groups limits the channel to admins
+type ChannelRestriction struct {
+	ChannelID  string   `datastore:"channelID,noindex"`
+	UserGroups []string `datastore:"userGroups,noindex"`
+}
+
+// channelRestriction returns the restriction of a channel, if it has one
+func (s WorkspaceSettings) channelRestriction(channelID string) (restriction ChannelRestriction, ok bool) {
+	for _, r := range s.ChannelRestrictions {
+		if r.ChannelID == channelID {
+			return r, true
+		}
+	}
+
+	return restriction, false
+}
+
+// withChannelRestriction returns the settings with a channel's restriction replaced. A nil restriction removes it
+func (s WorkspaceSettings) withChannelRestriction(channelID string, restriction *ChannelRestriction) WorkspaceSettings {
+	restrictions := make([]ChannelRestriction, 0, len(s.ChannelRestrictions)+1)
+	for _, r := range s.ChannelRestrictions {
+		if r.ChannelID != channelID {
+			restrictions = append(restrictions, r)
+		}
+	}
+
+	if restriction != nil {
+		restrictions = append(restrictions, *restriction)
+	}
+
+	s.ChannelRestrictions = restrictions
+	return s
+}
+
+// DefaultWorkspaceSettings returns the settings of a workspace that hasn't changed any
+func DefaultWorkspaceSettings(teamID string) (settings WorkspaceSettings) {
+	return WorkspaceSettings{TeamID: teamID}.withDefaults()
+}
+
+// withDefaults returns the settings with unset values replaced by their default
+func (s WorkspaceSettings) withDefaults() WorkspaceSettings {
+	if s.Timezone == "" {
+		s.Timezone = defaultTimezone
+	}
+
+	if s.UpdateIntervalMinutes == 0 {
+		s.UpdateIntervalMinutes = defaultUpdateIntervalMinutes
+	}
+
+	if s.QuietHoursStart == 0 {
+		s.QuietHoursStart = defaultQuietHoursStart
+	}
+
+	if s.QuietHoursEnd == 0 {
+		s.QuietHoursEnd = defaultQuietHoursEnd
+	}
+
+	if s.Metric == "" {
+		s.Metric = metricSteps
+	}
+
+	if s.OptInMode == "" {
+		s.OptInMode = optInAutomatic
+	}
+
+	if s.ChallengeStarters == "" {
+		s.ChallengeStarters = startersEveryone
+	}
+
+	return s
+}
+
+// InvalidSettingError is returned when a setting has an invalid value
+type InvalidSettingError struct {
+	// Setting is the name of the invalid setting
+	Setting string
+	// Reason explains why the value is invalid
+	Reason string
+}
+
+func (e InvalidSettingError) Error() string {
+	return fmt.Sprintf("invalid %s setting: %s", e.Setting, e.Reason)
+}
+
+// Validate returns an InvalidSettingError for the first setting with an invalid value. Unset values are valid since
+// they take their default
+func (s WorkspaceSettings) Validate() (err error) {
+	s = s.withDefaults()
+
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return InvalidSettingError{Setting: settingTimezone, Reason: fmt.Sprintf("unknown timezone [%s]", s.Timezone)}
+	}
+
+	if s.UpdateIntervalMinutes < minUpdateIntervalMinutes || s.UpdateIntervalMinutes > maxUpdateIntervalMinutes {
+		return InvalidSettingError{Setting: settingUpdateInterval, Reason: fmt.Sprintf("updates must be between %d and %d minutes apart", minUpdateIntervalMinutes, maxUpdateIntervalMinutes)}
+	}
+
+	// Quiet hours can't start before streak reminders are sent on the last updates of the day
+	if s.QuietHoursStart <= reminderHour || s.QuietHoursStart > maxQuietHoursStart {
+		return InvalidSettingError{Setting: settingQuietHoursStart, Reason: fmt.Sprintf("quiet hours must start between %d:00 and %d:00", reminderHour+1, maxQuietHoursStart)}
+	}
+
+	if s.QuietHoursEnd < minQuietHoursEnd || s.QuietHoursEnd > maxQuietHoursEnd {
+		return InvalidSettingError{Setting: settingQuietHoursEnd, Reason: fmt.Sprintf("quiet hours must end between %d:00 and %d:00", minQuietHoursEnd, maxQuietHoursEnd)}
+	}
+
+	if s.Metric != metricSteps && s.Metric != metricFloors {
+		return InvalidSettingError{Setting: settingMetric, Reason: fmt.Sprintf("unknown metric [%s], should be one of %s, %s", s.Metric, metricSteps, metricFloors)}
+	}
+
+	if s.OptInMode != optInAutomatic && s.OptInMode != optInExplicit {
+		return InvalidSettingError{Setting: settingOptInMode, Reason: fmt.Sprintf("unknown opt-in mode [%s], should be one of %s, %s", s.OptInMode, optInAutomatic, optInExplicit)}
+	}
+
+	if _, ok := messageCatalogs[s.Language]; s.Language != "" && !ok {
+		return InvalidSettingError{Setting: settingLanguage, Reason: unsupportedLanguageError(s.Language).Error()}
+	}
+
+	if s.ChallengeStarters != startersEveryone && s.ChallengeStarters != startersAdmins {
+		return InvalidSettingError{Setting: settingChallengeStarters, Reason: fmt.Sprintf("unknown challenge starters [%s], should be one of %s, %s", s.ChallengeStarters, startersEveryone, startersAdmins)}
+	}
+
+	if s.DailyChallengeCap < 0 || s.DailyChallengeCap > maxDailyChallengeCap {
+		return InvalidSettingError{Setting: settingDailyChallengeCap, Reason: fmt.Sprintf("the daily cap must be between 0 and %d challenges", maxDailyChallengeCap)}
+	}
+
+	for _, r := range s.ChannelRestrictions {
+		if r.ChannelID == "" {
+			return InvalidSettingError{Setting: settingChannelRestriction, Reason: "channel restrictions must have a channel"}
+		}
+	}
+
+	return nil
+}
+
+// location returns the location of the settings' timezone, falling back to the default timezone if it can't be loaded
+func (s WorkspaceSettings) location(logger Logger) (timezoneID string, location *time.Location, err error) {
+	location, err = time.LoadLocation(s.Timezone)
+	if err != nil {
+		newLogEntry(logger).with(Fields{teamIDField: s.TeamID}).withError(err).warningf("Error loading timezone [%s], using [%s]", s.Timezone, defaultTimezone)
+
+		location, err = time.LoadLocation(defaultTimezone)
+		return defaultTimezone, location, err
+	}
+
+	return s.Timezone, location, nil
+}
+
+// loadSettings loads the settings of a team. Stored settings that are invalid are ignored in favor of the defaults
+// so that a bad value doesn't keep challenges from running
+func loadSettings(storage Storage, teamID string, logger Logger) (settings WorkspaceSettings, err error) {
+	settings, err = storage.GetWorkspaceSettings(context.Background(), teamID)
+	if err == ErrNoSuchEntity {
+		return DefaultWorkspaceSettings(teamID), nil
+	} else if err != nil {
+		return settings, errors.Wrapf(err, "error loading settings for team [%s]", teamID)
+	}
+
+	err = settings.Validate()
+	if err != nil {
+		newLogEntry(logger).with(Fields{teamIDField: teamID}).withError(err).warningf("Error applying settings, using the defaults")
+		return DefaultWorkspaceSettings(teamID), nil
+	}
+
+	return settings.withDefaults(), nil
+}
+
+// loadTeamConfig loads the message customizations and settings of a team. The language of the settings, when set,
+// takes precedence over the default language of the messages
+func loadTeamConfig(storage Storage, teamID string, logger Logger) (messages *Messages, settings WorkspaceSettings, err error) {
+	messages, err = loadMessages(storage, teamID, logger)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	settings, err = loadSettings(storage, teamID, logger)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	if settings.Language != "" {
+		messages = messages.forLocale(settings.Language)
+	}
+
+	return messages, settings, nil
+}
+
+// SaveWorkspaceSettings validates and persists the settings of a workspace. The team's routed services are invalidated
+// so that the new settings apply to the next request
+func (sc *StepCurry) SaveWorkspaceSettings(ctx context.Context, settings WorkspaceSettings) (err error) {
+	err = settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = sc.storage.PutWorkspaceSettings(ctx, settings)
+	if err != nil {
+		return err
+	}
+
+	sc.Invalidate(settings.TeamID)
+	return nil
+}
+
+// isAdmin returns true if a user is an admin or owner of the workspace
+func isAdmin(svcs TeamServices, userID string) (admin bool, err error) {
+	userInfo, err := svcs.userInfoFinder.GetUserInfo(userID)
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting user info for [%s]", userID)
+	}
+
+	return userInfo.IsAdmin || userInfo.IsOwner, nil
+}
+
+// Config handles an incoming slack request in response to a user invoking /step-config and opens the modal to change
+// the settings of the workspace. Only admins can change settings, other users get an ephemeral message saying so
+func (sc *StepCurry) Config(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Parse the slack payload to get the originating context (team, user)
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	admin, err := isAdmin(svcs, userID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error checking if user [%s] is an admin", userID), http.StatusInternalServerError)
+	}
+
+	if !admin {
+		adminOnlyMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: svcs.userMessages(userID).render(msgConfigAdminOnly, messageData{"Command": sc.slashCommands.Config})}
+		return sendResponse(responseURL, adminOnlyMsg, "admin only")
+	}
+
+	if args := strings.Fields(params[textParam]); len(args) > 0 {
+		return sc.configureChannel(svcs, params, args)
+	}
+
+	err = sc.openSettingsModal(svcs, teamID, params[triggerIDParam])
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error opening settings modal for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// openSettingsModal opens the modal to change the settings of a workspace in response to an interaction
+func (sc *StepCurry) openSettingsModal(svcs TeamServices, teamID string, triggerID string) (err error) {
+	if svcs.viewOpener == nil {
+		return fmt.Errorf("no view opener configured for team [%s]", teamID)
+	}
+
+	_, err = svcs.viewOpener.OpenView(triggerID, renderSettingsModal(svcs.settings, svcs.messages.language))
+	return err
+}
+
+// renderSettingsModal renders the modal to change the settings of a workspace with the current settings selected
+func renderSettingsModal(settings WorkspaceSettings, language string) (modal slack.ModalViewRequest) {
+	if settings.Language != "" {
+		language = settings.Language
+	}
+
+	intervals := make([]*slack.OptionBlockObject, 0, len(updateIntervalOptions))
+	for _, minutes := range updateIntervalOptions {
+		text := fmt.Sprintf("Every %d minutes", minutes)
+		if minutes%60 == 0 {
+			text = fmt.Sprintf("Every %d hours", minutes/60)
+			if minutes == 60 {
+				text = "Every hour"
+			}
+		}
+		intervals = append(intervals, settingOption(strconv.Itoa(minutes), text))
+	}
+
+	quietStarts := make([]*slack.OptionBlockObject, 0)
+	for hour := reminderHour + 1; hour <= maxQuietHoursStart; hour++ {
+		quietStarts = append(quietStarts, settingOption(strconv.Itoa(hour), fmt.Sprintf("%d:00", hour)))
+	}
+
+	quietEnds := make([]*slack.OptionBlockObject, 0)
+	for hour := minQuietHoursEnd; hour <= maxQuietHoursEnd; hour++ {
+		quietEnds = append(quietEnds, settingOption(strconv.Itoa(hour), fmt.Sprintf("%d:00", hour)))
+	}
+
+	caps := make([]*slack.OptionBlockObject, 0, len(dailyChallengeCapOptions))
+	for _, c := range dailyChallengeCapOptions {
+		text := fmt.Sprintf("%d per day", c)
+		if c == 0 {
+			text = "No limit"
+		}
+		caps = append(caps, settingOption(strconv.Itoa(c), text))
+	}
+
+	languages := make([]*slack.OptionBlockObject, 0, len(languageNames))
+	for _, l := range Languages() {
+		languages = append(languages, settingOption(l, languageNames[l]))
+	}
+
+	timezone := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject(slack.PlainTextType, defaultTimezone, false, false), settingsValueActionID)
+	timezone.InitialValue = settings.Timezone
+	timezoneBlock := slack.NewInputBlock(settingTimezone, slack.NewTextBlockObject(slack.PlainTextType, "Timezone", false, false), timezone)
+	timezoneBlock.Hint = slack.NewTextBlockObject(slack.PlainTextType, "The IANA timezone challenges follow, such as America/Toronto or Asia/Tokyo", false, false)
+
+	blocks := []slack.Block{
+		timezoneBlock,
+		settingSelect(settingUpdateInterval, "Updates", strconv.Itoa(settings.UpdateIntervalMinutes), intervals...),
+		settingSelect(settingQuietHoursStart, "Quiet hours start", strconv.Itoa(settings.QuietHoursStart), quietStarts...),
+		settingSelect(settingQuietHoursEnd, "Quiet hours end and winner announcement", strconv.Itoa(settings.QuietHoursEnd), quietEnds...),
+		settingSelect(settingMetric, "Rank participants by", settings.Metric, settingOption(metricSteps, "Steps"), settingOption(metricFloors, "Floors")),
+		settingSelect(settingOptInMode, "Participants", settings.OptInMode, settingOption(optInAutomatic, "Every channel member with a linked Fitbit account"), settingOption(optInExplicit, "Only channel members who join")),
+		settingSelect(settingLanguage, "Language", language, languages...),
+		settingSelect(settingChallengeStarters, "Who can start challenges", settings.ChallengeStarters, settingOption(startersEveryone, "Everyone"), settingOption(startersAdmins, "Admins only")),
+		settingSelect(settingDailyChallengeCap, "Challenges each member can start per day", strconv.Itoa(settings.DailyChallengeCap), caps...),
+	}
+
+	return slack.ModalViewRequest{
+		Type:       slack.ViewType("modal"),
+		CallbackID: settingsCallbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, commandConfigDescription, false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, commandConfigSubmitButton, false, false),
+		Blocks:     slack.Blocks{BlockSet: blocks},
+	}
+}
+
+// settingOption returns the option of a setting select
+func settingOption(value string, text string) *slack.OptionBlockObject {
+	return slack.NewOptionBlockObject(value, slack.NewTextBlockObject(slack.PlainTextType, text, false, false))
+}
+
+// settingSelect returns the input block to select the value of a setting with the current value selected
+func settingSelect(setting string, label string, value string, options ...*slack.OptionBlockObject) *slack.InputBlock {
+	element := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, settingsValueActionID, options...)
+	for _, option := range options {
+		if option.Value == value {
+			element.InitialOption = option
+		}
+	}
+
+	return slack.NewInputBlock(setting, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), element)
+}
+
+// parseSettingsSubmission returns the settings submitted with the settings modal
+func parseSettingsSubmission(teamID string, state *slack.ViewState) (settings WorkspaceSettings, err error) {
+	settings = WorkspaceSettings{TeamID: teamID}
+	if state == nil {
+		return settings, fmt.Errorf("settings submission without state")
+	}
+
+	value := func(setting string) string {
+		action := state.Values[setting][settingsValueActionID]
+		if action.SelectedOption.Value != "" {
+			return action.SelectedOption.Value
+		}
+
+		return strings.TrimSpace(action.Value)
+	}
+
+	number := func(setting string) (n int, err error) {
+		n, err = strconv.Atoi(value(setting))
+		if err != nil {
+			return 0, InvalidSettingError{Setting: setting, Reason: fmt.Sprintf("[%s] isn't a number", value(setting))}
+		}
+
+		return n, nil
+	}
+
+	settings.Timezone = value(settingTimezone)
+	settings.Metric = value(settingMetric)
+	settings.OptInMode = value(settingOptInMode)
+	settings.Language = value(settingLanguage)
+	settings.ChallengeStarters = value(settingChallengeStarters)
+
+	if settings.UpdateIntervalMinutes, err = number(settingUpdateInterval); err != nil {
+		return settings, err
+	}
+
+	if settings.QuietHoursStart, err = number(settingQuietHoursStart); err != nil {
+		return settings, err
+	}
+
+	if settings.QuietHoursEnd, err = number(settingQuietHoursEnd); err != nil {
+		return settings, err
+	}
+
+	if settings.DailyChallengeCap, err = number(settingDailyChallengeCap); err != nil {
+		return settings, err
+	}
+
+	return settings, nil
+}
+
+// submitSettings saves the settings submitted by an admin with the settings modal. Invalid settings are reported
+// back to the modal next to the setting they're about
+func (sc *StepCurry) submitSettings(w http.ResponseWriter, teamID string, callback slack.InteractionCallback) (err error) {
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	// Check again in case the user stopped being an admin after opening the modal
+	admin, err := isAdmin(svcs, callback.User.ID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error checking if user [%s] is an admin", callback.User.ID), http.StatusInternalServerError)
+	}
+
+	if !admin {
+		return newHttpError(fmt.Errorf("user [%s] of team [%s] isn't an admin", callback.User.ID, teamID), "Error saving settings", http.StatusForbidden)
+	}
+
+	// Channel restrictions aren't part of the modal so they're kept as they are
+	current, err := loadSettings(sc.storage, teamID, sc.logger)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error loading settings for team [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	settings, err := parseSettingsSubmission(teamID, callback.View.State)
+	if err == nil {
+		settings.ChannelRestrictions = current.ChannelRestrictions
+		err = sc.SaveWorkspaceSettings(context.Background(), settings)
+	}
+
+	if invalid, ok := err.(InvalidSettingError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{invalid.Setting: invalid.Reason}))
+	} else if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error saving settings for team [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	newLogEntry(sc.logger).with(Fields{teamIDField: teamID, userIDField: callback.User.ID}).infof("Workspace settings updated")
+	return nil
+}
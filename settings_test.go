@@ -0,0 +1,347 @@
+package stepcurry
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDefaultWorkspaceSettings(t *testing.T) {
+	settings := DefaultWorkspaceSettings("T1")
+
+	assert.Equal(t, WorkspaceSettings{TeamID: "T1", Timezone: "America/Los_Angeles", UpdateIntervalMinutes: 60, QuietHoursStart: 19, QuietHoursEnd: 8, Metric: metricSteps, OptInMode: optInAutomatic, ChallengeStarters: startersEveryone}, settings)
+	assert.NoError(t, settings.Validate())
+}
+
+func TestValidateWorkspaceSettings(t *testing.T) {
+	tests := map[string]struct {
+		settings        WorkspaceSettings
+		expectedSetting string
+	}{
+		"Unset":                    {settings: WorkspaceSettings{TeamID: "T1"}},
+		"Valid":                    {settings: WorkspaceSettings{TeamID: "T1", Timezone: "Asia/Tokyo", UpdateIntervalMinutes: 120, QuietHoursStart: 21, QuietHoursEnd: 7, Metric: metricFloors, OptInMode: optInExplicit, Language: languageJapanese, ChallengeStarters: startersAdmins}},
+		"UnknownTimezone":          {settings: WorkspaceSettings{Timezone: "Mars/Olympus_Mons"}, expectedSetting: settingTimezone},
+		"UpdatesTooFrequent":       {settings: WorkspaceSettings{UpdateIntervalMinutes: 5}, expectedSetting: settingUpdateInterval},
+		"UpdatesTooRare":           {settings: WorkspaceSettings{UpdateIntervalMinutes: 480}, expectedSetting: settingUpdateInterval},
+		"QuietHoursBeforeReminder": {settings: WorkspaceSettings{QuietHoursStart: reminderHour}, expectedSetting: settingQuietHoursStart},
+		"QuietHoursEndTooLate":     {settings: WorkspaceSettings{QuietHoursEnd: 13}, expectedSetting: settingQuietHoursEnd},
+		"UnknownMetric":            {settings: WorkspaceSettings{Metric: "calories"}, expectedSetting: settingMetric},
+		"UnknownOptInMode":         {settings: WorkspaceSettings{OptInMode: "random"}, expectedSetting: settingOptInMode},
+		"UnsupportedLanguage":      {settings: WorkspaceSettings{Language: "de"}, expectedSetting: settingLanguage},
+		"UnknownChallengeStarters": {settings: WorkspaceSettings{ChallengeStarters: "owners"}, expectedSetting: settingChallengeStarters},
+		"DailyChallengeCapTooHigh": {settings: WorkspaceSettings{DailyChallengeCap: 11}, expectedSetting: settingDailyChallengeCap},
+		"RestrictionNoChannel":     {settings: WorkspaceSettings{ChannelRestrictions: []ChannelRestriction{{UserGroups: []string{"S1"}}}}, expectedSetting: settingChannelRestriction},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.settings.Validate()
+			if tc.expectedSetting == "" {
+				assert.NoError(t, err)
+			} else {
+				require.IsType(t, InvalidSettingError{}, err)
+				assert.Equal(t, tc.expectedSetting, err.(InvalidSettingError).Setting)
+			}
+		})
+	}
+}
+
+func TestLoadTeamConfig(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	messages, settings, err := loadTeamConfig(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultWorkspaceSettings("T1"), settings)
+	assert.Equal(t, languageEnglish, messages.language)
+
+	// Settings that became invalid are ignored rather than breaking challenges
+	require.NoError(t, storage.PutWorkspaceSettings(ctx, WorkspaceSettings{TeamID: "T1", Timezone: "Mars/Olympus_Mons"}))
+	_, settings, err = loadTeamConfig(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultWorkspaceSettings("T1"), settings)
+
+	require.NoError(t, storage.PutWorkspaceSettings(ctx, WorkspaceSettings{TeamID: "T1", Language: languageFrench, Metric: metricFloors}))
+	messages, settings, err = loadTeamConfig(storage, "T1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, metricFloors, settings.Metric)
+	assert.Equal(t, 60, settings.UpdateIntervalMinutes)
+	assert.Equal(t, languageFrench, messages.language)
+}
+
+func TestSaveWorkspaceSettings(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	err := h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, QuietHoursEnd: 12})
+	assert.Equal(t, InvalidSettingError{Setting: settingQuietHoursEnd, Reason: "quiet hours must end between 5:00 and 11:00"}, err)
+
+	_, err = h.storage.GetWorkspaceSettings(context.Background(), lifecycleTeamID)
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestConfigAsNonAdmin(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	err := h.sc.Config(httptest.NewRecorder(), h.slashCommand(commandConfig, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.views)
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, "ephemeral", responses[0].ResponseType)
+	assert.Equal(t, ":lock: Only workspace admins can change the Step Curry settings with `/step-config`", responses[0].Text)
+}
+
+func TestConfigAsAdmin(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, Timezone: "Asia/Tokyo", Metric: metricFloors}))
+
+	h.slack.users["U1"] = "Alice"
+	h.slack.admins["U1"] = true
+
+	err := h.sc.Config(httptest.NewRecorder(), h.slashCommand(commandConfig, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.receivedResponses())
+	require.Len(t, h.slack.views, 1)
+	modal := h.slack.views[0]
+	assert.Equal(t, settingsCallbackID, modal.CallbackID)
+	require.Len(t, modal.Blocks.BlockSet, 9)
+
+	timezone := modal.Blocks.BlockSet[0].(*slack.InputBlock)
+	assert.Equal(t, settingTimezone, timezone.BlockID)
+	assert.Equal(t, "Asia/Tokyo", timezone.Element.(*slack.PlainTextInputBlockElement).InitialValue)
+
+	metric := modal.Blocks.BlockSet[4].(*slack.InputBlock)
+	assert.Equal(t, settingMetric, metric.BlockID)
+	assert.Equal(t, metricFloors, metric.Element.(*slack.SelectBlockElement).InitialOption.Value)
+
+	// The messages' default language is selected when the settings don't set one
+	language := modal.Blocks.BlockSet[6].(*slack.InputBlock)
+	assert.Equal(t, languageEnglish, language.Element.(*slack.SelectBlockElement).InitialOption.Value)
+}
+
+// settingsSubmission returns the view submission of the settings modal with the given values
+func settingsSubmission(userID string, values map[string]string) (callback slack.InteractionCallback) {
+	state := &slack.ViewState{Values: make(map[string]map[string]slack.BlockAction)}
+	for setting, value := range values {
+		action := slack.BlockAction{ActionID: settingsValueActionID, BlockID: setting}
+		if setting == settingTimezone {
+			action.Value = value
+		} else {
+			action.SelectedOption = slack.OptionBlockObject{Value: value}
+		}
+
+		state.Values[setting] = map[string]slack.BlockAction{settingsValueActionID: action}
+	}
+
+	callback.Type = slack.InteractionTypeViewSubmission
+	callback.User.ID = userID
+	callback.View.CallbackID = settingsCallbackID
+	callback.View.State = state
+
+	return callback
+}
+
+func TestSubmitSettings(t *testing.T) {
+	values := map[string]string{settingTimezone: " Asia/Tokyo ", settingUpdateInterval: "120", settingQuietHoursStart: "21", settingQuietHoursEnd: "7", settingMetric: metricFloors, settingOptInMode: optInExplicit, settingLanguage: languageJapanese, settingChallengeStarters: startersAdmins, settingDailyChallengeCap: "3"}
+
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+	h.slack.admins["U1"] = true
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.interaction(settingsSubmission("U1", values)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	settings, err := h.storage.GetWorkspaceSettings(context.Background(), lifecycleTeamID)
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceSettings{TeamID: lifecycleTeamID, Timezone: "Asia/Tokyo", UpdateIntervalMinutes: 120, QuietHoursStart: 21, QuietHoursEnd: 7, Metric: metricFloors, OptInMode: optInExplicit, Language: languageJapanese, ChallengeStarters: startersAdmins, DailyChallengeCap: 3}, settings)
+}
+
+func TestSubmitInvalidSettings(t *testing.T) {
+	values := map[string]string{settingTimezone: "Mars/Olympus_Mons", settingUpdateInterval: "60", settingQuietHoursStart: "19", settingQuietHoursEnd: "8", settingMetric: metricSteps, settingOptInMode: optInAutomatic, settingLanguage: languageEnglish, settingChallengeStarters: startersEveryone, settingDailyChallengeCap: "0"}
+
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+	h.slack.admins["U1"] = true
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.interaction(settingsSubmission("U1", values)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response slack.ViewSubmissionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, slack.RAErrors, response.ResponseAction)
+	assert.Equal(t, map[string]string{settingTimezone: "unknown timezone [Mars/Olympus_Mons]"}, response.Errors)
+
+	_, err := h.storage.GetWorkspaceSettings(context.Background(), lifecycleTeamID)
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestSubmitSettingsAsNonAdmin(t *testing.T) {
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC))
+	defer cleanup()
+
+	h.slack.users["U1"] = "Alice"
+
+	w := httptest.NewRecorder()
+	h.mux.ServeHTTP(w, h.interaction(settingsSubmission("U1", map[string]string{settingMetric: metricFloors})))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	_, err := h.storage.GetWorkspaceSettings(context.Background(), lifecycleTeamID)
+	assert.Equal(t, ErrNoSuchEntity, err)
+}
+
+func TestChallengeStartersAdmins(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, ChallengeStarters: startersAdmins}))
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.slack.admins["U2"] = true
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	assert.Empty(t, h.slack.messagesTo(lifecycleChannelID))
+	responses := h.receivedResponses()
+	require.Len(t, responses, 1)
+	assert.Equal(t, ":lock: Only workspace admins can start challenges in this workspace", responses[0].Text)
+
+	err = h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U2"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "<@U2> started a steps challenge!")
+}
+
+func TestChallengeWithWorkspaceSettings(t *testing.T) {
+	location := mustLoadLocation(t, "Asia/Tokyo")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, Timezone: "Asia/Tokyo", UpdateIntervalMinutes: 120, QuietHoursStart: 20, QuietHoursEnd: 7, Metric: metricFloors, Language: languageFrench}))
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	challengeID := ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"}
+	challenge, err := h.storage.GetChallenge(ctx, challengeID)
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", challenge.TimezoneID)
+	assert.Equal(t, metricFloors, challenge.Metric)
+	assert.False(t, challenge.ExplicitOptIn)
+
+	// Alice walks more but Bob climbs more floors
+	h.setSteps("F1", 12000)
+	h.setFloors("F1", 5)
+	h.setSteps("F2", 8000)
+	h.setFloors("F2", 20)
+
+	dispatchTimes := make([]time.Time, 0)
+	dispatched := h.runScheduledTasks(func(now time.Time) {
+		dispatchTimes = append(dispatchTimes, now)
+	})
+
+	// Updates every two hours from 9:30 to 19:30, one task scheduling the final update, the final update at 7am
+	// the next morning and the perfect month evaluation once June is over
+	assert.Equal(t, 9, dispatched)
+	assert.Equal(t, time.Date(2020, 6, 1, 11, 30, 0, 0, location), dispatchTimes[1].In(location).Truncate(time.Minute))
+	assert.Equal(t, time.Date(2020, 6, 1, 19, 30, 0, 0, location), dispatchTimes[5].In(location).Truncate(time.Minute))
+	assert.Equal(t, time.Date(2020, 6, 2, 7, 0, 0, 0, location), dispatchTimes[7].In(location))
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 8)
+	assert.Contains(t, messages[0].text, "<@U1> a lancé un défi de pas!")
+
+	winnerAnnouncement := messages[7]
+	assert.Contains(t, winnerAnnouncement.text, "Nous avons un gagnant")
+	assert.Contains(t, winnerAnnouncement.blocks, "_Bob_ `20` :mountain: :tornado::rocket:")
+	assert.Contains(t, winnerAnnouncement.blocks, "_Alice_ `5` :mountain:")
+}
+
+func TestChallengeWithExplicitOptIn(t *testing.T) {
+	location := mustLoadLocation(t, "America/Los_Angeles")
+	h, cleanup := newLifecycleHarness(t, time.Date(2020, 6, 1, 9, 30, 0, 0, location))
+	defer cleanup()
+
+	h.sc.TeamRouter.(*SingleTenantRouter).UseStorage(h.storage)
+	require.NoError(t, h.sc.SaveWorkspaceSettings(context.Background(), WorkspaceSettings{TeamID: lifecycleTeamID, OptInMode: optInExplicit}))
+
+	h.addUser("U1", "Alice", "F1")
+	h.addUser("U2", "Bob", "F2")
+	h.addUser("U3", "Carol", "F3")
+	h.slack.members[lifecycleChannelID] = append(h.slack.members[lifecycleChannelID], "U4")
+	h.slack.users["U4"] = "Dave"
+	h.setSteps("F1", 1000)
+	h.setSteps("F2", 2000)
+	h.setSteps("F3", 3000)
+
+	err := h.sc.Challenge(httptest.NewRecorder(), h.slashCommand(commandChallenge, "U1"))
+	require.NoError(t, err)
+
+	messages := h.slack.messagesTo(lifecycleChannelID)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].text, "Hit *Join* to take part")
+	assert.Contains(t, messages[0].blocks, joinChallengeActionID)
+
+	join := func(userID string) {
+		var callback slack.InteractionCallback
+		callback.Type = slack.InteractionTypeBlockActions
+		callback.User.ID = userID
+		callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: joinChallengeActionID, BlockID: "join", Value: "2020-06-01"}}
+
+		w := httptest.NewRecorder()
+		h.mux.ServeHTTP(w, h.interaction(callback))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	join("U2")
+	join("U2")
+	join("U4")
+
+	responses := h.receivedResponses()
+	require.Len(t, responses, 3)
+	assert.Equal(t, ":athletic_shoe: You're in! Get moving", responses[0].Text)
+	assert.Equal(t, ":athletic_shoe: You're in! Get moving and link your Fitbit account with `/step-link` so your steps count", responses[2].Text)
+
+	challenge, err := h.storage.GetChallenge(context.Background(), ChallengeID{TeamID: lifecycleTeamID, ChannelID: lifecycleChannelID, Date: "2020-06-01"})
+	require.NoError(t, err)
+	assert.True(t, challenge.ExplicitOptIn)
+	assert.Equal(t, []string{"U1", "U2", "U4"}, challenge.Participants)
+
+	// Carol has a linked account but didn't join so she's left out of the ranking
+	h.runScheduledTasks(func(now time.Time) {})
+	challenge, err = h.storage.GetChallenge(context.Background(), challenge.ChallengeID)
+	require.NoError(t, err)
+	assert.Equal(t, []UserSteps{{UserID: "U2", Steps: 2000, Goal: 10000}, {UserID: "U1", Steps: 1000, Goal: 10000}}, challenge.RankedUsers)
+}
@@ -10,25 +10,29 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
-var slackScopes = [...]string{"chat:write", "users:read", "users.profile:read", "channels:read", "groups:read", "im:read", "mpim:read", "commands"}
+var slackScopes = [...]string{"chat:write", "chat:write.customize", "users:read", "users.profile:read", "channels:read", "channels:join", "groups:read", "usergroups:read", "im:read", "mpim:read", "commands"}
 
 const (
 	defaultSlackBaseURL = "https://slack.com"
 )
 
 type SlackAuthResponse struct {
-	Ok          bool       `json:"ok,omitempty"`
-	AppID       string     `json:"app_id,omitempty"`
-	AuthedUser  AuthedUser `json:"authed_user,omitempty"`
-	Scope       string     `json:"scope,omitempty"`
-	TokenType   string     `json:"token_type,omitempty"`
-	AccessToken string     `json:"access_token,omitempty"`
-	BotUserID   string     `json:"bot_user_id,omitempty"`
-	Team        TeamInfo   `json:"team,omitempty"`
-	Enterprise  string     `json:"enterprise,omitempty"`
-	Error       string     `json:"error,omitempty"`
+	Ok                  bool           `json:"ok,omitempty"`
+	AppID               string         `json:"app_id,omitempty"`
+	AuthedUser          AuthedUser     `json:"authed_user,omitempty"`
+	Scope               string         `json:"scope,omitempty"`
+	TokenType           string         `json:"token_type,omitempty"`
+	AccessToken         string         `json:"access_token,omitempty"`
+	BotUserID           string         `json:"bot_user_id,omitempty"`
+	RefreshToken        string         `json:"refresh_token,omitempty"`
+	ExpiresIn           int            `json:"expires_in,omitempty"`
+	Team                TeamInfo       `json:"team,omitempty"`
+	Enterprise          EnterpriseInfo `json:"enterprise,omitempty"`
+	IsEnterpriseInstall bool           `json:"is_enterprise_install,omitempty"`
+	Error               string         `json:"error,omitempty"`
 }
 
 type AuthedUser struct {
@@ -40,8 +44,29 @@ type TeamInfo struct {
 	Name string `json:"name,omitempty"`
 }
 
+// EnterpriseInfo holds the Enterprise Grid org of an installation. It's null for workspaces that aren't part of an org
+type EnterpriseInfo struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Token returns the token of an auth response. Tokens of apps with token rotation expire after ExpiresIn seconds
+func (authResp SlackAuthResponse) Token(now time.Time) SlackToken {
+	token := SlackToken{AccessToken: authResp.AccessToken, RefreshToken: authResp.RefreshToken}
+	if authResp.ExpiresIn > 0 {
+		token.Expiry = now.Add(time.Duration(authResp.ExpiresIn) * time.Second)
+	}
+
+	return token
+}
+
+// Installation returns the installation of an auth response. The team of org-wide installations is null
+func (authResp SlackAuthResponse) Installation() Installation {
+	return Installation{EnterpriseID: authResp.Enterprise.ID, TeamID: authResp.Team.ID, EnterpriseInstall: authResp.IsEnterpriseInstall}
+}
+
 func (sc *StepCurry) InvokeSlackAuth(w http.ResponseWriter, r *http.Request) {
-	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, "HandleSlackAuth")
+	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, sc.paths.SlackAuthCallback)
 	slackAuthURL := fmt.Sprintf("%s/oauth/v2/authorize?client_id=%s&redirect_uri=%s&scope=%s", sc.slackBaseURL, sc.slackClientID, redirectURI, strings.Join(slackScopes[:], ","))
 	http.Redirect(w, r, slackAuthURL, http.StatusFound)
 }
@@ -59,35 +84,86 @@ func (sc *StepCurry) HandleSlackAuth(w http.ResponseWriter, r *http.Request) err
 		return newHttpError(err, "Error getting slack access", http.StatusInternalServerError)
 	}
 
-	err = sc.SaveToken(authResp.Team.ID, authResp.AccessToken)
+	installation := authResp.Installation()
+	err = sc.SaveToken(installation, authResp.Token(sc.clock.Now()))
 	if err != nil {
+		if errors.Cause(err) == errTokenStoreMissing {
+			return newHttpError(err, "Installing the app isn't supported without a slack token store", http.StatusNotImplemented)
+		}
+
 		return newHttpError(err, "Error saving slack token", http.StatusInternalServerError)
 	}
 
 	ctx := context.Background()
-	botInfo := BotInfo{UserID: authResp.BotUserID}
-	k := NewKeyWithNamespace("BotInfo", authResp.Team.ID, "Bot", nil)
-	_, err = sc.storer.Put(ctx, k, &botInfo)
+	botInfo := BotInfo{UserID: authResp.BotUserID, EnterpriseID: installation.EnterpriseID, TeamID: installation.TeamID, EnterpriseInstall: installation.EnterpriseInstall}
+	err = sc.storage.PutBotInfo(ctx, installation.TenantID(), botInfo)
 	if err != nil {
-		return newHttpError(err, fmt.Sprintf("Error persisting bot info [%s] for team [%s]", botInfo.UserID, authResp.Team.ID), http.StatusInternalServerError)
+		return newHttpError(err, fmt.Sprintf("Error persisting bot info [%s] for team [%s]", botInfo.UserID, installation.TenantID()), http.StatusInternalServerError)
+	}
+
+	// Reinstalling the app issues a new token so services routed with the previous one are dropped
+	sc.Invalidate(installation.TenantID())
+
+	// Org-wide installations have no team to redirect to so users pick the workspace to open the app in
+	redirectURL := fmt.Sprintf("https://slack.com/app_redirect?app=%s&team=%s", sc.slackAppID, installation.TeamID)
+	if installation.TeamID == "" {
+		redirectURL = fmt.Sprintf("https://slack.com/app_redirect?app=%s", sc.slackAppID)
 	}
 
-	w.Write([]byte(fmt.Sprintf("<html><head><meta http-equiv=\"refresh\" content=\"0;URL=https://slack.com/app_redirect?app=%s&team=%s\"></head></html>", sc.slackAppID, authResp.Team.ID)))
+	w.Write([]byte(fmt.Sprintf("<html><head><meta http-equiv=\"refresh\" content=\"0;URL=%s\"></head></html>", redirectURL)))
 
 	return nil
 }
 
 func (sc *StepCurry) exchangeSlackAuthCodeForToken(code string) (authResp SlackAuthResponse, err error) {
-	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, "HandleSlackAuth")
+	redirectURI := fmt.Sprintf("%s/%s", sc.baseURL, sc.paths.SlackAuthCallback)
 
 	v := url.Values{}
-	v.Set("client_id", sc.slackClientID)
-	v.Set("client_secret", sc.slackClientSecret)
 	v.Set("code", code)
 	v.Set("redirect_uri", redirectURI)
 
+	return requestSlackToken(sc.slackBaseURL, sc.slackClientID, sc.slackClientSecret, v)
+}
+
+// SlackTokenRefresher refreshes rotating slack tokens with oauth.v2.access
+type SlackTokenRefresher struct {
+	slackBaseURL string
+	clientID     string
+	clientSecret string
+}
+
+// NewSlackTokenRefresher creates a new SlackTokenRefresher for the slack app with the given client ID and secret
+func NewSlackTokenRefresher(slackBaseURL string, clientID string, clientSecret string) (refresher *SlackTokenRefresher) {
+	refresher = new(SlackTokenRefresher)
+	refresher.slackBaseURL = slackBaseURL
+	refresher.clientID = clientID
+	refresher.clientSecret = clientSecret
+
+	return refresher
+}
+
+// SlackTokenRefresher returns a SlackTokenRefresher for the slack app StepCurry is configured with
+func (sc *StepCurry) SlackTokenRefresher() (refresher *SlackTokenRefresher) {
+	return NewSlackTokenRefresher(sc.slackBaseURL, sc.slackClientID, sc.slackClientSecret)
+}
+
+// RefreshToken implements TokenRefresher
+func (str *SlackTokenRefresher) RefreshToken(refreshToken string) (authResp SlackAuthResponse, err error) {
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("refresh_token", refreshToken)
+
+	return requestSlackToken(str.slackBaseURL, str.clientID, str.clientSecret, v)
+}
+
+// requestSlackToken requests a token from oauth.v2.access, either exchanging an authorization code or refreshing a
+// rotating token
+func requestSlackToken(slackBaseURL string, clientID string, clientSecret string, v url.Values) (authResp SlackAuthResponse, err error) {
+	v.Set("client_id", clientID)
+	v.Set("client_secret", clientSecret)
+
 	body := strings.NewReader(v.Encode())
-	tokenURL := fmt.Sprintf("%s/api/oauth.v2.access", sc.slackBaseURL)
+	tokenURL := fmt.Sprintf("%s/api/oauth.v2.access", slackBaseURL)
 
 	req, err := http.NewRequest("POST", tokenURL, body)
 	if err != nil {
@@ -95,13 +171,14 @@ func (sc *StepCurry) exchangeSlackAuthCodeForToken(code string) (authResp SlackA
 	}
 
 	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", sc.slackClientID, sc.slackClientSecret)))))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", clientID, clientSecret)))))
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return authResp, errors.Wrap(err, "error executing slack access token request")
 	}
+	defer resp.Body.Close()
 
 	tokenBody, err := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
@@ -0,0 +1,356 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// migration holds the statements bringing a schema to a version
+type migration struct {
+	version    int
+	statements []string
+}
+
+// dialect holds what differs between the supported databases
+type dialect struct {
+	name string
+	// migrations are the schema migrations, in version order
+	migrations []migration
+	// lockMigrations is a statement run in the migration transaction to keep concurrent servers from
+	// migrating at the same time
+	lockMigrations string
+	// txOptions are the options of transactions started by RunInTransaction
+	txOptions *sql.TxOptions
+	// numberedPlaceholders is true if query parameters are referenced as $1, $2 rather than ?
+	numberedPlaceholders bool
+	// isRetryable returns true for errors of transactions that should be retried
+	isRetryable func(err error) bool
+}
+
+// rebind rewrites the ? placeholders of a query for the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// schema returns the statements creating the tables with the column types of a dialect
+func schema(text string, blob string, timestamp string, json string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE steps_challenges (
+			team_id        %[1]s NOT NULL,
+			channel_id     %[1]s NOT NULL,
+			date           %[1]s NOT NULL,
+			active         BOOLEAN NOT NULL,
+			created_by     %[1]s NOT NULL,
+			creation_time  %[3]s NOT NULL,
+			timezone_id    %[1]s NOT NULL,
+			ranked_users   %[4]s NOT NULL,
+			reminders_sent BOOLEAN NOT NULL,
+			PRIMARY KEY (team_id, channel_id, date)
+		)`, text, blob, timestamp, json),
+		`CREATE INDEX steps_challenges_active ON steps_challenges (team_id, active)`,
+		fmt.Sprintf(`CREATE TABLE client_accesses (
+			team_id              %[1]s NOT NULL,
+			slack_user           %[1]s NOT NULL,
+			fitbit_user          %[1]s NOT NULL,
+			fetch_failures       INTEGER NOT NULL,
+			link_broken          BOOLEAN NOT NULL,
+			broken_link_notified BOOLEAN NOT NULL,
+			PRIMARY KEY (team_id, slack_user)
+		)`, text),
+		fmt.Sprintf(`CREATE TABLE fitbit_api_accesses (
+			fitbit_user   %[1]s NOT NULL PRIMARY KEY,
+			access_token  %[1]s NOT NULL,
+			refresh_token %[1]s NOT NULL,
+			expiry        %[2]s NOT NULL,
+			refreshed_at  %[2]s NOT NULL,
+			subscribed    BOOLEAN NOT NULL
+		)`, text, timestamp),
+		fmt.Sprintf(`CREATE TABLE csrf_tokens (
+			team_id    %[1]s NOT NULL,
+			slack_user %[1]s NOT NULL,
+			csrf       %[2]s NOT NULL,
+			PRIMARY KEY (team_id, slack_user)
+		)`, text, blob),
+		fmt.Sprintf(`CREATE TABLE bot_infos (
+			team_id     %[1]s NOT NULL PRIMARY KEY,
+			bot_user_id %[1]s NOT NULL
+		)`, text),
+		fmt.Sprintf(`CREATE TABLE leaderboards (
+			team_id    %[1]s NOT NULL,
+			name       %[1]s NOT NULL,
+			scope      %[1]s NOT NULL,
+			channel_id %[1]s NOT NULL,
+			period     %[1]s NOT NULL,
+			period_id  %[1]s NOT NULL,
+			challenges INTEGER NOT NULL,
+			standings  %[2]s NOT NULL,
+			PRIMARY KEY (team_id, name)
+		)`, text, json),
+		fmt.Sprintf(`CREATE TABLE user_achievements (
+			team_id       %[1]s NOT NULL,
+			user_id       %[1]s NOT NULL,
+			personal_best INTEGER NOT NULL,
+			win_streak    INTEGER NOT NULL,
+			last_win_date %[1]s NOT NULL,
+			badges        %[2]s NOT NULL,
+			PRIMARY KEY (team_id, user_id)
+		)`, text, json),
+		fmt.Sprintf(`CREATE TABLE user_streaks (
+			team_id              %[1]s NOT NULL,
+			user_id              %[1]s NOT NULL,
+			participation_streak INTEGER NOT NULL,
+			last_participation   %[1]s NOT NULL,
+			goal_streak          INTEGER NOT NULL,
+			last_goal_hit        %[1]s NOT NULL,
+			reminders_enabled    BOOLEAN NOT NULL,
+			last_reminder        %[1]s NOT NULL,
+			PRIMARY KEY (team_id, user_id)
+		)`, text),
+		fmt.Sprintf(`CREATE TABLE daily_activities (
+			fitbit_user %[1]s NOT NULL,
+			date        %[1]s NOT NULL,
+			steps       INTEGER NOT NULL,
+			goal        INTEGER NOT NULL,
+			updated_at  %[2]s NOT NULL,
+			PRIMARY KEY (fitbit_user, date)
+		)`, text, timestamp),
+	}
+}
+
+// jobsSchema returns the statements creating the table of scheduled jobs with the column types of a dialect
+func jobsSchema(text string, timestamp string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE jobs (
+			name           %[1]s NOT NULL PRIMARY KEY,
+			kind           %[1]s NOT NULL,
+			team_id        %[1]s NOT NULL,
+			channel_id     %[1]s NOT NULL,
+			date           %[1]s NOT NULL,
+			scheduled_time %[2]s NOT NULL,
+			attempts       INTEGER NOT NULL
+		)`, text, timestamp),
+		`CREATE INDEX jobs_scheduled_time ON jobs (scheduled_time)`,
+	}
+}
+
+// workspaceMessagesSchema returns the statements creating the table of workspace message customizations with the
+// column types of a dialect
+func workspaceMessagesSchema(text string, json string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE workspace_messages (
+			team_id        %[1]s NOT NULL PRIMARY KEY,
+			bot_name       %[1]s NOT NULL,
+			bot_icon_emoji %[1]s NOT NULL,
+			templates      %[2]s NOT NULL
+		)`, text, json),
+	}
+}
+
+// workspaceLanguageSchema returns the statements adding the default language of workspace messages
+func workspaceLanguageSchema(text string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE workspace_messages ADD COLUMN default_language %s NOT NULL DEFAULT ''`, text),
+	}
+}
+
+// workspaceSettingsSchema returns the statements creating the table of workspace settings and adding the settings
+// snapshotted by challenges and the floors of daily activities
+func workspaceSettingsSchema(text string, json string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE workspace_settings (
+			team_id                 %[1]s NOT NULL PRIMARY KEY,
+			timezone                %[1]s NOT NULL,
+			update_interval_minutes INTEGER NOT NULL,
+			quiet_hours_start       INTEGER NOT NULL,
+			quiet_hours_end         INTEGER NOT NULL,
+			metric                  %[1]s NOT NULL,
+			opt_in_mode             %[1]s NOT NULL,
+			language                %[1]s NOT NULL,
+			challenge_starters      %[1]s NOT NULL
+		)`, text),
+		fmt.Sprintf(`ALTER TABLE steps_challenges ADD COLUMN metric %s NOT NULL DEFAULT ''`, text),
+		`ALTER TABLE steps_challenges ADD COLUMN explicit_opt_in BOOLEAN NOT NULL DEFAULT FALSE`,
+		fmt.Sprintf(`ALTER TABLE steps_challenges ADD COLUMN participants %s NOT NULL DEFAULT 'null'`, json),
+		`ALTER TABLE daily_activities ADD COLUMN floors INTEGER NOT NULL DEFAULT 0`,
+	}
+}
+
+// challengePermissionsSchema returns the statements adding the challenge permissions of workspace settings
+func challengePermissionsSchema(json string) (statements []string) {
+	return []string{
+		`ALTER TABLE workspace_settings ADD COLUMN daily_challenge_cap INTEGER NOT NULL DEFAULT 0`,
+		fmt.Sprintf(`ALTER TABLE workspace_settings ADD COLUMN channel_restrictions %s NOT NULL DEFAULT 'null'`, json),
+	}
+}
+
+// enterpriseSchema returns the statements adding the enterprise and team of installations to bot infos. Bot infos
+// are keyed by tenant which is the enterprise rather than the team for org-wide installations
+func enterpriseSchema(text string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE bot_infos ADD COLUMN enterprise_id %s NOT NULL DEFAULT ''`, text),
+		fmt.Sprintf(`ALTER TABLE bot_infos ADD COLUMN install_team_id %s NOT NULL DEFAULT ''`, text),
+		`ALTER TABLE bot_infos ADD COLUMN enterprise_install BOOLEAN NOT NULL DEFAULT FALSE`,
+	}
+}
+
+// secretsSchema returns the statements creating the table of encrypted team secrets
+func secretsSchema(text string, blob string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE secrets (
+			team_id    %[1]s NOT NULL,
+			name       %[1]s NOT NULL,
+			ciphertext %[2]s NOT NULL,
+			PRIMARY KEY (team_id, name)
+		)`, text, blob),
+	}
+}
+
+// activityJobsSchema returns the statements adding the activity recorded by activity update jobs
+func activityJobsSchema(text string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN fitbit_user %s NOT NULL DEFAULT ''`, text),
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN activity_date %s NOT NULL DEFAULT ''`, text),
+	}
+}
+
+// homeJobsSchema returns the statements adding the app home published by home publish jobs
+func homeJobsSchema(text string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN home_team_id %s NOT NULL DEFAULT ''`, text),
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN home_user_id %s NOT NULL DEFAULT ''`, text),
+	}
+}
+
+// perfectMonthJobsSchema returns the statements adding the channel and month evaluated by perfect month jobs
+func perfectMonthJobsSchema(text string) (statements []string) {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN perfect_month_team_id %s NOT NULL DEFAULT ''`, text),
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN perfect_month_channel_id %s NOT NULL DEFAULT ''`, text),
+		fmt.Sprintf(`ALTER TABLE jobs ADD COLUMN perfect_month %s NOT NULL DEFAULT ''`, text),
+	}
+}
+
+var postgres = dialect{
+	name: "postgres",
+	migrations: []migration{
+		{version: 1, statements: schema("TEXT", "BYTEA", "TIMESTAMPTZ", "JSONB")},
+		{version: 2, statements: jobsSchema("TEXT", "TIMESTAMPTZ")},
+		{version: 3, statements: workspaceMessagesSchema("TEXT", "JSONB")},
+		{version: 4, statements: workspaceLanguageSchema("TEXT")},
+		{version: 5, statements: workspaceSettingsSchema("TEXT", "JSONB")},
+		{version: 6, statements: challengePermissionsSchema("JSONB")},
+		{version: 7, statements: enterpriseSchema("TEXT")},
+		{version: 8, statements: secretsSchema("TEXT", "BYTEA")},
+		{version: 9, statements: activityJobsSchema("TEXT")},
+		{version: 10, statements: homeJobsSchema("TEXT")},
+		{version: 11, statements: perfectMonthJobsSchema("TEXT")},
+	},
+	lockMigrations:       "SELECT pg_advisory_xact_lock(7837287)",
+	txOptions:            &sql.TxOptions{Isolation: sql.LevelSerializable},
+	numberedPlaceholders: true,
+	isRetryable: func(err error) bool {
+		// Serialization failures are expected with serializable transactions and resolve on retry
+		pqErr, ok := errors.Cause(err).(*pq.Error)
+		return ok && pqErr.Code == "40001"
+	},
+}
+
+var sqlite = dialect{
+	name: "sqlite3",
+	migrations: []migration{
+		{version: 1, statements: schema("TEXT", "BLOB", "TIMESTAMP", "TEXT")},
+		{version: 2, statements: jobsSchema("TEXT", "TIMESTAMP")},
+		{version: 3, statements: workspaceMessagesSchema("TEXT", "TEXT")},
+		{version: 4, statements: workspaceLanguageSchema("TEXT")},
+		{version: 5, statements: workspaceSettingsSchema("TEXT", "TEXT")},
+		{version: 6, statements: challengePermissionsSchema("TEXT")},
+		{version: 7, statements: enterpriseSchema("TEXT")},
+		{version: 8, statements: secretsSchema("TEXT", "BLOB")},
+		{version: 9, statements: activityJobsSchema("TEXT")},
+		{version: 10, statements: homeJobsSchema("TEXT")},
+		{version: 11, statements: perfectMonthJobsSchema("TEXT")},
+	},
+	isRetryable: func(err error) bool {
+		return false
+	},
+}
+
+// migrate applies all migrations newer than the current schema version. Each migration is applied in its own
+// transaction along with the recording of its version
+func (s *Storage) migrate(ctx context.Context) (err error) {
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		return errors.Wrap(err, "error creating schema migrations table")
+	}
+
+	for _, m := range s.dialect.migrations {
+		err = s.applyMigration(ctx, m)
+		if err != nil {
+			return errors.Wrapf(err, "error applying migration [%d]", m.version)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies a migration unless it was already applied
+func (s *Storage) applyMigration(ctx context.Context, m migration) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect.lockMigrations != "" {
+		_, err = tx.ExecContext(ctx, s.dialect.lockMigrations)
+		if err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), m.version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		return nil
+	}
+
+	for _, statement := range m.statements {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), m.version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,552 @@
+// Package sqlstorage implements Step Curry's storage on top of a SQL database. Postgres is supported for
+// self-hosted deployments and SQLite for embedded, single-process ones
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/pkg/errors"
+
+	// Register the database drivers
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxTransactionAttempts is the number of times a transaction is attempted before giving up on retryable errors
+	maxTransactionAttempts = 3
+)
+
+// transactionContextKey is the context key of the transaction of calls made within RunInTransaction
+type transactionContextKey struct{}
+
+// queryer is implemented by both sql.DB and sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scanner is implemented by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Storage implements stepcurry.Storage with a SQL database
+type Storage struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+var _ stepcurry.Storage = (*Storage)(nil)
+
+// NewPostgres opens a Postgres storage and migrates its schema. See https://godoc.org/github.com/lib/pq for the
+// format of the data source name
+func NewPostgres(dataSourceName string) (storage *Storage, err error) {
+	return open(postgres, dataSourceName)
+}
+
+// NewSQLite opens a SQLite storage and migrates its schema. The path is the database file, which is created if it
+// doesn't exist. SQLite databases are only meant to be used by a single process
+func NewSQLite(path string) (storage *Storage, err error) {
+	storage, err = open(sqlite, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer at a time so sharing a single connection avoids busy errors. Note that this
+	// means that calls within RunInTransaction must use the transaction context to avoid waiting on themselves
+	storage.db.SetMaxOpenConns(1)
+	return storage, nil
+}
+
+func open(d dialect, dataSourceName string) (storage *Storage, err error) {
+	db, err := sql.Open(d.name, dataSourceName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s database", d.name)
+	}
+
+	storage = &Storage{db: db, dialect: d}
+	err = storage.migrate(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Close closes the database
+func (s *Storage) Close() (err error) {
+	return s.db.Close()
+}
+
+// q returns the queryer for a context, which is the transaction when called within RunInTransaction
+func (s *Storage) q(ctx context.Context) queryer {
+	if tx, ok := ctx.Value(transactionContextKey{}).(*sql.Tx); ok {
+		return tx
+	}
+
+	return s.db
+}
+
+func (s *Storage) exec(ctx context.Context, query string, args ...interface{}) (err error) {
+	_, err = s.q(ctx).ExecContext(ctx, s.dialect.rebind(query), args...)
+	return err
+}
+
+func (s *Storage) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.q(ctx).QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// queryRows runs a query and calls scan for every row
+func (s *Storage) queryRows(ctx context.Context, scan func(row scanner) error, query string, args ...interface{}) (err error) {
+	rows, err := s.q(ctx).QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// notFound translates the error of a query for a single entity
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return stepcurry.ErrNoSuchEntity
+	}
+
+	return err
+}
+
+// RunInTransaction runs a function in a transaction that's committed if the function returns no error and rolled
+// back otherwise. The transaction is retried if it fails with an error the database deems retryable
+func (s *Storage) RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	// Nested calls are part of the enclosing transaction
+	if _, ok := ctx.Value(transactionContextKey{}).(*sql.Tx); ok {
+		return f(ctx)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = s.runTransaction(ctx, f)
+		if err == nil || attempt >= maxTransactionAttempts || !s.dialect.isRetryable(err) {
+			return err
+		}
+	}
+}
+
+func (s *Storage) runTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	tx, err := s.db.BeginTx(ctx, s.dialect.txOptions)
+	if err != nil {
+		return errors.Wrap(err, "error starting transaction")
+	}
+
+	err = f(context.WithValue(ctx, transactionContextKey{}, tx))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// challenge columns, in scan order
+const challengeColumns = `team_id, channel_id, date, active, created_by, creation_time, timezone_id, ranked_users, reminders_sent, metric, explicit_opt_in, participants`
+
+func scanChallenge(row scanner) (challenge stepcurry.StepsChallenge, err error) {
+	var rankedUsers, participants string
+	err = row.Scan(&challenge.TeamID, &challenge.ChannelID, &challenge.Date, &challenge.Active, &challenge.CreatorID, &challenge.CreationTime, &challenge.TimezoneID, &rankedUsers, &challenge.RemindersSent, &challenge.Metric, &challenge.ExplicitOptIn, &participants)
+	if err != nil {
+		return challenge, err
+	}
+
+	err = json.Unmarshal([]byte(rankedUsers), &challenge.RankedUsers)
+	if err != nil {
+		return challenge, err
+	}
+
+	err = json.Unmarshal([]byte(participants), &challenge.Participants)
+	return challenge, err
+}
+
+// GetChallenge implements stepcurry.Storage
+func (s *Storage) GetChallenge(ctx context.Context, challengeID stepcurry.ChallengeID) (challenge stepcurry.StepsChallenge, err error) {
+	challenge, err = scanChallenge(s.queryRow(ctx, `SELECT `+challengeColumns+` FROM steps_challenges WHERE team_id = ? AND channel_id = ? AND date = ?`, challengeID.TeamID, challengeID.ChannelID, challengeID.Date))
+	if err != nil {
+		return stepcurry.StepsChallenge{}, notFound(err)
+	}
+
+	return challenge, nil
+}
+
+// PutChallenge implements stepcurry.Storage
+func (s *Storage) PutChallenge(ctx context.Context, challenge stepcurry.StepsChallenge) (err error) {
+	rankedUsers, err := json.Marshal(challenge.RankedUsers)
+	if err != nil {
+		return errors.Wrap(err, "error encoding ranked users")
+	}
+
+	participants, err := json.Marshal(challenge.Participants)
+	if err != nil {
+		return errors.Wrap(err, "error encoding participants")
+	}
+
+	return s.exec(ctx, `INSERT INTO steps_challenges (`+challengeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id, channel_id, date) DO UPDATE SET active = excluded.active, created_by = excluded.created_by,
+		creation_time = excluded.creation_time, timezone_id = excluded.timezone_id, ranked_users = excluded.ranked_users,
+		reminders_sent = excluded.reminders_sent, metric = excluded.metric, explicit_opt_in = excluded.explicit_opt_in,
+		participants = excluded.participants`,
+		challenge.TeamID, challenge.ChannelID, challenge.Date, challenge.Active, challenge.CreatorID, challenge.CreationTime.UTC(), challenge.TimezoneID, string(rankedUsers), challenge.RemindersSent,
+		challenge.Metric, challenge.ExplicitOptIn, string(participants))
+}
+
+// ListActiveChallenges implements stepcurry.Storage
+func (s *Storage) ListActiveChallenges(ctx context.Context, teamID string) (challenges []stepcurry.StepsChallenge, err error) {
+	challenges = make([]stepcurry.StepsChallenge, 0)
+	err = s.queryRows(ctx, func(row scanner) error {
+		challenge, err := scanChallenge(row)
+		challenges = append(challenges, challenge)
+		return err
+	}, `SELECT `+challengeColumns+` FROM steps_challenges WHERE team_id = ? AND active = ? ORDER BY channel_id, date`, teamID, true)
+
+	return challenges, err
+}
+
+// client access columns, in scan order
+const clientAccessColumns = `team_id, slack_user, fitbit_user, fetch_failures, link_broken, broken_link_notified`
+
+func scanClientAccess(row scanner) (clientAccess stepcurry.ClientAccess, err error) {
+	err = row.Scan(&clientAccess.SlackTeam, &clientAccess.SlackUser, &clientAccess.FitbitUser, &clientAccess.FetchFailures, &clientAccess.LinkBroken, &clientAccess.BrokenLinkNotified)
+	return clientAccess, err
+}
+
+// GetClientAccess implements stepcurry.Storage
+func (s *Storage) GetClientAccess(ctx context.Context, teamID string, slackUser string) (clientAccess stepcurry.ClientAccess, err error) {
+	clientAccess, err = scanClientAccess(s.queryRow(ctx, `SELECT `+clientAccessColumns+` FROM client_accesses WHERE team_id = ? AND slack_user = ?`, teamID, slackUser))
+	if err != nil {
+		return stepcurry.ClientAccess{}, notFound(err)
+	}
+
+	return clientAccess, nil
+}
+
+// PutClientAccess implements stepcurry.Storage
+func (s *Storage) PutClientAccess(ctx context.Context, clientAccess stepcurry.ClientAccess) (err error) {
+	return s.exec(ctx, `INSERT INTO client_accesses (`+clientAccessColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id, slack_user) DO UPDATE SET fitbit_user = excluded.fitbit_user, fetch_failures = excluded.fetch_failures,
+		link_broken = excluded.link_broken, broken_link_notified = excluded.broken_link_notified`,
+		clientAccess.SlackTeam, clientAccess.SlackUser, clientAccess.FitbitUser, clientAccess.FetchFailures, clientAccess.LinkBroken, clientAccess.BrokenLinkNotified)
+}
+
+// DeleteClientAccess implements stepcurry.Storage
+func (s *Storage) DeleteClientAccess(ctx context.Context, teamID string, slackUser string) (err error) {
+	return s.exec(ctx, `DELETE FROM client_accesses WHERE team_id = ? AND slack_user = ?`, teamID, slackUser)
+}
+
+// ListClientAccesses implements stepcurry.Storage
+func (s *Storage) ListClientAccesses(ctx context.Context, teamID string) (clientAccesses []stepcurry.ClientAccess, err error) {
+	clientAccesses = make([]stepcurry.ClientAccess, 0)
+	err = s.queryRows(ctx, func(row scanner) error {
+		clientAccess, err := scanClientAccess(row)
+		clientAccesses = append(clientAccesses, clientAccess)
+		return err
+	}, `SELECT `+clientAccessColumns+` FROM client_accesses WHERE team_id = ? ORDER BY slack_user`, teamID)
+
+	return clientAccesses, err
+}
+
+// api access columns, in scan order
+const apiAccessColumns = `fitbit_user, access_token, refresh_token, expiry, refreshed_at, subscribed`
+
+func scanApiAccess(row scanner) (apiAccess stepcurry.FitbitApiAccess, err error) {
+	err = row.Scan(&apiAccess.FitbitUser, &apiAccess.Token, &apiAccess.RefreshToken, &apiAccess.Expiry, &apiAccess.RefreshedAt, &apiAccess.Subscribed)
+	return apiAccess, err
+}
+
+// GetFitbitApiAccess implements stepcurry.Storage
+func (s *Storage) GetFitbitApiAccess(ctx context.Context, fitbitUser string) (apiAccess stepcurry.FitbitApiAccess, err error) {
+	apiAccess, err = scanApiAccess(s.queryRow(ctx, `SELECT `+apiAccessColumns+` FROM fitbit_api_accesses WHERE fitbit_user = ?`, fitbitUser))
+	if err != nil {
+		return stepcurry.FitbitApiAccess{}, notFound(err)
+	}
+
+	return apiAccess, nil
+}
+
+// PutFitbitApiAccess implements stepcurry.Storage
+func (s *Storage) PutFitbitApiAccess(ctx context.Context, apiAccess stepcurry.FitbitApiAccess) (err error) {
+	return s.exec(ctx, `INSERT INTO fitbit_api_accesses (`+apiAccessColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (fitbit_user) DO UPDATE SET access_token = excluded.access_token, refresh_token = excluded.refresh_token,
+		expiry = excluded.expiry, refreshed_at = excluded.refreshed_at, subscribed = excluded.subscribed`,
+		apiAccess.FitbitUser, apiAccess.Token, apiAccess.RefreshToken, apiAccess.Expiry.UTC(), apiAccess.RefreshedAt.UTC(), apiAccess.Subscribed)
+}
+
+// ListFitbitApiAccesses implements stepcurry.Storage
+func (s *Storage) ListFitbitApiAccesses(ctx context.Context) (apiAccesses []stepcurry.FitbitApiAccess, err error) {
+	apiAccesses = make([]stepcurry.FitbitApiAccess, 0)
+	err = s.queryRows(ctx, func(row scanner) error {
+		apiAccess, err := scanApiAccess(row)
+		apiAccesses = append(apiAccesses, apiAccess)
+		return err
+	}, `SELECT `+apiAccessColumns+` FROM fitbit_api_accesses ORDER BY fitbit_user`)
+
+	return apiAccesses, err
+}
+
+// GetCsrfToken implements stepcurry.Storage
+func (s *Storage) GetCsrfToken(ctx context.Context, teamID string, slackUser string) (csrfToken stepcurry.CsrfToken, err error) {
+	err = s.queryRow(ctx, `SELECT csrf FROM csrf_tokens WHERE team_id = ? AND slack_user = ?`, teamID, slackUser).Scan(&csrfToken.Csrf)
+	if err != nil {
+		return stepcurry.CsrfToken{}, notFound(err)
+	}
+
+	return csrfToken, nil
+}
+
+// PutCsrfToken implements stepcurry.Storage
+func (s *Storage) PutCsrfToken(ctx context.Context, teamID string, slackUser string, csrfToken stepcurry.CsrfToken) (err error) {
+	return s.exec(ctx, `INSERT INTO csrf_tokens (team_id, slack_user, csrf) VALUES (?, ?, ?)
+		ON CONFLICT (team_id, slack_user) DO UPDATE SET csrf = excluded.csrf`, teamID, slackUser, csrfToken.Csrf)
+}
+
+// DeleteCsrfToken implements stepcurry.Storage
+func (s *Storage) DeleteCsrfToken(ctx context.Context, teamID string, slackUser string) (err error) {
+	return s.exec(ctx, `DELETE FROM csrf_tokens WHERE team_id = ? AND slack_user = ?`, teamID, slackUser)
+}
+
+// GetBotInfo implements stepcurry.Storage
+func (s *Storage) GetBotInfo(ctx context.Context, teamID string) (botInfo stepcurry.BotInfo, err error) {
+	err = s.queryRow(ctx, `SELECT bot_user_id, enterprise_id, install_team_id, enterprise_install FROM bot_infos WHERE team_id = ?`, teamID).
+		Scan(&botInfo.UserID, &botInfo.EnterpriseID, &botInfo.TeamID, &botInfo.EnterpriseInstall)
+	if err != nil {
+		return stepcurry.BotInfo{}, notFound(err)
+	}
+
+	return botInfo, nil
+}
+
+// PutBotInfo implements stepcurry.Storage
+func (s *Storage) PutBotInfo(ctx context.Context, teamID string, botInfo stepcurry.BotInfo) (err error) {
+	return s.exec(ctx, `INSERT INTO bot_infos (team_id, bot_user_id, enterprise_id, install_team_id, enterprise_install) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (team_id) DO UPDATE SET bot_user_id = excluded.bot_user_id, enterprise_id = excluded.enterprise_id,
+		install_team_id = excluded.install_team_id, enterprise_install = excluded.enterprise_install`,
+		teamID, botInfo.UserID, botInfo.EnterpriseID, botInfo.TeamID, botInfo.EnterpriseInstall)
+}
+
+// GetSecret implements stepcurry.Storage
+func (s *Storage) GetSecret(ctx context.Context, teamID string, name string) (secret stepcurry.Secret, err error) {
+	err = s.queryRow(ctx, `SELECT name, ciphertext FROM secrets WHERE team_id = ? AND name = ?`, teamID, name).Scan(&secret.Name, &secret.Ciphertext)
+	if err != nil {
+		return stepcurry.Secret{}, notFound(err)
+	}
+
+	return secret, nil
+}
+
+// PutSecret implements stepcurry.Storage
+func (s *Storage) PutSecret(ctx context.Context, teamID string, secret stepcurry.Secret) (err error) {
+	return s.exec(ctx, `INSERT INTO secrets (team_id, name, ciphertext) VALUES (?, ?, ?)
+		ON CONFLICT (team_id, name) DO UPDATE SET ciphertext = excluded.ciphertext`, teamID, secret.Name, secret.Ciphertext)
+}
+
+// GetWorkspaceMessages implements stepcurry.Storage
+func (s *Storage) GetWorkspaceMessages(ctx context.Context, teamID string) (workspaceMessages stepcurry.WorkspaceMessages, err error) {
+	var templates string
+	err = s.queryRow(ctx, `SELECT team_id, bot_name, bot_icon_emoji, default_language, templates FROM workspace_messages WHERE team_id = ?`, teamID).
+		Scan(&workspaceMessages.TeamID, &workspaceMessages.BotName, &workspaceMessages.BotIconEmoji, &workspaceMessages.DefaultLanguage, &templates)
+	if err != nil {
+		return stepcurry.WorkspaceMessages{}, notFound(err)
+	}
+
+	err = json.Unmarshal([]byte(templates), &workspaceMessages.Templates)
+	return workspaceMessages, err
+}
+
+// PutWorkspaceMessages implements stepcurry.Storage
+func (s *Storage) PutWorkspaceMessages(ctx context.Context, workspaceMessages stepcurry.WorkspaceMessages) (err error) {
+	templates, err := json.Marshal(workspaceMessages.Templates)
+	if err != nil {
+		return errors.Wrap(err, "error encoding message templates")
+	}
+
+	return s.exec(ctx, `INSERT INTO workspace_messages (team_id, bot_name, bot_icon_emoji, default_language, templates) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (team_id) DO UPDATE SET bot_name = excluded.bot_name, bot_icon_emoji = excluded.bot_icon_emoji, default_language = excluded.default_language, templates = excluded.templates`,
+		workspaceMessages.TeamID, workspaceMessages.BotName, workspaceMessages.BotIconEmoji, workspaceMessages.DefaultLanguage, string(templates))
+}
+
+// GetWorkspaceSettings implements stepcurry.Storage
+func (s *Storage) GetWorkspaceSettings(ctx context.Context, teamID string) (settings stepcurry.WorkspaceSettings, err error) {
+	var channelRestrictions string
+	err = s.queryRow(ctx, `SELECT team_id, timezone, update_interval_minutes, quiet_hours_start, quiet_hours_end, metric, opt_in_mode, language, challenge_starters, daily_challenge_cap, channel_restrictions FROM workspace_settings WHERE team_id = ?`, teamID).
+		Scan(&settings.TeamID, &settings.Timezone, &settings.UpdateIntervalMinutes, &settings.QuietHoursStart, &settings.QuietHoursEnd, &settings.Metric, &settings.OptInMode, &settings.Language, &settings.ChallengeStarters, &settings.DailyChallengeCap, &channelRestrictions)
+	if err != nil {
+		return stepcurry.WorkspaceSettings{}, notFound(err)
+	}
+
+	err = json.Unmarshal([]byte(channelRestrictions), &settings.ChannelRestrictions)
+	return settings, err
+}
+
+// PutWorkspaceSettings implements stepcurry.Storage
+func (s *Storage) PutWorkspaceSettings(ctx context.Context, settings stepcurry.WorkspaceSettings) (err error) {
+	channelRestrictions, err := json.Marshal(settings.ChannelRestrictions)
+	if err != nil {
+		return errors.Wrap(err, "error encoding channel restrictions")
+	}
+
+	return s.exec(ctx, `INSERT INTO workspace_settings (team_id, timezone, update_interval_minutes, quiet_hours_start, quiet_hours_end, metric, opt_in_mode, language, challenge_starters, daily_challenge_cap, channel_restrictions) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id) DO UPDATE SET timezone = excluded.timezone, update_interval_minutes = excluded.update_interval_minutes, quiet_hours_start = excluded.quiet_hours_start,
+		quiet_hours_end = excluded.quiet_hours_end, metric = excluded.metric, opt_in_mode = excluded.opt_in_mode, language = excluded.language, challenge_starters = excluded.challenge_starters,
+		daily_challenge_cap = excluded.daily_challenge_cap, channel_restrictions = excluded.channel_restrictions`,
+		settings.TeamID, settings.Timezone, settings.UpdateIntervalMinutes, settings.QuietHoursStart, settings.QuietHoursEnd, settings.Metric, settings.OptInMode, settings.Language, settings.ChallengeStarters,
+		settings.DailyChallengeCap, string(channelRestrictions))
+}
+
+// GetLeaderboard implements stepcurry.Storage
+func (s *Storage) GetLeaderboard(ctx context.Context, teamID string, scope string, channelID string, period string, periodID string) (leaderboard stepcurry.Leaderboard, err error) {
+	var standings string
+	err = s.queryRow(ctx, `SELECT scope, channel_id, period, period_id, challenges, standings FROM leaderboards WHERE team_id = ? AND name = ?`, teamID, stepcurry.LeaderboardName(scope, channelID, period, periodID)).
+		Scan(&leaderboard.Scope, &leaderboard.ChannelID, &leaderboard.Period, &leaderboard.PeriodID, &leaderboard.Challenges, &standings)
+	if err != nil {
+		return stepcurry.Leaderboard{}, notFound(err)
+	}
+
+	err = json.Unmarshal([]byte(standings), &leaderboard.Standings)
+	return leaderboard, err
+}
+
+// PutLeaderboard implements stepcurry.Storage
+func (s *Storage) PutLeaderboard(ctx context.Context, teamID string, leaderboard stepcurry.Leaderboard) (err error) {
+	standings, err := json.Marshal(leaderboard.Standings)
+	if err != nil {
+		return errors.Wrap(err, "error encoding standings")
+	}
+
+	return s.exec(ctx, `INSERT INTO leaderboards (team_id, name, scope, channel_id, period, period_id, challenges, standings) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id, name) DO UPDATE SET challenges = excluded.challenges, standings = excluded.standings`,
+		teamID, stepcurry.LeaderboardName(leaderboard.Scope, leaderboard.ChannelID, leaderboard.Period, leaderboard.PeriodID), leaderboard.Scope, leaderboard.ChannelID, leaderboard.Period, leaderboard.PeriodID, leaderboard.Challenges, string(standings))
+}
+
+// GetUserAchievements implements stepcurry.Storage
+func (s *Storage) GetUserAchievements(ctx context.Context, teamID string, userID string) (achievements stepcurry.UserAchievements, err error) {
+	var badges string
+	err = s.queryRow(ctx, `SELECT user_id, personal_best, win_streak, last_win_date, badges FROM user_achievements WHERE team_id = ? AND user_id = ?`, teamID, userID).
+		Scan(&achievements.UserID, &achievements.PersonalBest, &achievements.WinStreak, &achievements.LastWinDate, &badges)
+	if err != nil {
+		return stepcurry.UserAchievements{}, notFound(err)
+	}
+
+	err = json.Unmarshal([]byte(badges), &achievements.Badges)
+	return achievements, err
+}
+
+// PutUserAchievements implements stepcurry.Storage
+func (s *Storage) PutUserAchievements(ctx context.Context, teamID string, achievements stepcurry.UserAchievements) (err error) {
+	badges, err := json.Marshal(achievements.Badges)
+	if err != nil {
+		return errors.Wrap(err, "error encoding badges")
+	}
+
+	return s.exec(ctx, `INSERT INTO user_achievements (team_id, user_id, personal_best, win_streak, last_win_date, badges) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET personal_best = excluded.personal_best, win_streak = excluded.win_streak,
+		last_win_date = excluded.last_win_date, badges = excluded.badges`,
+		teamID, achievements.UserID, achievements.PersonalBest, achievements.WinStreak, achievements.LastWinDate, string(badges))
+}
+
+// GetUserStreaks implements stepcurry.Storage
+func (s *Storage) GetUserStreaks(ctx context.Context, teamID string, userID string) (streaks stepcurry.UserStreaks, err error) {
+	err = s.queryRow(ctx, `SELECT user_id, participation_streak, last_participation, goal_streak, last_goal_hit, reminders_enabled, last_reminder FROM user_streaks WHERE team_id = ? AND user_id = ?`, teamID, userID).
+		Scan(&streaks.UserID, &streaks.ParticipationStreak, &streaks.LastParticipation, &streaks.GoalStreak, &streaks.LastGoalHit, &streaks.RemindersEnabled, &streaks.LastReminder)
+	if err != nil {
+		return stepcurry.UserStreaks{}, notFound(err)
+	}
+
+	return streaks, nil
+}
+
+// PutUserStreaks implements stepcurry.Storage
+func (s *Storage) PutUserStreaks(ctx context.Context, teamID string, streaks stepcurry.UserStreaks) (err error) {
+	return s.exec(ctx, `INSERT INTO user_streaks (team_id, user_id, participation_streak, last_participation, goal_streak, last_goal_hit, reminders_enabled, last_reminder) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET participation_streak = excluded.participation_streak, last_participation = excluded.last_participation,
+		goal_streak = excluded.goal_streak, last_goal_hit = excluded.last_goal_hit, reminders_enabled = excluded.reminders_enabled, last_reminder = excluded.last_reminder`,
+		teamID, streaks.UserID, streaks.ParticipationStreak, streaks.LastParticipation, streaks.GoalStreak, streaks.LastGoalHit, streaks.RemindersEnabled, streaks.LastReminder)
+}
+
+// GetDailyActivity implements stepcurry.Storage
+func (s *Storage) GetDailyActivity(ctx context.Context, fitbitUser string, date string) (activity stepcurry.DailyActivity, err error) {
+	err = s.queryRow(ctx, `SELECT fitbit_user, date, steps, floors, goal, updated_at FROM daily_activities WHERE fitbit_user = ? AND date = ?`, fitbitUser, date).
+		Scan(&activity.FitbitUser, &activity.Date, &activity.Steps, &activity.Floors, &activity.Goal, &activity.UpdatedAt)
+	if err != nil {
+		return stepcurry.DailyActivity{}, notFound(err)
+	}
+
+	return activity, nil
+}
+
+// PutDailyActivity implements stepcurry.Storage
+func (s *Storage) PutDailyActivity(ctx context.Context, activity stepcurry.DailyActivity) (err error) {
+	return s.exec(ctx, `INSERT INTO daily_activities (fitbit_user, date, steps, floors, goal, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (fitbit_user, date) DO UPDATE SET steps = excluded.steps, floors = excluded.floors, goal = excluded.goal, updated_at = excluded.updated_at`,
+		activity.FitbitUser, activity.Date, activity.Steps, activity.Floors, activity.Goal, activity.UpdatedAt.UTC())
+}
+
+// job columns, in scan order
+const jobColumns = `name, kind, team_id, channel_id, date, scheduled_time, attempts, fitbit_user, activity_date, home_team_id, home_user_id, perfect_month_team_id,
+	perfect_month_channel_id, perfect_month`
+
+func scanJob(row scanner) (job stepcurry.Job, err error) {
+	err = row.Scan(&job.Name, &job.Kind, &job.ChallengeID.TeamID, &job.ChallengeID.ChannelID, &job.ChallengeID.Date, &job.ScheduledTime, &job.Attempts, &job.Activity.FitbitUser, &job.Activity.Date, &job.Home.TeamID, &job.Home.UserID,
+		&job.PerfectMonth.TeamID, &job.PerfectMonth.ChannelID, &job.PerfectMonth.Month)
+	return job, err
+}
+
+// GetJob implements stepcurry.Storage
+func (s *Storage) GetJob(ctx context.Context, name string) (job stepcurry.Job, err error) {
+	job, err = scanJob(s.queryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE name = ?`, name))
+	if err != nil {
+		return stepcurry.Job{}, notFound(err)
+	}
+
+	return job, nil
+}
+
+// PutJob implements stepcurry.Storage
+func (s *Storage) PutJob(ctx context.Context, job stepcurry.Job) (err error) {
+	return s.exec(ctx, `INSERT INTO jobs (`+jobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET kind = excluded.kind, team_id = excluded.team_id, channel_id = excluded.channel_id,
+		date = excluded.date, scheduled_time = excluded.scheduled_time, attempts = excluded.attempts,
+		fitbit_user = excluded.fitbit_user, activity_date = excluded.activity_date, home_team_id = excluded.home_team_id,
+		home_user_id = excluded.home_user_id, perfect_month_team_id = excluded.perfect_month_team_id,
+		perfect_month_channel_id = excluded.perfect_month_channel_id, perfect_month = excluded.perfect_month`,
+		job.Name, job.Kind, job.ChallengeID.TeamID, job.ChallengeID.ChannelID, job.ChallengeID.Date, job.ScheduledTime.UTC(), job.Attempts,
+		job.Activity.FitbitUser, job.Activity.Date, job.Home.TeamID, job.Home.UserID, job.PerfectMonth.TeamID, job.PerfectMonth.ChannelID,
+		job.PerfectMonth.Month)
+}
+
+// DeleteJob implements stepcurry.Storage
+func (s *Storage) DeleteJob(ctx context.Context, name string) (err error) {
+	return s.exec(ctx, `DELETE FROM jobs WHERE name = ?`, name)
+}
+
+// ListDueJobs implements stepcurry.Storage
+func (s *Storage) ListDueJobs(ctx context.Context, now time.Time, limit int) (jobs []stepcurry.Job, err error) {
+	jobs = make([]stepcurry.Job, 0)
+	err = s.queryRows(ctx, func(row scanner) error {
+		job, err := scanJob(row)
+		jobs = append(jobs, job)
+		return err
+	}, `SELECT `+jobColumns+` FROM jobs WHERE scheduled_time <= ? ORDER BY scheduled_time, name LIMIT ?`, now.UTC(), limit)
+
+	return jobs, err
+}
@@ -0,0 +1,445 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresTestDSNEnv is the environment variable holding the data source name of a Postgres database to run the
+// tests against. Postgres tests are skipped when it's not set
+const postgresTestDSNEnv = "STEPCURRY_TEST_POSTGRES_DSN"
+
+func newSQLiteTestStorage(t *testing.T) (storage *Storage, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlstorage")
+	require.NoError(t, err)
+
+	storage, err = NewSQLite(filepath.Join(dir, "stepcurry.db"))
+	require.NoError(t, err)
+
+	return storage, func() {
+		storage.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newPostgresTestStorage(t *testing.T) (storage *Storage, cleanup func()) {
+	dsn := os.Getenv(postgresTestDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresTestDSNEnv)
+	}
+
+	storage, err := NewPostgres(dsn)
+	require.NoError(t, err)
+
+	tables := []string{"steps_challenges", "client_accesses", "fitbit_api_accesses", "csrf_tokens", "bot_infos", "leaderboards", "user_achievements", "user_streaks", "daily_activities", "jobs", "workspace_messages", "workspace_settings", "secrets"}
+	for _, table := range tables {
+		_, err = storage.db.Exec(fmt.Sprintf("DELETE FROM %s", table))
+		require.NoError(t, err)
+	}
+
+	return storage, func() { storage.Close() }
+}
+
+// forEachBackend runs a test against every available backend
+func forEachBackend(t *testing.T, test func(t *testing.T, storage *Storage)) {
+	backends := map[string]func(t *testing.T) (*Storage, func()){
+		"SQLite":   newSQLiteTestStorage,
+		"Postgres": newPostgresTestStorage,
+	}
+
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			storage, cleanup := newStorage(t)
+			defer cleanup()
+
+			test(t, storage)
+		})
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+		challengeID := stepcurry.ChallengeID{TeamID: "T1", ChannelID: "C1", Date: "2019-10-11"}
+
+		_, err := storage.GetChallenge(ctx, challengeID)
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		challenge := stepcurry.StepsChallenge{ChallengeID: challengeID, Active: true, CreatorID: "U1", CreationTime: time.Date(2019, 10, 11, 9, 30, 0, 0, time.UTC), TimezoneID: "America/Los_Angeles"}
+		require.NoError(t, storage.PutChallenge(ctx, challenge))
+
+		loaded, err := storage.GetChallenge(ctx, challengeID)
+		require.NoError(t, err)
+		assert.True(t, challenge.CreationTime.Equal(loaded.CreationTime))
+		loaded.CreationTime = challenge.CreationTime
+		assert.Equal(t, challenge, loaded)
+
+		challenge.RankedUsers = []stepcurry.UserSteps{{UserID: "U1", Steps: 12000, Goal: 10000}, {UserID: "U2", Steps: 4000, Goal: 8000}}
+		challenge.RemindersSent = true
+		challenge.Metric = "floors"
+		challenge.ExplicitOptIn = true
+		challenge.Participants = []string{"U1", "U2"}
+		require.NoError(t, storage.PutChallenge(ctx, challenge))
+
+		require.NoError(t, storage.PutChallenge(ctx, stepcurry.StepsChallenge{ChallengeID: stepcurry.ChallengeID{TeamID: "T1", ChannelID: "C2", Date: "2019-10-11"}, Active: false}))
+		require.NoError(t, storage.PutChallenge(ctx, stepcurry.StepsChallenge{ChallengeID: stepcurry.ChallengeID{TeamID: "T2", ChannelID: "C1", Date: "2019-10-11"}, Active: true}))
+
+		active, err := storage.ListActiveChallenges(ctx, "T1")
+		require.NoError(t, err)
+		require.Len(t, active, 1)
+		assert.Equal(t, challenge.RankedUsers, active[0].RankedUsers)
+		assert.True(t, active[0].RemindersSent)
+		assert.Equal(t, "floors", active[0].Metric)
+		assert.True(t, active[0].ExplicitOptIn)
+		assert.Equal(t, []string{"U1", "U2"}, active[0].Participants)
+	})
+}
+
+func TestClientAccesses(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetClientAccess(ctx, "T1", "U1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		clientAccess := stepcurry.ClientAccess{SlackTeam: "T1", SlackUser: "U1", FitbitUser: "FITBITUSER1"}
+		require.NoError(t, storage.PutClientAccess(ctx, clientAccess))
+		require.NoError(t, storage.PutClientAccess(ctx, stepcurry.ClientAccess{SlackTeam: "T1", SlackUser: "U2", FitbitUser: "FITBITUSER2"}))
+		require.NoError(t, storage.PutClientAccess(ctx, stepcurry.ClientAccess{SlackTeam: "T2", SlackUser: "U3", FitbitUser: "FITBITUSER3"}))
+
+		clientAccess.FetchFailures = 3
+		clientAccess.LinkBroken = true
+		clientAccess.BrokenLinkNotified = true
+		require.NoError(t, storage.PutClientAccess(ctx, clientAccess))
+
+		loaded, err := storage.GetClientAccess(ctx, "T1", "U1")
+		require.NoError(t, err)
+		assert.Equal(t, clientAccess, loaded)
+
+		teamAccesses, err := storage.ListClientAccesses(ctx, "T1")
+		require.NoError(t, err)
+		assert.Equal(t, []stepcurry.ClientAccess{clientAccess, {SlackTeam: "T1", SlackUser: "U2", FitbitUser: "FITBITUSER2"}}, teamAccesses)
+
+		require.NoError(t, storage.DeleteClientAccess(ctx, "T1", "U1"))
+		_, err = storage.GetClientAccess(ctx, "T1", "U1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+	})
+}
+
+func TestFitbitApiAccesses(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetFitbitApiAccess(ctx, "FITBITUSER1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		refreshedAt := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+		apiAccess := stepcurry.FitbitApiAccess{FitbitUser: "FITBITUSER1", Token: "token", RefreshToken: "refresh", Expiry: refreshedAt.Add(8 * time.Hour), RefreshedAt: refreshedAt, Subscribed: true}
+		require.NoError(t, storage.PutFitbitApiAccess(ctx, apiAccess))
+		require.NoError(t, storage.PutFitbitApiAccess(ctx, stepcurry.FitbitApiAccess{FitbitUser: "FITBITUSER2", Token: "token2"}))
+
+		loaded, err := storage.GetFitbitApiAccess(ctx, "FITBITUSER1")
+		require.NoError(t, err)
+		assert.True(t, apiAccess.Expiry.Equal(loaded.Expiry))
+		assert.True(t, apiAccess.RefreshedAt.Equal(loaded.RefreshedAt))
+		assert.Equal(t, apiAccess.Token, loaded.Token)
+		assert.Equal(t, apiAccess.RefreshToken, loaded.RefreshToken)
+		assert.True(t, loaded.Subscribed)
+
+		all, err := storage.ListFitbitApiAccesses(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		assert.Equal(t, "FITBITUSER2", all[1].FitbitUser)
+		assert.True(t, all[1].Expiry.IsZero())
+	})
+}
+
+func TestCsrfTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		require.NoError(t, storage.PutCsrfToken(ctx, "T1", "U1", stepcurry.CsrfToken{Csrf: []byte{1, 2, 3}}))
+
+		loaded, err := storage.GetCsrfToken(ctx, "T1", "U1")
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3}, loaded.Csrf)
+
+		require.NoError(t, storage.DeleteCsrfToken(ctx, "T1", "U1"))
+
+		_, err = storage.GetCsrfToken(ctx, "T1", "U1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+	})
+}
+
+func TestBotInfos(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetBotInfo(ctx, "T1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		require.NoError(t, storage.PutBotInfo(ctx, "T1", stepcurry.BotInfo{UserID: "B1"}))
+		require.NoError(t, storage.PutBotInfo(ctx, "T1", stepcurry.BotInfo{UserID: "B2"}))
+
+		loaded, err := storage.GetBotInfo(ctx, "T1")
+		require.NoError(t, err)
+		assert.Equal(t, stepcurry.BotInfo{UserID: "B2"}, loaded)
+
+		orgInstall := stepcurry.BotInfo{UserID: "B3", EnterpriseID: "E1", EnterpriseInstall: true}
+		require.NoError(t, storage.PutBotInfo(ctx, "E1", orgInstall))
+
+		loaded, err = storage.GetBotInfo(ctx, "E1")
+		require.NoError(t, err)
+		assert.Equal(t, orgInstall, loaded)
+	})
+}
+
+func TestSecrets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetSecret(ctx, "T1", "slackToken")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		require.NoError(t, storage.PutSecret(ctx, "T1", stepcurry.Secret{Name: "slackToken", Ciphertext: []byte("sealed1")}))
+		require.NoError(t, storage.PutSecret(ctx, "T1", stepcurry.Secret{Name: "slackToken", Ciphertext: []byte("sealed2")}))
+		require.NoError(t, storage.PutSecret(ctx, "T2", stepcurry.Secret{Name: "slackToken", Ciphertext: []byte("sealed3")}))
+
+		loaded, err := storage.GetSecret(ctx, "T1", "slackToken")
+		require.NoError(t, err)
+		assert.Equal(t, stepcurry.Secret{Name: "slackToken", Ciphertext: []byte("sealed2")}, loaded)
+	})
+}
+
+func TestWorkspaceMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetWorkspaceMessages(ctx, "T1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		require.NoError(t, storage.PutWorkspaceMessages(ctx, stepcurry.WorkspaceMessages{TeamID: "T1", BotName: "Coach"}))
+		workspaceMessages := stepcurry.WorkspaceMessages{TeamID: "T1", BotName: "Coach", BotIconEmoji: ":runner:", DefaultLanguage: "fr", Templates: []stepcurry.MessageTemplate{{Name: "winnerBanner", Text: ":trophy: Gold for yesterday's challenge goes to"}, {Name: "winnerBanner", Language: "fr", Text: ":trophy: L'or du défi d'hier revient à"}}}
+		require.NoError(t, storage.PutWorkspaceMessages(ctx, workspaceMessages))
+
+		loaded, err := storage.GetWorkspaceMessages(ctx, "T1")
+		require.NoError(t, err)
+		assert.Equal(t, workspaceMessages, loaded)
+	})
+}
+
+func TestWorkspaceSettings(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetWorkspaceSettings(ctx, "T1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		require.NoError(t, storage.PutWorkspaceSettings(ctx, stepcurry.DefaultWorkspaceSettings("T1")))
+		settings := stepcurry.WorkspaceSettings{TeamID: "T1", Timezone: "Asia/Tokyo", UpdateIntervalMinutes: 120, QuietHoursStart: 21, QuietHoursEnd: 7, Metric: "floors", OptInMode: "explicit", Language: "ja", ChallengeStarters: "admins",
+			DailyChallengeCap: 2, ChannelRestrictions: []stepcurry.ChannelRestriction{{ChannelID: "C1", UserGroups: []string{"S1", "S2"}}, {ChannelID: "C2"}}}
+		require.NoError(t, storage.PutWorkspaceSettings(ctx, settings))
+
+		loaded, err := storage.GetWorkspaceSettings(ctx, "T1")
+		require.NoError(t, err)
+		assert.Equal(t, settings, loaded)
+	})
+}
+
+func TestLeaderboards(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetLeaderboard(ctx, "T1", "channel", "C1", "month", "2019-10")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		channelLeaderboard := stepcurry.Leaderboard{Scope: "channel", ChannelID: "C1", Period: "month", PeriodID: "2019-10", Challenges: 2, Standings: []stepcurry.LeaderboardRecord{{UserID: "U1", Wins: 2, Podiums: 2, TotalSteps: 30000, Challenges: 2}}}
+		workspaceLeaderboard := stepcurry.Leaderboard{Scope: "workspace", Period: "month", PeriodID: "2019-10", Challenges: 5}
+		require.NoError(t, storage.PutLeaderboard(ctx, "T1", channelLeaderboard))
+		require.NoError(t, storage.PutLeaderboard(ctx, "T1", workspaceLeaderboard))
+
+		loaded, err := storage.GetLeaderboard(ctx, "T1", "channel", "C1", "month", "2019-10")
+		require.NoError(t, err)
+		assert.Equal(t, channelLeaderboard, loaded)
+
+		// The channel is ignored for workspace leaderboards
+		loaded, err = storage.GetLeaderboard(ctx, "T1", "workspace", "C1", "month", "2019-10")
+		require.NoError(t, err)
+		assert.Equal(t, 5, loaded.Challenges)
+	})
+}
+
+func TestUserAchievementsAndStreaks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetUserAchievements(ctx, "T1", "U1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+		_, err = storage.GetUserStreaks(ctx, "T1", "U1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		achievements := stepcurry.UserAchievements{UserID: "U1", PersonalBest: 21000, WinStreak: 2, LastWinDate: "2019-10-11", Badges: []stepcurry.AwardedBadge{{BadgeID: "first20kDay", Count: 1, FirstAwarded: "2019-10-11", LastAwardKey: "2019-10-11"}}}
+		require.NoError(t, storage.PutUserAchievements(ctx, "T1", achievements))
+
+		loadedAchievements, err := storage.GetUserAchievements(ctx, "T1", "U1")
+		require.NoError(t, err)
+		assert.Equal(t, achievements, loadedAchievements)
+
+		streaks := stepcurry.UserStreaks{UserID: "U1", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 2, LastGoalHit: "2019-10-11", RemindersEnabled: true, LastReminder: "2019-10-10"}
+		require.NoError(t, storage.PutUserStreaks(ctx, "T1", streaks))
+
+		loadedStreaks, err := storage.GetUserStreaks(ctx, "T1", "U1")
+		require.NoError(t, err)
+		assert.Equal(t, streaks, loadedStreaks)
+	})
+}
+
+func TestDailyActivities(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetDailyActivity(ctx, "FITBITUSER1", "2019-10-11")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		activity := stepcurry.DailyActivity{FitbitUser: "FITBITUSER1", Date: "2019-10-11", Steps: 4000, Floors: 3, Goal: 10000, UpdatedAt: time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)}
+		require.NoError(t, storage.PutDailyActivity(ctx, activity))
+		activity.Steps = 5000
+		activity.Floors = 5
+		require.NoError(t, storage.PutDailyActivity(ctx, activity))
+
+		loaded, err := storage.GetDailyActivity(ctx, "FITBITUSER1", "2019-10-11")
+		require.NoError(t, err)
+		assert.Equal(t, 5000, loaded.Steps)
+		assert.Equal(t, 5, loaded.Floors)
+		assert.True(t, activity.UpdatedAt.Equal(loaded.UpdatedAt))
+	})
+}
+
+func TestJobs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		_, err := storage.GetJob(ctx, "job1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		now := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+		challengeID := stepcurry.ChallengeID{TeamID: "TEAM1", ChannelID: "CHANNEL1", Date: "2019-10-11"}
+		jobs := []stepcurry.Job{
+			{Name: "job3", Kind: stepcurry.JobKindTokenSweep, ScheduledTime: now.Add(time.Minute)},
+			{Name: "job2", Kind: stepcurry.JobKindChallengeUpdate, ChallengeID: challengeID, ScheduledTime: now},
+			{Name: "job1", Kind: stepcurry.JobKindChallengeUpdate, ChallengeID: challengeID, ScheduledTime: now.Add(-time.Minute)},
+			{Name: "job0", Kind: stepcurry.JobKindTokenSweep, ScheduledTime: now.Add(-time.Minute)},
+		}
+		for _, job := range jobs {
+			require.NoError(t, storage.PutJob(ctx, job))
+		}
+
+		jobs[1].Attempts = 2
+		require.NoError(t, storage.PutJob(ctx, jobs[1]))
+
+		due, err := storage.ListDueJobs(ctx, now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 3)
+		assert.Equal(t, []string{"job0", "job1", "job2"}, []string{due[0].Name, due[1].Name, due[2].Name})
+		assert.Equal(t, challengeID, due[1].ChallengeID)
+		assert.Equal(t, 2, due[2].Attempts)
+
+		due, err = storage.ListDueJobs(ctx, now, 1)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+
+		require.NoError(t, storage.DeleteJob(ctx, "job1"))
+		_, err = storage.GetJob(ctx, "job1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		loaded, err := storage.GetJob(ctx, "job3")
+		require.NoError(t, err)
+		assert.True(t, jobs[0].ScheduledTime.Equal(loaded.ScheduledTime))
+
+		activityJob := stepcurry.Job{Name: "job4", Kind: stepcurry.JobKindActivityUpdate, Activity: stepcurry.ActivityUpdate{FitbitUser: "FITBITUSER", Date: "2019-10-11"}, ScheduledTime: now}
+		require.NoError(t, storage.PutJob(ctx, activityJob))
+		loaded, err = storage.GetJob(ctx, "job4")
+		require.NoError(t, err)
+		assert.Equal(t, activityJob.Activity, loaded.Activity)
+
+		homeJob := stepcurry.Job{Name: "job5", Kind: stepcurry.JobKindHomePublish, Home: stepcurry.HomeUpdate{TeamID: "TEAMID", UserID: "U1"}, ScheduledTime: now}
+		require.NoError(t, storage.PutJob(ctx, homeJob))
+		loaded, err = storage.GetJob(ctx, "job5")
+		require.NoError(t, err)
+		assert.Equal(t, homeJob.Home, loaded.Home)
+
+		perfectMonthJob := stepcurry.Job{Name: "job6", Kind: stepcurry.JobKindPerfectMonth, PerfectMonth: stepcurry.PerfectMonthEvaluation{TeamID: "TEAMID", ChannelID: "CID", Month: "2019-10"}, ScheduledTime: now}
+		require.NoError(t, storage.PutJob(ctx, perfectMonthJob))
+		loaded, err = storage.GetJob(ctx, "job6")
+		require.NoError(t, err)
+		assert.Equal(t, perfectMonthJob.PerfectMonth, loaded.PerfectMonth)
+	})
+}
+
+func TestRunInTransaction(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage *Storage) {
+		ctx := context.Background()
+
+		err := storage.RunInTransaction(ctx, func(tc context.Context) error {
+			err := storage.PutBotInfo(tc, "T1", stepcurry.BotInfo{UserID: "B1"})
+			if err != nil {
+				return err
+			}
+
+			return fmt.Errorf("something went wrong")
+		})
+		assert.EqualError(t, err, "something went wrong")
+
+		_, err = storage.GetBotInfo(ctx, "T1")
+		assert.Equal(t, stepcurry.ErrNoSuchEntity, err)
+
+		err = storage.RunInTransaction(ctx, func(tc context.Context) error {
+			return storage.RunInTransaction(tc, func(nested context.Context) error {
+				return storage.PutBotInfo(nested, "T1", stepcurry.BotInfo{UserID: "B1"})
+			})
+		})
+		require.NoError(t, err)
+
+		loaded, err := storage.GetBotInfo(ctx, "T1")
+		require.NoError(t, err)
+		assert.Equal(t, "B1", loaded.UserID)
+	})
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlstorage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stepcurry.db")
+	storage, err := NewSQLite(path)
+	require.NoError(t, err)
+	require.NoError(t, storage.PutBotInfo(context.Background(), "T1", stepcurry.BotInfo{UserID: "B1"}))
+	require.NoError(t, storage.Close())
+
+	storage, err = NewSQLite(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	var versions int
+	require.NoError(t, storage.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&versions))
+	assert.Equal(t, len(sqlite.migrations), versions)
+
+	loaded, err := storage.GetBotInfo(context.Background(), "T1")
+	require.NoError(t, err)
+	assert.Equal(t, "B1", loaded.UserID)
+}
+
+func TestRebind(t *testing.T) {
+	assert.Equal(t, "SELECT a FROM t WHERE b = $1 AND c = $2", postgres.rebind("SELECT a FROM t WHERE b = ? AND c = ?"))
+	assert.Equal(t, "SELECT a FROM t WHERE b = ? AND c = ?", sqlite.rebind("SELECT a FROM t WHERE b = ? AND c = ?"))
+}
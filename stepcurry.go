@@ -9,6 +9,7 @@ import (
 	"go.opentelemetry.io/otel/metric"
 	otel "go.opentelemetry.io/otel/metric/global"
 	"net/http"
+	"time"
 )
 
 const (
@@ -17,23 +18,28 @@ const (
 
 // StepCurry holds state and dependencies for a server instance
 type StepCurry struct {
-	baseURL            string
-	fitbitAuthBaseURL  string
-	fitbitAPIBaseURL   string
-	slackBaseURL       string
-	slackAppID         string
-	slackClientID      string
-	slackClientSecret  string
-	fitbitClientID     string
-	fitbitClientSecret string
-	debug              bool
-	storer             Datastorer
-	verifier           Verifier
-	taskScheduler      TaskScheduler
-	paths              Paths
-	slashCommands      SlashCommands
-	meter              metric.Meter
-	instruments        *instruments
+	baseURL                string
+	fitbitAuthBaseURL      string
+	fitbitAPIBaseURL       string
+	slackBaseURL           string
+	slackAppID             string
+	slackClientID          string
+	slackClientSecret      string
+	fitbitClientID         string
+	fitbitClientSecret     string
+	fitbitVerificationCode string
+	debug                  bool
+	storer                 Datastorer
+	storage                Storage
+	verifier               Verifier
+	taskScheduler          TaskScheduler
+	scheduler              Scheduler
+	clock                  Clock
+	paths                  Paths
+	slashCommands          SlashCommands
+	meter                  metric.Meter
+	instruments            *instruments
+	logger                 Logger
 	TeamRouter
 }
 
@@ -44,13 +50,32 @@ type Paths struct {
 	LinkAccount        string
 	StartChallenge     string
 	Standings          string
+	Leaderboard        string
+	Badges             string
+	Reminders          string
+	Me                 string
+	SweepTokens        string
+	FitbitSubscription string
+	RecordActivity     string
+	PublishHome        string
+	PerfectMonth       string
+	InstallSlack       string
+	SlackAuthCallback  string
+	Config             string
+	Interaction        string
+	Events             string
 }
 
 // SlashCommands holds the names of the app's slash commands
 type SlashCommands struct {
-	Link      string
-	Challenge string
-	Standings string
+	Link        string
+	Challenge   string
+	Standings   string
+	Leaderboard string
+	Badges      string
+	Reminders   string
+	Me          string
+	Config      string
 }
 
 // instruments holds general application metrics
@@ -159,6 +184,30 @@ type ConversationMemberFinder interface {
 	GetUsersInConversation(params *slack.GetUsersInConversationParameters) (members []string, cursor string, err error)
 }
 
+// ConversationJoiner defines the interface for joining conversations
+type ConversationJoiner interface {
+	// JoinConversation joins a public channel. See https://godoc.org/github.com/slack-go/slack#Client.JoinConversation for more details
+	JoinConversation(channelID string) (channel *slack.Channel, warning string, warnings []string, err error)
+}
+
+// UserGroupMemberFinder defines the interface for finding the members of user groups
+type UserGroupMemberFinder interface {
+	// GetUserGroupMembers fetches the members of a user group. See https://godoc.org/github.com/slack-go/slack#Client.GetUserGroupMembers for more details
+	GetUserGroupMembers(userGroup string) (members []string, err error)
+}
+
+// ViewOpener defines the interface for opening modals
+type ViewOpener interface {
+	// OpenView opens a modal in response to an interaction. See https://godoc.org/github.com/slack-go/slack#Client.OpenView for more details
+	OpenView(triggerID string, view slack.ModalViewRequest) (resp *slack.ViewResponse, err error)
+}
+
+// HomePublisher defines the interface for publishing the app home tab of users
+type HomePublisher interface {
+	// PublishView publishes a user's home tab. See https://godoc.org/github.com/slack-go/slack#Client.PublishView for more details
+	PublishView(userID string, view slack.HomeTabViewRequest, hash string) (resp *slack.ViewResponse, err error)
+}
+
 // OptionTaskScheduler sets a taskScheduler as the implementation on StepCurry
 func OptionTaskScheduler(taskScheduler TaskScheduler) Option {
 	return func(sc *StepCurry) (err error) {
@@ -181,75 +230,238 @@ type TeamServices struct {
 	botIdentificator         BotIdentificator
 	messenger                Messenger
 	conversationMemberFinder ConversationMemberFinder
+	conversationJoiner       ConversationJoiner
+	viewOpener               ViewOpener
+	userGroupMemberFinder    UserGroupMemberFinder
+	homePublisher            HomePublisher
+	messages                 *Messages
+	settings                 WorkspaceSettings
+	logger                   Logger
 }
 
-// TeamRouter defines the interface for routing to various tenanted services on team ID
+// TeamRouter defines the interface for routing to various tenanted services on team ID or, for org-wide installations
+// on Enterprise Grid, on enterprise ID
 type TeamRouter interface {
 	Route(teamID string) (svcs TeamServices, err error)
+	// Invalidate drops anything the router keeps for a team so that the next Route picks up a new token, settings or
+	// message customizations
+	Invalidate(teamID string)
 	TokenSaver
 	TokenLoader
 }
 
+// errTokenStoreMissing is the cause of errors saving or loading slack tokens with a router that has nowhere to keep
+// them
+var errTokenStoreMissing = errors.New("no slack token store configured")
+
 type SingleTenantRouter struct {
 	services TeamServices
+	storage  Storage
+	logger   Logger
 	TokenSaver
 	TokenLoader
 }
 
 func (stRouter *SingleTenantRouter) Route(teamID string) (svcs TeamServices, err error) {
-	return stRouter.services, nil
+	svcs = stRouter.services
+	svcs.settings = DefaultWorkspaceSettings(teamID)
+	svcs.logger = stRouter.logger
+	if stRouter.storage != nil {
+		svcs.messages, svcs.settings, err = loadTeamConfig(stRouter.storage, teamID, stRouter.logger)
+		if err != nil {
+			return svcs, err
+		}
+	}
+
+	return svcs, nil
+}
+
+// SaveToken implements TokenSaver. The single tenant services are built with a fixed slack client so installing the
+// app through the Slack OAuth flow fails with errTokenStoreMissing unless a TokenSaver is set
+func (stRouter *SingleTenantRouter) SaveToken(installation Installation, token SlackToken) (err error) {
+	if stRouter.TokenSaver == nil {
+		return errors.Wrapf(errTokenStoreMissing, "error saving token of tenant [%s]", installation.TenantID())
+	}
+
+	return stRouter.TokenSaver.SaveToken(installation, token)
+}
+
+// LoadToken implements TokenLoader. It fails with errTokenStoreMissing unless a TokenLoader is set
+func (stRouter *SingleTenantRouter) LoadToken(tenantID string) (token SlackToken, err error) {
+	if stRouter.TokenLoader == nil {
+		return token, errors.Wrapf(errTokenStoreMissing, "error loading token of tenant [%s]", tenantID)
+	}
+
+	return stRouter.TokenLoader.LoadToken(tenantID)
+}
+
+// Invalidate implements TeamRouter. The single tenant services are fixed and the workspace configuration is loaded on
+// every Route so there's nothing to drop
+func (stRouter *SingleTenantRouter) Invalidate(teamID string) {
+}
+
+// UseStorage makes the router load the workspace's message customizations and settings from storage. Without it,
+// messages are sent with their default definitions and the default settings apply
+func (stRouter *SingleTenantRouter) UseStorage(storage Storage) {
+	stRouter.storage = storage
+}
+
+// UseLogger sets the logger of the routed services. StepCurry hands its own logger to its router
+func (stRouter *SingleTenantRouter) UseLogger(logger Logger) {
+	stRouter.logger = logger
+}
+
+// UseViewOpener sets the implementation used to open modals such as the settings modal
+func (stRouter *SingleTenantRouter) UseViewOpener(viewOpener ViewOpener) {
+	stRouter.services.viewOpener = viewOpener
+}
+
+// UseUserGroupMemberFinder sets the implementation used to check user group membership for channels where starting
+// challenges is restricted to user groups. Without it, only admins can start challenges in those channels
+func (stRouter *SingleTenantRouter) UseUserGroupMemberFinder(userGroupMemberFinder UserGroupMemberFinder) {
+	stRouter.services.userGroupMemberFinder = userGroupMemberFinder
+}
+
+// UseConversationJoiner sets the implementation used to join public channels a challenge is started in when the app
+// isn't a member yet. Without it, users are asked to invite the app
+func (stRouter *SingleTenantRouter) UseConversationJoiner(conversationJoiner ConversationJoiner) {
+	stRouter.services.conversationJoiner = conversationJoiner
+}
+
+// UseHomePublisher sets the implementation used to publish the app home tab of users. Without it, opening the app
+// home shows Slack's default tab
+func (stRouter *SingleTenantRouter) UseHomePublisher(homePublisher HomePublisher) {
+	stRouter.services.homePublisher = homePublisher
 }
 
 func NewSingleTenantRouter(userInfoFinder UserInfoFinder, botIdentificator BotIdentificator, messenger Messenger, conversationMemberFinder ConversationMemberFinder) (stRouter *SingleTenantRouter, err error) {
 	stRouter = new(SingleTenantRouter)
+	stRouter.logger = defaultLogger
 	meter := otel.GetMeterProvider().Meter("github.com/alexandre-normand/stepcurry")
-	stRouter.services = TeamServices{userInfoFinder: userInfoFinder, botIdentificator: botIdentificator, messenger: NewMessengerWithTelemetry(messenger, appName, meter), conversationMemberFinder: conversationMemberFinder}
+	stRouter.services = TeamServices{userInfoFinder: userInfoFinder, botIdentificator: botIdentificator, messenger: NewMessengerWithTelemetry(messenger, appName, meter), conversationMemberFinder: conversationMemberFinder, messages: DefaultMessages()}
 
 	return stRouter, nil
 }
 
 type MultiTenantRouter struct {
-	debug      bool
-	projectID  string
-	storer     Datastorer
-	svcsByTeam map[string]TeamServices
+	debug          bool
+	projectID      string
+	storage        Storage
+	clock          Clock
+	cache          *teamServicesCache
+	tokenRefresher TokenRefresher
+	logger         Logger
 	TokenLoader
 	TokenSaver
 }
 
-func (mtRouter *MultiTenantRouter) Route(teamID string) (svcs TeamServices, err error) {
-	if svcs, ok := mtRouter.svcsByTeam[teamID]; !ok {
-		token, err := mtRouter.LoadToken(teamID)
+// Route returns the services of a tenant. Tenants are teams for workspace installations and enterprises for org-wide
+// installations on Enterprise Grid so requests from any workspace of an org are routed to the org's installation.
+// Services are cached and concurrent requests for a tenant that isn't cached share the same load. Services created
+// with a rotating token are only cached until the token is due for a refresh
+func (mtRouter *MultiTenantRouter) Route(tenantID string) (svcs TeamServices, err error) {
+	return mtRouter.cache.get(tenantID, func() (svcs TeamServices, expiry time.Time, err error) {
+		return mtRouter.loadServices(tenantID)
+	})
+}
 
-		if err != nil {
-			return svcs, errors.Wrapf(err, "team [%s] not found", teamID)
-		}
+// loadServices creates the services of a tenant from its token, bot info and workspace configuration. A rotating token
+// close to its expiry is refreshed first. The returned expiry is when the token is next due for a refresh, if it's a
+// rotating one
+func (mtRouter *MultiTenantRouter) loadServices(tenantID string) (svcs TeamServices, expiry time.Time, err error) {
+	token, err := mtRouter.LoadToken(tenantID)
+	if err != nil {
+		return svcs, expiry, errors.Wrapf(err, "tenant [%s] not found", tenantID)
+	}
 
-		ctx := context.Background()
-		var botInfo BotInfo
-		k := NewKeyWithNamespace("BotInfo", teamID, "Bot", nil)
-		err = mtRouter.storer.Get(ctx, k, &botInfo)
+	ctx := context.Background()
+	botInfo, err := mtRouter.storage.GetBotInfo(ctx, tenantID)
+	if err != nil {
+		return svcs, expiry, errors.Wrapf(err, "Error loading bot info [%s] for tenant [%s]", botInfo.UserID, tenantID)
+	}
+
+	if token.NeedsRefresh(mtRouter.clock.Now()) {
+		token, err = mtRouter.refreshToken(botInfo.Installation(tenantID), token)
 		if err != nil {
-			return svcs, errors.Wrapf(err, "Error loading bot info [%s] for team [%s]", botInfo.UserID, teamID)
+			return svcs, expiry, err
 		}
+	}
+
+	messages, settings, err := loadTeamConfig(mtRouter.storage, tenantID, mtRouter.logger)
+	if err != nil {
+		return svcs, expiry, err
+	}
+
+	if token.Rotating() {
+		expiry = token.Expiry.Add(-slackTokenRefreshMargin)
+	}
+
+	slackClient := slack.New(token.AccessToken, slack.OptionDebug(mtRouter.debug))
+	meter := otel.GetMeterProvider().Meter("github.com/alexandre-normand/stepcurry")
+	svcs = TeamServices{userInfoFinder: slackClient, botIdentificator: FixedBotIdentificator{botUserID: botInfo.UserID}, messenger: NewMessengerWithTelemetry(slackClient, appName, meter), conversationMemberFinder: slackClient, conversationJoiner: slackClient, viewOpener: slackClient, userGroupMemberFinder: slackClient, homePublisher: slackClient, messages: messages, settings: settings, logger: mtRouter.logger}
+
+	return svcs, expiry, nil
+}
+
+// refreshToken exchanges the refresh token of a rotating token for a new token and saves it
+func (mtRouter *MultiTenantRouter) refreshToken(installation Installation, token SlackToken) (refreshed SlackToken, err error) {
+	if mtRouter.tokenRefresher == nil {
+		return token, fmt.Errorf("token of tenant [%s] is due for a refresh but no token refresher is configured", installation.TenantID())
+	}
 
-		slackClient := slack.New(token, slack.OptionDebug(mtRouter.debug))
-		meter := otel.GetMeterProvider().Meter("github.com/alexandre-normand/stepcurry")
-		teamSvcs := TeamServices{userInfoFinder: slackClient, botIdentificator: FixedBotIdentificator{botUserID: botInfo.UserID}, messenger: NewMessengerWithTelemetry(slackClient, appName, meter), conversationMemberFinder: slackClient}
-		mtRouter.svcsByTeam[teamID] = teamSvcs
+	authResp, err := mtRouter.tokenRefresher.RefreshToken(token.RefreshToken)
+	if err != nil {
+		return token, errors.Wrapf(err, "error refreshing token of tenant [%s]", installation.TenantID())
 	}
 
-	return mtRouter.svcsByTeam[teamID], nil
+	refreshed = authResp.Token(mtRouter.clock.Now())
+	err = mtRouter.SaveToken(installation, refreshed)
+	if err != nil {
+		return token, errors.Wrapf(err, "error saving refreshed token of tenant [%s]", installation.TenantID())
+	}
+
+	newLogEntry(mtRouter.logger).with(Fields{teamIDField: installation.TenantID()}).infof("Refreshed slack token, now expiring at [%s]", refreshed.Expiry)
+	return refreshed, nil
 }
 
-func NewMultiTenantRouter(projectID string, storer Datastorer, tokenLoader TokenLoader, tokenSaver TokenSaver, debug bool) (mtRouter *MultiTenantRouter, err error) {
+// Invalidate implements TeamRouter by dropping the cached services of a tenant along with its token if the token
+// loader caches tokens
+func (mtRouter *MultiTenantRouter) Invalidate(tenantID string) {
+	if forgetter, ok := mtRouter.TokenLoader.(TokenForgetter); ok {
+		forgetter.Forget(tenantID)
+	}
+
+	mtRouter.cache.invalidate(tenantID)
+}
+
+// UseCacheLimits overrides how long the services of a tenant are cached and how many tenants are cached before
+// evicting the least recently used. They default to an hour and 1000 tenants
+func (mtRouter *MultiTenantRouter) UseCacheLimits(ttl time.Duration, maxTenants int) {
+	mtRouter.cache = newTeamServicesCache(ttl, maxTenants, mtRouter.clock)
+}
+
+// UseLogger sets the logger of the router and of the services it routes to. StepCurry hands its own logger to its
+// router
+func (mtRouter *MultiTenantRouter) UseLogger(logger Logger) {
+	mtRouter.logger = logger
+}
+
+// UseTokenRefresher sets the implementation used to refresh rotating tokens before they expire. Without it, routing
+// to a tenant whose rotating token is due for a refresh fails
+func (mtRouter *MultiTenantRouter) UseTokenRefresher(tokenRefresher TokenRefresher) {
+	mtRouter.tokenRefresher = tokenRefresher
+}
+
+func NewMultiTenantRouter(projectID string, storage Storage, tokenLoader TokenLoader, tokenSaver TokenSaver, debug bool) (mtRouter *MultiTenantRouter, err error) {
 	mtRouter = new(MultiTenantRouter)
 	mtRouter.projectID = projectID
-	mtRouter.storer = storer
+	mtRouter.storage = storage
 	mtRouter.TokenSaver = tokenSaver
 	mtRouter.TokenLoader = tokenLoader
-	mtRouter.svcsByTeam = make(map[string]TeamServices)
+	mtRouter.clock = NewSystemClock()
+	mtRouter.cache = newTeamServicesCache(defaultRouterCacheTTL, defaultRouterCacheSize, mtRouter.clock)
 	mtRouter.debug = debug
+	mtRouter.logger = defaultLogger
 
 	return mtRouter, nil
 }
@@ -284,6 +496,15 @@ func OptionFitbitURLs(fitbitAuthBaseURL string, fitbitAPIBaseUrl string) Option
 	}
 }
 
+// OptionFitbitSubscriber enables the Fitbit subscriptions for push-based step updates. The verification code is the one
+// configured for the subscriber in the Fitbit app settings
+func OptionFitbitSubscriber(verificationCode string) Option {
+	return func(sc *StepCurry) (err error) {
+		sc.fitbitVerificationCode = verificationCode
+		return nil
+	}
+}
+
 // OptionSlackBaseURL overrides the Slack base URL
 func OptionSlackBaseURL(slackBaseURL string) Option {
 	return func(sc *StepCurry) (err error) {
@@ -318,12 +539,14 @@ func New(baseURL string, slackAppID string, fitbitClientID string, fitbitClientS
 	sc.fitbitAuthBaseURL = defaultFitbitAuthBaseURL
 	sc.fitbitAPIBaseURL = defaultFitbitAPIBaseURL
 	sc.slackBaseURL = defaultSlackBaseURL
-	sc.slashCommands = SlashCommands{Link: commandLinkFitbit, Challenge: commandChallenge, Standings: commandStandings}
-	sc.paths = Paths{UpdateChallenge: updateChallengePath, FitbitAuthCallback: oauthCallbackPath, LinkAccount: linkAccountPath, StartChallenge: startChallengePath, Standings: standingsPath}
+	sc.slashCommands = SlashCommands{Link: commandLinkFitbit, Challenge: commandChallenge, Standings: commandStandings, Leaderboard: commandLeaderboard, Badges: commandBadges, Reminders: commandReminders, Me: commandMe, Config: commandConfig}
+	sc.paths = Paths{UpdateChallenge: updateChallengePath, FitbitAuthCallback: oauthCallbackPath, LinkAccount: linkAccountPath, StartChallenge: startChallengePath, Standings: standingsPath, Leaderboard: leaderboardPath, Badges: badgesPath, Reminders: remindersPath, Me: mePath, SweepTokens: sweepTokensPath, FitbitSubscription: fitbitSubscriptionPath, RecordActivity: recordActivityPath, PublishHome: publishHomePath, PerfectMonth: perfectMonthPath, InstallSlack: installSlackPath, SlackAuthCallback: slackAuthCallbackPath, Config: configPath, Interaction: interactionPath, Events: eventsPath}
 	sc.slackClientID = slackClientID
 	sc.slackClientSecret = slackClientSecret
 	sc.fitbitClientID = fitbitClientID
 	sc.fitbitClientSecret = fitbitClientSecret
+	sc.clock = systemClock{}
+	sc.logger = defaultLogger
 
 	for _, apply := range opts {
 		err := apply(sc)
@@ -332,7 +555,7 @@ func New(baseURL string, slackAppID string, fitbitClientID string, fitbitClientS
 		}
 	}
 
-	if sc.storer == nil {
+	if sc.storer == nil && sc.storage == nil {
 		return nil, fmt.Errorf("storer is nil after applying all Options. Did you forget to set one?")
 	}
 
@@ -344,16 +567,37 @@ func New(baseURL string, slackAppID string, fitbitClientID string, fitbitClientS
 		return nil, fmt.Errorf("teamRouter is nil after applying all Options. Did you forget to set one?")
 	}
 
-	if sc.taskScheduler == nil {
-		return nil, fmt.Errorf("taskScheduler is nil after applying all Options. Did you forget to set one?")
+	if sc.taskScheduler == nil && sc.scheduler == nil {
+		return nil, fmt.Errorf("scheduler is nil after applying all Options. Did you forget to set one?")
+	}
+
+	// The router and scheduler log along with StepCurry
+	for _, component := range []interface{}{sc.TeamRouter, sc.scheduler} {
+		if lu, ok := component.(loggerUser); ok {
+			lu.UseLogger(sc.logger)
+		}
 	}
 
 	sc.meter = otel.GetMeterProvider().Meter("github.com/alexandre-normand/stepcurry")
 	sc.instruments = newInstruments(sc.meter)
 
 	sc.verifier = NewVerifierWithTelemetry(sc.verifier, appName, sc.meter)
-	sc.storer = NewDatastorerWithTelemetry(sc.storer, appName, sc.meter)
-	sc.taskScheduler = NewTaskSchedulerWithTelemetry(sc.taskScheduler, appName, sc.meter)
+	// Datastore-backed storage keeps reporting metrics at the Datastorer level while other storage implementations
+	// are instrumented as a whole
+	if sc.storage == nil {
+		sc.storer = NewDatastorerWithTelemetry(sc.storer, appName, sc.meter)
+		sc.storage = NewDatastoreStorage(sc.storer)
+	} else {
+		sc.storage = NewStorageWithTelemetry(sc.storage, appName, sc.meter)
+	}
+	// Cloud Tasks scheduling keeps reporting metrics at the TaskScheduler level while other schedulers are instrumented
+	// as a whole
+	if sc.scheduler == nil {
+		sc.taskScheduler = NewTaskSchedulerWithTelemetry(sc.taskScheduler, appName, sc.meter)
+		sc.scheduler = newCloudTasksScheduler(sc.taskScheduler, sc.baseURL, sc.paths)
+	} else {
+		sc.scheduler = NewSchedulerWithTelemetry(sc.scheduler, appName, sc.meter)
+	}
 
 	return sc, nil
 }
@@ -0,0 +1,442 @@
+// Package stepcurrytest provides fake Fitbit and Slack API servers for running Step Curry against scripted users,
+// step counts and failures without network access. Point a StepCurry instance at them with stepcurry.OptionFitbitURLs,
+// stepcurry.OptionSlackBaseURL and a slack client created with slack.OptionAPIURL
+package stepcurrytest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFitbitTokenLifetime is the lifetime of access tokens issued by the fake Fitbit server, the same as
+	// Fitbit's
+	defaultFitbitTokenLifetime = 8 * time.Hour
+	// defaultFitbitStepsGoal is the daily steps goal of users unless set otherwise
+	defaultFitbitStepsGoal = 10000
+	// fitbitDateFormat is the format of dates in Fitbit api paths
+	fitbitDateFormat = "2006-01-02"
+)
+
+// Fitbit error types. See https://dev.fitbit.com/build/reference/web-api/troubleshooting-guide/error-messages/
+const (
+	fitbitErrorExpiredToken     = "expired_token"
+	fitbitErrorInvalidToken     = "invalid_token"
+	fitbitErrorInvalidGrant     = "invalid_grant"
+	fitbitErrorInvalidClient    = "invalid_client"
+	fitbitErrorInvalidRequest   = "invalid_request"
+	fitbitErrorInsufficientPerm = "insufficient_permissions"
+	fitbitErrorRateLimited      = "system"
+)
+
+// Clock defines the interface for telling the current time. stepcurry.FakeClock implements it so that the fake
+// servers and the app share the same controlled time
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+// systemClock is the Clock telling the actual time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// fitbitTokens holds the current tokens of a fitbit user
+type fitbitTokens struct {
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// fitbitUser holds the scripted data of a fitbit user
+type fitbitUser struct {
+	stepsByDate map[string]int
+	goal        int
+	tokens      fitbitTokens
+	// requests holds the start of the current rate limit window and the number of api requests made during it
+	windowStart time.Time
+	requests    int
+}
+
+// FakeFitbit is a fake of the Fitbit OAuth and activity APIs. Users get scriptable step counts per date and tokens
+// that expire, rotate on refresh and can be revoked like Fitbit's. It's safe for concurrent use
+type FakeFitbit struct {
+	*httptest.Server
+
+	clientID     string
+	clientSecret string
+
+	mu            sync.Mutex
+	clock         Clock
+	tokenLifetime time.Duration
+	rateLimit     int
+	loggedInUser  string
+	users         map[string]*fitbitUser
+	codes         map[string]string
+	issued        int
+	requests      []string
+}
+
+// NewFakeFitbit starts a fake Fitbit server accepting the given client credentials. Callers should call Close when
+// done
+func NewFakeFitbit(clientID string, clientSecret string) (ff *FakeFitbit) {
+	ff = &FakeFitbit{clientID: clientID, clientSecret: clientSecret, clock: systemClock{}, tokenLifetime: defaultFitbitTokenLifetime, users: make(map[string]*fitbitUser), codes: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/authorize", ff.serveAuthorize)
+	mux.HandleFunc("/oauth2/token", ff.serveToken)
+	mux.HandleFunc("/1/user/", ff.serveActivity)
+	ff.Server = httptest.NewServer(ff.recordRequests(mux))
+
+	return ff
+}
+
+// UseClock sets the clock telling when tokens expire and rate limit windows reset
+func (ff *FakeFitbit) UseClock(clock Clock) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.clock = clock
+}
+
+// SetTokenLifetime sets the lifetime of access tokens issued from now on
+func (ff *FakeFitbit) SetTokenLifetime(lifetime time.Duration) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.tokenLifetime = lifetime
+}
+
+// SetRateLimit limits the number of activity requests each user can make per clock hour, like Fitbit does. Requests
+// over the limit get a 429. A limit of 0 removes the limit
+func (ff *FakeFitbit) SetRateLimit(requestsPerHour int) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.rateLimit = requestsPerHour
+}
+
+// AddUser adds a fitbit user with the default steps goal and no steps
+func (ff *FakeFitbit) AddUser(fitbitUserID string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID)
+}
+
+// user returns a fitbit user, adding it if it doesn't exist yet. It must be called with the lock held
+func (ff *FakeFitbit) user(fitbitUserID string) (user *fitbitUser) {
+	user, ok := ff.users[fitbitUserID]
+	if !ok {
+		user = &fitbitUser{stepsByDate: make(map[string]int), goal: defaultFitbitStepsGoal}
+		ff.users[fitbitUserID] = user
+	}
+
+	return user
+}
+
+// SetSteps sets the steps of a user on a date formatted as yyyy-MM-dd
+func (ff *FakeFitbit) SetSteps(fitbitUserID string, date string, steps int) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID).stepsByDate[date] = steps
+}
+
+// SetGoal sets the daily steps goal of a user
+func (ff *FakeFitbit) SetGoal(fitbitUserID string, goal int) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID).goal = goal
+}
+
+// LogInAs sets the user consenting to access when a browser is sent to the authorize page
+func (ff *FakeFitbit) LogInAs(fitbitUserID string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID)
+	ff.loggedInUser = fitbitUserID
+}
+
+// IssueCode issues an authorization code for a user as if they had consented to access on the authorize page
+func (ff *FakeFitbit) IssueCode(fitbitUserID string) (code string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	return ff.issueCode(fitbitUserID)
+}
+
+// issueCode issues an authorization code for a user. It must be called with the lock held
+func (ff *FakeFitbit) issueCode(fitbitUserID string) (code string) {
+	ff.user(fitbitUserID)
+	ff.issued++
+	code = fmt.Sprintf("code-%s-%d", fitbitUserID, ff.issued)
+	ff.codes[code] = fitbitUserID
+
+	return code
+}
+
+// IssueTokens issues new tokens for a user, replacing their current ones, and returns them. It's meant to seed linked
+// accounts directly in storage
+func (ff *FakeFitbit) IssueTokens(fitbitUserID string) (accessToken string, refreshToken string, expiry time.Time) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	tokens := ff.issueTokens(fitbitUserID)
+	return tokens.accessToken, tokens.refreshToken, tokens.expiry
+}
+
+// issueTokens issues new tokens for a user. It must be called with the lock held
+func (ff *FakeFitbit) issueTokens(fitbitUserID string) (tokens fitbitTokens) {
+	user := ff.user(fitbitUserID)
+	ff.issued++
+	user.tokens = fitbitTokens{
+		accessToken:  fmt.Sprintf("access-%s-%d", fitbitUserID, ff.issued),
+		refreshToken: fmt.Sprintf("refresh-%s-%d", fitbitUserID, ff.issued),
+		expiry:       ff.clock.Now().Add(ff.tokenLifetime),
+	}
+
+	return user.tokens
+}
+
+// ExpireToken expires the current access token of a user. Its refresh token stays valid
+func (ff *FakeFitbit) ExpireToken(fitbitUserID string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID).tokens.expiry = ff.clock.Now().Add(-time.Second)
+}
+
+// RevokeTokens revokes the tokens of a user, as when they remove the app's access from their Fitbit account.
+// Requests with the access token and refreshes fail until the user consents again
+func (ff *FakeFitbit) RevokeTokens(fitbitUserID string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	ff.user(fitbitUserID).tokens = fitbitTokens{}
+}
+
+// Requests returns the requests received so far as "METHOD path"
+func (ff *FakeFitbit) Requests() (requests []string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	return append([]string(nil), ff.requests...)
+}
+
+// recordRequests records every request before handing it off to next
+func (ff *FakeFitbit) recordRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ff.mu.Lock()
+		ff.requests = append(ff.requests, r.Method+" "+r.URL.Path)
+		ff.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveAuthorize serves the authorize page by consenting as the logged in user and redirecting to the redirect uri
+// with an authorization code
+func (ff *FakeFitbit) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != ff.clientID || query.Get("response_type") != "code" {
+		writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidRequest, "invalid client_id or response_type")
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidRequest, "invalid redirect_uri")
+		return
+	}
+
+	ff.mu.Lock()
+	if ff.loggedInUser == "" {
+		ff.mu.Unlock()
+		writeFitbitError(w, http.StatusUnauthorized, fitbitErrorInvalidRequest, "no user logged in, call LogInAs first")
+		return
+	}
+	code := ff.issueCode(ff.loggedInUser)
+	ff.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// serveToken serves the token endpoint for the authorization_code and refresh_token grants
+func (ff *FakeFitbit) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeFitbitError(w, http.StatusMethodNotAllowed, fitbitErrorInvalidRequest, "token requests must be POST")
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(ff.clientID+":"+ff.clientSecret)) {
+		writeFitbitError(w, http.StatusUnauthorized, fitbitErrorInvalidClient, "invalid client credentials")
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidRequest, err.Error())
+		return
+	}
+
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	var fitbitUserID string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		userID, ok := ff.codes[code]
+		if !ok {
+			writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidGrant, fmt.Sprintf("Authorization code invalid: %s", code))
+			return
+		}
+
+		// Codes can only be used once
+		delete(ff.codes, code)
+		fitbitUserID = userID
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		for userID, user := range ff.users {
+			if refreshToken != "" && user.tokens.refreshToken == refreshToken {
+				fitbitUserID = userID
+			}
+		}
+
+		// Refresh tokens can only be used once, the refresh token of a user's previous refresh is invalid
+		if fitbitUserID == "" {
+			writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidGrant, fmt.Sprintf("Refresh token invalid: %s", refreshToken))
+			return
+		}
+	default:
+		writeFitbitError(w, http.StatusBadRequest, fitbitErrorInvalidRequest, fmt.Sprintf("unsupported grant_type [%s]", r.PostForm.Get("grant_type")))
+		return
+	}
+
+	tokens := ff.issueTokens(fitbitUserID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  tokens.accessToken,
+		"refresh_token": tokens.refreshToken,
+		"expires_in":    int(ff.tokenLifetime.Seconds()),
+		"token_type":    "Bearer",
+		"scope":         "activity",
+		"user_id":       fitbitUserID,
+	})
+}
+
+// serveActivity serves the daily activity summary at /1/user/{user-id}/activities/date/{date}.json where user-id is
+// either the id of the token's user or -
+func (ff *FakeFitbit) serveActivity(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/1/user/"), "/")
+	if len(parts) != 4 || parts[1] != "activities" || parts[2] != "date" || !strings.HasSuffix(parts[3], ".json") {
+		http.NotFound(w, r)
+		return
+	}
+
+	date := strings.TrimSuffix(parts[3], ".json")
+	if _, err := time.Parse(fitbitDateFormat, date); err != nil {
+		writeFitbitError(w, http.StatusBadRequest, "validation", fmt.Sprintf("Invalid date: %s", date))
+		return
+	}
+
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var tokenUserID string
+	for userID, user := range ff.users {
+		if accessToken != "" && user.tokens.accessToken == accessToken {
+			tokenUserID = userID
+		}
+	}
+
+	if tokenUserID == "" {
+		writeFitbitError(w, http.StatusUnauthorized, fitbitErrorInvalidToken, fmt.Sprintf("Access token invalid: %s", accessToken))
+		return
+	}
+
+	now := ff.clock.Now()
+	user := ff.users[tokenUserID]
+	if !now.Before(user.tokens.expiry) {
+		writeFitbitError(w, http.StatusUnauthorized, fitbitErrorExpiredToken, fmt.Sprintf("Access token expired: %s", accessToken))
+		return
+	}
+
+	if parts[0] != "-" && parts[0] != tokenUserID {
+		writeFitbitError(w, http.StatusForbidden, fitbitErrorInsufficientPerm, fmt.Sprintf("Access token of user [%s] can't read data of user [%s]", tokenUserID, parts[0]))
+		return
+	}
+
+	if !ff.allowRequest(user, now, w) {
+		writeFitbitError(w, http.StatusTooManyRequests, fitbitErrorRateLimited, "Too Many Requests")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"goals":   map[string]int{"steps": user.goal},
+		"summary": map[string]int{"steps": user.stepsByDate[date]},
+	})
+}
+
+// allowRequest counts a request against the user's rate limit and returns false if it's over it. The Fitbit rate
+// limit headers are set on the response. It must be called with the lock held
+func (ff *FakeFitbit) allowRequest(user *fitbitUser, now time.Time, w http.ResponseWriter) (allowed bool) {
+	if ff.rateLimit == 0 {
+		return true
+	}
+
+	windowStart := now.Truncate(time.Hour)
+	if !user.windowStart.Equal(windowStart) {
+		user.windowStart = windowStart
+		user.requests = 0
+	}
+
+	reset := strconv.Itoa(int(windowStart.Add(time.Hour).Sub(now).Seconds()))
+	w.Header().Set("Fitbit-Rate-Limit-Limit", strconv.Itoa(ff.rateLimit))
+	w.Header().Set("Fitbit-Rate-Limit-Reset", reset)
+
+	if user.requests >= ff.rateLimit {
+		w.Header().Set("Fitbit-Rate-Limit-Remaining", "0")
+		w.Header().Set("Retry-After", reset)
+		return false
+	}
+
+	user.requests++
+	w.Header().Set("Fitbit-Rate-Limit-Remaining", strconv.Itoa(ff.rateLimit-user.requests))
+	return true
+}
+
+// writeFitbitError writes an error response in the format of the Fitbit web API
+func writeFitbitError(w http.ResponseWriter, status int, errorType string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors":  []map[string]string{{"errorType": errorType, "message": message}},
+		"success": false,
+	})
+}
+
+// writeJSON writes value as a json response with the given status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
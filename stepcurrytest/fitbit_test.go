@@ -0,0 +1,229 @@
+package stepcurrytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+// tokenResponse holds the fields of a token response the tests check
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	UserID       string `json:"user_id"`
+}
+
+// errorResponse holds a Fitbit error response
+type errorResponse struct {
+	Errors []struct {
+		ErrorType string `json:"errorType"`
+	} `json:"errors"`
+}
+
+func requestToken(t *testing.T, ff *FakeFitbit, clientSecret string, form url.Values) (resp *http.Response) {
+	req, err := http.NewRequest(http.MethodPost, ff.URL+"/oauth2/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("fitbitClientID", clientSecret)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func getActivity(t *testing.T, ff *FakeFitbit, user string, date string, accessToken string) (resp *http.Response) {
+	req, err := http.NewRequest(http.MethodGet, ff.URL+"/1/user/"+user+"/activities/date/"+date+".json", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, value interface{}) {
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(value))
+}
+
+func assertFitbitError(t *testing.T, resp *http.Response, status int, errorType string) {
+	assert.Equal(t, status, resp.StatusCode)
+
+	var errResp errorResponse
+	decode(t, resp, &errResp)
+	require.Len(t, errResp.Errors, 1)
+	assert.Equal(t, errorType, errResp.Errors[0].ErrorType)
+}
+
+func TestFakeFitbitAuthorizationCodeFlow(t *testing.T) {
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+
+	ff.LogInAs("F1")
+
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(ff.URL + "/oauth2/authorize?response_type=code&client_id=fitbitClientID&redirect_uri=" + url.QueryEscape("https://app.example.com/callback") + "&scope=activity&state=abc")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com", location.Host)
+	assert.Equal(t, "abc", location.Query().Get("state"))
+
+	code := location.Query().Get("code")
+	resp = requestToken(t, ff, "fitbitClientSecret", url.Values{"grant_type": {"authorization_code"}, "code": {code}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tokens tokenResponse
+	decode(t, resp, &tokens)
+	assert.Equal(t, "F1", tokens.UserID)
+	assert.Equal(t, 28800, tokens.ExpiresIn)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// Codes can only be used once
+	resp = requestToken(t, ff, "fitbitClientSecret", url.Values{"grant_type": {"authorization_code"}, "code": {code}})
+	assertFitbitError(t, resp, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestFakeFitbitRejectsInvalidClient(t *testing.T) {
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+
+	resp := requestToken(t, ff, "wrongSecret", url.Values{"grant_type": {"authorization_code"}, "code": {ff.IssueCode("F1")}})
+	assertFitbitError(t, resp, http.StatusUnauthorized, "invalid_client")
+}
+
+func TestFakeFitbitAuthorizeWithoutLoggedInUser(t *testing.T) {
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+
+	resp, err := http.Get(ff.URL + "/oauth2/authorize?response_type=code&client_id=fitbitClientID&redirect_uri=" + url.QueryEscape("https://app.example.com/callback"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestFakeFitbitDailyActivity(t *testing.T) {
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+
+	ff.SetSteps("F1", "2020-06-01", 4321)
+	ff.SetGoal("F1", 8000)
+	accessToken, _, _ := ff.IssueTokens("F1")
+
+	for _, user := range []string{"F1", "-"} {
+		resp := getActivity(t, ff, user, "2020-06-01", accessToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var summary struct {
+			Goals struct {
+				Steps int `json:"steps"`
+			} `json:"goals"`
+			Summary struct {
+				Steps int `json:"steps"`
+			} `json:"summary"`
+		}
+		decode(t, resp, &summary)
+		assert.Equal(t, 4321, summary.Summary.Steps)
+		assert.Equal(t, 8000, summary.Goals.Steps)
+	}
+
+	assertFitbitError(t, getActivity(t, ff, "F2", "2020-06-01", accessToken), http.StatusForbidden, "insufficient_permissions")
+	assertFitbitError(t, getActivity(t, ff, "F1", "2020-06-01", "unknown"), http.StatusUnauthorized, "invalid_token")
+	assert.Equal(t, []string{"GET /1/user/F1/activities/date/2020-06-01.json", "GET /1/user/-/activities/date/2020-06-01.json", "GET /1/user/F2/activities/date/2020-06-01.json", "GET /1/user/F1/activities/date/2020-06-01.json"}, ff.Requests())
+}
+
+func TestFakeFitbitExpiredTokenAndRefresh(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)}
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+	ff.UseClock(clock)
+	ff.SetTokenLifetime(time.Hour)
+
+	accessToken, refreshToken, expiry := ff.IssueTokens("F1")
+	assert.Equal(t, clock.now.Add(time.Hour), expiry)
+
+	clock.now = clock.now.Add(time.Hour)
+	assertFitbitError(t, getActivity(t, ff, "F1", "2020-06-01", accessToken), http.StatusUnauthorized, "expired_token")
+
+	resp := requestToken(t, ff, "fitbitClientSecret", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tokens tokenResponse
+	decode(t, resp, &tokens)
+	assert.Equal(t, "F1", tokens.UserID)
+	assert.NotEqual(t, refreshToken, tokens.RefreshToken)
+
+	resp = getActivity(t, ff, "F1", "2020-06-01", tokens.AccessToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Refresh tokens rotate and the previous one is no longer valid
+	resp = requestToken(t, ff, "fitbitClientSecret", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+	assertFitbitError(t, resp, http.StatusBadRequest, "invalid_grant")
+
+	ff.ExpireToken("F1")
+	assertFitbitError(t, getActivity(t, ff, "F1", "2020-06-01", tokens.AccessToken), http.StatusUnauthorized, "expired_token")
+}
+
+func TestFakeFitbitRevokedTokens(t *testing.T) {
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+
+	accessToken, refreshToken, _ := ff.IssueTokens("F1")
+	ff.RevokeTokens("F1")
+
+	assertFitbitError(t, getActivity(t, ff, "F1", "2020-06-01", accessToken), http.StatusUnauthorized, "invalid_token")
+	resp := requestToken(t, ff, "fitbitClientSecret", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+	assertFitbitError(t, resp, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestFakeFitbitRateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 6, 1, 9, 30, 0, 0, time.UTC)}
+	ff := NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	defer ff.Close()
+	ff.UseClock(clock)
+	ff.SetTokenLifetime(24 * time.Hour)
+	ff.SetRateLimit(2)
+
+	accessToken, _, _ := ff.IssueTokens("F1")
+	for remaining := 1; remaining >= 0; remaining-- {
+		resp := getActivity(t, ff, "F1", "2020-06-01", accessToken)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Fitbit-Rate-Limit-Limit"))
+		assert.Equal(t, strconv.Itoa(remaining), resp.Header.Get("Fitbit-Rate-Limit-Remaining"))
+	}
+
+	resp := getActivity(t, ff, "F1", "2020-06-01", accessToken)
+	assert.Equal(t, "1800", resp.Header.Get("Retry-After"))
+	assertFitbitError(t, resp, http.StatusTooManyRequests, "system")
+
+	// The limit resets at the top of the hour
+	clock.now = time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	resp = getActivity(t, ff, "F1", "2020-06-01", accessToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,182 @@
+package stepcurrytest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexandre-normand/stepcurry"
+	"github.com/alexandre-normand/stepcurry/stepcurrytest"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	teamID        = "T1"
+	channelID     = "C1"
+	botUserID     = "UBOT"
+	signingSecret = "signingSecret"
+)
+
+// botIdentificator identifies the bot with a fixed user id
+type botIdentificator string
+
+func (bi botIdentificator) GetBotID() (botUserID string, err error) {
+	return string(bi), nil
+}
+
+// integration runs a StepCurry server against fake Fitbit and Slack servers with in-memory storage and a local
+// scheduler on a fake clock
+type integration struct {
+	t         *testing.T
+	clock     *stepcurry.FakeClock
+	fitbit    *stepcurrytest.FakeFitbit
+	slack     *stepcurrytest.FakeSlack
+	storage   *stepcurry.MemoryStorage
+	scheduler *stepcurry.LocalScheduler
+	sc        *stepcurry.StepCurry
+	app       *httptest.Server
+}
+
+func newIntegration(t *testing.T, start time.Time) (it *integration, cleanup func()) {
+	it = &integration{t: t, clock: stepcurry.NewFakeClock(start), storage: stepcurry.NewMemoryStorage()}
+	it.scheduler = stepcurry.NewLocalScheduler(it.storage, it.clock)
+
+	it.fitbit = stepcurrytest.NewFakeFitbit("fitbitClientID", "fitbitClientSecret")
+	it.fitbit.UseClock(it.clock)
+
+	it.slack = stepcurrytest.NewFakeSlack("slackClientID", "slackClientSecret", signingSecret)
+	it.slack.UseClock(it.clock)
+	it.slack.AddToken("xoxb-1", teamID, botUserID)
+	it.slack.AddChannel(channelID, botUserID)
+
+	slackClient := slack.New("xoxb-1", slack.OptionAPIURL(it.slack.APIURL()))
+	router, err := stepcurry.NewSingleTenantRouter(slackClient, botIdentificator(botUserID), slackClient, slackClient)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	it.app = httptest.NewServer(mux)
+
+	it.sc, err = stepcurry.New(it.app.URL, "app", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret",
+		stepcurry.OptionSlackVerifier(signingSecret), stepcurry.OptionStorage(it.storage), stepcurry.OptionScheduler(it.scheduler),
+		stepcurry.OptionTeamRouter(router), stepcurry.OptionClock(it.clock), stepcurry.OptionFitbitURLs(it.fitbit.URL, it.fitbit.URL),
+		stepcurry.OptionSlackBaseURL(it.slack.URL))
+	require.NoError(t, err)
+	it.sc.RegisterHandlers(mux)
+
+	return it, func() {
+		it.app.Close()
+		it.fitbit.Close()
+		it.slack.Close()
+	}
+}
+
+// command sends a slash command from a user in the test channel to the handler at path and returns the responses
+// sent back to slack
+func (it *integration) command(path string, command string, userID string) (responses []stepcurrytest.CommandResponse) {
+	r, responseURL := it.slack.SlashCommand(it.app.URL+"/"+path, stepcurrytest.SlashCommand{Command: command, TeamID: teamID, ChannelID: channelID, UserID: userID})
+	r.RequestURI = ""
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(it.t, err)
+	resp.Body.Close()
+	require.Equal(it.t, http.StatusOK, resp.StatusCode)
+
+	return it.slack.Responses(responseURL)
+}
+
+// link joins a slack user to the test channel and links their fitbit account by going through the oauth flow
+func (it *integration) link(slackUserID string, realName string, fitbitUserID string) {
+	it.slack.AddUser(slack.User{ID: slackUserID, TeamID: teamID, RealName: realName})
+	it.slack.AddMembers(channelID, slackUserID)
+
+	responses := it.command("LinkAccount", "/step-link", slackUserID)
+	require.Len(it.t, responses, 1)
+
+	// The response holds the authorize link formatted as <url|text>
+	text := responses[0].Text
+	authorizeURL := text[strings.Index(text, "<")+1 : strings.Index(text, "|")]
+
+	// Following the authorize link consents as the logged in user and redirects back to the app's callback
+	it.fitbit.LogInAs(fitbitUserID)
+	resp, err := http.Get(authorizeURL)
+	require.NoError(it.t, err)
+	resp.Body.Close()
+	require.Equal(it.t, http.StatusOK, resp.StatusCode)
+}
+
+// runJobsUntil moves the clock to each scheduled job in turn and runs it until no job is due before deadline. before
+// is called ahead of every run with the time it's happening at
+func (it *integration) runJobsUntil(deadline time.Time, before func(now time.Time)) {
+	ctx := context.Background()
+	for {
+		next, err := it.storage.ListDueJobs(ctx, deadline, 1)
+		require.NoError(it.t, err)
+		if len(next) == 0 {
+			return
+		}
+
+		if next[0].ScheduledTime.After(it.clock.Now()) {
+			it.clock.Set(next[0].ScheduledTime)
+		}
+
+		before(it.clock.Now())
+		_, err = it.scheduler.RunDue(ctx, it.sc)
+		require.NoError(it.t, err)
+	}
+}
+
+func TestChallengeAgainstFakeServers(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, location)
+	it, cleanup := newIntegration(t, start)
+	defer cleanup()
+
+	it.link("U1", "Alice", "F1")
+	it.link("U2", "Bob", "F2")
+
+	ctx := context.Background()
+	clientAccess, err := it.storage.GetClientAccess(ctx, teamID, "U1")
+	require.NoError(t, err)
+	assert.Equal(t, "F1", clientAccess.FitbitUser)
+
+	responses := it.command("Challenge", "/step-challenge", "U1")
+	assert.Empty(t, responses)
+
+	// Alice leads in the morning and Bob catches up in the afternoon. Alice's token expires mid-day and gets refreshed
+	it.runJobsUntil(start.Add(24*time.Hour), func(now time.Time) {
+		hours := int(now.Sub(start).Hours())
+		it.fitbit.SetSteps("F1", "2020-06-01", 2000+hours*500)
+		it.fitbit.SetSteps("F2", "2020-06-01", hours*1000)
+
+		if hours == 4 {
+			it.fitbit.ExpireToken("F1")
+		}
+	})
+
+	challenge, err := it.storage.GetChallenge(ctx, stepcurry.ChallengeID{TeamID: teamID, ChannelID: channelID, Date: "2020-06-01"})
+	require.NoError(t, err)
+	assert.False(t, challenge.Active)
+	require.Len(t, challenge.RankedUsers, 2)
+	assert.Equal(t, "U2", challenge.RankedUsers[0].UserID)
+
+	messages := it.slack.Messages(channelID)
+	require.Len(t, messages, 12)
+	assert.Contains(t, messages[0].Text, "<@U1> started a steps challenge!")
+	assert.Contains(t, messages[11].Text, "We have a winner")
+
+	refreshes := 0
+	for _, request := range it.fitbit.Requests() {
+		if request == "POST /oauth2/token" {
+			refreshes++
+		}
+	}
+	// One token exchange per linked account and at least one refresh of Alice's expired token
+	assert.True(t, refreshes > 2, "expected refreshes beyond the two token exchanges, got [%d] token requests", refreshes)
+}
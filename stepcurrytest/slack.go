@@ -0,0 +1,478 @@
+package stepcurrytest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Slack api methods served by FakeSlack
+const (
+	MethodPostMessage         = "chat.postMessage"
+	MethodUsersInfo           = "users.info"
+	MethodConversationMembers = "conversations.members"
+	MethodOAuthV2Access       = "oauth.v2.access"
+)
+
+// slackToken holds the team and user a token acts for
+type slackToken struct {
+	teamID string
+	userID string
+}
+
+// scriptedFailure holds a failure returned by the next call to a method. A positive retryAfter fails the call with
+// a rate limit rather than an error
+type scriptedFailure struct {
+	slackError string
+	retryAfter time.Duration
+}
+
+// SlackInstall holds the result of an app installation returned by oauth.v2.access
+type SlackInstall struct {
+	AppID          string
+	TeamID         string
+	TeamName       string
+	EnterpriseID   string
+	EnterpriseName string
+	// EnterpriseInstall makes the install an org-wide installation of the enterprise, which has no team
+	EnterpriseInstall bool
+	AuthedUserID      string
+	BotUserID         string
+	AccessToken       string
+	Scope             string
+}
+
+// SlackMessage holds a message posted with chat.postMessage
+type SlackMessage struct {
+	TeamID    string
+	Channel   string
+	Text      string
+	Blocks    string
+	Timestamp string
+}
+
+// SlashCommand holds the fields of a slash command sent to the app
+type SlashCommand struct {
+	Command   string
+	Text      string
+	TeamID    string
+	ChannelID string
+	UserID    string
+}
+
+// CommandResponse holds a message sent by the app to a slash command's response url
+type CommandResponse struct {
+	ResponseType    string          `json:"response_type,omitempty"`
+	Text            string          `json:"text,omitempty"`
+	Blocks          json.RawMessage `json:"blocks,omitempty"`
+	ReplaceOriginal bool            `json:"replace_original"`
+}
+
+// FakeSlack is a fake of the Slack web api methods Step Curry uses along with slash command response urls. Calls
+// are authenticated with tokens added with AddToken or issued by installs and any call can be scripted to fail. It's
+// safe for concurrent use
+type FakeSlack struct {
+	*httptest.Server
+
+	clientID      string
+	clientSecret  string
+	signingSecret string
+
+	mu        sync.Mutex
+	clock     Clock
+	tokens    map[string]slackToken
+	users     map[string]slack.User
+	channels  map[string][]string
+	installs  map[string]SlackInstall
+	failures  map[string][]scriptedFailure
+	messages  []SlackMessage
+	responses map[string][]CommandResponse
+	commands  int
+}
+
+// NewFakeSlack starts a fake Slack server accepting the given app client credentials. Slash commands created with
+// SlashCommand are signed with signingSecret. Callers should call Close when done
+func NewFakeSlack(clientID string, clientSecret string, signingSecret string) (fs *FakeSlack) {
+	fs = &FakeSlack{clientID: clientID, clientSecret: clientSecret, signingSecret: signingSecret, clock: systemClock{},
+		tokens: make(map[string]slackToken), users: make(map[string]slack.User), channels: make(map[string][]string),
+		installs: make(map[string]SlackInstall), failures: make(map[string][]scriptedFailure), responses: make(map[string][]CommandResponse)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", fs.serveAPI)
+	mux.HandleFunc("/commands/", fs.serveCommandResponse)
+	fs.Server = httptest.NewServer(mux)
+
+	return fs
+}
+
+// APIURL returns the url to create slack clients with using slack.OptionAPIURL
+func (fs *FakeSlack) APIURL() string {
+	return fs.URL + "/api/"
+}
+
+// UseClock sets the clock used for message timestamps
+func (fs *FakeSlack) UseClock(clock Clock) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.clock = clock
+}
+
+// AddToken adds a token acting as userID in a team. For bot tokens, userID is the bot user id
+func (fs *FakeSlack) AddToken(token string, teamID string, userID string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.tokens[token] = slackToken{teamID: teamID, userID: userID}
+}
+
+// AddUser adds a user. Users with a TeamID are only visible to tokens of that team
+func (fs *FakeSlack) AddUser(user slack.User) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.users[user.ID] = user
+}
+
+// AddChannel adds a channel with the given members. Members can be added to an existing channel with AddMembers
+func (fs *FakeSlack) AddChannel(channelID string, memberIDs ...string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.channels[channelID] = append([]string{}, memberIDs...)
+}
+
+// AddMembers adds members to a channel
+func (fs *FakeSlack) AddMembers(channelID string, memberIDs ...string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.channels[channelID] = append(fs.channels[channelID], memberIDs...)
+}
+
+// AddInstall makes oauth.v2.access return install when called with code. Codes can only be used once and the
+// install's access token is accepted once it's been exchanged
+func (fs *FakeSlack) AddInstall(code string, install SlackInstall) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.installs[code] = install
+}
+
+// FailNext makes the next call to method fail with the given slack error (i.e. channel_not_found)
+func (fs *FakeSlack) FailNext(method string, slackError string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.failures[method] = append(fs.failures[method], scriptedFailure{slackError: slackError})
+}
+
+// RateLimitNext makes the next call to method fail with a 429 asking to retry after the given duration
+func (fs *FakeSlack) RateLimitNext(method string, retryAfter time.Duration) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.failures[method] = append(fs.failures[method], scriptedFailure{retryAfter: retryAfter})
+}
+
+// Messages returns the messages posted to a channel or user, in the order they were posted
+func (fs *FakeSlack) Messages(channelID string) (messages []SlackMessage) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	messages = make([]SlackMessage, 0)
+	for _, m := range fs.messages {
+		if m.Channel == channelID {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// Responses returns the messages sent to a slash command response url, in the order they were sent
+func (fs *FakeSlack) Responses(responseURL string) (responses []CommandResponse) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return append([]CommandResponse{}, fs.responses[strings.TrimPrefix(responseURL, fs.URL)]...)
+}
+
+// SlashCommand creates the request Slack sends to target when a user runs a slash command. The request is signed
+// with the signing secret for the current time and carries a new response url to get responses from with Responses
+func (fs *FakeSlack) SlashCommand(target string, command SlashCommand) (r *http.Request, responseURL string) {
+	fs.mu.Lock()
+	fs.commands++
+	responsePath := fmt.Sprintf("/commands/%s/%d", command.TeamID, fs.commands)
+	fs.responses[responsePath] = []CommandResponse{}
+	fs.mu.Unlock()
+
+	responseURL = fs.URL + responsePath
+	params := url.Values{}
+	params.Set("command", command.Command)
+	params.Set("text", command.Text)
+	params.Set("team_id", command.TeamID)
+	params.Set("channel_id", command.ChannelID)
+	params.Set("user_id", command.UserID)
+	params.Set("response_url", responseURL)
+	body := params.Encode()
+
+	// Slack request verification checks the timestamp against the actual time rather than a fake clock's
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(fs.signingSecret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", ts, body)))
+
+	r = httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	return r, responseURL
+}
+
+// serveCommandResponse records a message sent to a response url
+func (fs *FakeSlack) serveCommandResponse(w http.ResponseWriter, r *http.Request) {
+	var response CommandResponse
+	err := json.NewDecoder(r.Body).Decode(&response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.responses[r.URL.Path]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	fs.responses[r.URL.Path] = append(fs.responses[r.URL.Path], response)
+}
+
+// serveAPI serves the web api methods
+func (fs *FakeSlack) serveAPI(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeSlackError(w, "invalid_form_data")
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if failures := fs.failures[method]; len(failures) > 0 {
+		fs.failures[method] = failures[1:]
+		if failures[0].retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(failures[0].retryAfter.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		writeSlackError(w, failures[0].slackError)
+		return
+	}
+
+	if method == MethodOAuthV2Access {
+		fs.serveOAuthV2Access(w, r)
+		return
+	}
+
+	token, ok := fs.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	switch method {
+	case MethodPostMessage:
+		fs.servePostMessage(w, r, token)
+	case MethodUsersInfo:
+		fs.serveUsersInfo(w, r, token)
+	case MethodConversationMembers:
+		fs.serveConversationMembers(w, r, token)
+	default:
+		writeSlackError(w, "unknown_method")
+	}
+}
+
+// authenticate returns the token of a request, writing the error response if it's missing or invalid. It must be
+// called with the lock held
+func (fs *FakeSlack) authenticate(w http.ResponseWriter, r *http.Request) (token slackToken, ok bool) {
+	value := r.Form.Get("token")
+	if value == "" {
+		value = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	if value == "" {
+		writeSlackError(w, "not_authed")
+		return slackToken{}, false
+	}
+
+	token, ok = fs.tokens[value]
+	if !ok {
+		writeSlackError(w, "invalid_auth")
+		return slackToken{}, false
+	}
+
+	return token, true
+}
+
+// servePostMessage serves chat.postMessage. Posting to a channel requires the token's user to be a member of it
+// while users can always be messaged directly
+func (fs *FakeSlack) servePostMessage(w http.ResponseWriter, r *http.Request, token slackToken) {
+	channel := r.Form.Get("channel")
+	if _, isUser := fs.visibleUser(channel, token); !isUser {
+		members, ok := fs.channels[channel]
+		if !ok {
+			writeSlackError(w, "channel_not_found")
+			return
+		}
+
+		if !contains(members, token.userID) {
+			writeSlackError(w, "not_in_channel")
+			return
+		}
+	}
+
+	if r.Form.Get("text") == "" && r.Form.Get("blocks") == "" {
+		writeSlackError(w, "no_text")
+		return
+	}
+
+	ts := fmt.Sprintf("%d.%06d", fs.clock.Now().Unix(), len(fs.messages)+1)
+	fs.messages = append(fs.messages, SlackMessage{TeamID: token.teamID, Channel: channel, Text: r.Form.Get("text"), Blocks: r.Form.Get("blocks"), Timestamp: ts})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "channel": channel, "ts": ts})
+}
+
+// serveUsersInfo serves users.info
+func (fs *FakeSlack) serveUsersInfo(w http.ResponseWriter, r *http.Request, token slackToken) {
+	user, ok := fs.visibleUser(r.Form.Get("user"), token)
+	if !ok {
+		writeSlackError(w, "user_not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "user": user})
+}
+
+// serveConversationMembers serves conversations.members, paginated with the limit and cursor parameters
+func (fs *FakeSlack) serveConversationMembers(w http.ResponseWriter, r *http.Request, token slackToken) {
+	members, ok := fs.channels[r.Form.Get("channel")]
+	if !ok {
+		writeSlackError(w, "channel_not_found")
+		return
+	}
+
+	start := 0
+	if cursor := r.Form.Get("cursor"); cursor != "" {
+		offset, err := base64.StdEncoding.DecodeString(cursor)
+		if err == nil {
+			start, err = strconv.Atoi(string(offset))
+		}
+
+		if err != nil || start > len(members) {
+			writeSlackError(w, "invalid_cursor")
+			return
+		}
+	}
+
+	end := len(members)
+	if limit, err := strconv.Atoi(r.Form.Get("limit")); err == nil && limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	nextCursor := ""
+	if end < len(members) {
+		nextCursor = base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "members": members[start:end], "response_metadata": map[string]string{"next_cursor": nextCursor}})
+}
+
+// serveOAuthV2Access serves oauth.v2.access, exchanging a code for the install it was added with
+func (fs *FakeSlack) serveOAuthV2Access(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+
+	if clientID != fs.clientID || clientSecret != fs.clientSecret {
+		writeSlackError(w, "invalid_client_id")
+		return
+	}
+
+	code := r.Form.Get("code")
+	install, ok := fs.installs[code]
+	if !ok {
+		writeSlackError(w, "invalid_code")
+		return
+	}
+
+	delete(fs.installs, code)
+	fs.tokens[install.AccessToken] = slackToken{teamID: install.TeamID, userID: install.BotUserID}
+
+	var enterprise interface{}
+	if install.EnterpriseID != "" {
+		enterprise = map[string]string{"id": install.EnterpriseID, "name": install.EnterpriseName}
+	}
+
+	var team interface{}
+	if !install.EnterpriseInstall {
+		team = map[string]string{"id": install.TeamID, "name": install.TeamName}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":                    true,
+		"app_id":                install.AppID,
+		"authed_user":           map[string]string{"id": install.AuthedUserID},
+		"scope":                 install.Scope,
+		"token_type":            "bot",
+		"access_token":          install.AccessToken,
+		"bot_user_id":           install.BotUserID,
+		"team":                  team,
+		"enterprise":            enterprise,
+		"is_enterprise_install": install.EnterpriseInstall,
+	})
+}
+
+// visibleUser returns a user if it exists and is visible to the token. It must be called with the lock held
+func (fs *FakeSlack) visibleUser(userID string, token slackToken) (user slack.User, ok bool) {
+	user, ok = fs.users[userID]
+	// Tokens of org-wide installations have no team and see the users of all the workspaces of the org
+	if !ok || (user.TeamID != "" && token.teamID != "" && user.TeamID != token.teamID) {
+		return slack.User{}, false
+	}
+
+	return user, true
+}
+
+// writeSlackError writes a failed web api response with the given error
+func writeSlackError(w http.ResponseWriter, slackError string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": false, "error": slackError})
+}
+
+// contains returns true if values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
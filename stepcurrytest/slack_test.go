@@ -0,0 +1,207 @@
+package stepcurrytest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFakeSlack() (fs *FakeSlack) {
+	fs = NewFakeSlack("slackClientID", "slackClientSecret", "signingSecret")
+	fs.AddToken("xoxb-1", "T1", "UBOT")
+	fs.AddUser(slack.User{ID: "U1", TeamID: "T1", RealName: "Alice", Locale: "fr-FR"})
+	fs.AddUser(slack.User{ID: "U9", TeamID: "T9", RealName: "Mallory"})
+	fs.AddChannel("C1", "UBOT", "U1", "U2", "U3")
+	fs.AddChannel("C2", "U1")
+
+	return fs
+}
+
+func TestFakeSlackPostMessage(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+	fs.UseClock(&fakeClock{now: time.Unix(1591000000, 0)})
+
+	client := slack.New("xoxb-1", slack.OptionAPIURL(fs.APIURL()))
+	channel, ts, err := client.PostMessage("C1", slack.MsgOptionText("hello", false), slack.MsgOptionBlocks(slack.NewDividerBlock()))
+	require.NoError(t, err)
+	assert.Equal(t, "C1", channel)
+	assert.Equal(t, "1591000000.000001", ts)
+
+	// Users can be messaged directly
+	_, _, err = client.PostMessage("U1", slack.MsgOptionText("psst", false))
+	require.NoError(t, err)
+
+	messages := fs.Messages("C1")
+	require.Len(t, messages, 1)
+	assert.Equal(t, "T1", messages[0].TeamID)
+	assert.Equal(t, "hello", messages[0].Text)
+	assert.Equal(t, `[{"type":"divider"}]`, messages[0].Blocks)
+	assert.Len(t, fs.Messages("U1"), 1)
+
+	_, _, err = client.PostMessage("C2", slack.MsgOptionText("hello", false))
+	assert.EqualError(t, err, "not_in_channel")
+
+	_, _, err = client.PostMessage("C404", slack.MsgOptionText("hello", false))
+	assert.EqualError(t, err, "channel_not_found")
+
+	// Users of other teams aren't visible
+	_, _, err = client.PostMessage("U9", slack.MsgOptionText("hello", false))
+	assert.EqualError(t, err, "channel_not_found")
+}
+
+func TestFakeSlackAuthentication(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	_, err := slack.New("xoxb-unknown", slack.OptionAPIURL(fs.APIURL())).GetUserInfo("U1")
+	assert.EqualError(t, err, "invalid_auth")
+
+	_, err = slack.New("", slack.OptionAPIURL(fs.APIURL())).GetUserInfo("U1")
+	assert.EqualError(t, err, "not_authed")
+}
+
+func TestFakeSlackUsersInfo(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	client := slack.New("xoxb-1", slack.OptionAPIURL(fs.APIURL()))
+	user, err := client.GetUserInfo("U1")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.RealName)
+	assert.Equal(t, "fr-FR", user.Locale)
+
+	_, err = client.GetUserInfo("U9")
+	assert.EqualError(t, err, "user_not_found")
+}
+
+func TestFakeSlackConversationMembers(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	client := slack.New("xoxb-1", slack.OptionAPIURL(fs.APIURL()))
+	members, cursor, err := client.GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: "C1", Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"UBOT", "U1", "U2"}, members)
+	require.NotEmpty(t, cursor)
+
+	members, cursor, err = client.GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: "C1", Limit: 3, Cursor: cursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"U3"}, members)
+	assert.Empty(t, cursor)
+
+	fs.AddMembers("C2", "U4")
+	members, _, err = client.GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: "C2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"U1", "U4"}, members)
+
+	_, _, err = client.GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: "C404"})
+	assert.EqualError(t, err, "channel_not_found")
+}
+
+func TestFakeSlackScriptedFailures(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	fs.FailNext(MethodPostMessage, "is_archived")
+	fs.RateLimitNext(MethodPostMessage, 30*time.Second)
+
+	client := slack.New("xoxb-1", slack.OptionAPIURL(fs.APIURL()))
+	_, _, err := client.PostMessage("C1", slack.MsgOptionText("hello", false))
+	assert.EqualError(t, err, "is_archived")
+
+	_, _, err = client.PostMessage("C1", slack.MsgOptionText("hello", false))
+	require.IsType(t, &slack.RateLimitedError{}, err)
+	assert.Equal(t, 30*time.Second, err.(*slack.RateLimitedError).RetryAfter)
+
+	_, _, err = client.PostMessage("C1", slack.MsgOptionText("hello", false))
+	require.NoError(t, err)
+	assert.Len(t, fs.Messages("C1"), 1)
+}
+
+func TestFakeSlackOAuthV2Access(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	fs.AddInstall("code1", SlackInstall{AppID: "A1", TeamID: "T2", TeamName: "Team Two", EnterpriseID: "E1", EnterpriseName: "Enterprise", AuthedUserID: "U5", BotUserID: "UBOT2", AccessToken: "xoxb-2", Scope: "chat:write"})
+
+	exchange := func(clientSecret string, code string) (response map[string]interface{}) {
+		req, err := http.NewRequest(http.MethodPost, fs.APIURL()+MethodOAuthV2Access, strings.NewReader(url.Values{"code": {code}}.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("slackClientID:"+clientSecret)))
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		return response
+	}
+
+	assert.Equal(t, "invalid_client_id", exchange("wrongSecret", "code1")["error"])
+
+	response := exchange("slackClientSecret", "code1")
+	assert.Equal(t, true, response["ok"])
+	assert.Equal(t, "xoxb-2", response["access_token"])
+	assert.Equal(t, "UBOT2", response["bot_user_id"])
+	assert.Equal(t, map[string]interface{}{"id": "T2", "name": "Team Two"}, response["team"])
+	assert.Equal(t, map[string]interface{}{"id": "E1", "name": "Enterprise"}, response["enterprise"])
+
+	// Codes can only be used once
+	assert.Equal(t, "invalid_code", exchange("slackClientSecret", "code1")["error"])
+
+	// The installed token is accepted
+	_, _, err := slack.New("xoxb-2", slack.OptionAPIURL(fs.APIURL())).GetUsersInConversation(&slack.GetUsersInConversationParameters{ChannelID: "C1"})
+	require.NoError(t, err)
+
+	// Org-wide installations have no team
+	fs.AddInstall("code2", SlackInstall{AppID: "A1", EnterpriseID: "E1", EnterpriseName: "Enterprise", EnterpriseInstall: true, AuthedUserID: "U5", BotUserID: "UBOT3", AccessToken: "xoxb-3", Scope: "chat:write"})
+
+	response = exchange("slackClientSecret", "code2")
+	assert.Equal(t, true, response["ok"])
+	assert.Nil(t, response["team"])
+	assert.Equal(t, map[string]interface{}{"id": "E1", "name": "Enterprise"}, response["enterprise"])
+	assert.Equal(t, true, response["is_enterprise_install"])
+}
+
+func TestFakeSlackSlashCommand(t *testing.T) {
+	fs := newTestFakeSlack()
+	defer fs.Close()
+
+	r, responseURL := fs.SlashCommand("/Challenge", SlashCommand{Command: "/step-challenge", TeamID: "T1", ChannelID: "C1", UserID: "U1"})
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	verifier, err := slack.NewSecretsVerifier(r.Header, "signingSecret")
+	require.NoError(t, err)
+	_, err = verifier.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, verifier.Ensure())
+
+	params, err := url.ParseQuery(string(body))
+	require.NoError(t, err)
+	assert.Equal(t, "/step-challenge", params.Get("command"))
+	assert.Equal(t, responseURL, params.Get("response_url"))
+
+	resp, err := http.Post(responseURL, "application/json", strings.NewReader(`{"response_type":"ephemeral","text":"hi"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, []CommandResponse{{ResponseType: "ephemeral", Text: "hi"}}, fs.Responses(responseURL))
+
+	resp, err = http.Post(fs.URL+"/commands/T1/404", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
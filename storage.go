@@ -0,0 +1,387 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"google.golang.org/api/iterator"
+	"time"
+)
+
+// ErrNoSuchEntity is returned by Storage implementations when an entity isn't found. It's the same error value
+// returned by Datastore so that callers can check for either
+var ErrNoSuchEntity = datastore.ErrNoSuchEntity
+
+// Storage defines the interface for persisting Step Curry's entities. Get methods return ErrNoSuchEntity along with
+// a zero-valued entity when it doesn't exist
+type Storage interface {
+	// GetChallenge loads a steps challenge
+	GetChallenge(ctx context.Context, challengeID ChallengeID) (challenge StepsChallenge, err error)
+	// PutChallenge persists a steps challenge
+	PutChallenge(ctx context.Context, challenge StepsChallenge) (err error)
+	// ListActiveChallenges loads all active challenges of a team
+	ListActiveChallenges(ctx context.Context, teamID string) (challenges []StepsChallenge, err error)
+
+	// GetClientAccess loads the fitbit account link of a slack user
+	GetClientAccess(ctx context.Context, teamID string, slackUser string) (clientAccess ClientAccess, err error)
+	// PutClientAccess persists the fitbit account link of a slack user
+	PutClientAccess(ctx context.Context, clientAccess ClientAccess) (err error)
+	// ListClientAccesses loads the fitbit account links of all users of a team
+	ListClientAccesses(ctx context.Context, teamID string) (clientAccesses []ClientAccess, err error)
+	// DeleteClientAccess deletes the fitbit account link of a slack user
+	DeleteClientAccess(ctx context.Context, teamID string, slackUser string) (err error)
+
+	// GetFitbitApiAccess loads the api access of a fitbit user
+	GetFitbitApiAccess(ctx context.Context, fitbitUser string) (apiAccess FitbitApiAccess, err error)
+	// PutFitbitApiAccess persists the api access of a fitbit user
+	PutFitbitApiAccess(ctx context.Context, apiAccess FitbitApiAccess) (err error)
+	// ListFitbitApiAccesses loads the api accesses of all fitbit users
+	ListFitbitApiAccesses(ctx context.Context) (apiAccesses []FitbitApiAccess, err error)
+
+	// GetCsrfToken loads the csrf token of a slack user's pending account link
+	GetCsrfToken(ctx context.Context, teamID string, slackUser string) (csrfToken CsrfToken, err error)
+	// PutCsrfToken persists the csrf token of a slack user's pending account link
+	PutCsrfToken(ctx context.Context, teamID string, slackUser string, csrfToken CsrfToken) (err error)
+	// DeleteCsrfToken deletes the csrf token of a slack user's pending account link
+	DeleteCsrfToken(ctx context.Context, teamID string, slackUser string) (err error)
+
+	// GetBotInfo loads the bot info of a team
+	GetBotInfo(ctx context.Context, teamID string) (botInfo BotInfo, err error)
+	// PutBotInfo persists the bot info of a team
+	PutBotInfo(ctx context.Context, teamID string, botInfo BotInfo) (err error)
+
+	// GetSecret loads an encrypted secret of a team
+	GetSecret(ctx context.Context, teamID string, name string) (secret Secret, err error)
+	// PutSecret persists an encrypted secret of a team
+	PutSecret(ctx context.Context, teamID string, secret Secret) (err error)
+
+	// GetWorkspaceMessages loads the message customizations of a team
+	GetWorkspaceMessages(ctx context.Context, teamID string) (workspaceMessages WorkspaceMessages, err error)
+	// PutWorkspaceMessages persists the message customizations of a team
+	PutWorkspaceMessages(ctx context.Context, workspaceMessages WorkspaceMessages) (err error)
+	// GetWorkspaceSettings loads the settings of a team
+	GetWorkspaceSettings(ctx context.Context, teamID string) (settings WorkspaceSettings, err error)
+	// PutWorkspaceSettings persists the settings of a team
+	PutWorkspaceSettings(ctx context.Context, settings WorkspaceSettings) (err error)
+
+	// GetLeaderboard loads a leaderboard. The channel ID is ignored for workspace leaderboards
+	GetLeaderboard(ctx context.Context, teamID string, scope string, channelID string, period string, periodID string) (leaderboard Leaderboard, err error)
+	// PutLeaderboard persists a leaderboard
+	PutLeaderboard(ctx context.Context, teamID string, leaderboard Leaderboard) (err error)
+
+	// GetUserAchievements loads the achievements of a user
+	GetUserAchievements(ctx context.Context, teamID string, userID string) (achievements UserAchievements, err error)
+	// PutUserAchievements persists the achievements of a user
+	PutUserAchievements(ctx context.Context, teamID string, achievements UserAchievements) (err error)
+
+	// GetUserStreaks loads the streaks of a user
+	GetUserStreaks(ctx context.Context, teamID string, userID string) (streaks UserStreaks, err error)
+	// PutUserStreaks persists the streaks of a user
+	PutUserStreaks(ctx context.Context, teamID string, streaks UserStreaks) (err error)
+
+	// GetDailyActivity loads the cached activity of a fitbit user for a day
+	GetDailyActivity(ctx context.Context, fitbitUser string, date string) (activity DailyActivity, err error)
+	// PutDailyActivity persists the cached activity of a fitbit user for a day
+	PutDailyActivity(ctx context.Context, activity DailyActivity) (err error)
+
+	// GetJob loads a scheduled job
+	GetJob(ctx context.Context, name string) (job Job, err error)
+	// PutJob persists a scheduled job
+	PutJob(ctx context.Context, job Job) (err error)
+	// DeleteJob deletes a scheduled job
+	DeleteJob(ctx context.Context, name string) (err error)
+	// ListDueJobs loads up to limit jobs scheduled at or before the given time, ordered by scheduled time
+	ListDueJobs(ctx context.Context, now time.Time, limit int) (jobs []Job, err error)
+
+	// RunInTransaction runs a function in a transaction. Storage calls made with the context passed to the
+	// function are part of the transaction. Nested calls are part of the enclosing transaction
+	RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error)
+}
+
+// OptionStorage sets a storage as the implementation on StepCurry. This is an alternative to OptionStorer for
+// backends other than Datastore
+func OptionStorage(storage Storage) Option {
+	return func(sc *StepCurry) (err error) {
+		sc.storage = storage
+		return nil
+	}
+}
+
+// datastoreStorage implements Storage on top of a Datastorer
+type datastoreStorage struct {
+	storer Datastorer
+}
+
+// NewDatastoreStorage returns a Storage backed by a Datastorer
+func NewDatastoreStorage(storer Datastorer) (storage Storage) {
+	return &datastoreStorage{storer: storer}
+}
+
+func challengeKey(challengeID ChallengeID) (key *datastore.Key) {
+	return NewKeyWithNamespace("StepsChallenge", challengeID.TeamID, challengeID.Key(), nil)
+}
+
+func clientAccessKey(teamID string, slackUser string) (key *datastore.Key) {
+	return NewKeyWithNamespace("ClientAccess", teamID, slackUser, nil)
+}
+
+func fitbitApiAccessKey(fitbitUser string) (key *datastore.Key) {
+	return datastore.NameKey("FitbitApiAccess", fitbitUser, nil)
+}
+
+func csrfTokenKey(teamID string, slackUser string) (key *datastore.Key) {
+	return NewKeyWithNamespace("CsrfToken", teamID, slackUser, nil)
+}
+
+func botInfoKey(teamID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("BotInfo", teamID, "Bot", nil)
+}
+
+func secretKey(teamID string, name string) (key *datastore.Key) {
+	return NewKeyWithNamespace("Secret", teamID, name, nil)
+}
+
+func workspaceMessagesKey(teamID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("WorkspaceMessages", teamID, "Messages", nil)
+}
+
+func workspaceSettingsKey(teamID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("WorkspaceSettings", teamID, "Settings", nil)
+}
+
+func userAchievementsKey(teamID string, userID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("UserAchievements", teamID, userID, nil)
+}
+
+func jobKey(name string) (key *datastore.Key) {
+	return datastore.NameKey("Job", name, nil)
+}
+
+func userStreaksKey(teamID string, userID string) (key *datastore.Key) {
+	return NewKeyWithNamespace("UserStreaks", teamID, userID, nil)
+}
+
+func (ds *datastoreStorage) GetChallenge(ctx context.Context, challengeID ChallengeID) (challenge StepsChallenge, err error) {
+	err = ds.storer.Get(ctx, challengeKey(challengeID), &challenge)
+	return challenge, err
+}
+
+func (ds *datastoreStorage) PutChallenge(ctx context.Context, challenge StepsChallenge) (err error) {
+	_, err = ds.storer.Put(ctx, challengeKey(challenge.ChallengeID), &challenge)
+	return err
+}
+
+func (ds *datastoreStorage) ListActiveChallenges(ctx context.Context, teamID string) (challenges []StepsChallenge, err error) {
+	challenges = make([]StepsChallenge, 0)
+
+	q := datastore.NewQuery("StepsChallenge").Namespace(teamID).Filter("active =", true)
+	it := ds.storer.Run(ctx, q)
+	for {
+		var challenge StepsChallenge
+		_, err := it.Next(&challenge)
+		if err == iterator.Done {
+			return challenges, nil
+		}
+
+		if err != nil {
+			return challenges, err
+		}
+
+		challenges = append(challenges, challenge)
+	}
+}
+
+func (ds *datastoreStorage) GetClientAccess(ctx context.Context, teamID string, slackUser string) (clientAccess ClientAccess, err error) {
+	err = ds.storer.Get(ctx, clientAccessKey(teamID, slackUser), &clientAccess)
+	return clientAccess, err
+}
+
+func (ds *datastoreStorage) PutClientAccess(ctx context.Context, clientAccess ClientAccess) (err error) {
+	_, err = ds.storer.Put(ctx, clientAccessKey(clientAccess.SlackTeam, clientAccess.SlackUser), &clientAccess)
+	return err
+}
+
+func (ds *datastoreStorage) ListClientAccesses(ctx context.Context, teamID string) (clientAccesses []ClientAccess, err error) {
+	clientAccesses = make([]ClientAccess, 0)
+
+	q := datastore.NewQuery("ClientAccess").Namespace(teamID)
+	it := ds.storer.Run(ctx, q)
+	for {
+		var clientAccess ClientAccess
+		_, err := it.Next(&clientAccess)
+		if err == iterator.Done {
+			return clientAccesses, nil
+		}
+
+		if err != nil {
+			return clientAccesses, err
+		}
+
+		clientAccesses = append(clientAccesses, clientAccess)
+	}
+}
+
+func (ds *datastoreStorage) GetFitbitApiAccess(ctx context.Context, fitbitUser string) (apiAccess FitbitApiAccess, err error) {
+	err = ds.storer.Get(ctx, fitbitApiAccessKey(fitbitUser), &apiAccess)
+	return apiAccess, err
+}
+
+func (ds *datastoreStorage) PutFitbitApiAccess(ctx context.Context, apiAccess FitbitApiAccess) (err error) {
+	_, err = ds.storer.Put(ctx, fitbitApiAccessKey(apiAccess.FitbitUser), &apiAccess)
+	return err
+}
+
+func (ds *datastoreStorage) ListFitbitApiAccesses(ctx context.Context) (apiAccesses []FitbitApiAccess, err error) {
+	apiAccesses = make([]FitbitApiAccess, 0)
+
+	q := datastore.NewQuery("FitbitApiAccess")
+	it := ds.storer.Run(ctx, q)
+	for {
+		var apiAccess FitbitApiAccess
+		_, err := it.Next(&apiAccess)
+		if err == iterator.Done {
+			return apiAccesses, nil
+		}
+
+		if err != nil {
+			return apiAccesses, err
+		}
+
+		apiAccesses = append(apiAccesses, apiAccess)
+	}
+}
+
+func (ds *datastoreStorage) GetCsrfToken(ctx context.Context, teamID string, slackUser string) (csrfToken CsrfToken, err error) {
+	err = ds.storer.Get(ctx, csrfTokenKey(teamID, slackUser), &csrfToken)
+	return csrfToken, err
+}
+
+func (ds *datastoreStorage) PutCsrfToken(ctx context.Context, teamID string, slackUser string, csrfToken CsrfToken) (err error) {
+	_, err = ds.storer.Put(ctx, csrfTokenKey(teamID, slackUser), &csrfToken)
+	return err
+}
+
+func (ds *datastoreStorage) DeleteClientAccess(ctx context.Context, teamID string, slackUser string) (err error) {
+	return ds.storer.Delete(ctx, clientAccessKey(teamID, slackUser))
+}
+
+func (ds *datastoreStorage) DeleteCsrfToken(ctx context.Context, teamID string, slackUser string) (err error) {
+	return ds.storer.Delete(ctx, csrfTokenKey(teamID, slackUser))
+}
+
+func (ds *datastoreStorage) GetBotInfo(ctx context.Context, teamID string) (botInfo BotInfo, err error) {
+	err = ds.storer.Get(ctx, botInfoKey(teamID), &botInfo)
+	return botInfo, err
+}
+
+func (ds *datastoreStorage) PutBotInfo(ctx context.Context, teamID string, botInfo BotInfo) (err error) {
+	_, err = ds.storer.Put(ctx, botInfoKey(teamID), &botInfo)
+	return err
+}
+
+func (ds *datastoreStorage) GetSecret(ctx context.Context, teamID string, name string) (secret Secret, err error) {
+	err = ds.storer.Get(ctx, secretKey(teamID, name), &secret)
+	return secret, err
+}
+
+func (ds *datastoreStorage) PutSecret(ctx context.Context, teamID string, secret Secret) (err error) {
+	_, err = ds.storer.Put(ctx, secretKey(teamID, secret.Name), &secret)
+	return err
+}
+
+func (ds *datastoreStorage) GetWorkspaceMessages(ctx context.Context, teamID string) (workspaceMessages WorkspaceMessages, err error) {
+	err = ds.storer.Get(ctx, workspaceMessagesKey(teamID), &workspaceMessages)
+	return workspaceMessages, err
+}
+
+func (ds *datastoreStorage) PutWorkspaceMessages(ctx context.Context, workspaceMessages WorkspaceMessages) (err error) {
+	_, err = ds.storer.Put(ctx, workspaceMessagesKey(workspaceMessages.TeamID), &workspaceMessages)
+	return err
+}
+
+func (ds *datastoreStorage) GetWorkspaceSettings(ctx context.Context, teamID string) (settings WorkspaceSettings, err error) {
+	err = ds.storer.Get(ctx, workspaceSettingsKey(teamID), &settings)
+	return settings, err
+}
+
+func (ds *datastoreStorage) PutWorkspaceSettings(ctx context.Context, settings WorkspaceSettings) (err error) {
+	_, err = ds.storer.Put(ctx, workspaceSettingsKey(settings.TeamID), &settings)
+	return err
+}
+
+func (ds *datastoreStorage) GetLeaderboard(ctx context.Context, teamID string, scope string, channelID string, period string, periodID string) (leaderboard Leaderboard, err error) {
+	err = ds.storer.Get(ctx, leaderboardKey(teamID, scope, channelID, period, periodID), &leaderboard)
+	return leaderboard, err
+}
+
+func (ds *datastoreStorage) PutLeaderboard(ctx context.Context, teamID string, leaderboard Leaderboard) (err error) {
+	_, err = ds.storer.Put(ctx, leaderboardKey(teamID, leaderboard.Scope, leaderboard.ChannelID, leaderboard.Period, leaderboard.PeriodID), &leaderboard)
+	return err
+}
+
+func (ds *datastoreStorage) GetUserAchievements(ctx context.Context, teamID string, userID string) (achievements UserAchievements, err error) {
+	err = ds.storer.Get(ctx, userAchievementsKey(teamID, userID), &achievements)
+	return achievements, err
+}
+
+func (ds *datastoreStorage) PutUserAchievements(ctx context.Context, teamID string, achievements UserAchievements) (err error) {
+	_, err = ds.storer.Put(ctx, userAchievementsKey(teamID, achievements.UserID), &achievements)
+	return err
+}
+
+func (ds *datastoreStorage) GetUserStreaks(ctx context.Context, teamID string, userID string) (streaks UserStreaks, err error) {
+	err = ds.storer.Get(ctx, userStreaksKey(teamID, userID), &streaks)
+	return streaks, err
+}
+
+func (ds *datastoreStorage) PutUserStreaks(ctx context.Context, teamID string, streaks UserStreaks) (err error) {
+	_, err = ds.storer.Put(ctx, userStreaksKey(teamID, streaks.UserID), &streaks)
+	return err
+}
+
+func (ds *datastoreStorage) GetDailyActivity(ctx context.Context, fitbitUser string, date string) (activity DailyActivity, err error) {
+	err = ds.storer.Get(ctx, dailyActivityKey(fitbitUser, date), &activity)
+	return activity, err
+}
+
+func (ds *datastoreStorage) PutDailyActivity(ctx context.Context, activity DailyActivity) (err error) {
+	_, err = ds.storer.Put(ctx, dailyActivityKey(activity.FitbitUser, activity.Date), &activity)
+	return err
+}
+
+func (ds *datastoreStorage) GetJob(ctx context.Context, name string) (job Job, err error) {
+	err = ds.storer.Get(ctx, jobKey(name), &job)
+	return job, err
+}
+
+func (ds *datastoreStorage) PutJob(ctx context.Context, job Job) (err error) {
+	_, err = ds.storer.Put(ctx, jobKey(job.Name), &job)
+	return err
+}
+
+func (ds *datastoreStorage) DeleteJob(ctx context.Context, name string) (err error) {
+	return ds.storer.Delete(ctx, jobKey(name))
+}
+
+func (ds *datastoreStorage) ListDueJobs(ctx context.Context, now time.Time, limit int) (jobs []Job, err error) {
+	jobs = make([]Job, 0)
+
+	q := datastore.NewQuery("Job").Filter("scheduledTime <=", now).Order("scheduledTime").Limit(limit)
+	it := ds.storer.Run(ctx, q)
+	for {
+		var job Job
+		_, err := it.Next(&job)
+		if err == iterator.Done {
+			return jobs, nil
+		}
+
+		if err != nil {
+			return jobs, err
+		}
+
+		jobs = append(jobs, job)
+	}
+}
+
+func (ds *datastoreStorage) RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	return ds.storer.RunInTransaction(ctx, f)
+}
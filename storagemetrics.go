@@ -0,0 +1,931 @@
+package stepcurry
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using https://raw.githubusercontent.com/alexandre-normand/slackscot/master/opentelemetry.template template
+
+//go:generate gowrap gen -p github.com/alexandre-normand/stepcurry -i Storage -t https://raw.githubusercontent.com/alexandre-normand/slackscot/master/opentelemetry.template -o storagemetrics.go
+
+import (
+	"context"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// StorageWithTelemetry implements Storage interface with all methods wrapped
+// with open telemetry metrics
+type StorageWithTelemetry struct {
+	base                     Storage
+	methodCounters           map[string]metric.BoundInt64Counter
+	errCounters              map[string]metric.BoundInt64Counter
+	methodTimeValueRecorders map[string]metric.BoundInt64ValueRecorder
+}
+
+// NewStorageWithTelemetry returns an instance of the Storage decorated with open telemetry timing and count metrics
+func NewStorageWithTelemetry(base Storage, name string, meter metric.Meter) StorageWithTelemetry {
+	return StorageWithTelemetry{
+		base:                     base,
+		methodCounters:           newStorageMethodCounters("Calls", name, meter),
+		errCounters:              newStorageMethodCounters("Errors", name, meter),
+		methodTimeValueRecorders: newStorageMethodTimeValueRecorders(name, meter),
+	}
+}
+
+func newStorageMethodTimeValueRecorders(appName string, meter metric.Meter) (boundTimeValueRecorders map[string]metric.BoundInt64ValueRecorder) {
+	boundTimeValueRecorders = make(map[string]metric.BoundInt64ValueRecorder)
+	mt := metric.Must(meter)
+	nDeleteClientAccessValRecorder := []rune("Storage_DeleteClientAccess_ProcessingTimeMillis")
+	nDeleteClientAccessValRecorder[0] = unicode.ToLower(nDeleteClientAccessValRecorder[0])
+	mDeleteClientAccess := mt.NewInt64ValueRecorder(string(nDeleteClientAccessValRecorder))
+	boundTimeValueRecorders["DeleteClientAccess"] = mDeleteClientAccess.Bind(label.String("name", appName))
+	nDeleteCsrfTokenValRecorder := []rune("Storage_DeleteCsrfToken_ProcessingTimeMillis")
+	nDeleteCsrfTokenValRecorder[0] = unicode.ToLower(nDeleteCsrfTokenValRecorder[0])
+	mDeleteCsrfToken := mt.NewInt64ValueRecorder(string(nDeleteCsrfTokenValRecorder))
+	boundTimeValueRecorders["DeleteCsrfToken"] = mDeleteCsrfToken.Bind(label.String("name", appName))
+	nDeleteJobValRecorder := []rune("Storage_DeleteJob_ProcessingTimeMillis")
+	nDeleteJobValRecorder[0] = unicode.ToLower(nDeleteJobValRecorder[0])
+	mDeleteJob := mt.NewInt64ValueRecorder(string(nDeleteJobValRecorder))
+	boundTimeValueRecorders["DeleteJob"] = mDeleteJob.Bind(label.String("name", appName))
+	nGetBotInfoValRecorder := []rune("Storage_GetBotInfo_ProcessingTimeMillis")
+	nGetBotInfoValRecorder[0] = unicode.ToLower(nGetBotInfoValRecorder[0])
+	mGetBotInfo := mt.NewInt64ValueRecorder(string(nGetBotInfoValRecorder))
+	boundTimeValueRecorders["GetBotInfo"] = mGetBotInfo.Bind(label.String("name", appName))
+	nGetChallengeValRecorder := []rune("Storage_GetChallenge_ProcessingTimeMillis")
+	nGetChallengeValRecorder[0] = unicode.ToLower(nGetChallengeValRecorder[0])
+	mGetChallenge := mt.NewInt64ValueRecorder(string(nGetChallengeValRecorder))
+	boundTimeValueRecorders["GetChallenge"] = mGetChallenge.Bind(label.String("name", appName))
+	nGetClientAccessValRecorder := []rune("Storage_GetClientAccess_ProcessingTimeMillis")
+	nGetClientAccessValRecorder[0] = unicode.ToLower(nGetClientAccessValRecorder[0])
+	mGetClientAccess := mt.NewInt64ValueRecorder(string(nGetClientAccessValRecorder))
+	boundTimeValueRecorders["GetClientAccess"] = mGetClientAccess.Bind(label.String("name", appName))
+	nGetCsrfTokenValRecorder := []rune("Storage_GetCsrfToken_ProcessingTimeMillis")
+	nGetCsrfTokenValRecorder[0] = unicode.ToLower(nGetCsrfTokenValRecorder[0])
+	mGetCsrfToken := mt.NewInt64ValueRecorder(string(nGetCsrfTokenValRecorder))
+	boundTimeValueRecorders["GetCsrfToken"] = mGetCsrfToken.Bind(label.String("name", appName))
+	nGetDailyActivityValRecorder := []rune("Storage_GetDailyActivity_ProcessingTimeMillis")
+	nGetDailyActivityValRecorder[0] = unicode.ToLower(nGetDailyActivityValRecorder[0])
+	mGetDailyActivity := mt.NewInt64ValueRecorder(string(nGetDailyActivityValRecorder))
+	boundTimeValueRecorders["GetDailyActivity"] = mGetDailyActivity.Bind(label.String("name", appName))
+	nGetFitbitApiAccessValRecorder := []rune("Storage_GetFitbitApiAccess_ProcessingTimeMillis")
+	nGetFitbitApiAccessValRecorder[0] = unicode.ToLower(nGetFitbitApiAccessValRecorder[0])
+	mGetFitbitApiAccess := mt.NewInt64ValueRecorder(string(nGetFitbitApiAccessValRecorder))
+	boundTimeValueRecorders["GetFitbitApiAccess"] = mGetFitbitApiAccess.Bind(label.String("name", appName))
+	nGetJobValRecorder := []rune("Storage_GetJob_ProcessingTimeMillis")
+	nGetJobValRecorder[0] = unicode.ToLower(nGetJobValRecorder[0])
+	mGetJob := mt.NewInt64ValueRecorder(string(nGetJobValRecorder))
+	boundTimeValueRecorders["GetJob"] = mGetJob.Bind(label.String("name", appName))
+	nGetLeaderboardValRecorder := []rune("Storage_GetLeaderboard_ProcessingTimeMillis")
+	nGetLeaderboardValRecorder[0] = unicode.ToLower(nGetLeaderboardValRecorder[0])
+	mGetLeaderboard := mt.NewInt64ValueRecorder(string(nGetLeaderboardValRecorder))
+	boundTimeValueRecorders["GetLeaderboard"] = mGetLeaderboard.Bind(label.String("name", appName))
+	nGetSecretValRecorder := []rune("Storage_GetSecret_ProcessingTimeMillis")
+	nGetSecretValRecorder[0] = unicode.ToLower(nGetSecretValRecorder[0])
+	mGetSecret := mt.NewInt64ValueRecorder(string(nGetSecretValRecorder))
+	boundTimeValueRecorders["GetSecret"] = mGetSecret.Bind(label.String("name", appName))
+	nGetUserAchievementsValRecorder := []rune("Storage_GetUserAchievements_ProcessingTimeMillis")
+	nGetUserAchievementsValRecorder[0] = unicode.ToLower(nGetUserAchievementsValRecorder[0])
+	mGetUserAchievements := mt.NewInt64ValueRecorder(string(nGetUserAchievementsValRecorder))
+	boundTimeValueRecorders["GetUserAchievements"] = mGetUserAchievements.Bind(label.String("name", appName))
+	nGetUserStreaksValRecorder := []rune("Storage_GetUserStreaks_ProcessingTimeMillis")
+	nGetUserStreaksValRecorder[0] = unicode.ToLower(nGetUserStreaksValRecorder[0])
+	mGetUserStreaks := mt.NewInt64ValueRecorder(string(nGetUserStreaksValRecorder))
+	boundTimeValueRecorders["GetUserStreaks"] = mGetUserStreaks.Bind(label.String("name", appName))
+	nGetWorkspaceMessagesValRecorder := []rune("Storage_GetWorkspaceMessages_ProcessingTimeMillis")
+	nGetWorkspaceMessagesValRecorder[0] = unicode.ToLower(nGetWorkspaceMessagesValRecorder[0])
+	mGetWorkspaceMessages := mt.NewInt64ValueRecorder(string(nGetWorkspaceMessagesValRecorder))
+	boundTimeValueRecorders["GetWorkspaceMessages"] = mGetWorkspaceMessages.Bind(label.String("name", appName))
+	nGetWorkspaceSettingsValRecorder := []rune("Storage_GetWorkspaceSettings_ProcessingTimeMillis")
+	nGetWorkspaceSettingsValRecorder[0] = unicode.ToLower(nGetWorkspaceSettingsValRecorder[0])
+	mGetWorkspaceSettings := mt.NewInt64ValueRecorder(string(nGetWorkspaceSettingsValRecorder))
+	boundTimeValueRecorders["GetWorkspaceSettings"] = mGetWorkspaceSettings.Bind(label.String("name", appName))
+	nListActiveChallengesValRecorder := []rune("Storage_ListActiveChallenges_ProcessingTimeMillis")
+	nListActiveChallengesValRecorder[0] = unicode.ToLower(nListActiveChallengesValRecorder[0])
+	mListActiveChallenges := mt.NewInt64ValueRecorder(string(nListActiveChallengesValRecorder))
+	boundTimeValueRecorders["ListActiveChallenges"] = mListActiveChallenges.Bind(label.String("name", appName))
+	nListClientAccessesValRecorder := []rune("Storage_ListClientAccesses_ProcessingTimeMillis")
+	nListClientAccessesValRecorder[0] = unicode.ToLower(nListClientAccessesValRecorder[0])
+	mListClientAccesses := mt.NewInt64ValueRecorder(string(nListClientAccessesValRecorder))
+	boundTimeValueRecorders["ListClientAccesses"] = mListClientAccesses.Bind(label.String("name", appName))
+	nListDueJobsValRecorder := []rune("Storage_ListDueJobs_ProcessingTimeMillis")
+	nListDueJobsValRecorder[0] = unicode.ToLower(nListDueJobsValRecorder[0])
+	mListDueJobs := mt.NewInt64ValueRecorder(string(nListDueJobsValRecorder))
+	boundTimeValueRecorders["ListDueJobs"] = mListDueJobs.Bind(label.String("name", appName))
+	nListFitbitApiAccessesValRecorder := []rune("Storage_ListFitbitApiAccesses_ProcessingTimeMillis")
+	nListFitbitApiAccessesValRecorder[0] = unicode.ToLower(nListFitbitApiAccessesValRecorder[0])
+	mListFitbitApiAccesses := mt.NewInt64ValueRecorder(string(nListFitbitApiAccessesValRecorder))
+	boundTimeValueRecorders["ListFitbitApiAccesses"] = mListFitbitApiAccesses.Bind(label.String("name", appName))
+	nPutBotInfoValRecorder := []rune("Storage_PutBotInfo_ProcessingTimeMillis")
+	nPutBotInfoValRecorder[0] = unicode.ToLower(nPutBotInfoValRecorder[0])
+	mPutBotInfo := mt.NewInt64ValueRecorder(string(nPutBotInfoValRecorder))
+	boundTimeValueRecorders["PutBotInfo"] = mPutBotInfo.Bind(label.String("name", appName))
+	nPutChallengeValRecorder := []rune("Storage_PutChallenge_ProcessingTimeMillis")
+	nPutChallengeValRecorder[0] = unicode.ToLower(nPutChallengeValRecorder[0])
+	mPutChallenge := mt.NewInt64ValueRecorder(string(nPutChallengeValRecorder))
+	boundTimeValueRecorders["PutChallenge"] = mPutChallenge.Bind(label.String("name", appName))
+	nPutClientAccessValRecorder := []rune("Storage_PutClientAccess_ProcessingTimeMillis")
+	nPutClientAccessValRecorder[0] = unicode.ToLower(nPutClientAccessValRecorder[0])
+	mPutClientAccess := mt.NewInt64ValueRecorder(string(nPutClientAccessValRecorder))
+	boundTimeValueRecorders["PutClientAccess"] = mPutClientAccess.Bind(label.String("name", appName))
+	nPutCsrfTokenValRecorder := []rune("Storage_PutCsrfToken_ProcessingTimeMillis")
+	nPutCsrfTokenValRecorder[0] = unicode.ToLower(nPutCsrfTokenValRecorder[0])
+	mPutCsrfToken := mt.NewInt64ValueRecorder(string(nPutCsrfTokenValRecorder))
+	boundTimeValueRecorders["PutCsrfToken"] = mPutCsrfToken.Bind(label.String("name", appName))
+	nPutDailyActivityValRecorder := []rune("Storage_PutDailyActivity_ProcessingTimeMillis")
+	nPutDailyActivityValRecorder[0] = unicode.ToLower(nPutDailyActivityValRecorder[0])
+	mPutDailyActivity := mt.NewInt64ValueRecorder(string(nPutDailyActivityValRecorder))
+	boundTimeValueRecorders["PutDailyActivity"] = mPutDailyActivity.Bind(label.String("name", appName))
+	nPutFitbitApiAccessValRecorder := []rune("Storage_PutFitbitApiAccess_ProcessingTimeMillis")
+	nPutFitbitApiAccessValRecorder[0] = unicode.ToLower(nPutFitbitApiAccessValRecorder[0])
+	mPutFitbitApiAccess := mt.NewInt64ValueRecorder(string(nPutFitbitApiAccessValRecorder))
+	boundTimeValueRecorders["PutFitbitApiAccess"] = mPutFitbitApiAccess.Bind(label.String("name", appName))
+	nPutJobValRecorder := []rune("Storage_PutJob_ProcessingTimeMillis")
+	nPutJobValRecorder[0] = unicode.ToLower(nPutJobValRecorder[0])
+	mPutJob := mt.NewInt64ValueRecorder(string(nPutJobValRecorder))
+	boundTimeValueRecorders["PutJob"] = mPutJob.Bind(label.String("name", appName))
+	nPutLeaderboardValRecorder := []rune("Storage_PutLeaderboard_ProcessingTimeMillis")
+	nPutLeaderboardValRecorder[0] = unicode.ToLower(nPutLeaderboardValRecorder[0])
+	mPutLeaderboard := mt.NewInt64ValueRecorder(string(nPutLeaderboardValRecorder))
+	boundTimeValueRecorders["PutLeaderboard"] = mPutLeaderboard.Bind(label.String("name", appName))
+	nPutSecretValRecorder := []rune("Storage_PutSecret_ProcessingTimeMillis")
+	nPutSecretValRecorder[0] = unicode.ToLower(nPutSecretValRecorder[0])
+	mPutSecret := mt.NewInt64ValueRecorder(string(nPutSecretValRecorder))
+	boundTimeValueRecorders["PutSecret"] = mPutSecret.Bind(label.String("name", appName))
+	nPutUserAchievementsValRecorder := []rune("Storage_PutUserAchievements_ProcessingTimeMillis")
+	nPutUserAchievementsValRecorder[0] = unicode.ToLower(nPutUserAchievementsValRecorder[0])
+	mPutUserAchievements := mt.NewInt64ValueRecorder(string(nPutUserAchievementsValRecorder))
+	boundTimeValueRecorders["PutUserAchievements"] = mPutUserAchievements.Bind(label.String("name", appName))
+	nPutUserStreaksValRecorder := []rune("Storage_PutUserStreaks_ProcessingTimeMillis")
+	nPutUserStreaksValRecorder[0] = unicode.ToLower(nPutUserStreaksValRecorder[0])
+	mPutUserStreaks := mt.NewInt64ValueRecorder(string(nPutUserStreaksValRecorder))
+	boundTimeValueRecorders["PutUserStreaks"] = mPutUserStreaks.Bind(label.String("name", appName))
+	nPutWorkspaceMessagesValRecorder := []rune("Storage_PutWorkspaceMessages_ProcessingTimeMillis")
+	nPutWorkspaceMessagesValRecorder[0] = unicode.ToLower(nPutWorkspaceMessagesValRecorder[0])
+	mPutWorkspaceMessages := mt.NewInt64ValueRecorder(string(nPutWorkspaceMessagesValRecorder))
+	boundTimeValueRecorders["PutWorkspaceMessages"] = mPutWorkspaceMessages.Bind(label.String("name", appName))
+	nPutWorkspaceSettingsValRecorder := []rune("Storage_PutWorkspaceSettings_ProcessingTimeMillis")
+	nPutWorkspaceSettingsValRecorder[0] = unicode.ToLower(nPutWorkspaceSettingsValRecorder[0])
+	mPutWorkspaceSettings := mt.NewInt64ValueRecorder(string(nPutWorkspaceSettingsValRecorder))
+	boundTimeValueRecorders["PutWorkspaceSettings"] = mPutWorkspaceSettings.Bind(label.String("name", appName))
+	nRunInTransactionValRecorder := []rune("Storage_RunInTransaction_ProcessingTimeMillis")
+	nRunInTransactionValRecorder[0] = unicode.ToLower(nRunInTransactionValRecorder[0])
+	mRunInTransaction := mt.NewInt64ValueRecorder(string(nRunInTransactionValRecorder))
+	boundTimeValueRecorders["RunInTransaction"] = mRunInTransaction.Bind(label.String("name", appName))
+	return boundTimeValueRecorders
+}
+
+func newStorageMethodCounters(suffix string, appName string, meter metric.Meter) (boundCounters map[string]metric.BoundInt64Counter) {
+	boundCounters = make(map[string]metric.BoundInt64Counter)
+	mt := metric.Must(meter)
+	nDeleteClientAccessCounter := []rune("Storage_DeleteClientAccess_" + suffix)
+	nDeleteClientAccessCounter[0] = unicode.ToLower(nDeleteClientAccessCounter[0])
+	cDeleteClientAccess := mt.NewInt64Counter(string(nDeleteClientAccessCounter))
+	boundCounters["DeleteClientAccess"] = cDeleteClientAccess.Bind(label.String("name", appName))
+	nDeleteCsrfTokenCounter := []rune("Storage_DeleteCsrfToken_" + suffix)
+	nDeleteCsrfTokenCounter[0] = unicode.ToLower(nDeleteCsrfTokenCounter[0])
+	cDeleteCsrfToken := mt.NewInt64Counter(string(nDeleteCsrfTokenCounter))
+	boundCounters["DeleteCsrfToken"] = cDeleteCsrfToken.Bind(label.String("name", appName))
+	nDeleteJobCounter := []rune("Storage_DeleteJob_" + suffix)
+	nDeleteJobCounter[0] = unicode.ToLower(nDeleteJobCounter[0])
+	cDeleteJob := mt.NewInt64Counter(string(nDeleteJobCounter))
+	boundCounters["DeleteJob"] = cDeleteJob.Bind(label.String("name", appName))
+	nGetBotInfoCounter := []rune("Storage_GetBotInfo_" + suffix)
+	nGetBotInfoCounter[0] = unicode.ToLower(nGetBotInfoCounter[0])
+	cGetBotInfo := mt.NewInt64Counter(string(nGetBotInfoCounter))
+	boundCounters["GetBotInfo"] = cGetBotInfo.Bind(label.String("name", appName))
+	nGetChallengeCounter := []rune("Storage_GetChallenge_" + suffix)
+	nGetChallengeCounter[0] = unicode.ToLower(nGetChallengeCounter[0])
+	cGetChallenge := mt.NewInt64Counter(string(nGetChallengeCounter))
+	boundCounters["GetChallenge"] = cGetChallenge.Bind(label.String("name", appName))
+	nGetClientAccessCounter := []rune("Storage_GetClientAccess_" + suffix)
+	nGetClientAccessCounter[0] = unicode.ToLower(nGetClientAccessCounter[0])
+	cGetClientAccess := mt.NewInt64Counter(string(nGetClientAccessCounter))
+	boundCounters["GetClientAccess"] = cGetClientAccess.Bind(label.String("name", appName))
+	nGetCsrfTokenCounter := []rune("Storage_GetCsrfToken_" + suffix)
+	nGetCsrfTokenCounter[0] = unicode.ToLower(nGetCsrfTokenCounter[0])
+	cGetCsrfToken := mt.NewInt64Counter(string(nGetCsrfTokenCounter))
+	boundCounters["GetCsrfToken"] = cGetCsrfToken.Bind(label.String("name", appName))
+	nGetDailyActivityCounter := []rune("Storage_GetDailyActivity_" + suffix)
+	nGetDailyActivityCounter[0] = unicode.ToLower(nGetDailyActivityCounter[0])
+	cGetDailyActivity := mt.NewInt64Counter(string(nGetDailyActivityCounter))
+	boundCounters["GetDailyActivity"] = cGetDailyActivity.Bind(label.String("name", appName))
+	nGetFitbitApiAccessCounter := []rune("Storage_GetFitbitApiAccess_" + suffix)
+	nGetFitbitApiAccessCounter[0] = unicode.ToLower(nGetFitbitApiAccessCounter[0])
+	cGetFitbitApiAccess := mt.NewInt64Counter(string(nGetFitbitApiAccessCounter))
+	boundCounters["GetFitbitApiAccess"] = cGetFitbitApiAccess.Bind(label.String("name", appName))
+	nGetJobCounter := []rune("Storage_GetJob_" + suffix)
+	nGetJobCounter[0] = unicode.ToLower(nGetJobCounter[0])
+	cGetJob := mt.NewInt64Counter(string(nGetJobCounter))
+	boundCounters["GetJob"] = cGetJob.Bind(label.String("name", appName))
+	nGetLeaderboardCounter := []rune("Storage_GetLeaderboard_" + suffix)
+	nGetLeaderboardCounter[0] = unicode.ToLower(nGetLeaderboardCounter[0])
+	cGetLeaderboard := mt.NewInt64Counter(string(nGetLeaderboardCounter))
+	boundCounters["GetLeaderboard"] = cGetLeaderboard.Bind(label.String("name", appName))
+	nGetSecretCounter := []rune("Storage_GetSecret_" + suffix)
+	nGetSecretCounter[0] = unicode.ToLower(nGetSecretCounter[0])
+	cGetSecret := mt.NewInt64Counter(string(nGetSecretCounter))
+	boundCounters["GetSecret"] = cGetSecret.Bind(label.String("name", appName))
+	nGetUserAchievementsCounter := []rune("Storage_GetUserAchievements_" + suffix)
+	nGetUserAchievementsCounter[0] = unicode.ToLower(nGetUserAchievementsCounter[0])
+	cGetUserAchievements := mt.NewInt64Counter(string(nGetUserAchievementsCounter))
+	boundCounters["GetUserAchievements"] = cGetUserAchievements.Bind(label.String("name", appName))
+	nGetUserStreaksCounter := []rune("Storage_GetUserStreaks_" + suffix)
+	nGetUserStreaksCounter[0] = unicode.ToLower(nGetUserStreaksCounter[0])
+	cGetUserStreaks := mt.NewInt64Counter(string(nGetUserStreaksCounter))
+	boundCounters["GetUserStreaks"] = cGetUserStreaks.Bind(label.String("name", appName))
+	nGetWorkspaceMessagesCounter := []rune("Storage_GetWorkspaceMessages_" + suffix)
+	nGetWorkspaceMessagesCounter[0] = unicode.ToLower(nGetWorkspaceMessagesCounter[0])
+	cGetWorkspaceMessages := mt.NewInt64Counter(string(nGetWorkspaceMessagesCounter))
+	boundCounters["GetWorkspaceMessages"] = cGetWorkspaceMessages.Bind(label.String("name", appName))
+	nGetWorkspaceSettingsCounter := []rune("Storage_GetWorkspaceSettings_" + suffix)
+	nGetWorkspaceSettingsCounter[0] = unicode.ToLower(nGetWorkspaceSettingsCounter[0])
+	cGetWorkspaceSettings := mt.NewInt64Counter(string(nGetWorkspaceSettingsCounter))
+	boundCounters["GetWorkspaceSettings"] = cGetWorkspaceSettings.Bind(label.String("name", appName))
+	nListActiveChallengesCounter := []rune("Storage_ListActiveChallenges_" + suffix)
+	nListActiveChallengesCounter[0] = unicode.ToLower(nListActiveChallengesCounter[0])
+	cListActiveChallenges := mt.NewInt64Counter(string(nListActiveChallengesCounter))
+	boundCounters["ListActiveChallenges"] = cListActiveChallenges.Bind(label.String("name", appName))
+	nListClientAccessesCounter := []rune("Storage_ListClientAccesses_" + suffix)
+	nListClientAccessesCounter[0] = unicode.ToLower(nListClientAccessesCounter[0])
+	cListClientAccesses := mt.NewInt64Counter(string(nListClientAccessesCounter))
+	boundCounters["ListClientAccesses"] = cListClientAccesses.Bind(label.String("name", appName))
+	nListDueJobsCounter := []rune("Storage_ListDueJobs_" + suffix)
+	nListDueJobsCounter[0] = unicode.ToLower(nListDueJobsCounter[0])
+	cListDueJobs := mt.NewInt64Counter(string(nListDueJobsCounter))
+	boundCounters["ListDueJobs"] = cListDueJobs.Bind(label.String("name", appName))
+	nListFitbitApiAccessesCounter := []rune("Storage_ListFitbitApiAccesses_" + suffix)
+	nListFitbitApiAccessesCounter[0] = unicode.ToLower(nListFitbitApiAccessesCounter[0])
+	cListFitbitApiAccesses := mt.NewInt64Counter(string(nListFitbitApiAccessesCounter))
+	boundCounters["ListFitbitApiAccesses"] = cListFitbitApiAccesses.Bind(label.String("name", appName))
+	nPutBotInfoCounter := []rune("Storage_PutBotInfo_" + suffix)
+	nPutBotInfoCounter[0] = unicode.ToLower(nPutBotInfoCounter[0])
+	cPutBotInfo := mt.NewInt64Counter(string(nPutBotInfoCounter))
+	boundCounters["PutBotInfo"] = cPutBotInfo.Bind(label.String("name", appName))
+	nPutChallengeCounter := []rune("Storage_PutChallenge_" + suffix)
+	nPutChallengeCounter[0] = unicode.ToLower(nPutChallengeCounter[0])
+	cPutChallenge := mt.NewInt64Counter(string(nPutChallengeCounter))
+	boundCounters["PutChallenge"] = cPutChallenge.Bind(label.String("name", appName))
+	nPutClientAccessCounter := []rune("Storage_PutClientAccess_" + suffix)
+	nPutClientAccessCounter[0] = unicode.ToLower(nPutClientAccessCounter[0])
+	cPutClientAccess := mt.NewInt64Counter(string(nPutClientAccessCounter))
+	boundCounters["PutClientAccess"] = cPutClientAccess.Bind(label.String("name", appName))
+	nPutCsrfTokenCounter := []rune("Storage_PutCsrfToken_" + suffix)
+	nPutCsrfTokenCounter[0] = unicode.ToLower(nPutCsrfTokenCounter[0])
+	cPutCsrfToken := mt.NewInt64Counter(string(nPutCsrfTokenCounter))
+	boundCounters["PutCsrfToken"] = cPutCsrfToken.Bind(label.String("name", appName))
+	nPutDailyActivityCounter := []rune("Storage_PutDailyActivity_" + suffix)
+	nPutDailyActivityCounter[0] = unicode.ToLower(nPutDailyActivityCounter[0])
+	cPutDailyActivity := mt.NewInt64Counter(string(nPutDailyActivityCounter))
+	boundCounters["PutDailyActivity"] = cPutDailyActivity.Bind(label.String("name", appName))
+	nPutFitbitApiAccessCounter := []rune("Storage_PutFitbitApiAccess_" + suffix)
+	nPutFitbitApiAccessCounter[0] = unicode.ToLower(nPutFitbitApiAccessCounter[0])
+	cPutFitbitApiAccess := mt.NewInt64Counter(string(nPutFitbitApiAccessCounter))
+	boundCounters["PutFitbitApiAccess"] = cPutFitbitApiAccess.Bind(label.String("name", appName))
+	nPutJobCounter := []rune("Storage_PutJob_" + suffix)
+	nPutJobCounter[0] = unicode.ToLower(nPutJobCounter[0])
+	cPutJob := mt.NewInt64Counter(string(nPutJobCounter))
+	boundCounters["PutJob"] = cPutJob.Bind(label.String("name", appName))
+	nPutLeaderboardCounter := []rune("Storage_PutLeaderboard_" + suffix)
+	nPutLeaderboardCounter[0] = unicode.ToLower(nPutLeaderboardCounter[0])
+	cPutLeaderboard := mt.NewInt64Counter(string(nPutLeaderboardCounter))
+	boundCounters["PutLeaderboard"] = cPutLeaderboard.Bind(label.String("name", appName))
+	nPutSecretCounter := []rune("Storage_PutSecret_" + suffix)
+	nPutSecretCounter[0] = unicode.ToLower(nPutSecretCounter[0])
+	cPutSecret := mt.NewInt64Counter(string(nPutSecretCounter))
+	boundCounters["PutSecret"] = cPutSecret.Bind(label.String("name", appName))
+	nPutUserAchievementsCounter := []rune("Storage_PutUserAchievements_" + suffix)
+	nPutUserAchievementsCounter[0] = unicode.ToLower(nPutUserAchievementsCounter[0])
+	cPutUserAchievements := mt.NewInt64Counter(string(nPutUserAchievementsCounter))
+	boundCounters["PutUserAchievements"] = cPutUserAchievements.Bind(label.String("name", appName))
+	nPutUserStreaksCounter := []rune("Storage_PutUserStreaks_" + suffix)
+	nPutUserStreaksCounter[0] = unicode.ToLower(nPutUserStreaksCounter[0])
+	cPutUserStreaks := mt.NewInt64Counter(string(nPutUserStreaksCounter))
+	boundCounters["PutUserStreaks"] = cPutUserStreaks.Bind(label.String("name", appName))
+	nPutWorkspaceMessagesCounter := []rune("Storage_PutWorkspaceMessages_" + suffix)
+	nPutWorkspaceMessagesCounter[0] = unicode.ToLower(nPutWorkspaceMessagesCounter[0])
+	cPutWorkspaceMessages := mt.NewInt64Counter(string(nPutWorkspaceMessagesCounter))
+	boundCounters["PutWorkspaceMessages"] = cPutWorkspaceMessages.Bind(label.String("name", appName))
+	nPutWorkspaceSettingsCounter := []rune("Storage_PutWorkspaceSettings_" + suffix)
+	nPutWorkspaceSettingsCounter[0] = unicode.ToLower(nPutWorkspaceSettingsCounter[0])
+	cPutWorkspaceSettings := mt.NewInt64Counter(string(nPutWorkspaceSettingsCounter))
+	boundCounters["PutWorkspaceSettings"] = cPutWorkspaceSettings.Bind(label.String("name", appName))
+	nRunInTransactionCounter := []rune("Storage_RunInTransaction_" + suffix)
+	nRunInTransactionCounter[0] = unicode.ToLower(nRunInTransactionCounter[0])
+	cRunInTransaction := mt.NewInt64Counter(string(nRunInTransactionCounter))
+	boundCounters["RunInTransaction"] = cRunInTransaction.Bind(label.String("name", appName))
+	return boundCounters
+}
+
+// DeleteClientAccess implements Storage
+func (_d StorageWithTelemetry) DeleteClientAccess(ctx context.Context, teamID string, slackUser string) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["DeleteClientAccess"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["DeleteClientAccess"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["DeleteClientAccess"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.DeleteClientAccess(ctx, teamID, slackUser)
+}
+
+// DeleteCsrfToken implements Storage
+func (_d StorageWithTelemetry) DeleteCsrfToken(ctx context.Context, teamID string, slackUser string) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["DeleteCsrfToken"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["DeleteCsrfToken"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["DeleteCsrfToken"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.DeleteCsrfToken(ctx, teamID, slackUser)
+}
+
+// DeleteJob implements Storage
+func (_d StorageWithTelemetry) DeleteJob(ctx context.Context, name string) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["DeleteJob"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["DeleteJob"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["DeleteJob"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.DeleteJob(ctx, name)
+}
+
+// GetBotInfo implements Storage
+func (_d StorageWithTelemetry) GetBotInfo(ctx context.Context, teamID string) (botInfo BotInfo, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetBotInfo"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetBotInfo"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetBotInfo"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetBotInfo(ctx, teamID)
+}
+
+// GetChallenge implements Storage
+func (_d StorageWithTelemetry) GetChallenge(ctx context.Context, challengeID ChallengeID) (challenge StepsChallenge, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetChallenge"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetChallenge"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetChallenge"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetChallenge(ctx, challengeID)
+}
+
+// GetClientAccess implements Storage
+func (_d StorageWithTelemetry) GetClientAccess(ctx context.Context, teamID string, slackUser string) (clientAccess ClientAccess, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetClientAccess"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetClientAccess"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetClientAccess"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetClientAccess(ctx, teamID, slackUser)
+}
+
+// GetCsrfToken implements Storage
+func (_d StorageWithTelemetry) GetCsrfToken(ctx context.Context, teamID string, slackUser string) (csrfToken CsrfToken, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetCsrfToken"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetCsrfToken"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetCsrfToken"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetCsrfToken(ctx, teamID, slackUser)
+}
+
+// GetDailyActivity implements Storage
+func (_d StorageWithTelemetry) GetDailyActivity(ctx context.Context, fitbitUser string, date string) (activity DailyActivity, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetDailyActivity"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetDailyActivity"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetDailyActivity"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetDailyActivity(ctx, fitbitUser, date)
+}
+
+// GetFitbitApiAccess implements Storage
+func (_d StorageWithTelemetry) GetFitbitApiAccess(ctx context.Context, fitbitUser string) (apiAccess FitbitApiAccess, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetFitbitApiAccess"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetFitbitApiAccess"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetFitbitApiAccess"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetFitbitApiAccess(ctx, fitbitUser)
+}
+
+// GetJob implements Storage
+func (_d StorageWithTelemetry) GetJob(ctx context.Context, name string) (job Job, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetJob"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetJob"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetJob"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetJob(ctx, name)
+}
+
+// GetLeaderboard implements Storage
+func (_d StorageWithTelemetry) GetLeaderboard(ctx context.Context, teamID string, scope string, channelID string, period string, periodID string) (leaderboard Leaderboard, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetLeaderboard"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetLeaderboard"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetLeaderboard"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetLeaderboard(ctx, teamID, scope, channelID, period, periodID)
+}
+
+// GetSecret implements Storage
+func (_d StorageWithTelemetry) GetSecret(ctx context.Context, teamID string, name string) (secret Secret, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetSecret"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetSecret"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetSecret"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetSecret(ctx, teamID, name)
+}
+
+// GetUserAchievements implements Storage
+func (_d StorageWithTelemetry) GetUserAchievements(ctx context.Context, teamID string, userID string) (achievements UserAchievements, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetUserAchievements"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetUserAchievements"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetUserAchievements"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetUserAchievements(ctx, teamID, userID)
+}
+
+// GetUserStreaks implements Storage
+func (_d StorageWithTelemetry) GetUserStreaks(ctx context.Context, teamID string, userID string) (streaks UserStreaks, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetUserStreaks"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetUserStreaks"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetUserStreaks"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetUserStreaks(ctx, teamID, userID)
+}
+
+// GetWorkspaceMessages implements Storage
+func (_d StorageWithTelemetry) GetWorkspaceMessages(ctx context.Context, teamID string) (workspaceMessages WorkspaceMessages, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetWorkspaceMessages"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetWorkspaceMessages"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetWorkspaceMessages"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetWorkspaceMessages(ctx, teamID)
+}
+
+// GetWorkspaceSettings implements Storage
+func (_d StorageWithTelemetry) GetWorkspaceSettings(ctx context.Context, teamID string) (settings WorkspaceSettings, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["GetWorkspaceSettings"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["GetWorkspaceSettings"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["GetWorkspaceSettings"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.GetWorkspaceSettings(ctx, teamID)
+}
+
+// ListActiveChallenges implements Storage
+func (_d StorageWithTelemetry) ListActiveChallenges(ctx context.Context, teamID string) (challenges []StepsChallenge, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ListActiveChallenges"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ListActiveChallenges"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ListActiveChallenges"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ListActiveChallenges(ctx, teamID)
+}
+
+// ListClientAccesses implements Storage
+func (_d StorageWithTelemetry) ListClientAccesses(ctx context.Context, teamID string) (clientAccesses []ClientAccess, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ListClientAccesses"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ListClientAccesses"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ListClientAccesses"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ListClientAccesses(ctx, teamID)
+}
+
+// ListDueJobs implements Storage
+func (_d StorageWithTelemetry) ListDueJobs(ctx context.Context, now time.Time, limit int) (jobs []Job, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ListDueJobs"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ListDueJobs"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ListDueJobs"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ListDueJobs(ctx, now, limit)
+}
+
+// ListFitbitApiAccesses implements Storage
+func (_d StorageWithTelemetry) ListFitbitApiAccesses(ctx context.Context) (apiAccesses []FitbitApiAccess, err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["ListFitbitApiAccesses"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["ListFitbitApiAccesses"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["ListFitbitApiAccesses"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.ListFitbitApiAccesses(ctx)
+}
+
+// PutBotInfo implements Storage
+func (_d StorageWithTelemetry) PutBotInfo(ctx context.Context, teamID string, botInfo BotInfo) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutBotInfo"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutBotInfo"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutBotInfo"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutBotInfo(ctx, teamID, botInfo)
+}
+
+// PutChallenge implements Storage
+func (_d StorageWithTelemetry) PutChallenge(ctx context.Context, challenge StepsChallenge) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutChallenge"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutChallenge"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutChallenge"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutChallenge(ctx, challenge)
+}
+
+// PutClientAccess implements Storage
+func (_d StorageWithTelemetry) PutClientAccess(ctx context.Context, clientAccess ClientAccess) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutClientAccess"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutClientAccess"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutClientAccess"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutClientAccess(ctx, clientAccess)
+}
+
+// PutCsrfToken implements Storage
+func (_d StorageWithTelemetry) PutCsrfToken(ctx context.Context, teamID string, slackUser string, csrfToken CsrfToken) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutCsrfToken"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutCsrfToken"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutCsrfToken"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutCsrfToken(ctx, teamID, slackUser, csrfToken)
+}
+
+// PutDailyActivity implements Storage
+func (_d StorageWithTelemetry) PutDailyActivity(ctx context.Context, activity DailyActivity) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutDailyActivity"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutDailyActivity"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutDailyActivity"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutDailyActivity(ctx, activity)
+}
+
+// PutFitbitApiAccess implements Storage
+func (_d StorageWithTelemetry) PutFitbitApiAccess(ctx context.Context, apiAccess FitbitApiAccess) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutFitbitApiAccess"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutFitbitApiAccess"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutFitbitApiAccess"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutFitbitApiAccess(ctx, apiAccess)
+}
+
+// PutJob implements Storage
+func (_d StorageWithTelemetry) PutJob(ctx context.Context, job Job) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutJob"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutJob"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutJob"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutJob(ctx, job)
+}
+
+// PutLeaderboard implements Storage
+func (_d StorageWithTelemetry) PutLeaderboard(ctx context.Context, teamID string, leaderboard Leaderboard) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutLeaderboard"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutLeaderboard"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutLeaderboard"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutLeaderboard(ctx, teamID, leaderboard)
+}
+
+// PutSecret implements Storage
+func (_d StorageWithTelemetry) PutSecret(ctx context.Context, teamID string, secret Secret) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutSecret"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutSecret"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutSecret"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutSecret(ctx, teamID, secret)
+}
+
+// PutUserAchievements implements Storage
+func (_d StorageWithTelemetry) PutUserAchievements(ctx context.Context, teamID string, achievements UserAchievements) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutUserAchievements"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutUserAchievements"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutUserAchievements"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutUserAchievements(ctx, teamID, achievements)
+}
+
+// PutUserStreaks implements Storage
+func (_d StorageWithTelemetry) PutUserStreaks(ctx context.Context, teamID string, streaks UserStreaks) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutUserStreaks"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutUserStreaks"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutUserStreaks"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutUserStreaks(ctx, teamID, streaks)
+}
+
+// PutWorkspaceMessages implements Storage
+func (_d StorageWithTelemetry) PutWorkspaceMessages(ctx context.Context, workspaceMessages WorkspaceMessages) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutWorkspaceMessages"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutWorkspaceMessages"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutWorkspaceMessages"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutWorkspaceMessages(ctx, workspaceMessages)
+}
+
+// PutWorkspaceSettings implements Storage
+func (_d StorageWithTelemetry) PutWorkspaceSettings(ctx context.Context, settings WorkspaceSettings) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["PutWorkspaceSettings"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["PutWorkspaceSettings"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["PutWorkspaceSettings"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.PutWorkspaceSettings(ctx, settings)
+}
+
+// RunInTransaction implements Storage
+func (_d StorageWithTelemetry) RunInTransaction(ctx context.Context, f func(tc context.Context) error) (err error) {
+	_since := time.Now()
+	defer func() {
+		if err != nil {
+			errCounter := _d.errCounters["RunInTransaction"]
+			errCounter.Add(context.Background(), 1)
+		}
+
+		methodCounter := _d.methodCounters["RunInTransaction"]
+		methodCounter.Add(context.Background(), 1)
+
+		methodTimeMeasure := _d.methodTimeValueRecorders["RunInTransaction"]
+		methodTimeMeasure.Record(context.Background(), time.Since(_since).Milliseconds())
+	}()
+	return _d.base.RunInTransaction(ctx, f)
+}
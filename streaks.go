@@ -0,0 +1,263 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// reminderHour is the local hour of the challenge day after which users with a goal streak at risk get reminded
+	reminderHour = 17
+	// minDisplayedStreak is the minimum streak length shown next to a user in a ranking
+	minDisplayedStreak = 2
+)
+
+// Reminder settings
+const (
+	remindersOn  = "on"
+	remindersOff = "off"
+)
+
+// UserStreaks holds a user's consecutive-day participation and goal-hit streaks along with their
+// reminder preferences. Dates are challenge dates formatted with challengeDateFormat
+type UserStreaks struct {
+	UserID              string `datastore:"userID"`
+	ParticipationStreak int    `datastore:"participationStreak,noindex"`
+	LastParticipation   string `datastore:"lastParticipation,noindex"`
+	GoalStreak          int    `datastore:"goalStreak,noindex"`
+	LastGoalHit         string `datastore:"lastGoalHit,noindex"`
+	RemindersEnabled    bool   `datastore:"remindersEnabled,noindex"`
+	LastReminder        string `datastore:"lastReminder,noindex"`
+}
+
+// streakDayOffset returns the number of days between the last recorded date of a streak and the given date
+func streakDayOffset(last string, date time.Time) (days int, ok bool) {
+	lastDate, err := time.Parse(challengeDateFormat, last)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(date.Sub(lastDate).Hours() / 24), true
+}
+
+// nextStreak returns the updated streak length and last date after recording the given date
+func nextStreak(streak int, last string, date time.Time) (int, string) {
+	days, ok := streakDayOffset(last, date)
+	switch {
+	case !ok:
+		return 1, date.Format(challengeDateFormat)
+	// Recording the same day again or an older day (such as a late final update) doesn't affect the streak
+	case days <= 0:
+		return streak, last
+	case days == 1:
+		return streak + 1, date.Format(challengeDateFormat)
+	default:
+		return 1, date.Format(challengeDateFormat)
+	}
+}
+
+// record updates the streaks with a user's steps and goal for a given day
+func (us *UserStreaks) record(steps int, goal int, date time.Time) {
+	if steps > 0 {
+		us.ParticipationStreak, us.LastParticipation = nextStreak(us.ParticipationStreak, us.LastParticipation, date)
+	}
+
+	if goal > 0 && steps >= goal {
+		us.GoalStreak, us.LastGoalHit = nextStreak(us.GoalStreak, us.LastGoalHit, date)
+	}
+}
+
+// goalStreakAtRisk returns true if the user has an ongoing goal streak that would be broken if they
+// don't hit their goal on the given day
+func (us *UserStreaks) goalStreakAtRisk(date time.Time) bool {
+	days, ok := streakDayOffset(us.LastGoalHit, date)
+	return ok && days == 1 && us.GoalStreak > 0
+}
+
+// recordStreaks updates the streaks of all participants in a challenge ranking and returns them by user id
+func (sc *StepCurry) recordStreaks(stepsChallenge StepsChallenge) (streaks map[string]UserStreaks, err error) {
+	streaks = make(map[string]UserStreaks)
+
+	challengeDate, err := time.Parse(challengeDateFormat, stepsChallenge.Date)
+	if err != nil {
+		return streaks, errors.Wrapf(err, "error parsing date of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	ctx := context.Background()
+	for _, us := range stepsChallenge.RankedUsers {
+		userStreaks, err := sc.updateUserStreaks(ctx, stepsChallenge.TeamID, us.UserID, func(userStreaks *UserStreaks) {
+			userStreaks.record(us.Steps, us.Goal, challengeDate)
+		})
+		if err != nil {
+			return streaks, err
+		}
+
+		streaks[us.UserID] = userStreaks
+	}
+
+	return streaks, nil
+}
+
+// updateUserStreaks applies an update to the stored streaks of a user. Streaks are read and written in a transaction
+// since challenge updates, reminders and users changing their reminder setting all update the same entity
+func (sc *StepCurry) updateUserStreaks(ctx context.Context, teamID string, userID string, update func(userStreaks *UserStreaks)) (userStreaks UserStreaks, err error) {
+	err = sc.storage.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		userStreaks, err = sc.storage.GetUserStreaks(tc, teamID, userID)
+		if err != nil && err != ErrNoSuchEntity {
+			return errors.Wrapf(err, "error loading streaks for user [%s]", userID)
+		}
+
+		userStreaks.UserID = userID
+		update(&userStreaks)
+
+		err = sc.storage.PutUserStreaks(tc, teamID, userStreaks)
+		if err != nil {
+			return errors.Wrapf(err, "error persisting streaks for user [%s]", userID)
+		}
+
+		return nil
+	})
+
+	return userStreaks, err
+}
+
+// renderStreaks renders a user's streaks to be shown next to their name in a ranking
+func renderStreaks(messages *Messages, streaks UserStreaks) string {
+	data := messageData{"ParticipationStreak": 0, "GoalStreak": 0}
+	if streaks.ParticipationStreak >= minDisplayedStreak {
+		data["ParticipationStreak"] = streaks.ParticipationStreak
+	}
+
+	if streaks.GoalStreak >= minDisplayedStreak {
+		data["GoalStreak"] = streaks.GoalStreak
+	}
+
+	return messages.render(msgStreaks, data)
+}
+
+// isReminderTime returns true if it's past reminderHour on the day of a challenge in the given location
+func isReminderTime(date string, location *time.Location, now time.Time) bool {
+	localNow := now.In(location)
+	return localNow.Format(challengeDateFormat) == date && localNow.Hour() >= reminderHour
+}
+
+// userLocation returns the location of a user's slack timezone. The fallback location is returned if the user's
+// info can't be fetched or doesn't have a valid timezone
+func userLocation(svcs TeamServices, userID string, fallback *time.Location) *time.Location {
+	userInfo, err := svcs.userInfoFinder.GetUserInfo(userID)
+	if err != nil {
+		newLogEntry(svcs.logger).with(Fields{teamIDField: svcs.settings.TeamID, userIDField: userID}).withError(err).warningf("Error getting timezone of user, using the challenge's timezone")
+		return fallback
+	}
+
+	location, err := time.LoadLocation(userInfo.TZ)
+	if userInfo.TZ == "" || err != nil {
+		return fallback
+	}
+
+	return location
+}
+
+// sendStreakReminders sends a direct message to challenge participants who opted in to reminders and are below
+// their goal while having a goal streak that would be broken by it. Users are reminded with the first update after
+// reminderHour in their own slack timezone and at most once per day even if participating in multiple challenges
+func (sc *StepCurry) sendStreakReminders(ctx context.Context, svcs TeamServices, stepsChallenge StepsChallenge, streaks map[string]UserStreaks) (err error) {
+	challengeDate, err := time.Parse(challengeDateFormat, stepsChallenge.Date)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing date of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	challengeLocation, err := time.LoadLocation(stepsChallenge.TimezoneID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading timezone of challenge [%s.%s]", stepsChallenge.TeamID, stepsChallenge.ChallengeID.Key())
+	}
+
+	now := sc.clock.Now()
+	for _, us := range stepsChallenge.RankedUsers {
+		userStreaks, ok := streaks[us.UserID]
+		if !ok || !userStreaks.RemindersEnabled || userStreaks.LastReminder == stepsChallenge.Date || us.Goal == 0 || us.Steps >= us.Goal || !userStreaks.goalStreakAtRisk(challengeDate) {
+			continue
+		}
+
+		// Only users who are due a reminder get their timezone looked up to save on slack calls
+		if !isReminderTime(stepsChallenge.Date, userLocation(svcs, us.UserID, challengeLocation), now) {
+			continue
+		}
+
+		messages := svcs.userMessages(us.UserID)
+		reminder := messages.render(msgStreakReminder, messageData{"GoalStreak": userStreaks.GoalStreak, "Steps": us.Steps, "Goal": us.Goal, "OffCommand": sc.slashCommands.Reminders + " " + remindersOff})
+
+		// Posting to a user id delivers the message in the user's IM channel with the app
+		_, _, err = svcs.messenger.PostMessage(us.UserID, messages.postOptions(slack.MsgOptionText(reminder, false))...)
+		if err != nil {
+			sc.log(ctx).with(Fields{userIDField: us.UserID}).withError(err).warningf("Error sending streak reminder")
+			continue
+		}
+
+		_, err = sc.updateUserStreaks(ctx, stepsChallenge.TeamID, us.UserID, func(userStreaks *UserStreaks) {
+			userStreaks.LastReminder = stepsChallenge.Date
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reminders handles an incoming slack request in response to a user invoking /step-reminders to opt in or out
+// of streak reminders
+func (sc *StepCurry) Reminders(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	err = sc.verifier.Verify(r.Header, body)
+	if err != nil {
+		return newHttpError(err, "Error validating request", http.StatusForbidden)
+	}
+
+	// Parse the slack payload to get the originating context (channel, user)
+	params, err := parseSlackRequest(string(body))
+	if err != nil {
+		return newHttpError(err, "Error parsing slack request", http.StatusInternalServerError)
+	}
+
+	teamID := commandTenantID(params)
+	userID := params[userIDParam]
+	responseURL := params[responseURLParam]
+
+	svcs, err := sc.Route(teamID)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error getting api services for team id [%s]", teamID), http.StatusInternalServerError)
+	}
+
+	messages := svcs.userMessages(userID)
+
+	setting := strings.ToLower(strings.TrimSpace(params[textParam]))
+	if setting != remindersOn && setting != remindersOff {
+		usageMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgRemindersUsage, messageData{"OnCommand": sc.slashCommands.Reminders + " " + remindersOn, "OffCommand": sc.slashCommands.Reminders + " " + remindersOff})}
+		return sendResponse(responseURL, usageMsg, "reminders usage")
+	}
+
+	userStreaks, err := sc.updateUserStreaks(context.Background(), teamID, userID, func(userStreaks *UserStreaks) {
+		userStreaks.RemindersEnabled = setting == remindersOn
+	})
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error updating reminder setting for user [%s]", userID), http.StatusInternalServerError)
+	}
+
+	confirmationMsg := ActionResponse{ResponseType: "ephemeral", ReplaceOriginal: false, Text: messages.render(msgRemindersOn, messageData{"Hour": reminderHour})}
+	if !userStreaks.RemindersEnabled {
+		confirmationMsg.Text = messages.render(msgRemindersOff, nil)
+	}
+
+	return sendResponse(responseURL, confirmationMsg, "reminders confirmation")
+}
@@ -0,0 +1,332 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordStreaksForDay(t *testing.T) {
+	tests := map[string]struct {
+		streaks  UserStreaks
+		steps    int
+		goal     int
+		date     time.Time
+		expected UserStreaks
+	}{
+		"FirstDay": {
+			streaks:  UserStreaks{},
+			steps:    12000,
+			goal:     10000,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 1, LastParticipation: "2019-10-11", GoalStreak: 1, LastGoalHit: "2019-10-11"},
+		},
+		"ConsecutiveDayBelowGoal": {
+			streaks:  UserStreaks{ParticipationStreak: 3, LastParticipation: "2019-10-10", GoalStreak: 3, LastGoalHit: "2019-10-10"},
+			steps:    8000,
+			goal:     10000,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10"},
+		},
+		"SameDayGoalHit": {
+			streaks:  UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10"},
+			steps:    10000,
+			goal:     10000,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 4, LastGoalHit: "2019-10-11"},
+		},
+		"GapResetsStreaks": {
+			streaks:  UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-08", GoalStreak: 3, LastGoalHit: "2019-10-08"},
+			steps:    10000,
+			goal:     9000,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 1, LastParticipation: "2019-10-11", GoalStreak: 1, LastGoalHit: "2019-10-11"},
+		},
+		"OlderDayIgnored": {
+			streaks:  UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-12", GoalStreak: 2, LastGoalHit: "2019-10-12"},
+			steps:    10000,
+			goal:     9000,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-12", GoalStreak: 2, LastGoalHit: "2019-10-12"},
+		},
+		"NoStepsNoGoal": {
+			streaks:  UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-10"},
+			steps:    0,
+			goal:     0,
+			date:     time.Date(2019, 10, 11, 0, 0, 0, 0, time.UTC),
+			expected: UserStreaks{ParticipationStreak: 4, LastParticipation: "2019-10-10"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.streaks.record(tc.steps, tc.goal, tc.date)
+			assert.Equal(t, tc.expected, tc.streaks)
+		})
+	}
+}
+
+func TestRenderStreaks(t *testing.T) {
+	assert.Equal(t, "", renderStreaks(DefaultMessages(), UserStreaks{ParticipationStreak: 1, GoalStreak: 1}))
+	assert.Equal(t, " :fire: 5", renderStreaks(DefaultMessages(), UserStreaks{ParticipationStreak: 5, GoalStreak: 1}))
+	assert.Equal(t, " :fire: 5 :dart: 3", renderStreaks(DefaultMessages(), UserStreaks{ParticipationStreak: 5, GoalStreak: 3}))
+}
+
+func TestIsReminderTime(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+
+	assert.False(t, isReminderTime("2019-10-11", la, time.Date(2019, 10, 11, 16, 59, 0, 0, la)))
+	assert.True(t, isReminderTime("2019-10-11", la, time.Date(2019, 10, 11, 17, 5, 0, 0, la)))
+	assert.False(t, isReminderTime("2019-10-11", la, time.Date(2019, 10, 12, 8, 0, 0, 0, la)))
+	assert.False(t, isReminderTime("2019-10-11", time.UTC, time.Date(2019, 10, 11, 17, 5, 0, 0, la)))
+}
+
+func TestRecordStreaks(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U1"
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		returnVal := args.Get(2).(*UserStreaks)
+		*returnVal = UserStreaks{UserID: "U1", ParticipationStreak: 2, LastParticipation: "2019-10-10", RemindersEnabled: true}
+	})
+	storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U2"
+	}), mock.Anything).Return(datastore.ErrNoSuchEntity)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U1"
+	}), &UserStreaks{UserID: "U1", ParticipationStreak: 3, LastParticipation: "2019-10-11", GoalStreak: 1, LastGoalHit: "2019-10-11", RemindersEnabled: true}).Return(nil, nil)
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U2"
+	}), &UserStreaks{UserID: "U2", ParticipationStreak: 1, LastParticipation: "2019-10-11"}).Return(nil, nil)
+	defer storer.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+	require.NoError(t, err)
+
+	streaks, err := sc.recordStreaks(StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, RankedUsers: []UserSteps{{UserID: "U1", Steps: 11000, Goal: 10000}, {UserID: "U2", Steps: 9000, Goal: 10000}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, streaks["U1"].ParticipationStreak)
+	assert.Equal(t, 1, streaks["U2"].ParticipationStreak)
+}
+
+func TestSendStreakReminders(t *testing.T) {
+	verifier := &mocks.Verifier{}
+	defer verifier.AssertExpectations(t)
+
+	storer := &mocks.Datastorer{}
+	storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+		return f(c)
+	})
+	for _, userID := range []string{"U1", "U7"} {
+		userID := userID
+		storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+			return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == userID
+		}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			returnVal := args.Get(2).(*UserStreaks)
+			*returnVal = UserStreaks{UserID: userID, ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true}
+		})
+	}
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U1"
+	}), &UserStreaks{UserID: "U1", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true, LastReminder: "2019-10-11"}).Return(nil, nil)
+	defer storer.AssertExpectations(t)
+
+	storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+		return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "U7"
+	}), &UserStreaks{UserID: "U7", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true, LastReminder: "2019-10-11"}).Return(nil, nil)
+
+	messenger := &mocks.Messenger{}
+	messenger.On("PostMessage", "U1", mock.Anything).Return("", "", nil)
+	messenger.On("PostMessage", "U7", mock.Anything).Return("", "", nil)
+	defer messenger.AssertExpectations(t)
+
+	// It's 17:30 in Los Angeles, the challenge's timezone, and 20:30 in New York
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "U1").Return(&slack.User{ID: "U1", TZ: "America/New_York"}, nil)
+	userInfoFinder.On("GetUserInfo", "U6").Return(&slack.User{ID: "U6", TZ: "Pacific/Honolulu"}, nil)
+	userInfoFinder.On("GetUserInfo", "U7").Return(nil, fmt.Errorf("user_not_found"))
+	defer userInfoFinder.AssertExpectations(t)
+
+	taskScheduler := &mocks.TaskScheduler{}
+	defer taskScheduler.AssertExpectations(t)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, messenger, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	la, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler), OptionClock(NewFakeClock(time.Date(2019, 10, 11, 17, 30, 0, 0, la))))
+	require.NoError(t, err)
+
+	svcs, err := sc.Route("TEAMID")
+	require.NoError(t, err)
+
+	streaks := map[string]UserStreaks{
+		// At risk and opted in
+		"U1": {UserID: "U1", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true},
+		// At risk but not opted in
+		"U2": {UserID: "U2", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10"},
+		// Opted in but already hit their goal
+		"U3": {UserID: "U3", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 4, LastGoalHit: "2019-10-11", RemindersEnabled: true},
+		// Opted in but already reminded today
+		"U4": {UserID: "U4", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true, LastReminder: "2019-10-11"},
+		// Opted in but without a goal streak
+		"U5": {UserID: "U5", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-08", RemindersEnabled: true},
+		// At risk and opted in but it's only 14:30 in their timezone
+		"U6": {UserID: "U6", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true},
+		// At risk and opted in with a timezone that can't be fetched so the challenge's timezone is used
+		"U7": {UserID: "U7", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true},
+	}
+
+	err = sc.sendStreakReminders(context.Background(), svcs, StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, TimezoneID: "America/Los_Angeles", RankedUsers: []UserSteps{{UserID: "U3", Steps: 12000, Goal: 10000}, {UserID: "U1", Steps: 6000, Goal: 10000}, {UserID: "U2", Steps: 5000, Goal: 10000}, {UserID: "U4", Steps: 5000, Goal: 10000}, {UserID: "U5", Steps: 5000, Goal: 10000}, {UserID: "U6", Steps: 5000, Goal: 10000}, {UserID: "U7", Steps: 5000, Goal: 10000}}}, streaks)
+	require.NoError(t, err)
+}
+
+func TestSendStreakRemindersKeepsReminderSetting(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	// The user opted out of reminders after their streaks were recorded by the update sending reminders
+	require.NoError(t, storage.PutUserStreaks(ctx, "TEAMID", UserStreaks{UserID: "U1", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10"}))
+	streaks := map[string]UserStreaks{"U1": {UserID: "U1", ParticipationStreak: 4, LastParticipation: "2019-10-11", GoalStreak: 3, LastGoalHit: "2019-10-10", RemindersEnabled: true}}
+
+	messenger := &mocks.Messenger{}
+	messenger.On("PostMessage", "U1", mock.Anything).Return("", "", nil)
+	defer messenger.AssertExpectations(t)
+
+	userInfoFinder := &mocks.UserInfoFinder{}
+	userInfoFinder.On("GetUserInfo", "U1").Return(&slack.User{ID: "U1", TZ: "America/Los_Angeles"}, nil)
+
+	teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, messenger, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	la, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(&mocks.Verifier{}), OptionStorage(storage), OptionTaskScheduler(&mocks.TaskScheduler{}), OptionClock(NewFakeClock(time.Date(2019, 10, 11, 17, 30, 0, 0, la))))
+	require.NoError(t, err)
+
+	svcs, err := sc.Route("TEAMID")
+	require.NoError(t, err)
+
+	err = sc.sendStreakReminders(ctx, svcs, StepsChallenge{ChallengeID: ChallengeID{TeamID: "TEAMID", ChannelID: "CID", Date: "2019-10-11"}, TimezoneID: "America/Los_Angeles", RankedUsers: []UserSteps{{UserID: "U1", Steps: 6000, Goal: 10000}}}, streaks)
+	require.NoError(t, err)
+
+	storedStreaks, err := storage.GetUserStreaks(ctx, "TEAMID", "U1")
+	require.NoError(t, err)
+	assert.False(t, storedStreaks.RemindersEnabled)
+	assert.Equal(t, "2019-10-11", storedStreaks.LastReminder)
+}
+
+func TestReminders(t *testing.T) {
+	tests := map[string]struct {
+		text             string
+		existing         *UserStreaks
+		expectedStreaks  *UserStreaks
+		expectedResponse string
+	}{
+		"OptIn": {
+			text:             "on",
+			existing:         nil,
+			expectedStreaks:  &UserStreaks{UserID: "frans", RemindersEnabled: true},
+			expectedResponse: "I'll send you a reminder around 17:00 when your goal streak is at risk",
+		},
+		"OptOut": {
+			text:             "OFF",
+			existing:         &UserStreaks{UserID: "frans", GoalStreak: 3, RemindersEnabled: true},
+			expectedStreaks:  &UserStreaks{UserID: "frans", GoalStreak: 3, RemindersEnabled: false},
+			expectedResponse: "Got it, no more streak reminders",
+		},
+		"InvalidSetting": {
+			text:             "maybe",
+			expectedResponse: "Try `/step-reminders on` or `/step-reminders off`",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			slackRequest := ""
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqBody, _ := ioutil.ReadAll(r.Body)
+				slackRequest = string(reqBody)
+				fmt.Fprintln(w, "OK")
+			}))
+			defer server.Close()
+
+			body := fmt.Sprintf("token=sometoken&team_id=TEAMID&channel_id=CID&user_id=frans&command=%%2Fstep-reminders&text=%s&response_url=%s&trigger_id=someTriggerID", tc.text, server.URL)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			verifier := &mocks.Verifier{}
+			verifier.On("Verify", r.Header, []byte(body)).Return(nil)
+			defer verifier.AssertExpectations(t)
+
+			storer := &mocks.Datastorer{}
+			if tc.expectedStreaks != nil {
+				storer.On("RunInTransaction", mock.Anything, mock.Anything).Return(func(c context.Context, f func(context.Context) error) error {
+					return f(c)
+				})
+				call := storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "frans"
+				}), mock.Anything)
+				if tc.existing != nil {
+					call.Return(nil).Run(func(args mock.Arguments) {
+						returnVal := args.Get(2).(*UserStreaks)
+						*returnVal = *tc.existing
+					})
+				} else {
+					call.Return(datastore.ErrNoSuchEntity)
+				}
+
+				storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Namespace == "TEAMID" && k.Kind == "UserStreaks" && k.Name == "frans"
+				}), tc.expectedStreaks).Return(nil, nil)
+			}
+			defer storer.AssertExpectations(t)
+
+			taskScheduler := &mocks.TaskScheduler{}
+			defer taskScheduler.AssertExpectations(t)
+
+			userInfoFinder := &mocks.UserInfoFinder{}
+			userInfoFinder.On("GetUserInfo", "frans").Return(&slack.User{ID: "frans", Locale: "en-US"}, nil)
+			defer userInfoFinder.AssertExpectations(t)
+
+			teamRouter, err := NewSingleTenantRouter(userInfoFinder, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionVerifier(verifier), OptionStorer(storer), OptionTaskScheduler(taskScheduler))
+			require.NoError(t, err)
+
+			err = sc.Reminders(w, r)
+			require.NoError(t, err)
+
+			assert.Contains(t, slackRequest, tc.expectedResponse)
+		})
+	}
+}
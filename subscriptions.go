@@ -0,0 +1,258 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// fitbitSignatureHeader is the header holding the signature of Fitbit subscription notifications
+	fitbitSignatureHeader = "X-Fitbit-Signature"
+	// fitbitVerifyParam is the query parameter holding the verification code sent by Fitbit to verify a subscriber
+	fitbitVerifyParam = "verify"
+	// activitiesCollection is the collection type of activity notifications
+	activitiesCollection = "activities"
+	// dailyActivityMaxAge is how long cached activity is trusted. Fitbit doesn't guarantee the delivery of every
+	// notification so older activity is fetched again rather than kept for the rest of a challenge
+	dailyActivityMaxAge = 30 * time.Minute
+)
+
+// FitbitNotification holds a Fitbit subscription notification. See details at
+// https://dev.fitbit.com/build/reference/web-api/subscriptions/#receiving-notifications
+type FitbitNotification struct {
+	CollectionType string `json:"collectionType"`
+	Date           string `json:"date"`
+	OwnerID        string `json:"ownerId"`
+	OwnerType      string `json:"ownerType"`
+	SubscriptionID string `json:"subscriptionId"`
+}
+
+// ActivityUpdate identifies the activity of a fitbit user for a day to record following a notification
+type ActivityUpdate struct {
+	FitbitUser string `json:"fitbitUser" datastore:"fitbitUser"`
+	Date       string `json:"date" datastore:"date"`
+}
+
+// DailyActivity holds the cached steps, steps goal and floors of a fitbit user for a given day. It's kept up to date
+// from Fitbit subscription notifications
+type DailyActivity struct {
+	FitbitUser string    `datastore:"fitbitUser"`
+	Date       string    `datastore:"date"`
+	Steps      int       `datastore:"steps,noindex"`
+	Goal       int       `datastore:"goal,noindex"`
+	Floors     int       `datastore:"floors,noindex"`
+	UpdatedAt  time.Time `datastore:"updatedAt,noindex"`
+}
+
+// dailyActivityKey returns the key of a fitbit user's cached daily activity
+func dailyActivityKey(fitbitUser string, date string) (key *datastore.Key) {
+	return datastore.NameKey("DailyActivity", fmt.Sprintf("%s:%s", fitbitUser, date), nil)
+}
+
+// subscriptionsEnabled returns true if Fitbit subscriptions are configured
+func (sc *StepCurry) subscriptionsEnabled() bool {
+	return sc.fitbitVerificationCode != ""
+}
+
+// subscribeToActivities creates a subscription for the activities of a fitbit user. The fitbit user id is used as
+// the subscription id since there's only ever one subscription per user. Creating a subscription that already
+// exists succeeds. See https://dev.fitbit.com/build/reference/web-api/subscriptions/#adding-a-subscription
+func (sc *StepCurry) subscribeToActivities(apiAccess FitbitApiAccess) (err error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/1/user/-/%s/apiSubscriptions/%s.json", sc.fitbitAPIBaseURL, activitiesCollection, apiAccess.FitbitUser), nil)
+	if err != nil {
+		return errors.Wrap(err, "error creating subscription request")
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", apiAccess.Token))
+
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error subscribing to activities of fitbit user [%s]", apiAccess.FitbitUser)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error subscribing to activities of fitbit user [%s] [%s]: %s", apiAccess.FitbitUser, resp.Status, body)
+	}
+
+	return nil
+}
+
+// isValidFitbitSignature returns true if the signature matches the one expected for the body. Fitbit signs notifications
+// with HMAC-SHA1 using the client secret followed by & as the key
+func (sc *StepCurry) isValidFitbitSignature(body []byte, signature string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, []byte(sc.fitbitClientSecret+"&"))
+	mac.Write(body)
+
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// FitbitSubscription handles requests from Fitbit to the subscriber endpoint. Fitbit uses the same endpoint to verify
+// the subscriber and to send notifications
+func (sc *StepCurry) FitbitSubscription(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodGet {
+		return sc.VerifyFitbitSubscriber(w, r)
+	}
+
+	return sc.HandleFitbitNotifications(w, r)
+}
+
+// VerifyFitbitSubscriber responds to the verification of the subscriber endpoint by Fitbit. See details at
+// https://dev.fitbit.com/build/reference/web-api/subscriptions/#verify-a-subscriber
+func (sc *StepCurry) VerifyFitbitSubscriber(w http.ResponseWriter, r *http.Request) error {
+	if !sc.subscriptionsEnabled() || r.URL.Query().Get(fitbitVerifyParam) != sc.fitbitVerificationCode {
+		return newHttpError(errors.New("Invalid subscriber verification code"), "", http.StatusNotFound)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// HandleFitbitNotifications handles Fitbit subscription notifications by scheduling the recording of the updated
+// activity of the users they're about. Fitbit expects a response within 5 seconds and disables subscribers that
+// keep missing that deadline so the activity is recorded later by RecordActivity rather than while handling the
+// notifications. Failures to schedule individual updates are logged rather than returned since Fitbit would otherwise
+// keep retrying the whole batch
+func (sc *StepCurry) HandleFitbitNotifications(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	// Fitbit recommends responding with a 404 to notifications with an invalid signature
+	if !sc.isValidFitbitSignature(body, r.Header.Get(fitbitSignatureHeader)) {
+		return newHttpError(errors.New("Invalid notification signature"), "", http.StatusNotFound)
+	}
+
+	var notifications []FitbitNotification
+	err = json.Unmarshal(body, &notifications)
+	if err != nil {
+		return newHttpError(err, "Error decoding notifications", http.StatusBadRequest)
+	}
+
+	for _, notification := range notifications {
+		if notification.CollectionType != activitiesCollection {
+			continue
+		}
+
+		err = sc.scheduler.ScheduleActivityUpdate(r.Context(), ActivityUpdate{FitbitUser: notification.OwnerID, Date: notification.Date}, sc.clock.Now())
+		if err != nil {
+			sc.log(r.Context()).with(Fields{fitbitUserField: notification.OwnerID}).withError(err).errorf("Error scheduling activity update for [%s]", notification.Date)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// RecordActivity handles a request to record the activity of a fitbit user for a day. The requests are coming from
+// updates scheduled by HandleFitbitNotifications
+func (sc *StepCurry) RecordActivity(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newHttpError(err, "Error reading request body", http.StatusInternalServerError)
+	}
+
+	var update ActivityUpdate
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		return newHttpError(err, "Error decoding activity update from body", http.StatusBadRequest)
+	}
+
+	err = sc.recordDailyActivity(update.FitbitUser, update.Date)
+	if err != nil {
+		return newHttpError(err, fmt.Sprintf("Error recording activity of fitbit user [%s] for [%s]", update.FitbitUser, update.Date), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// recordDailyActivity fetches and caches the latest steps and steps goal of a fitbit user for the given day
+func (sc *StepCurry) recordDailyActivity(fitbitUser string, date string) (err error) {
+	day, err := time.Parse(fitbitDateFormat, date)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing notification date [%s]", date)
+	}
+
+	apiAccess, err := sc.getFitbitApiAccess(fitbitUser)
+	if err != nil {
+		return err
+	}
+
+	activitySummary, err := sc.getActivitySummary(fmt.Sprintf("fitbit:%s", fitbitUser), apiAccess, day)
+	if err != nil {
+		return err
+	}
+
+	return sc.cacheDailyActivity(DailyActivity{FitbitUser: fitbitUser, Date: date, Steps: activitySummary.Summary.Steps, Goal: activitySummary.Goals.Steps, Floors: activitySummary.Summary.Floors, UpdatedAt: sc.clock.Now()})
+}
+
+// cacheDailyActivity persists the daily activity of a fitbit user
+func (sc *StepCurry) cacheDailyActivity(activity DailyActivity) (err error) {
+	err = sc.storage.PutDailyActivity(context.Background(), activity)
+	if err != nil {
+		return errors.Wrapf(err, "error persisting daily activity of fitbit user [%s] for [%s]", activity.FitbitUser, activity.Date)
+	}
+
+	return nil
+}
+
+// getUserStepsWithCache returns a user's steps and steps goal for a given day. See getUserActivityWithCache for
+// details on caching
+func (sc *StepCurry) getUserStepsWithCache(slackUser string, apiAccess FitbitApiAccess, date time.Time) (steps int, goal int, err error) {
+	activity, err := sc.getUserActivityWithCache(slackUser, apiAccess, date)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return activity.Steps, activity.Goal, nil
+}
+
+// getUserActivityWithCache returns a user's activity for a given day. For users with a subscription, cached data is
+// kept current by notifications so it's used when present and updated within dailyActivityMaxAge. Otherwise, the
+// activity is fetched from the Fitbit API and cached for subscribed users
+func (sc *StepCurry) getUserActivityWithCache(slackUser string, apiAccess FitbitApiAccess, date time.Time) (activity DailyActivity, err error) {
+	day := date.Format(fitbitDateFormat)
+	if apiAccess.Subscribed {
+		activity, err = sc.storage.GetDailyActivity(context.Background(), apiAccess.FitbitUser, day)
+		if err == nil && sc.clock.Now().Sub(activity.UpdatedAt) < dailyActivityMaxAge {
+			return activity, nil
+		}
+
+		if err != nil && err != ErrNoSuchEntity {
+			newLogEntry(sc.logger).with(Fields{userIDField: slackUser}).withError(err).warningf("Error loading cached activity, fetching from Fitbit instead")
+		}
+	}
+
+	activitySummary, err := sc.getActivitySummary(slackUser, apiAccess, date)
+	if err != nil {
+		return activity, err
+	}
+
+	activity = DailyActivity{FitbitUser: apiAccess.FitbitUser, Date: day, Steps: activitySummary.Summary.Steps, Goal: activitySummary.Goals.Steps, Floors: activitySummary.Summary.Floors, UpdatedAt: sc.clock.Now()}
+	if !apiAccess.Subscribed {
+		return activity, nil
+	}
+
+	err = sc.cacheDailyActivity(activity)
+	if err != nil {
+		newLogEntry(sc.logger).with(Fields{userIDField: slackUser}).withError(err).warningf("Error caching activity")
+	}
+
+	return activity, nil
+}
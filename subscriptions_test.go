@@ -0,0 +1,266 @@
+package stepcurry
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSubscriptionTestStepCurry(t *testing.T, fitbitURL string, storer Datastorer) (sc *StepCurry) {
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err = New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(fitbitURL, fitbitURL), OptionFitbitSubscriber("verifyme"), OptionVerifier(&mocks.Verifier{}), OptionStorer(storer), OptionTaskScheduler(&mocks.TaskScheduler{}))
+	require.NoError(t, err)
+
+	return sc
+}
+
+func signFitbitNotification(body string) (signature string) {
+	mac := hmac.New(sha1.New, []byte("fitbitClientSecret&"))
+	mac.Write([]byte(body))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestIsValidFitbitSignature(t *testing.T) {
+	sc := newSubscriptionTestStepCurry(t, "https://localhost", &mocks.Datastorer{})
+
+	body := `[{"collectionType":"activities","date":"2019-10-11","ownerId":"FITBITUSER","ownerType":"user","subscriptionId":"FITBITUSER"}]`
+	assert.True(t, sc.isValidFitbitSignature([]byte(body), signFitbitNotification(body)))
+	assert.False(t, sc.isValidFitbitSignature([]byte(body), signFitbitNotification("tampered")))
+	assert.False(t, sc.isValidFitbitSignature([]byte(body), "not base64!"))
+}
+
+func TestVerifyFitbitSubscriber(t *testing.T) {
+	tests := map[string]struct {
+		code         string
+		expectedCode int
+	}{
+		"ValidCode": {
+			code:         "verifyme",
+			expectedCode: http.StatusNoContent,
+		},
+		"InvalidCode": {
+			code:         "nope",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sc := newSubscriptionTestStepCurry(t, "https://localhost", &mocks.Datastorer{})
+
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s?verify=%s", fitbitSubscriptionPath, tc.code), nil)
+			w := httptest.NewRecorder()
+			Handler(sc.FitbitSubscription).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestHandleFitbitNotifications(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetches := 0
+	mux.HandleFunc("/1/user/FITBITUSER/activities/date/2019-10-11.json", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 4000}}`)
+	})
+
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC))
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.PutFitbitApiAccess(ctx, FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token", RefreshToken: "refresh", Subscribed: true}))
+	scheduler := NewMemoryTaskScheduler(clock)
+
+	teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+	require.NoError(t, err)
+
+	sc, err := New("https://localhost", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionFitbitURLs(server.URL, server.URL), OptionFitbitSubscriber("verifyme"), OptionVerifier(&mocks.Verifier{}), OptionStorage(storage), OptionTaskScheduler(scheduler), OptionClock(clock))
+	require.NoError(t, err)
+
+	body := `[{"collectionType":"activities","date":"2019-10-11","ownerId":"FITBITUSER","ownerType":"user","subscriptionId":"FITBITUSER"},` +
+		`{"collectionType":"sleep","date":"2019-10-11","ownerId":"FITBITUSER","ownerType":"user","subscriptionId":"FITBITUSER"}]`
+	r := httptest.NewRequest(http.MethodPost, "/"+fitbitSubscriptionPath, strings.NewReader(body))
+	r.Header.Set(fitbitSignatureHeader, signFitbitNotification(body))
+	w := httptest.NewRecorder()
+	Handler(sc.FitbitSubscription).ServeHTTP(w, r)
+
+	// Notifications are acknowledged without fetching from Fitbit and activity is recorded by the scheduled update
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	assert.Equal(t, 0, fetches)
+	require.Len(t, scheduler.Pending(), 1)
+
+	handlers := http.NewServeMux()
+	sc.RegisterHandlers(handlers)
+	dispatched, err := scheduler.RunDue(handlers)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+	assert.Equal(t, 1, fetches)
+
+	activity, err := storage.GetDailyActivity(ctx, "FITBITUSER", "2019-10-11")
+	require.NoError(t, err)
+	assert.Equal(t, 4000, activity.Steps)
+	assert.Equal(t, 10000, activity.Goal)
+}
+
+func TestHandleFitbitNotificationsWithInvalidSignature(t *testing.T) {
+	storer := &mocks.Datastorer{}
+	defer storer.AssertExpectations(t)
+
+	sc := newSubscriptionTestStepCurry(t, "https://localhost", storer)
+
+	body := `[{"collectionType":"activities","date":"2019-10-11","ownerId":"FITBITUSER","ownerType":"user","subscriptionId":"FITBITUSER"}]`
+	r := httptest.NewRequest(http.MethodPost, "/"+fitbitSubscriptionPath, strings.NewReader(body))
+	r.Header.Set(fitbitSignatureHeader, signFitbitNotification("something else"))
+	w := httptest.NewRecorder()
+	Handler(sc.FitbitSubscription).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestSubscribeToActivities(t *testing.T) {
+	tests := map[string]struct {
+		status        int
+		expectedError string
+	}{
+		"Created": {
+			status: http.StatusCreated,
+		},
+		"AlreadyExists": {
+			status: http.StatusOK,
+		},
+		"Conflict": {
+			status:        http.StatusConflict,
+			expectedError: "error subscribing to activities of fitbit user [FITBITUSER] [409 Conflict]: conflict",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			mux.HandleFunc("/1/user/-/activities/apiSubscriptions/FITBITUSER.json", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+				w.WriteHeader(tc.status)
+				if tc.status == http.StatusConflict {
+					fmt.Fprint(w, "conflict")
+				}
+			})
+
+			sc := newSubscriptionTestStepCurry(t, server.URL, &mocks.Datastorer{})
+
+			err := sc.subscribeToActivities(FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token"})
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetUserStepsWithCache(t *testing.T) {
+	tests := map[string]struct {
+		apiAccess      FitbitApiAccess
+		cached         *DailyActivity
+		expectCacheGet bool
+		expectCachePut bool
+		expectedSteps  int
+		expectedFetch  int
+	}{
+		"CacheHit": {
+			apiAccess:      FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token", Subscribed: true},
+			cached:         &DailyActivity{FitbitUser: "FITBITUSER", Date: "2019-10-11", Steps: 5000, Goal: 10000, UpdatedAt: time.Now().Add(-time.Minute)},
+			expectCacheGet: true,
+			expectedSteps:  5000,
+			expectedFetch:  0,
+		},
+		"StaleCache": {
+			apiAccess:      FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token", Subscribed: true},
+			cached:         &DailyActivity{FitbitUser: "FITBITUSER", Date: "2019-10-11", Steps: 5000, Goal: 10000, UpdatedAt: time.Now().Add(-dailyActivityMaxAge)},
+			expectCacheGet: true,
+			expectCachePut: true,
+			expectedSteps:  4000,
+			expectedFetch:  1,
+		},
+		"CacheMiss": {
+			apiAccess:      FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token", Subscribed: true},
+			expectCacheGet: true,
+			expectCachePut: true,
+			expectedSteps:  4000,
+			expectedFetch:  1,
+		},
+		"NotSubscribed": {
+			apiAccess:     FitbitApiAccess{FitbitUser: "FITBITUSER", Token: "token"},
+			expectedSteps: 4000,
+			expectedFetch: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			fetches := 0
+			mux.HandleFunc("/1/user/FITBITUSER/activities/date/2019-10-11.json", func(w http.ResponseWriter, r *http.Request) {
+				fetches++
+				fmt.Fprintln(w, `{"goals": {"steps": 10000}, "summary": {"steps": 4000}}`)
+			})
+
+			storer := &mocks.Datastorer{}
+			if tc.expectCacheGet {
+				call := storer.On("Get", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Kind == "DailyActivity" && k.Name == "FITBITUSER:2019-10-11"
+				}), mock.Anything)
+				if tc.cached != nil {
+					call.Return(nil).Run(func(args mock.Arguments) {
+						returnVal := args.Get(2).(*DailyActivity)
+						*returnVal = *tc.cached
+					})
+				} else {
+					call.Return(datastore.ErrNoSuchEntity)
+				}
+			}
+			if tc.expectCachePut {
+				storer.On("Put", mock.Anything, mock.MatchedBy(func(k *datastore.Key) bool {
+					return k.Kind == "DailyActivity" && k.Name == "FITBITUSER:2019-10-11"
+				}), mock.MatchedBy(func(activity *DailyActivity) bool {
+					return activity.Steps == 4000 && activity.Goal == 10000
+				})).Return(nil, nil)
+			}
+			defer storer.AssertExpectations(t)
+
+			sc := newSubscriptionTestStepCurry(t, server.URL, storer)
+
+			steps, goal, err := sc.getUserStepsWithCache("U1", tc.apiAccess, time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedSteps, steps)
+			assert.Equal(t, 10000, goal)
+			assert.Equal(t, tc.expectedFetch, fetches)
+		})
+	}
+}
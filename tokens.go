@@ -0,0 +1,103 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin is how long before its expiry a token gets refreshed when used
+	tokenRefreshMargin = 5 * time.Minute
+	// tokenSweepInterval is the time between two sweeps of stored tokens
+	tokenSweepInterval = 24 * time.Hour
+	// tokenIdleThreshold is how long a token can go without being refreshed before it gets refreshed by a sweep. This
+	// keeps refresh tokens of users who haven't been part of challenges lately from aging out
+	tokenIdleThreshold = 14 * 24 * time.Hour
+)
+
+// setExpiry sets the expiry of a freshly issued token from its lifetime in seconds
+func (apiAccess *FitbitApiAccess) setExpiry(issuedAt time.Time) {
+	apiAccess.RefreshedAt = issuedAt
+	if apiAccess.ExpiresIn > 0 {
+		apiAccess.Expiry = issuedAt.Add(time.Duration(apiAccess.ExpiresIn) * time.Second)
+	}
+}
+
+// expiresWithin returns true if the token has a known expiry that falls within the given duration from now
+func (apiAccess FitbitApiAccess) expiresWithin(d time.Duration, now time.Time) bool {
+	return !apiAccess.Expiry.IsZero() && !now.Add(d).Before(apiAccess.Expiry)
+}
+
+// needsSweepRefresh returns true if a sweep happening at the given time should refresh the token. That's the case for
+// tokens that expire before the next sweep and for tokens that haven't been refreshed in a long time
+func (apiAccess FitbitApiAccess) needsSweepRefresh(now time.Time) bool {
+	nearingExpiry := apiAccess.Expiry.After(now) && apiAccess.expiresWithin(tokenSweepInterval, now)
+	idle := apiAccess.RefreshedAt.Before(now.Add(-tokenIdleThreshold))
+
+	return nearingExpiry || idle
+}
+
+// SweepTokens handles a request to refresh stored tokens nearing expiry or idle for a long time. The requests are
+// coming from sweeps scheduled via scheduleTokenSweep and every sweep schedules the next one
+func (sc *StepCurry) SweepTokens(w http.ResponseWriter, r *http.Request) error {
+	err := sc.runTokenSweep()
+	if err != nil {
+		return newHttpError(err, "Error running token sweep", http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// ScheduleTokenSweeps makes sure the next token sweep is scheduled. It's meant to be called when the app starts so that
+// stored tokens are kept fresh even if no account gets linked afterwards. Sweeps are scheduled at fixed times so that
+// calling it more than once, including from multiple instances, results in a single sweep
+func (sc *StepCurry) ScheduleTokenSweeps() (err error) {
+	return sc.scheduleTokenSweep(sc.clock.Now())
+}
+
+// runTokenSweep sweeps tokens and schedules the next sweep
+func (sc *StepCurry) runTokenSweep() (err error) {
+	now := sc.clock.Now()
+
+	err = sc.sweepTokens(now)
+	if err != nil {
+		return errors.Wrap(err, "error sweeping tokens")
+	}
+
+	err = sc.scheduleTokenSweep(now)
+	if err != nil {
+		return errors.Wrap(err, "error scheduling next token sweep")
+	}
+
+	return nil
+}
+
+// sweepTokens refreshes all stored tokens that need it. Failures to refresh individual tokens are logged and don't
+// interrupt the sweep
+func (sc *StepCurry) sweepTokens(now time.Time) (err error) {
+	apiAccesses, err := sc.storage.ListFitbitApiAccesses(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "error loading fitbit api accesses")
+	}
+
+	refreshed := 0
+	for _, apiAccess := range apiAccesses {
+		if !apiAccess.needsSweepRefresh(now) {
+			continue
+		}
+
+		_, err = sc.refreshApiAccess(fmt.Sprintf("fitbit:%s", apiAccess.FitbitUser), apiAccess)
+		if err != nil {
+			newLogEntry(sc.logger).with(Fields{fitbitUserField: apiAccess.FitbitUser}).withError(err).warningf("Error refreshing fitbit token during sweep")
+			continue
+		}
+
+		refreshed++
+	}
+
+	newLogEntry(sc.logger).infof("Token sweep refreshed [%d] tokens", refreshed)
+	return nil
+}
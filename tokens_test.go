@@ -0,0 +1,135 @@
+package stepcurry
+
+import (
+	"context"
+	"fmt"
+	"github.com/alexandre-normand/stepcurry/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestSetExpiry(t *testing.T) {
+	issuedAt := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+
+	apiAccess := FitbitApiAccess{ExpiresIn: 28800}
+	apiAccess.setExpiry(issuedAt)
+	assert.Equal(t, time.Date(2019, 10, 11, 18, 0, 0, 0, time.UTC), apiAccess.Expiry)
+	assert.Equal(t, issuedAt, apiAccess.RefreshedAt)
+
+	apiAccess = FitbitApiAccess{}
+	apiAccess.setExpiry(issuedAt)
+	assert.True(t, apiAccess.Expiry.IsZero())
+	assert.Equal(t, issuedAt, apiAccess.RefreshedAt)
+}
+
+func TestExpiresWithin(t *testing.T) {
+	now := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+
+	assert.False(t, FitbitApiAccess{}.expiresWithin(tokenRefreshMargin, now))
+	assert.False(t, FitbitApiAccess{Expiry: now.Add(time.Hour)}.expiresWithin(tokenRefreshMargin, now))
+	assert.True(t, FitbitApiAccess{Expiry: now.Add(time.Minute)}.expiresWithin(tokenRefreshMargin, now))
+	assert.True(t, FitbitApiAccess{Expiry: now.Add(-time.Minute)}.expiresWithin(tokenRefreshMargin, now))
+}
+
+func TestNeedsSweepRefresh(t *testing.T) {
+	now := time.Date(2019, 10, 11, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		apiAccess FitbitApiAccess
+		expected  bool
+	}{
+		"NearingExpiry": {
+			apiAccess: FitbitApiAccess{RefreshedAt: now.Add(-time.Hour), Expiry: now.Add(7 * time.Hour)},
+			expected:  true,
+		},
+		"RecentlyExpired": {
+			apiAccess: FitbitApiAccess{RefreshedAt: now.Add(-3 * 24 * time.Hour), Expiry: now.Add(-3*24*time.Hour + 8*time.Hour)},
+			expected:  false,
+		},
+		"Idle": {
+			apiAccess: FitbitApiAccess{RefreshedAt: now.Add(-15 * 24 * time.Hour), Expiry: now.Add(-15*24*time.Hour + 8*time.Hour)},
+			expected:  true,
+		},
+		"UnknownExpiry": {
+			apiAccess: FitbitApiAccess{},
+			expected:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.apiAccess.needsSweepRefresh(now))
+		})
+	}
+}
+
+func TestScheduleTokenSweep(t *testing.T) {
+	tests := map[string]struct {
+		createErr     error
+		expectedError string
+	}{
+		"Scheduled": {
+			createErr: nil,
+		},
+		"AlreadyScheduled": {
+			createErr: status.Error(codes.AlreadyExists, "task already exists"),
+		},
+		"CloudTasksUnavailable": {
+			createErr:     fmt.Errorf("cloud tasks unavailable"),
+			expectedError: "cloud tasks unavailable",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			taskScheduler := &mocks.TaskScheduler{}
+			taskScheduler.On("GenerateQueueID").Return("queue/path")
+			taskScheduler.On("CreateTask", mock.Anything, mock.MatchedBy(func(req *taskspb.CreateTaskRequest) bool {
+				return req.GetParent() == "queue/path" && req.GetTask().GetName() == "queue/path/tasks/token-sweep-1570838400" &&
+					req.GetTask().GetScheduleTime().GetSeconds() == 1570838400 && req.GetTask().GetHttpRequest().GetUrl() == "https://stepcurry.com/"+sweepTokensPath
+			})).Return(nil, tc.createErr)
+			defer taskScheduler.AssertExpectations(t)
+
+			teamRouter, err := NewSingleTenantRouter(&mocks.UserInfoFinder{}, nil, &mocks.Messenger{}, &mocks.ConversationMemberFinder{})
+			require.NoError(t, err)
+
+			sc, err := New("https://stepcurry.com", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionTeamRouter(teamRouter), OptionStorer(&mocks.Datastorer{}), OptionVerifier(&mocks.Verifier{}), OptionTaskScheduler(taskScheduler))
+			require.NoError(t, err)
+
+			// Any time during the day of 2019-10-11 (UTC) schedules the sweep at the start of 2019-10-12
+			err = sc.scheduleTokenSweep(time.Date(2019, 10, 11, 15, 32, 0, 0, time.UTC))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestScheduleTokenSweeps(t *testing.T) {
+	storage := NewMemoryStorage()
+	clock := NewFakeClock(time.Date(2019, 10, 11, 15, 32, 0, 0, time.UTC))
+	scheduler := NewLocalScheduler(storage, clock)
+	teamRouter, err := NewSingleTenantRouter(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	sc, err := New("https://stepcurry.com", "roger", "fitbitClientID", "fitbitClientSecret", "slackClientID", "slackClientSecret", OptionSlackVerifier("secret"), OptionStorage(storage), OptionTeamRouter(teamRouter), OptionScheduler(scheduler), OptionClock(clock))
+	require.NoError(t, err)
+
+	// Every instance schedules the sweep on startup but the sweep only runs once
+	require.NoError(t, sc.ScheduleTokenSweeps())
+	require.NoError(t, sc.ScheduleTokenSweeps())
+
+	jobs, err := storage.ListDueJobs(context.Background(), time.Date(2019, 10, 13, 0, 0, 0, 0, time.UTC), jobBatchSize)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, JobKindTokenSweep, jobs[0].Kind)
+	assert.True(t, time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC).Equal(jobs[0].ScheduledTime))
+}